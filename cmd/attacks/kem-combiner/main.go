@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"os"
+
+	"deadend-lab/pkg/dee"
+)
+
+// The attacker gets a decapsulation oracle for every ciphertext except the
+// challenge, and in some scenarios one broken component (its private seed).
+// Each combiner runs as a standalone KEM over X25519 + ML-KEM-768, the X-Wing
+// instantiation, so only the combiner binds the ciphertexts.
+var kems = []dee.KEM{dee.KEMX25519, dee.KEMMLKEM768}
+
+const (
+	classical = 0
+	pq        = 1
+)
+
+type game struct {
+	h         *dee.HybridKEM
+	seed      []byte
+	pub       []byte
+	challenge []byte
+	key       []byte
+}
+
+func main() {
+	fmt.Println("=== Hybrid KEM combiner demo (X25519 + ML-KEM-768, CCA oracle) ===")
+	fmt.Println("1. maul: flip the ignored top bit of the X25519 ciphertext; both components secure")
+	fmt.Println("2. mix, X25519 broken: swap in our own X25519 ciphertext, peel its secret off the oracle answer")
+	fmt.Println("3. mix, ML-KEM broken: the same with our own ML-KEM ciphertext")
+
+	for _, c := range dee.Combiners() {
+		g, err := newGame(c)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", c, err)
+			os.Exit(1)
+		}
+		fmt.Printf("\n[%s]\n", c)
+		fmt.Printf("maul recovers key:                 %v\n", g.maul())
+		fmt.Printf("mix recovers key (X25519 broken):  %v\n", g.mix(classical))
+		fmt.Printf("mix recovers key (ML-KEM broken):  %v\n", g.mix(pq))
+	}
+	fmt.Println("\nIn the DEE handshake every combiner but XOR is also salted with the")
+	fmt.Println("transcript, which covers all public keys and ciphertexts.")
+}
+
+func newGame(c dee.Combiner) (*game, error) {
+	h, err := dee.NewHybridKEM(c, kems...)
+	if err != nil {
+		return nil, err
+	}
+	g := &game{h: h, seed: make([]byte, h.SeedSize())}
+	if _, err := rand.Read(g.seed); err != nil {
+		return nil, err
+	}
+	if g.pub, err = h.PublicKey(g.seed); err != nil {
+		return nil, err
+	}
+	g.challenge, g.key, err = h.Encapsulate(g.pub, rand.Reader)
+	return g, err
+}
+
+// oracle decapsulates anything but the challenge.
+func (g *game) oracle(ct []byte) []byte {
+	if bytes.Equal(ct, g.challenge) {
+		return nil
+	}
+	key, err := g.h.Decapsulate(g.seed, ct)
+	if err != nil {
+		return nil
+	}
+	return key
+}
+
+func (g *game) maul() bool {
+	ct := append([]byte(nil), g.challenge...)
+	ct[kems[classical].CiphertextSize()-1] ^= 0x80
+	return bytes.Equal(g.oracle(ct), g.key)
+}
+
+// mix keeps the secure component's challenge ciphertext and replaces the
+// broken one's with a fresh encapsulation whose secret we know. If the key is
+// ss_secure XOR ss_ours, the oracle answer reveals ss_secure, and the broken
+// component gives the challenge's other half.
+func (g *game) mix(broken int) bool {
+	secure := 1 - broken
+	fields := g.fields(g.challenge, dee.KEM.CiphertextSize)
+	pubs := g.fields(g.pub, dee.KEM.PublicKeySize)
+	seeds := g.fields(g.seed, dee.KEM.SeedSize)
+
+	ownCt, ownSS, err := kems[broken].Encapsulate(pubs[broken], rand.Reader)
+	if err != nil {
+		return false
+	}
+	query := make([][]byte, len(kems))
+	query[secure], query[broken] = fields[secure], ownCt
+	answer := g.oracle(bytes.Join(query, nil))
+	if len(answer) != len(ownSS) {
+		return false
+	}
+	brokenSS, err := kems[broken].Decapsulate(seeds[broken], fields[broken])
+	if err != nil {
+		return false
+	}
+	guess := make([]byte, len(answer))
+	for i := range guess {
+		secureSS := answer[i] ^ ownSS[i]
+		guess[i] = secureSS ^ brokenSS[i]
+	}
+	return bytes.Equal(guess, g.key)
+}
+
+func (g *game) fields(b []byte, size func(dee.KEM) int) [][]byte {
+	out := make([][]byte, len(kems))
+	off := 0
+	for i, k := range kems {
+		out[i] = b[off : off+size(k)]
+		off += size(k)
+	}
+	return out
+}
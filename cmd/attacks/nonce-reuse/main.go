@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
 	"math/rand"
 	"os"
@@ -10,50 +11,56 @@ import (
 
 func main() {
 	fmt.Println("=== NAIVE nonce-reuse attack demo ===")
+	fmt.Println("Steps: same nonce -> same keystream -> ct1 XOR ct2 = p1 XOR p2")
+	fmt.Println("With known p1, recover p2 = ct1 XOR ct2 XOR p1")
+
+	for _, suite := range dee.Suites() {
+		if err := attack(suite); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", suite, err)
+			os.Exit(1)
+		}
+	}
+}
 
+// attack runs the nonce-reuse recovery against one AEAD suite.
+func attack(suite dee.Suite) error {
 	rng := rand.New(rand.NewSource(12345))
-	initMsg, initSession, err := dee.HandshakeInit(dee.Naive, rng)
+	opts := []dee.Option{dee.WithSuites(suite)}
+	initMsg, initSession, err := dee.HandshakeInit(dee.Naive, rng, opts...)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "HandshakeInit: %v\n", err)
-		os.Exit(1)
+		return fmt.Errorf("HandshakeInit: %w", err)
 	}
-	respMsg, _, err := dee.HandshakeResp(dee.Naive, initMsg, rng)
+	respMsg, _, err := dee.HandshakeResp(dee.Naive, initMsg, rng, opts...)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "HandshakeResp: %v\n", err)
-		os.Exit(1)
+		return fmt.Errorf("HandshakeResp: %w", err)
 	}
 	if err := initSession.HandshakeComplete(respMsg); err != nil {
-		fmt.Fprintf(os.Stderr, "HandshakeComplete: %v\n", err)
-		os.Exit(1)
+		return fmt.Errorf("HandshakeComplete: %w", err)
 	}
 
-	nonce := make([]byte, 12)
-	for i := range nonce {
-		nonce[i] = 0x41
-	}
+	nonce := bytes.Repeat([]byte{0x41}, initSession.NonceSize())
 
 	p1 := []byte("AAAAAAAAAAAAAAAA")
 	p2 := []byte("BBBBBBBBBBBBBBBB")
 	ct1, err := initSession.EncryptNaiveWithNonce(p1, nil, nonce)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "EncryptNaiveWithNonce 1: %v\n", err)
-		os.Exit(1)
+		return fmt.Errorf("EncryptNaiveWithNonce 1: %w", err)
 	}
 	ct2, err := initSession.EncryptNaiveWithNonce(p2, nil, nonce)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "EncryptNaiveWithNonce 2: %v\n", err)
-		os.Exit(1)
+		return fmt.Errorf("EncryptNaiveWithNonce 2: %w", err)
 	}
 
-	// ChaCha20: ct = keystream XOR plaintext for ciphertext portion
-	// Same nonce = same keystream, so ct1 XOR ct2 = p1 XOR p2
-	// Recover p2: p2 = ct1 XOR ct2 XOR p1
+	// Stream-cipher AEADs (ChaCha20, AES-GCM's CTR) reuse the keystream.
+	// AES-GCM-SIV derives its CTR start from a tag over the plaintext and AD,
+	// so different messages get different keystreams; reuse only reveals
+	// whether two messages (with the same header) were equal.
 	recovered := make([]byte, len(p1))
 	for i := range recovered {
 		recovered[i] = ct1[i] ^ ct2[i] ^ p1[i]
 	}
 
-	fmt.Println("Steps: same nonce -> same keystream -> ct1 XOR ct2 = p1 XOR p2")
-	fmt.Println("With known p1, recover p2 = ct1 XOR ct2 XOR p1")
-	fmt.Printf("Recovered plaintext == expected: %v\n", string(recovered) == string(p2))
+	fmt.Printf("\n[%s] nonce %d bytes\n", suite, len(nonce))
+	fmt.Printf("Recovered plaintext == expected: %v\n", bytes.Equal(recovered, p2))
+	return nil
 }
@@ -26,6 +26,15 @@ func main() {
 		fmt.Fprintf(os.Stderr, "HandshakeComplete: %v\n", err)
 		os.Exit(1)
 	}
+	finMsg, err := initSession.HandshakeFinishMsg()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "HandshakeFinishMsg: %v\n", err)
+		os.Exit(1)
+	}
+	if err := respSession.HandshakeFinish(finMsg); err != nil {
+		fmt.Fprintf(os.Stderr, "HandshakeFinish: %v\n", err)
+		os.Exit(1)
+	}
 
 	plaintext := []byte("replay me")
 	ct, _ := initSession.Encrypt(plaintext, nil)
@@ -51,6 +51,8 @@ func main() {
 		initMsg, initSession, _ := dee.HandshakeInit(dee.Safe, nil)
 		respMsg, respSession, _ := dee.HandshakeResp(dee.Safe, initMsg, nil)
 		_ = initSession.HandshakeComplete(respMsg)
+		finMsg, _ := initSession.HandshakeFinishMsg()
+		_ = respSession.HandshakeFinish(finMsg)
 		ct, _ := initSession.Encrypt([]byte("once"), nil)
 		header := respSession.WireHeader(0)
 		pt, err := respSession.Decrypt(ct, header)
@@ -66,6 +68,8 @@ func main() {
 		initMsg, initSession, _ := dee.HandshakeInit(dee.Safe, nil)
 		respMsg, respSession, _ := dee.HandshakeResp(dee.Safe, initMsg, nil)
 		_ = initSession.HandshakeComplete(respMsg)
+		finMsg, _ := initSession.HandshakeFinishMsg()
+		_ = respSession.HandshakeFinish(finMsg)
 		ct, _ := initSession.Encrypt([]byte("secret"), nil)
 		if len(ct) > 20 {
 			ct[20] ^= 0x01
@@ -80,6 +84,8 @@ func main() {
 		initMsg, initSession, _ := dee.HandshakeInit(dee.Safe, nil)
 		respMsg, respSession, _ := dee.HandshakeResp(dee.Safe, initMsg, nil)
 		_ = initSession.HandshakeComplete(respMsg)
+		finMsg, _ := initSession.HandshakeFinishMsg()
+		_ = respSession.HandshakeFinish(finMsg)
 		plain := make([]byte, 32)
 		if _, err := rand.Read(plain); err == nil {
 			ct, _ := initSession.Encrypt(plain, nil)
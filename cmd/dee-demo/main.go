@@ -12,6 +12,9 @@ import (
 func main() {
 	modeFlag := flag.String("mode", "SAFE", "DEE mode: SAFE or NAIVE")
 	msgFlag := flag.String("msg", "hello", "Message to send")
+	pwFlag := flag.String("password", "", "Shared password for a PAKE handshake (empty: none)")
+	peerPwFlag := flag.String("peer-password", "", "Responder's password, if different from -password")
+	sasFlag := flag.Bool("sas", false, "Negotiate a short authentication string and print both sides' values")
 	flag.Parse()
 
 	var m dee.Mode
@@ -25,13 +28,26 @@ func main() {
 		os.Exit(1)
 	}
 
-	initMsg, initSession, err := dee.HandshakeInit(m, nil)
+	var initOpts, respOpts []dee.Option
+	if *pwFlag != "" {
+		initOpts = append(initOpts, dee.WithPassword([]byte(*pwFlag)))
+		respOpts = append(respOpts, dee.WithPassword([]byte(*pwFlag)))
+	}
+	if *peerPwFlag != "" {
+		respOpts = []dee.Option{dee.WithPassword([]byte(*peerPwFlag))}
+	}
+	if *sasFlag {
+		initOpts = append(initOpts, dee.WithSAS())
+		respOpts = append(respOpts, dee.WithSAS())
+	}
+
+	initMsg, initSession, err := dee.HandshakeInit(m, nil, initOpts...)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "HandshakeInit: %v\n", err)
 		os.Exit(1)
 	}
 
-	respMsg, respSession, err := dee.HandshakeResp(m, initMsg, nil)
+	respMsg, respSession, err := dee.HandshakeResp(m, initMsg, nil, respOpts...)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "HandshakeResp: %v\n", err)
 		os.Exit(1)
@@ -42,6 +58,16 @@ func main() {
 		os.Exit(1)
 	}
 
+	finMsg, err := initSession.HandshakeFinishMsg()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "HandshakeFinishMsg: %v\n", err)
+		os.Exit(1)
+	}
+	if err := respSession.HandshakeFinish(finMsg); err != nil {
+		fmt.Fprintf(os.Stderr, "HandshakeFinish: %v\n", err)
+		os.Exit(1)
+	}
+
 	plaintext := []byte(*msgFlag)
 	ct, err := initSession.Encrypt(plaintext, nil)
 	if err != nil {
@@ -61,4 +87,20 @@ func main() {
 	fmt.Printf("Plaintext: %s\n", plaintext)
 	fmt.Printf("Decrypted: %s\n", pt)
 	fmt.Printf("Roundtrip OK: %v\n", string(pt) == string(plaintext))
+
+	if *sasFlag {
+		initSAS, err := initSession.SAS()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "SAS: %v\n", err)
+			os.Exit(1)
+		}
+		respSAS, err := respSession.SAS()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "SAS: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Initiator SAS: %s\n", initSAS)
+		fmt.Printf("Responder SAS: %s\n", respSAS)
+		fmt.Printf("SAS match: %v\n", initSAS == respSAS)
+	}
 }
@@ -1,9 +1,12 @@
 package main
 
 import (
+	"bytes"
+	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
 	"flag"
+	"io"
 	"log"
 	"net/http"
 	"time"
@@ -19,6 +22,8 @@ func main() {
 
 	http.HandleFunc("/scenario/safe", handleScenarioSafe)
 	http.HandleFunc("/scenario/naive", handleScenarioNaive)
+	http.HandleFunc("/scenario/quantum", handleScenarioQuantum)
+	http.HandleFunc("/scenario/early-replay", handleScenarioEarlyReplay)
 	http.HandleFunc("/health", handleHealth)
 
 	log.Printf("lab-server listening on :%s", port)
@@ -78,6 +83,17 @@ func handleScenario(w http.ResponseWriter, r *http.Request, mode dee.Mode) {
 		writeResult(w, res)
 		return
 	}
+	finMsg, err := initSession.HandshakeFinishMsg()
+	if err != nil {
+		res.ReasonCode = "error"
+		writeResult(w, res)
+		return
+	}
+	if err := respSession.HandshakeFinish(finMsg); err != nil {
+		res.ReasonCode = "error"
+		writeResult(w, res)
+		return
+	}
 	res.HandshakeMs = time.Since(t0).Milliseconds()
 
 	plaintext := []byte("test message")
@@ -114,6 +130,234 @@ func handleScenario(w http.ResponseWriter, r *http.Request, mode dee.Mode) {
 	writeResult(w, res)
 }
 
+// QuantumResult reports the quantum attacker scenario: per handshake variant,
+// whether the attacker read the responder's first frame.
+type QuantumResult struct {
+	OK         bool             `json:"ok"`
+	ReasonCode string           `json:"reason_code"`
+	Variants   []QuantumVariant `json:"variants,omitempty"`
+}
+
+type QuantumVariant struct {
+	Name             string `json:"name"`
+	AttackerDecrypts bool   `json:"attacker_decrypts"`
+}
+
+// handleScenarioQuantum plays a quantum attacker that recovers the initiator's
+// ephemeral private keys from its public keys, modelled by handing it the
+// initiator's randomness. It rebuilds the initiator from that and the public
+// init message, knowing everything but the PSK, and tries to read the
+// responder's first frame. Only the PSK variants (hybrid + PSK and the
+// PSK-only fallback) must stop it.
+func handleScenarioQuantum(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+
+	res := QuantumResult{ReasonCode: "error"}
+	psk := make([]byte, dee.MinPSKSize)
+	if _, err := rand.Read(psk); err != nil {
+		_ = json.NewEncoder(w).Encode(res)
+		return
+	}
+	id := []byte("lab-quantum")
+	guess := make([]byte, dee.MinPSKSize)
+	variants := []struct {
+		name          string
+		opts, attempt []dee.Option
+	}{
+		{"hybrid", nil, nil},
+		{"hybrid+psk", []dee.Option{dee.WithPSK(id, psk)}, []dee.Option{dee.WithPSK(id, guess)}},
+		{"psk-only", []dee.Option{dee.WithVersions(dee.Version2), dee.WithPSKOnly(), dee.WithPSK(id, psk)},
+			[]dee.Option{dee.WithVersions(dee.Version2), dee.WithPSKOnly(), dee.WithPSK(id, guess)}},
+	}
+	res.OK = true
+	for _, v := range variants {
+		decrypts, err := quantumAttack(v.opts, v.attempt)
+		if err != nil {
+			res.OK = false
+			break
+		}
+		res.Variants = append(res.Variants, QuantumVariant{Name: v.name, AttackerDecrypts: decrypts})
+		res.OK = res.OK && decrypts == (v.attempt == nil)
+	}
+	if res.OK {
+		res.ReasonCode = "ok"
+	}
+	_ = json.NewEncoder(w).Encode(res)
+}
+
+// quantumAttack runs one handshake with opts and reports whether an attacker
+// holding the initiator's randomness and configured with attempt reads the
+// responder's first frame.
+func quantumAttack(opts, attempt []dee.Option) (bool, error) {
+	var tape bytes.Buffer
+	initMsg, initSession, err := dee.HandshakeInit(dee.Safe, io.TeeReader(rand.Reader, &tape), opts...)
+	if err != nil {
+		return false, err
+	}
+	respMsg, respSession, err := dee.HandshakeResp(dee.Safe, initMsg, nil, opts...)
+	if err != nil {
+		return false, err
+	}
+	if err := initSession.HandshakeComplete(respMsg); err != nil {
+		return false, err
+	}
+	finMsg, err := initSession.HandshakeFinishMsg()
+	if err != nil {
+		return false, err
+	}
+	if err := respSession.HandshakeFinish(finMsg); err != nil {
+		return false, err
+	}
+	frame, err := respSession.EncryptToFrame([]byte("quantum"), nil)
+	if err != nil {
+		return false, err
+	}
+
+	_, clone, err := dee.HandshakeInit(dee.Safe, &tape, attempt...)
+	if err != nil {
+		return false, err
+	}
+	if clone.HandshakeComplete(respMsg) != nil {
+		return false, nil
+	}
+	_, err = clone.DecryptFromFrame(frame)
+	return err == nil, nil
+}
+
+// EarlyReplayResult reports the 0-RTT replay scenario: per server setup,
+// how often a replayed first flight delivered its early data.
+type EarlyReplayResult struct {
+	OK         bool                 `json:"ok"`
+	ReasonCode string               `json:"reason_code"`
+	Variants   []EarlyReplayVariant `json:"variants,omitempty"`
+}
+
+type EarlyReplayVariant struct {
+	Name       string `json:"name"`
+	Mode       string `json:"mode"`
+	Deliveries int    `json:"deliveries"`
+}
+
+// handleScenarioEarlyReplay plays an attacker that records a client's first
+// flight, an init message with a "transfer" request as early data, and sends
+// it to the server again. With a strike register the copy is refused; with
+// the register disabled (NAIVE only) the server acts on it twice. A
+// single-use resumption ticket stops the copy even without a register.
+func handleScenarioEarlyReplay(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+
+	res := EarlyReplayResult{ReasonCode: "error"}
+	server, err := dee.GenerateKEMIdentity(nil)
+	if err != nil {
+		_ = json.NewEncoder(w).Encode(res)
+		return
+	}
+	strikes, err := dee.NewStrikeRegister(10 * time.Second)
+	if err != nil {
+		_ = json.NewEncoder(w).Encode(res)
+		return
+	}
+	keys, err := dee.NewTicketKeys(time.Hour, time.Hour, nil)
+	if err != nil {
+		_ = json.NewEncoder(w).Encode(res)
+		return
+	}
+	kemInit := func() ([]dee.Option, error) { return []dee.Option{dee.WithPeerKEMKey(server.PublicKey())}, nil }
+	ticketInit := func() ([]dee.Option, error) {
+		ticket, err := labTicket(keys)
+		return []dee.Option{dee.WithTicket(ticket)}, err
+	}
+	variants := []struct {
+		name     string
+		mode     dee.Mode
+		initOpts func() ([]dee.Option, error)
+		respOpts []dee.Option
+		want     int
+	}{
+		{"strike-register", dee.Safe, kemInit, []dee.Option{dee.WithKEMIdentity(server), dee.WithStrikeRegister(strikes)}, 1},
+		{"register-disabled", dee.Naive, kemInit, []dee.Option{dee.WithKEMIdentity(server)}, 2},
+		{"ticket-no-register", dee.Naive, ticketInit, []dee.Option{dee.WithTicketKeys(keys), dee.WithVersions(dee.Version, dee.Version2)}, 1},
+	}
+	res.OK = true
+	for _, v := range variants {
+		initOpts, err := v.initOpts()
+		if err != nil {
+			res.OK = false
+			break
+		}
+		n, err := earlyReplay(v.mode, initOpts, append(v.respOpts, dee.WithEarlyData()))
+		if err != nil {
+			res.OK = false
+			break
+		}
+		res.Variants = append(res.Variants, EarlyReplayVariant{Name: v.name, Mode: v.mode.String(), Deliveries: n})
+		res.OK = res.OK && n == v.want
+	}
+	if res.OK {
+		res.ReasonCode = "ok"
+	}
+	_ = json.NewEncoder(w).Encode(res)
+}
+
+// earlyReplay sends one first flight with early data to the server twice and
+// returns how many times the server read the early data.
+func earlyReplay(mode dee.Mode, initOpts, respOpts []dee.Option) (int, error) {
+	initMsg, initSession, err := dee.HandshakeInit(mode, nil, append(initOpts, dee.WithEarlyData())...)
+	if err != nil {
+		return 0, err
+	}
+	frame, err := initSession.EncryptEarly([]byte("transfer 100 to mallory"), nil)
+	if err != nil {
+		return 0, err
+	}
+	deliveries := 0
+	for i := 0; i < 2; i++ {
+		_, respSession, err := dee.HandshakeResp(mode, initMsg, nil, respOpts...)
+		if err != nil {
+			continue
+		}
+		if _, err := respSession.DecryptEarly(frame, nil); err == nil {
+			deliveries++
+		}
+	}
+	return deliveries, nil
+}
+
+// labTicket runs a full handshake and returns the client's resumption ticket.
+func labTicket(keys *dee.TicketKeys) (*dee.Ticket, error) {
+	initMsg, initSession, err := dee.HandshakeInit(dee.Safe, nil)
+	if err != nil {
+		return nil, err
+	}
+	respMsg, respSession, err := dee.HandshakeResp(dee.Safe, initMsg, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := initSession.HandshakeComplete(respMsg); err != nil {
+		return nil, err
+	}
+	finMsg, err := initSession.HandshakeFinishMsg()
+	if err != nil {
+		return nil, err
+	}
+	if err := respSession.HandshakeFinish(finMsg); err != nil {
+		return nil, err
+	}
+	msg, err := respSession.IssueTicket(keys)
+	if err != nil {
+		return nil, err
+	}
+	return initSession.ReceiveTicket(msg)
+}
+
 func handleHealth(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
@@ -62,3 +62,39 @@ func TestScenarioSchemaAndUniformFailure(t *testing.T) {
 		t.Errorf("session_id_trunc must be 8 bytes hex (16 chars) or empty, got len %d", len(res.SessionIDTrunc))
 	}
 }
+
+func TestQuantumScenario(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/scenario/quantum", nil)
+	w := httptest.NewRecorder()
+	handleScenarioQuantum(w, req)
+	var res QuantumResult
+	if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+		t.Fatalf("scenario JSON: %v", err)
+	}
+	if !res.OK || res.ReasonCode != "ok" || len(res.Variants) != 3 {
+		t.Fatalf("quantum scenario: %+v", res)
+	}
+	for _, v := range res.Variants {
+		if v.AttackerDecrypts != (v.Name == "hybrid") {
+			t.Errorf("%s: attacker_decrypts = %v", v.Name, v.AttackerDecrypts)
+		}
+	}
+}
+
+func TestEarlyReplayScenario(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/scenario/early-replay", nil)
+	w := httptest.NewRecorder()
+	handleScenarioEarlyReplay(w, req)
+	var res EarlyReplayResult
+	if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+		t.Fatalf("scenario JSON: %v", err)
+	}
+	if !res.OK || res.ReasonCode != "ok" || len(res.Variants) != 3 {
+		t.Fatalf("early replay scenario: %+v", res)
+	}
+	for _, v := range res.Variants {
+		if replayed := v.Deliveries > 1; replayed != (v.Name == "register-disabled") {
+			t.Errorf("%s: %d deliveries", v.Name, v.Deliveries)
+		}
+	}
+}
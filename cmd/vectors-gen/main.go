@@ -11,6 +11,7 @@ import (
 )
 
 type jsonMessageEntry struct {
+	Direction string `json:"direction"`
 	Counter   uint64 `json:"counter"`
 	MsgHex    string `json:"msg_hex"`
 	ADHex     string `json:"ad_hex"`
@@ -24,6 +25,20 @@ type jsonMessageVector struct {
 	Label             string             `json:"label"`
 }
 
+type jsonCombinerVector struct {
+	Label         string `json:"label"`
+	PublicKeyHex  string `json:"public_key_hex"`
+	CiphertextHex string `json:"ciphertext_hex"`
+	OutputHex     string `json:"output_hex"`
+}
+
+type jsonNoiseVector struct {
+	Label            string             `json:"label"`
+	HandshakeHashHex string             `json:"handshake_hash_hex"`
+	Handshake        []jsonMessageEntry `json:"handshake"`
+	Messages         []jsonMessageEntry `json:"messages"`
+}
+
 func main() {
 	outDir := flag.String("out", "tests/vectors/testdata", "Output directory")
 	flag.Parse()
@@ -38,20 +53,77 @@ func main() {
 		fmt.Fprintf(os.Stderr, "GenerateMessageVector: %v\n", err)
 		os.Exit(1)
 	}
+	writeMessageVector(filepath.Join(*outDir, "message_vector.json"), v)
+
+	rv, err := vectorgenerate.GenerateRatchetVector(vectorgenerate.VectorSeed)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "GenerateRatchetVector: %v\n", err)
+		os.Exit(1)
+	}
+	writeMessageVector(filepath.Join(*outDir, "ratchet_vector.json"), rv)
 
-	entries := make([]jsonMessageEntry, len(v.Messages))
-	for i, m := range v.Messages {
-		entries[i] = jsonMessageEntry{Counter: m.Counter, MsgHex: m.MsgHex, ADHex: m.ADHex, CipherHex: m.CipherHex}
+	sv, err := vectorgenerate.GenerateSuiteVectors(vectorgenerate.VectorSeed)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "GenerateSuiteVectors: %v\n", err)
+		os.Exit(1)
+	}
+	suiteVectors := make([]jsonMessageVector, len(sv))
+	for i, v := range sv {
+		suiteVectors[i] = toJSON(v)
+	}
+	writeJSON(filepath.Join(*outDir, "suite_vectors.json"), suiteVectors)
+
+	cv, err := vectorgenerate.GenerateCombinerVectors(vectorgenerate.VectorSeed)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "GenerateCombinerVectors: %v\n", err)
+		os.Exit(1)
 	}
-	jv := jsonMessageVector{
+	combinerVectors := make([]jsonCombinerVector, len(cv))
+	for i, v := range cv {
+		combinerVectors[i] = jsonCombinerVector(v)
+	}
+	writeJSON(filepath.Join(*outDir, "combiner_vectors.json"), combinerVectors)
+
+	nv, err := vectorgenerate.GenerateNoiseVectors(vectorgenerate.VectorSeed)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "GenerateNoiseVectors: %v\n", err)
+		os.Exit(1)
+	}
+	noiseVectors := make([]jsonNoiseVector, len(nv))
+	for i, v := range nv {
+		noiseVectors[i] = jsonNoiseVector{
+			Label:            v.Label,
+			HandshakeHashHex: v.HandshakeHashHex,
+			Handshake:        toJSONEntries(v.Handshake),
+			Messages:         toJSONEntries(v.Messages),
+		}
+	}
+	writeJSON(filepath.Join(*outDir, "noise_vectors.json"), noiseVectors)
+}
+
+func writeMessageVector(path string, v vectorgenerate.MessageVector) {
+	writeJSON(path, toJSON(v))
+}
+
+func toJSON(v vectorgenerate.MessageVector) jsonMessageVector {
+	return jsonMessageVector{
 		SessionIDTruncHex: v.SessionIDTruncHex,
 		TranscriptHex:     v.TranscriptHex,
-		Messages:          entries,
+		Messages:          toJSONEntries(v.Messages),
 		Label:             v.Label,
 	}
+}
+
+func toJSONEntries(ms []vectorgenerate.MessageVectorEntry) []jsonMessageEntry {
+	entries := make([]jsonMessageEntry, len(ms))
+	for i, m := range ms {
+		entries[i] = jsonMessageEntry{Direction: m.Direction, Counter: m.Counter, MsgHex: m.MsgHex, ADHex: m.ADHex, CipherHex: m.CipherHex}
+	}
+	return entries
+}
 
-	b, _ := json.MarshalIndent(jv, "", "  ")
-	path := filepath.Join(*outDir, "message_vector.json")
+func writeJSON(path string, v interface{}) {
+	b, _ := json.MarshalIndent(v, "", "  ")
 	if err := os.WriteFile(path, b, 0644); err != nil {
 		fmt.Fprintf(os.Stderr, "WriteFile: %v\n", err)
 		os.Exit(1)
@@ -0,0 +1,137 @@
+// Package aesgcmsiv implements AEAD_AES_256_GCM_SIV (RFC 8452), a
+// nonce-misuse-resistant AEAD: repeating a nonce reveals only whether two
+// (AD, plaintext) pairs are identical.
+package aesgcmsiv
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	KeySize   = 32
+	NonceSize = 12
+	TagSize   = 16
+)
+
+var errOpen = errors.New("aesgcmsiv: message authentication failed")
+
+type aead struct {
+	kgk cipher.Block // key-generating key
+}
+
+// New returns AES-256-GCM-SIV keyed with key.
+func New(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, errors.New("aesgcmsiv: bad key length")
+	}
+	b, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return &aead{kgk: b}, nil
+}
+
+func (a *aead) NonceSize() int { return NonceSize }
+func (a *aead) Overhead() int  { return TagSize }
+
+// deriveKeys returns the per-nonce POLYVAL key and AES encryption key.
+func (a *aead) deriveKeys(nonce []byte) (authKey [16]byte, enc cipher.Block) {
+	var in, out [16]byte
+	var encKey [32]byte
+	copy(in[4:], nonce)
+	for i := uint32(0); i < 6; i++ {
+		binary.LittleEndian.PutUint32(in[:4], i)
+		a.kgk.Encrypt(out[:], in[:])
+		if i < 2 {
+			copy(authKey[8*i:], out[:8])
+		} else {
+			copy(encKey[8*(i-2):], out[:8])
+		}
+	}
+	enc, _ = aes.NewCipher(encKey[:])
+	return authKey, enc
+}
+
+func (a *aead) tag(authKey [16]byte, enc cipher.Block, nonce, plaintext, ad []byte) [16]byte {
+	p := newPolyval(authKey)
+	p.update(ad)
+	p.update(plaintext)
+	var lengths [16]byte
+	binary.LittleEndian.PutUint64(lengths[:8], uint64(len(ad))*8)
+	binary.LittleEndian.PutUint64(lengths[8:], uint64(len(plaintext))*8)
+	p.update(lengths[:])
+	s := p.sum()
+	for i := 0; i < NonceSize; i++ {
+		s[i] ^= nonce[i]
+	}
+	s[15] &= 0x7f
+	var t [16]byte
+	enc.Encrypt(t[:], s[:])
+	return t
+}
+
+// ctr runs AES-CTR with a 32-bit little-endian counter in the first word.
+func ctr(enc cipher.Block, tag [16]byte, dst, src []byte) {
+	block := tag
+	block[15] |= 0x80
+	var ks [16]byte
+	for off := 0; off < len(src); off += 16 {
+		enc.Encrypt(ks[:], block[:])
+		n := len(src) - off
+		if n > 16 {
+			n = 16
+		}
+		subtle.XORBytes(dst[off:off+n], src[off:off+n], ks[:n])
+		binary.LittleEndian.PutUint32(block[:4], binary.LittleEndian.Uint32(block[:4])+1)
+	}
+}
+
+func (a *aead) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	if len(nonce) != NonceSize {
+		panic("aesgcmsiv: incorrect nonce length")
+	}
+	authKey, enc := a.deriveKeys(nonce)
+	t := a.tag(authKey, enc, nonce, plaintext, additionalData)
+	ret, out := sliceForAppend(dst, len(plaintext)+TagSize)
+	ctr(enc, t, out[:len(plaintext)], plaintext)
+	copy(out[len(plaintext):], t[:])
+	return ret
+}
+
+func (a *aead) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(nonce) != NonceSize {
+		panic("aesgcmsiv: incorrect nonce length")
+	}
+	if len(ciphertext) < TagSize {
+		return nil, errOpen
+	}
+	authKey, enc := a.deriveKeys(nonce)
+	var t [16]byte
+	copy(t[:], ciphertext[len(ciphertext)-TagSize:])
+	ct := ciphertext[:len(ciphertext)-TagSize]
+	ret, out := sliceForAppend(dst, len(ct))
+	ctr(enc, t, out, ct)
+	expected := a.tag(authKey, enc, nonce, out, additionalData)
+	if subtle.ConstantTimeCompare(expected[:], t[:]) != 1 {
+		for i := range out {
+			out[i] = 0
+		}
+		return nil, errOpen
+	}
+	return ret, nil
+}
+
+func sliceForAppend(in []byte, n int) (head, tail []byte) {
+	if total := len(in) + n; cap(in) >= total {
+		head = in[:total]
+	} else {
+		head = make([]byte, total)
+		copy(head, in)
+	}
+	tail = head[len(in):]
+	return
+}
@@ -0,0 +1,97 @@
+package aesgcmsiv
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// RFC 8452, Appendix A.
+func TestPolyvalRFC8452(t *testing.T) {
+	var h [16]byte
+	copy(h[:], unhex(t, "25629347589242761d31f826ba4b757b"))
+	p := newPolyval(h)
+	p.update(unhex(t, "4f4f95668c83dfb6401762bb2d01a262d1a24ddd2721d006bbe45f20d3c9f362"))
+	got := p.sum()
+	if want := unhex(t, "f7a3b47b846119fae5b7866cf5e5b77e"); !bytes.Equal(got[:], want) {
+		t.Fatalf("POLYVAL: got %x want %x", got, want)
+	}
+}
+
+// RFC 8452, Appendix C.2 (AEAD_AES_256_GCM_SIV): empty and non-empty AD,
+// partial blocks and plaintexts of up to three blocks.
+func TestSealRFC8452(t *testing.T) {
+	const zeros15 = "000000000000000000000000000000"
+	key := unhex(t, "0100000000000000000000000000000000000000000000000000000000000000")
+	nonce := unhex(t, "030000000000000000000000")
+	cases := []struct{ plaintext, ad, result string }{
+		{"", "", "07f5f4169bbf55a8400cd47ea6fd400f"},
+		{"0100000000000000", "", "c2ef328e5c71c83b843122130f7364b761e0b97427e3df28"},
+		{"01" + zeros15 + "02" + zeros15, "", "4a6a9db4c8c6549201b9edb53006cba821ec9cf850948a7c86c68ac7539d027fe819e63abcd020b006a976397632eb5d"},
+		{"01" + zeros15 + "02" + zeros15 + "03" + zeros15, "", "c00d121893a9fa603f48ccc1ca3c57ce7499245ea0046db16c53c7c66fe717e39cf6c748837b61f6ee3adcee17534ed5790bc96880a99ba804bd12c0e6a22cc4"},
+		{"0200000000000000", "01", "1de22967237a813291213f267e3b452f02d01ae33e4ec854"},
+		{"020000000000000000000000", "01", "163d6f9cc1b346cd453a2e4cc1a4a19ae800941ccdc57cc8413c277f"},
+		{"02" + zeros15 + "03" + zeros15, "01", "07dad364bfc2b9da89116d7bef6daaaf6f255510aa654f920ac81b94e8bad365aea1bad12702e1965604374aab96dbbc"},
+		{"02" + zeros15 + "03" + zeros15 + "04" + zeros15, "01", "c67a1f0f567a5198aa1fcc8e3f21314336f7f51ca8b1af61feac35a86416fa47fbca3b5f749cdf564527f2314f42fe2503332742b228c647173616cfd44c54eb"},
+	}
+	a, err := New(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, tc := range cases {
+		pt, ad := unhex(t, tc.plaintext), unhex(t, tc.ad)
+		got := a.Seal(nil, nonce, pt, ad)
+		if want := unhex(t, tc.result); !bytes.Equal(got, want) {
+			t.Fatalf("case %d: got %x want %x", i, got, want)
+		}
+		back, err := a.Open(nil, nonce, got, ad)
+		if err != nil || !bytes.Equal(back, pt) {
+			t.Fatalf("case %d: Open: %v", i, err)
+		}
+	}
+}
+
+func TestOpenRejectsTamper(t *testing.T) {
+	a, _ := New(make([]byte, KeySize))
+	nonce := make([]byte, NonceSize)
+	ct := a.Seal(nil, nonce, []byte("a longer message spanning blocks"), []byte("ad"))
+	for i := range ct {
+		c := append([]byte(nil), ct...)
+		c[i] ^= 1
+		if _, err := a.Open(nil, nonce, c, []byte("ad")); err == nil {
+			t.Fatalf("tampered byte %d accepted", i)
+		}
+	}
+	if _, err := a.Open(nil, nonce, ct, []byte("other")); err == nil {
+		t.Fatal("wrong AD accepted")
+	}
+}
+
+// Reusing a nonce with different plaintexts must not reuse a keystream.
+func TestNonceReuseDoesNotLeakXOR(t *testing.T) {
+	a, _ := New(bytes.Repeat([]byte{9}, KeySize))
+	nonce := make([]byte, NonceSize)
+	p1 := []byte("AAAAAAAAAAAAAAAA")
+	p2 := []byte("BBBBBBBBBBBBBBBB")
+	c1 := a.Seal(nil, nonce, p1, nil)
+	c2 := a.Seal(nil, nonce, p2, nil)
+	x := make([]byte, len(p1))
+	for i := range x {
+		x[i] = c1[i] ^ c2[i] ^ p1[i]
+	}
+	if bytes.Equal(x, p2) {
+		t.Fatal("nonce reuse leaked the plaintext XOR")
+	}
+	if !bytes.Equal(c1, a.Seal(nil, nonce, p1, nil)) {
+		t.Fatal("same inputs must give the same ciphertext")
+	}
+}
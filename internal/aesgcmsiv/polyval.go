@@ -0,0 +1,86 @@
+package aesgcmsiv
+
+import "encoding/binary"
+
+// fieldElement is an element of GF(2^128) as used by POLYVAL: little-endian,
+// lo holds the coefficients of x^0..x^63.
+type fieldElement struct {
+	lo, hi uint64
+}
+
+func loadElement(b []byte) fieldElement {
+	return fieldElement{binary.LittleEndian.Uint64(b[:8]), binary.LittleEndian.Uint64(b[8:16])}
+}
+
+func (e fieldElement) store(b []byte) {
+	binary.LittleEndian.PutUint64(b[:8], e.lo)
+	binary.LittleEndian.PutUint64(b[8:16], e.hi)
+}
+
+// mulX multiplies by x modulo x^128 + x^127 + x^126 + x^121 + 1.
+func (e fieldElement) mulX() fieldElement {
+	mask := -(e.hi >> 63)
+	e.hi = e.hi<<1 | e.lo>>63
+	e.lo <<= 1
+	e.lo ^= 1 & mask
+	e.hi ^= (1<<63 | 1<<62 | 1<<57) & mask
+	return e
+}
+
+// mul returns a*b modulo the POLYVAL polynomial. It is a slow bit-serial
+// reference: each bit of b selects a masked XOR of a, so the loop does not
+// branch on secret data.
+func mul(a, b fieldElement) fieldElement {
+	var r fieldElement
+	for i := 127; i >= 0; i-- {
+		r = r.mulX()
+		var bit uint64
+		if i >= 64 {
+			bit = b.hi >> uint(i-64) & 1
+		} else {
+			bit = b.lo >> uint(i) & 1
+		}
+		mask := -bit
+		r.lo ^= a.lo & mask
+		r.hi ^= a.hi & mask
+	}
+	return r
+}
+
+// xInv128 is x^-128 = x^127 + x^124 + x^121 + x^114 + 1.
+var xInv128 = fieldElement{lo: 1, hi: 1<<63 | 1<<60 | 1<<57 | 1<<50}
+
+// dot is the POLYVAL product a*b*x^-128.
+func dot(a, b fieldElement) fieldElement {
+	return mul(mul(a, b), xInv128)
+}
+
+type polyval struct {
+	h, s fieldElement
+}
+
+func newPolyval(key [16]byte) *polyval {
+	return &polyval{h: loadElement(key[:])}
+}
+
+// update absorbs data zero-padded to a multiple of 16 bytes.
+func (p *polyval) update(data []byte) {
+	var block [16]byte
+	for len(data) > 0 {
+		n := copy(block[:], data)
+		for i := n; i < 16; i++ {
+			block[i] = 0
+		}
+		data = data[n:]
+		x := loadElement(block[:])
+		p.s.lo ^= x.lo
+		p.s.hi ^= x.hi
+		p.s = dot(p.s, p.h)
+	}
+}
+
+func (p *polyval) sum() [16]byte {
+	var out [16]byte
+	p.s.store(out[:])
+	return out
+}
@@ -1,15 +1,22 @@
 package vectorgenerate
 
 import (
+	"bytes"
 	"encoding/hex"
+	"errors"
+	"io"
+	"strconv"
 
 	"deadend-lab/internal/drbg"
 	"deadend-lab/pkg/dee"
+	"deadend-lab/pkg/dee/noise"
 	"github.com/cloudflare/circl/kem/kyber/kyber768"
 )
 
-// MessageVectorEntry is one message in the vector.
+// MessageVectorEntry is one message in the vector. Direction is "i2r" for
+// initiator-to-responder and "r2i" for responder-to-initiator.
 type MessageVectorEntry struct {
+	Direction string
 	Counter   uint64
 	MsgHex    string
 	ADHex     string
@@ -24,12 +31,21 @@ type MessageVector struct {
 	Label             string
 }
 
+var (
+	errRatchetMismatch  = errors.New("ratchet vector: peer did not follow")
+	errSuiteMismatch    = errors.New("suite vector: suite not negotiated")
+	errCombinerMismatch = errors.New("combiner vector: decapsulation disagrees")
+	errNoiseMismatch    = errors.New("noise vector: peers disagree")
+)
+
 // Seed used for vector generation. Fixed for reproducibility.
 const VectorSeed = 42
 
-// GenerateMessageVector produces a deterministic 2-message vector. All randomness
-// is driven from the DRBG via HandshakeInitDeterministic/HandshakeRespDeterministic;
-// output is identical across runs and machines.
+// GenerateMessageVector produces a deterministic 4-message vector: two messages in
+// each direction, with the responder reusing the initiator's plaintexts and AD so
+// the vector pins down direction separation. All randomness is driven from the
+// DRBG via HandshakeInitDeterministic/HandshakeRespDeterministic; output is
+// identical across runs and machines.
 func GenerateMessageVector(seed int64) (MessageVector, error) {
 	rng := drbg.NewSeed(seed)
 
@@ -50,6 +66,13 @@ func GenerateMessageVector(seed int64) (MessageVector, error) {
 	if err := initSession.HandshakeComplete(respMsg); err != nil {
 		return MessageVector{}, err
 	}
+	finMsg, err := initSession.HandshakeFinishMsg()
+	if err != nil {
+		return MessageVector{}, err
+	}
+	if err := respSession.HandshakeFinish(finMsg); err != nil {
+		return MessageVector{}, err
+	}
 
 	msg0 := []byte("vector message 0")
 	ad0 := []byte("associated data 0")
@@ -64,6 +87,14 @@ func GenerateMessageVector(seed int64) (MessageVector, error) {
 	if err != nil {
 		return MessageVector{}, err
 	}
+	rct0, err := respSession.Encrypt(msg0, ad0)
+	if err != nil {
+		return MessageVector{}, err
+	}
+	rct1, err := respSession.Encrypt(msg1, ad1)
+	if err != nil {
+		return MessageVector{}, err
+	}
 
 	sessionID := initSession.SessionID()
 	truncLen := 8
@@ -73,16 +104,302 @@ func GenerateMessageVector(seed int64) (MessageVector, error) {
 	sessionIDTrunc := sessionID[:truncLen]
 	transcript := sessionID
 
-	_ = respMsg
-	_ = respSession
-
 	return MessageVector{
 		SessionIDTruncHex: hex.EncodeToString(sessionIDTrunc),
 		TranscriptHex:     hex.EncodeToString(transcript),
 		Messages: []MessageVectorEntry{
-			{Counter: 0, MsgHex: hex.EncodeToString(msg0), ADHex: hex.EncodeToString(ad0), CipherHex: hex.EncodeToString(ct0)},
-			{Counter: 1, MsgHex: hex.EncodeToString(msg1), ADHex: hex.EncodeToString(ad1), CipherHex: hex.EncodeToString(ct1)},
+			{Direction: "i2r", Counter: 0, MsgHex: hex.EncodeToString(msg0), ADHex: hex.EncodeToString(ad0), CipherHex: hex.EncodeToString(ct0)},
+			{Direction: "i2r", Counter: 1, MsgHex: hex.EncodeToString(msg1), ADHex: hex.EncodeToString(ad1), CipherHex: hex.EncodeToString(ct1)},
+			{Direction: "r2i", Counter: 0, MsgHex: hex.EncodeToString(msg0), ADHex: hex.EncodeToString(ad0), CipherHex: hex.EncodeToString(rct0)},
+			{Direction: "r2i", Counter: 1, MsgHex: hex.EncodeToString(msg1), ADHex: hex.EncodeToString(ad1), CipherHex: hex.EncodeToString(rct1)},
 		},
-		Label: "two_message_safe_deterministic",
+		Label: "bidirectional_safe_deterministic",
 	}, nil
 }
+
+// GenerateRatchetVector produces a deterministic hybrid ratchet vector: the
+// initiator offers fresh keys, the responder answers, and one more frame goes
+// each way under the new root. Entries hold whole frames (no user AD) so the
+// epoch byte is pinned too. Ratchet key generation draws from the same DRBG as the
+// handshake, and every frame is checked to decrypt on the peer.
+func GenerateRatchetVector(seed int64) (MessageVector, error) {
+	initSession, respSession, err := deterministicHandshake(seed, dee.WithHybridRatchet(0))
+	if err != nil {
+		return MessageVector{}, err
+	}
+	if err := initSession.RequestRatchet(); err != nil {
+		return MessageVector{}, err
+	}
+
+	steps := []struct {
+		dir      string
+		from, to *dee.Session
+	}{
+		{"i2r", initSession, respSession}, // offer
+		{"r2i", respSession, initSession}, // answer
+		{"i2r", initSession, respSession},
+		{"r2i", respSession, initSession},
+	}
+	counters := map[string]uint64{}
+	var entries []MessageVectorEntry
+	for i, st := range steps {
+		msg := []byte("ratchet message " + strconv.Itoa(i))
+		frame, err := st.from.EncryptToFrame(msg, nil)
+		if err != nil {
+			return MessageVector{}, err
+		}
+		got, err := st.to.DecryptFromFrame(frame)
+		if err != nil {
+			return MessageVector{}, err
+		}
+		if string(got) != string(msg) {
+			return MessageVector{}, errRatchetMismatch
+		}
+		entries = append(entries, MessageVectorEntry{
+			Direction: st.dir,
+			Counter:   counters[st.dir],
+			MsgHex:    hex.EncodeToString(msg),
+			CipherHex: hex.EncodeToString(frame),
+		})
+		counters[st.dir]++
+	}
+	if initSession.RatchetSteps() != 1 || respSession.RatchetSteps() != 1 {
+		return MessageVector{}, errRatchetMismatch
+	}
+
+	sessionID := initSession.SessionID()
+	return MessageVector{
+		SessionIDTruncHex: hex.EncodeToString(sessionID[:8]),
+		TranscriptHex:     hex.EncodeToString(sessionID),
+		Messages:          entries,
+		Label:             "hybrid_ratchet_safe_deterministic",
+	}, nil
+}
+
+// CombinerVector is one standalone hybrid KEM encapsulation under a combiner.
+type CombinerVector struct {
+	Label         string
+	PublicKeyHex  string
+	CiphertextHex string
+	OutputHex     string
+}
+
+// GenerateCombinerVectors produces one vector per KEM combiner over X25519 +
+// ML-KEM-768: the key pair seed and the encapsulation randomness come from the
+// DRBG, and the output is checked to decapsulate to the same value.
+func GenerateCombinerVectors(seed int64) ([]CombinerVector, error) {
+	var out []CombinerVector
+	for _, c := range dee.Combiners() {
+		rng := drbg.NewSeed(seed)
+		h, err := dee.NewHybridKEM(c, dee.KEMX25519, dee.KEMMLKEM768)
+		if err != nil {
+			return nil, err
+		}
+		keySeed := make([]byte, h.SeedSize())
+		if _, err := io.ReadFull(rng, keySeed); err != nil {
+			return nil, err
+		}
+		pub, err := h.PublicKey(keySeed)
+		if err != nil {
+			return nil, err
+		}
+		ct, output, err := h.Encapsulate(pub, rng)
+		if err != nil {
+			return nil, err
+		}
+		got, err := h.Decapsulate(keySeed, ct)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(got, output) {
+			return nil, errCombinerMismatch
+		}
+		out = append(out, CombinerVector{
+			Label:         "combiner_" + c.String() + "_x25519_mlkem768",
+			PublicKeyHex:  hex.EncodeToString(pub),
+			CiphertextHex: hex.EncodeToString(ct),
+			OutputHex:     hex.EncodeToString(output),
+		})
+	}
+	return out, nil
+}
+
+// GenerateSuiteVectors produces one vector per AEAD suite: a SAFE handshake
+// negotiating that suite, then one message with AD in each direction. The
+// messages and DRBG are the same for every suite, so the vectors differ only in
+// the suite.
+func GenerateSuiteVectors(seed int64) ([]MessageVector, error) {
+	msg := []byte("suite vector message")
+	ad := []byte("suite vector ad")
+	var out []MessageVector
+	for _, suite := range dee.Suites() {
+		initSession, respSession, err := deterministicHandshake(seed, dee.WithSuites(suite))
+		if err != nil {
+			return nil, err
+		}
+		if initSession.Suite() != suite || respSession.Suite() != suite {
+			return nil, errSuiteMismatch
+		}
+		ct0, err := initSession.Encrypt(msg, ad)
+		if err != nil {
+			return nil, err
+		}
+		rct0, err := respSession.Encrypt(msg, ad)
+		if err != nil {
+			return nil, err
+		}
+		sessionID := initSession.SessionID()
+		out = append(out, MessageVector{
+			SessionIDTruncHex: hex.EncodeToString(sessionID[:8]),
+			TranscriptHex:     hex.EncodeToString(sessionID),
+			Messages: []MessageVectorEntry{
+				{Direction: "i2r", Counter: 0, MsgHex: hex.EncodeToString(msg), ADHex: hex.EncodeToString(ad), CipherHex: hex.EncodeToString(ct0)},
+				{Direction: "r2i", Counter: 0, MsgHex: hex.EncodeToString(msg), ADHex: hex.EncodeToString(ad), CipherHex: hex.EncodeToString(rct0)},
+			},
+			Label: "suite_" + suite.String() + "_safe_deterministic",
+		})
+	}
+	return out, nil
+}
+
+// NoiseVector is one PQNoise handshake followed by a frame each way. Handshake
+// entries hold the payload (MsgHex) and the whole handshake message
+// (CipherHex); Messages hold whole frames.
+type NoiseVector struct {
+	Label            string
+	HandshakeHashHex string
+	Handshake        []MessageVectorEntry
+	Messages         []MessageVectorEntry
+}
+
+// GenerateNoiseVectors produces one vector per built-in PQNoise pattern over
+// the default X25519 + ML-KEM-768 hybrid. Static keys, ephemeral keys and
+// encapsulations are drawn from one DRBG in protocol order, and every message
+// is checked to be read back by the peer.
+func GenerateNoiseVectors(seed int64) ([]NoiseVector, error) {
+	var out []NoiseVector
+	for _, p := range noise.Patterns() {
+		var err error
+		rng := drbg.NewSeed(seed)
+		initCfg := noise.Config{Pattern: p, Initiator: true, Prologue: []byte("noise vector"), Rand: rng}
+		respCfg := noise.Config{Pattern: p, Prologue: []byte("noise vector"), Rand: rng}
+		if p.NeedsStatic(true) {
+			if initCfg.Static, err = noise.GenerateKeypair(nil, rng); err != nil {
+				return nil, err
+			}
+		}
+		if p.NeedsStatic(false) {
+			if respCfg.Static, err = noise.GenerateKeypair(nil, rng); err != nil {
+				return nil, err
+			}
+		}
+		if len(p.ResponderPre) > 0 {
+			initCfg.PeerStatic = respCfg.Static.Public
+		}
+		v, err := noiseVector(p, initCfg, respCfg)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, nil
+}
+
+func noiseVector(p noise.Pattern, initCfg, respCfg noise.Config) (NoiseVector, error) {
+	hi, err := noise.NewHandshake(initCfg)
+	if err != nil {
+		return NoiseVector{}, err
+	}
+	hr, err := noise.NewHandshake(respCfg)
+	if err != nil {
+		return NoiseVector{}, err
+	}
+	v := NoiseVector{Label: "noise_" + p.Name + "_x25519_mlkem768"}
+	sender, receiver, dir := hi, hr, "i2r"
+	for i := range p.Messages {
+		payload := []byte("noise payload " + strconv.Itoa(i))
+		msg, err := sender.WriteMessage(payload)
+		if err != nil {
+			return NoiseVector{}, err
+		}
+		got, err := receiver.ReadMessage(msg)
+		if err != nil {
+			return NoiseVector{}, err
+		}
+		if !bytes.Equal(got, payload) {
+			return NoiseVector{}, errNoiseMismatch
+		}
+		v.Handshake = append(v.Handshake, MessageVectorEntry{
+			Direction: dir,
+			Counter:   uint64(i),
+			MsgHex:    hex.EncodeToString(payload),
+			CipherHex: hex.EncodeToString(msg),
+		})
+		sender, receiver = receiver, sender
+		if dir == "i2r" {
+			dir = "r2i"
+		} else {
+			dir = "i2r"
+		}
+	}
+	initSession, err := hi.Session()
+	if err != nil {
+		return NoiseVector{}, err
+	}
+	respSession, err := hr.Session()
+	if err != nil {
+		return NoiseVector{}, err
+	}
+	v.HandshakeHashHex = hex.EncodeToString(hi.HandshakeHash())
+	msg := []byte("noise vector message")
+	for _, st := range []struct {
+		dir      string
+		from, to *dee.Session
+	}{
+		{"i2r", initSession, respSession},
+		{"r2i", respSession, initSession},
+	} {
+		frame, err := st.from.EncryptToFrame(msg, nil)
+		if err != nil {
+			return NoiseVector{}, err
+		}
+		if got, err := st.to.DecryptFromFrame(frame); err != nil || !bytes.Equal(got, msg) {
+			return NoiseVector{}, errNoiseMismatch
+		}
+		v.Messages = append(v.Messages, MessageVectorEntry{
+			Direction: st.dir,
+			MsgHex:    hex.EncodeToString(msg),
+			CipherHex: hex.EncodeToString(frame),
+		})
+	}
+	return v, nil
+}
+
+// deterministicHandshake runs a SAFE handshake from the DRBG seeded with seed,
+// passing opts to both sides.
+func deterministicHandshake(seed int64, opts ...dee.Option) (initSession, respSession *dee.Session, err error) {
+	rng := drbg.NewSeed(seed)
+	initMsg, initSession, err := dee.HandshakeInitDeterministic(dee.Safe, rng, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	encSeed := make([]byte, kyber768.EncapsulationSeedSize)
+	for i := range encSeed {
+		encSeed[i] = byte(seed)
+	}
+	respMsg, respSession, err := dee.HandshakeRespDeterministic(dee.Safe, initMsg, rng, encSeed, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := initSession.HandshakeComplete(respMsg); err != nil {
+		return nil, nil, err
+	}
+	finMsg, err := initSession.HandshakeFinishMsg()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := respSession.HandshakeFinish(finMsg); err != nil {
+		return nil, nil, err
+	}
+	return initSession, respSession, nil
+}
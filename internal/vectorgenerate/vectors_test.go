@@ -22,3 +22,109 @@ func TestGenerateDeterministic(t *testing.T) {
 		}
 	}
 }
+
+func TestGenerateRatchetDeterministic(t *testing.T) {
+	v1, err := GenerateRatchetVector(VectorSeed)
+	if err != nil {
+		t.Fatalf("GenerateRatchetVector: %v", err)
+	}
+	v2, err := GenerateRatchetVector(VectorSeed)
+	if err != nil {
+		t.Fatalf("GenerateRatchetVector: %v", err)
+	}
+	if len(v1.Messages) != len(v2.Messages) {
+		t.Fatalf("message count: %d != %d", len(v1.Messages), len(v2.Messages))
+	}
+	for i := range v1.Messages {
+		if v1.Messages[i].CipherHex != v2.Messages[i].CipherHex {
+			t.Errorf("message %d frame mismatch", i)
+		}
+	}
+}
+
+func TestGenerateSuiteVectorsDeterministic(t *testing.T) {
+	v1, err := GenerateSuiteVectors(VectorSeed)
+	if err != nil {
+		t.Fatalf("GenerateSuiteVectors: %v", err)
+	}
+	v2, err := GenerateSuiteVectors(VectorSeed)
+	if err != nil {
+		t.Fatalf("GenerateSuiteVectors: %v", err)
+	}
+	if len(v1) != len(v2) {
+		t.Fatalf("vector count: %d != %d", len(v1), len(v2))
+	}
+	seen := map[string]bool{}
+	for i := range v1 {
+		if seen[v1[i].TranscriptHex] {
+			t.Errorf("%s: transcript repeated across suites", v1[i].Label)
+		}
+		seen[v1[i].TranscriptHex] = true
+		for j := range v1[i].Messages {
+			if v1[i].Messages[j].CipherHex != v2[i].Messages[j].CipherHex {
+				t.Errorf("%s message %d cipher mismatch", v1[i].Label, j)
+			}
+		}
+	}
+}
+
+func TestGenerateCombinerVectorsDeterministic(t *testing.T) {
+	v1, err := GenerateCombinerVectors(VectorSeed)
+	if err != nil {
+		t.Fatalf("GenerateCombinerVectors: %v", err)
+	}
+	v2, err := GenerateCombinerVectors(VectorSeed)
+	if err != nil {
+		t.Fatalf("GenerateCombinerVectors: %v", err)
+	}
+	if len(v1) != len(v2) {
+		t.Fatalf("vector count: %d != %d", len(v1), len(v2))
+	}
+	outputs := map[string]bool{}
+	for i := range v1 {
+		if v1[i] != v2[i] {
+			t.Errorf("%s: not deterministic", v1[i].Label)
+		}
+		if v1[i].CiphertextHex != v1[0].CiphertextHex {
+			t.Errorf("%s: ciphertext should not depend on the combiner", v1[i].Label)
+		}
+		if outputs[v1[i].OutputHex] {
+			t.Errorf("%s: output repeated across combiners", v1[i].Label)
+		}
+		outputs[v1[i].OutputHex] = true
+	}
+}
+
+func TestGenerateNoiseVectorsDeterministic(t *testing.T) {
+	v1, err := GenerateNoiseVectors(VectorSeed)
+	if err != nil {
+		t.Fatalf("GenerateNoiseVectors: %v", err)
+	}
+	v2, err := GenerateNoiseVectors(VectorSeed)
+	if err != nil {
+		t.Fatalf("GenerateNoiseVectors: %v", err)
+	}
+	if len(v1) != len(v2) {
+		t.Fatalf("vector count: %d != %d", len(v1), len(v2))
+	}
+	hashes := map[string]bool{}
+	for i := range v1 {
+		if v1[i].HandshakeHashHex != v2[i].HandshakeHashHex || len(v1[i].Handshake) != len(v2[i].Handshake) {
+			t.Fatalf("%s: not deterministic", v1[i].Label)
+		}
+		for j := range v1[i].Handshake {
+			if v1[i].Handshake[j] != v2[i].Handshake[j] {
+				t.Errorf("%s handshake message %d: not deterministic", v1[i].Label, j)
+			}
+		}
+		for j := range v1[i].Messages {
+			if v1[i].Messages[j] != v2[i].Messages[j] {
+				t.Errorf("%s message %d: not deterministic", v1[i].Label, j)
+			}
+		}
+		if hashes[v1[i].HandshakeHashHex] {
+			t.Errorf("%s: handshake hash repeated across patterns", v1[i].Label)
+		}
+		hashes[v1[i].HandshakeHashHex] = true
+	}
+}
@@ -33,3 +33,12 @@ func TestEqualConstantTime(t *testing.T) {
 		t.Error("expected not equal")
 	}
 }
+
+func TestVersionLabel(t *testing.T) {
+	if got := VersionLabel(LabelMaster, 1); got != LabelMaster {
+		t.Errorf("v1: got %q", got)
+	}
+	if got := VersionLabel(LabelTrafficI2R, 2); got != "dee-v2-traffic-i2r" {
+		t.Errorf("v2: got %q", got)
+	}
+}
@@ -2,6 +2,7 @@ package common
 
 import (
 	"crypto/sha256"
+	"hash"
 	"io"
 
 	"golang.org/x/crypto/hkdf"
@@ -9,12 +10,22 @@ import (
 
 // Extract derives a pseudorandom key from secret and salt.
 func Extract(secret, salt []byte) []byte {
-	return hkdf.Extract(sha256.New, secret, salt)
+	return ExtractWith(sha256.New, secret, salt)
 }
 
 // Expand derives output from prk using info label.
 func Expand(prk []byte, info string, size int) []byte {
-	r := hkdf.Expand(sha256.New, prk, []byte(info))
+	return ExpandWith(sha256.New, prk, info, size)
+}
+
+// ExtractWith is Extract over hash h.
+func ExtractWith(h func() hash.Hash, secret, salt []byte) []byte {
+	return hkdf.Extract(h, secret, salt)
+}
+
+// ExpandWith is Expand over hash h.
+func ExpandWith(h func() hash.Hash, prk []byte, info string, size int) []byte {
+	r := hkdf.Expand(h, prk, []byte(info))
 	out := make([]byte, size)
 	_, _ = io.ReadFull(r, out)
 	return out
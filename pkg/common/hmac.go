@@ -3,6 +3,7 @@ package common
 import (
 	"crypto/hmac"
 	"crypto/sha256"
+	"hash"
 )
 
 // HMAC256 computes HMAC-SHA256(key, data).
@@ -14,7 +15,14 @@ func HMAC256(key, data []byte) []byte {
 
 // HMAC256Truncate returns first n bytes of HMAC-SHA256.
 func HMAC256Truncate(key, data []byte, n int) []byte {
-	out := HMAC256(key, data)
+	return HMACTruncate(sha256.New, key, data, n)
+}
+
+// HMACTruncate returns the first n bytes of HMAC over hash h.
+func HMACTruncate(h func() hash.Hash, key, data []byte, n int) []byte {
+	m := hmac.New(h, key)
+	m.Write(data)
+	out := m.Sum(nil)
 	if n > len(out) {
 		n = len(out)
 	}
@@ -1,11 +1,39 @@
 package common
 
+import "strconv"
+
 // Domain separation labels for HKDF.
 const (
 	LabelMaster       = "dee-v1-master"
+	LabelTrafficI2R   = "dee-v1-traffic-i2r"
+	LabelTrafficR2I   = "dee-v1-traffic-r2i"
 	LabelAEADKey      = "dee-v1-aead-key"
 	LabelNonceBase    = "dee-v1-nonce-base"
 	LabelAuditTag     = "dee-v1-audit-tag-key"
 	LabelRekey        = "dee-v1-rekey"
 	LabelRekeyRatchet = "dee-v1-rekey-ratchet"
+	LabelRatchetRoot  = "dee-v1-ratchet-root"
+	LabelSigResp      = "dee-v1-sig-resp"
+	LabelSigInit      = "dee-v1-sig-init"
+	LabelConfirm      = "dee-v1-confirm"
+	LabelPSKBinder    = "dee-v1-psk-binder"
+	LabelPAKE         = "dee-v1-pake"
+	LabelSAS          = "dee-v1-sas"
+	LabelSASCommit    = "dee-v1-sas-commit"
+	LabelResumption   = "dee-v1-resumption"
+	LabelTicket       = "dee-v1-ticket"
+	LabelEarly        = "dee-v1-early"
+	LabelChunk        = "dee-v1-chunk"
+	LabelStream       = "dee-v1-stream"
 )
+
+const labelPrefixV1 = "dee-v1-"
+
+// VersionLabel returns label as used by protocol version: the dee-v1- prefix
+// becomes dee-vN-. Version 1 labels are returned unchanged.
+func VersionLabel(label string, version byte) string {
+	if version == 1 || len(label) < len(labelPrefixV1) || label[:len(labelPrefixV1)] != labelPrefixV1 {
+		return label
+	}
+	return "dee-v" + strconv.Itoa(int(version)) + "-" + label[len(labelPrefixV1):]
+}
@@ -0,0 +1,222 @@
+package dee
+
+import (
+	"encoding/binary"
+	"io"
+
+	"deadend-lab/pkg/common"
+)
+
+// ChunkSize is the most plaintext one frame of a chunked stream carries.
+const ChunkSize = 64 << 10
+
+// maxChunkPayload bounds the payload of a chunk frame: a full chunk, the audit
+// and AEAD tags, and the largest hybrid ratchet header.
+const maxChunkPayload = ChunkSize + 16 + tagSize + ratchetOfferSize
+
+// Writer encrypts a byte stream of any length as session frames of at most
+// ChunkSize plaintext bytes. It follows the STREAM construction: each chunk
+// is bound to the stream and its position, and the last one carries
+// FlagLastChunk, so a Reader detects chunks that were reordered, dropped,
+// taken from another stream or cut off at the end.
+type Writer struct {
+	s      *Session
+	w      io.Writer
+	start  uint64 // counter of the stream's first frame
+	index  uint64
+	buf    []byte
+	err    error
+	closed bool
+}
+
+// NewWriter starts a chunked stream to w and sends ad, at most ChunkSize
+// bytes, in its first frame; the peer's Reader returns it from AD. Writes
+// are buffered into full chunks, and Close sends the rest as the last chunk.
+// Memory use is one chunk however long the stream is.
+//
+// Each chunk is one frame, written to w with a single Write. The frames use
+// the session's counters, so the peer must open them in the order they were
+// sealed relative to the session's other frames.
+func (s *Session) NewWriter(w io.Writer, ad []byte) (*Writer, error) {
+	if len(ad) > ChunkSize {
+		return nil, ErrConfig
+	}
+	sw := &Writer{s: s, w: w, buf: make([]byte, 0, ChunkSize)}
+	if err := sw.send(ad, 0); err != nil {
+		return nil, err
+	}
+	return sw, nil
+}
+
+// Write buffers p, sending each chunk once it is full and more data follows.
+func (w *Writer) Write(p []byte) (n int, err error) {
+	if w.closed {
+		return 0, ErrConfig
+	}
+	if w.err != nil {
+		return 0, w.err
+	}
+	for len(p) > 0 {
+		if len(w.buf) == ChunkSize {
+			if err := w.send(w.buf, 0); err != nil {
+				return n, err
+			}
+			w.buf = w.buf[:0]
+		}
+		k := copy(w.buf[len(w.buf):ChunkSize], p)
+		w.buf = w.buf[:len(w.buf)+k]
+		p = p[k:]
+		n += k
+	}
+	return n, nil
+}
+
+// Close sends the buffered data, possibly none, as the last chunk. It does
+// not close the underlying writer. Later writes fail with ErrConfig.
+func (w *Writer) Close() error {
+	if w.closed || w.err != nil {
+		return w.err
+	}
+	if err := w.send(w.buf, FlagLastChunk); err != nil {
+		return err
+	}
+	wipe(w.buf)
+	w.buf, w.closed = nil, true
+	return nil
+}
+
+func (w *Writer) send(chunk []byte, flags uint16) error {
+	frame, err := w.s.sealChunk(chunk, w.s.chunkAD(w.start, w.index), flags)
+	if err == nil {
+		_, err = w.w.Write(frame)
+	}
+	if err != nil {
+		w.err = err
+		return err
+	}
+	if w.index == 0 {
+		w.start = w.s.wire().counterOf(frame)
+	}
+	w.index++
+	return nil
+}
+
+// Reader decrypts a stream written by the peer's Writer. Read returns io.EOF
+// only after the last chunk; a stream that ends early, or any chunk that does
+// not authenticate in its place, fails with ErrDecrypt. Errors of the
+// underlying reader other than EOF are returned as they are.
+type Reader struct {
+	s       *Session
+	r       io.Reader
+	ad      []byte
+	start   uint64
+	index   uint64
+	head    []byte
+	payload []byte
+	chunk   []byte // plaintext not yet read
+	last    bool
+	err     error
+}
+
+// NewReader returns a Reader for a chunked stream read from r. It holds at
+// most one frame in memory.
+func (s *Session) NewReader(r io.Reader) *Reader {
+	return &Reader{s: s, r: r}
+}
+
+// AD returns the associated data the writer passed to NewWriter, reading the
+// stream's first frame if Read has not.
+func (r *Reader) AD() ([]byte, error) {
+	if r.index == 0 {
+		if err := r.next(); err != nil {
+			return nil, err
+		}
+	}
+	return r.ad, nil
+}
+
+func (r *Reader) Read(p []byte) (n int, err error) {
+	for len(r.chunk) == 0 {
+		if r.last {
+			return 0, io.EOF
+		}
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+	n = copy(p, r.chunk)
+	r.chunk = r.chunk[n:]
+	return n, nil
+}
+
+// next reads and opens the stream's next frame.
+func (r *Reader) next() error {
+	if r.err != nil {
+		return r.err
+	}
+	w := r.s.wire()
+	if r.head == nil {
+		r.head = make([]byte, w.headerSize+4)
+	}
+	if _, err := io.ReadFull(r.r, r.head); err != nil {
+		return r.fail(err)
+	}
+	n := binary.BigEndian.Uint32(r.head[w.headerSize:])
+	if n > maxChunkPayload {
+		return r.fail(ErrDecrypt)
+	}
+	if cap(r.payload) < int(n) {
+		r.payload = make([]byte, n)
+	}
+	payload := r.payload[:n]
+	if _, err := io.ReadFull(r.r, payload); err != nil {
+		return r.fail(err)
+	}
+	header := r.head[:w.headerSize]
+	plaintext, last, err := r.s.openChunk(payload, header, r.s.chunkAD(r.start, r.index))
+	if err != nil || (last && r.index == 0) {
+		return r.fail(ErrDecrypt)
+	}
+	if r.index == 0 {
+		r.ad, r.start = plaintext, w.counterOf(header)
+	} else {
+		r.chunk = plaintext
+	}
+	r.index++
+	r.last = last
+	return nil
+}
+
+// fail makes err sticky. Running out of input before the last chunk is
+// truncation, which fails like a forged chunk.
+func (r *Reader) fail(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = ErrDecrypt
+	}
+	r.err = err
+	return err
+}
+
+// chunkAD is the associated data of chunk index of the stream whose first
+// frame has counter start: label || start || index. The first frame itself
+// uses start 0.
+func (s *Session) chunkAD(start, index uint64) []byte {
+	ad := []byte(s.ks.label(common.LabelChunk))
+	ad = binary.BigEndian.AppendUint64(ad, start)
+	return binary.BigEndian.AppendUint64(ad, index)
+}
+
+func (s *Session) sealChunk(chunk, ad []byte, flags uint16) ([]byte, error) {
+	defer s.lockTx()()
+	header, ct, err := s.seal(chunk, ad, flags)
+	if err != nil {
+		return nil, err
+	}
+	return buildFrame(header, ct), nil
+}
+
+func (s *Session) openChunk(payload, header, ad []byte) (plaintext []byte, last bool, err error) {
+	defer s.lockRx()()
+	plaintext, flags, err := s.decrypt(payload, append(append([]byte(nil), header...), ad...), FlagLastChunk)
+	return plaintext, flags&FlagLastChunk != 0, err
+}
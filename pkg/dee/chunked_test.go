@@ -0,0 +1,190 @@
+package dee
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+)
+
+// frameRecorder keeps each Write as one frame.
+type frameRecorder struct {
+	frames [][]byte
+}
+
+func (f *frameRecorder) Write(p []byte) (int, error) {
+	f.frames = append(f.frames, append([]byte(nil), p...))
+	return len(p), nil
+}
+
+func (f *frameRecorder) stream() io.Reader {
+	return bytes.NewReader(bytes.Join(f.frames, nil))
+}
+
+// writeChunked writes data through a Writer in uneven pieces.
+func writeChunked(t *testing.T, s *Session, ad, data []byte) *frameRecorder {
+	t.Helper()
+	rec := &frameRecorder{}
+	w, err := s.NewWriter(rec, ad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rest := data; len(rest) > 0; {
+		n := min(len(rest), 7919)
+		if _, err := w.Write(rest[:n]); err != nil {
+			t.Fatal(err)
+		}
+		rest = rest[n:]
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return rec
+}
+
+func TestChunkedRoundTrip(t *testing.T) {
+	data := make([]byte, 3*ChunkSize+5)
+	rand.Read(data)
+	v2 := []Option{WithVersions(Version2)}
+	for _, tc := range []struct {
+		name string
+		mode Mode
+		opts []Option
+	}{
+		{"safe", Safe, nil},
+		{"naive", Naive, nil},
+		{"v2", Safe, v2},
+		{"hybrid ratchet", Safe, []Option{WithHybridRatchet(2)}},
+	} {
+		for _, n := range []int{0, 1, ChunkSize, ChunkSize + 1, len(data)} {
+			initSession, respSession := sessionPair(t, tc.mode, tc.opts, tc.opts)
+			sendFrames(t, respSession, initSession, 1)
+			rec := writeChunked(t, initSession, []byte("name=report.pdf"), data[:n])
+			if want := 2 + max(n-1, 0)/ChunkSize; len(rec.frames) != want {
+				t.Fatalf("%s, %d bytes: %d frames, want %d", tc.name, n, len(rec.frames), want)
+			}
+			r := respSession.NewReader(rec.stream())
+			ad, err := r.AD()
+			if err != nil || string(ad) != "name=report.pdf" {
+				t.Fatalf("%s, %d bytes: AD %q, %v", tc.name, n, ad, err)
+			}
+			got, err := io.ReadAll(r)
+			if err != nil || !bytes.Equal(got, data[:n]) {
+				t.Fatalf("%s, %d bytes: read %d bytes, %v", tc.name, n, len(got), err)
+			}
+			sendFrames(t, initSession, respSession, 1)
+		}
+	}
+}
+
+// Dropped, reordered and cut-off chunks all fail, even on a session whose
+// replay window would accept the frames out of order.
+func TestChunkedTamper(t *testing.T) {
+	data := make([]byte, 4*ChunkSize)
+	opts := []Option{WithReplayWindow(MinReplayWindow)}
+	for _, tc := range []struct {
+		name   string
+		tamper func([][]byte) []byte
+	}{
+		{"last chunk dropped", func(f [][]byte) []byte { return bytes.Join(f[:len(f)-1], nil) }},
+		{"middle chunk dropped", func(f [][]byte) []byte { return bytes.Join(append(f[:2:2], f[3:]...), nil) }},
+		{"chunks swapped", func(f [][]byte) []byte {
+			f[1], f[2] = f[2], f[1]
+			return bytes.Join(f, nil)
+		}},
+		{"cut mid-frame", func(f [][]byte) []byte { b := bytes.Join(f, nil); return b[:len(b)-100] }},
+		{"only the first frame", func(f [][]byte) []byte { return f[0] }},
+		{"empty", func(f [][]byte) []byte { return nil }},
+	} {
+		initSession, respSession := sessionPair(t, Safe, opts, opts)
+		rec := writeChunked(t, initSession, nil, data)
+		_, err := io.ReadAll(respSession.NewReader(bytes.NewReader(tc.tamper(rec.frames))))
+		if err != ErrDecrypt {
+			t.Fatalf("%s: got %v, want ErrDecrypt", tc.name, err)
+		}
+	}
+
+	// A chunk of one stream does not fit another.
+	initSession, respSession := sessionPair(t, Safe, opts, opts)
+	first := writeChunked(t, initSession, nil, data)
+	second := writeChunked(t, initSession, nil, data)
+	second.frames[1] = first.frames[1]
+	if _, err := io.ReadAll(respSession.NewReader(second.stream())); err != ErrDecrypt {
+		t.Fatalf("spliced chunk: got %v, want ErrDecrypt", err)
+	}
+}
+
+func TestChunkedLimits(t *testing.T) {
+	initSession, respSession := sessionPair(t, Safe, nil, nil)
+	if _, err := initSession.NewWriter(io.Discard, make([]byte, ChunkSize+1)); err != ErrConfig {
+		t.Fatalf("oversized AD: got %v, want ErrConfig", err)
+	}
+
+	// A forged length is refused before anything is allocated for it.
+	rec := writeChunked(t, initSession, nil, []byte("data"))
+	hs := initSession.HeaderSize()
+	forged := append([]byte(nil), rec.frames[0][:hs]...)
+	forged = append(forged, 0xff, 0xff, 0xff, 0xff)
+	if _, err := respSession.NewReader(bytes.NewReader(forged)).AD(); err != ErrDecrypt {
+		t.Fatalf("forged length: got %v, want ErrDecrypt", err)
+	}
+
+	// The last chunk is not an ordinary frame.
+	initSession, respSession = sessionPair(t, Safe, nil, nil)
+	rec = writeChunked(t, initSession, nil, nil)
+	if _, err := respSession.DecryptFromFrame(rec.frames[1]); err != ErrDecrypt {
+		t.Fatalf("last chunk as a frame: got %v, want ErrDecrypt", err)
+	}
+
+	w, err := initSession.NewWriter(io.Discard, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	if _, err := w.Write([]byte("late")); err != ErrConfig {
+		t.Fatalf("Write after Close: got %v, want ErrConfig", err)
+	}
+
+	errPipe := errors.New("pipe broken")
+	r := respSession.NewReader(io.MultiReader(bytes.NewReader(rec.frames[0][:10]), &failingReader{errPipe}))
+	if _, err := io.ReadAll(r); err != errPipe {
+		t.Fatalf("reader error: got %v, want it passed through", err)
+	}
+}
+
+type failingReader struct{ err error }
+
+func (f *failingReader) Read([]byte) (int, error) { return 0, f.err }
+
+// A stream far larger than a chunk goes through a pipe with at most one
+// chunk buffered on either side.
+func TestChunkedLarge(t *testing.T) {
+	if testing.Short() {
+		t.Skip("large stream")
+	}
+	initSession, respSession := sessionPair(t, Safe, nil, nil)
+	const size = 64 << 20
+	pr, pw := io.Pipe()
+	go func() {
+		w, err := initSession.NewWriter(pw, nil)
+		if err == nil {
+			_, err = io.CopyN(w, zeroReader{}, size)
+		}
+		if err == nil {
+			err = w.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	n, err := io.Copy(io.Discard, respSession.NewReader(pr))
+	if err != nil || n != size {
+		t.Fatalf("read %d bytes, %v", n, err)
+	}
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
@@ -0,0 +1,252 @@
+package dee
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+
+	"deadend-lab/pkg/common"
+	"golang.org/x/crypto/sha3"
+)
+
+// Combiner fuses the shared secrets of the hybrid components into the input
+// of the master secret.
+type Combiner byte
+
+const (
+	// CombinerConcat extracts ss_1 || ... || ss_n; the default. In the
+	// handshake the transcript salt binds it to every public key and
+	// ciphertext; on its own it binds none.
+	CombinerConcat Combiner = 0x01
+	// CombinerXWing is the X-Wing combiner generalized to any list:
+	// SHA3-256 over the post-quantum secrets, then ss || ct || pk of every
+	// classical component, then the X-Wing label.
+	CombinerXWing Combiner = 0x02
+	// CombinerCiphertextBound extracts the length-prefixed ss, ct and pk of
+	// every component.
+	CombinerCiphertextBound Combiner = 0x03
+	// CombinerXOR XORs the raw secrets and uses the result as the key, with
+	// no KDF and no transcript. Deliberately broken; NAIVE only.
+	CombinerXOR Combiner = 0x04
+)
+
+// xwingLabel is the X-Wing domain separator, the ASCII art "\.//^\".
+var xwingLabel = []byte{0x5c, 0x2e, 0x2f, 0x2f, 0x5e, 0x5c}
+
+var combinerNames = map[Combiner]string{
+	CombinerConcat:          "concat-extract",
+	CombinerXWing:           "X-Wing",
+	CombinerCiphertextBound: "ciphertext-bound",
+	CombinerXOR:             "XOR",
+}
+
+// Combiners lists every combiner, default first.
+func Combiners() []Combiner {
+	return []Combiner{CombinerConcat, CombinerXWing, CombinerCiphertextBound, CombinerXOR}
+}
+
+func (c Combiner) String() string {
+	if name, ok := combinerNames[c]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// WithCombiner selects how the component secrets are fused; the default is
+// CombinerConcat. Both peers must configure the same combiner; a non-default
+// one is announced in the init message and so bound into the transcript.
+// CombinerXOR is refused for SAFE.
+func WithCombiner(c Combiner) Option {
+	return func(cfg *config) {
+		cfg.combiner = c
+	}
+}
+
+func (c config) combinerOrDefault() Combiner {
+	if c.combiner == 0 {
+		return CombinerConcat
+	}
+	return c.combiner
+}
+
+// allowsCombiner reports whether the combiner may protect a session in mode.
+func allowsCombiner(c Combiner, mode Mode) bool {
+	return c != CombinerXOR || !mode.IsSafe()
+}
+
+// offeredCombiner returns the combiner an init message asks for.
+func offeredCombiner(initExts extensions) (Combiner, bool) {
+	v, ok := initExts[extCombiner]
+	if !ok {
+		return CombinerConcat, true
+	}
+	if len(v) != 1 {
+		return 0, false
+	}
+	return Combiner(v[0]), true
+}
+
+// combine fuses the component secrets. raw reports that the output is the key
+// itself rather than input to an extract.
+func combine(c Combiner, parts []kemPart) (ikm []byte, raw bool) {
+	switch c {
+	case CombinerXWing:
+		h := sha3.New256()
+		for _, p := range parts {
+			if kemSchemes[p.kem].postQuantum() {
+				h.Write(p.ss)
+			}
+		}
+		for _, p := range parts {
+			if !kemSchemes[p.kem].postQuantum() {
+				h.Write(p.ss)
+				h.Write(p.ct)
+				h.Write(p.pub)
+			}
+		}
+		h.Write(xwingLabel)
+		return h.Sum(nil), false
+	case CombinerCiphertextBound:
+		for _, p := range parts {
+			for _, field := range [][]byte{p.ss, p.ct, p.pub} {
+				ikm = binary.BigEndian.AppendUint16(ikm, uint16(len(field)))
+				ikm = append(ikm, field...)
+			}
+		}
+		return ikm, false
+	case CombinerXOR:
+		for _, p := range parts {
+			if len(p.ss) > len(ikm) {
+				ikm = append(ikm, make([]byte, len(p.ss)-len(ikm))...)
+			}
+			for i, b := range p.ss {
+				ikm[i] ^= b
+			}
+		}
+		return ikm, true
+	}
+	for _, p := range parts {
+		ikm = append(ikm, p.ss...)
+	}
+	return ikm, false
+}
+
+// fuse derives K_raw from the component secrets followed by the mixed-in
+// secrets (see mixedSecrets), salted with the transcript. Only a raw combiner
+// with nothing mixed in skips the extract.
+func (ks keySchedule) fuse(c Combiner, parts []kemPart, mixed, transcript []byte) []byte {
+	ikm, raw := combine(c, parts)
+	if raw && mixed == nil {
+		return ikm
+	}
+	return ks.extract(append(ikm, mixed...), transcript)
+}
+
+// HybridKEM is the handshake's component list and combiner as a standalone
+// KEM, for studying combiners outside the handshake: its key is the combiner
+// output extracted without a salt, so nothing but the combiner binds the
+// ciphertexts. Private keys are seeds: the component seeds concatenated in
+// list order. Public keys and ciphertexts are the component values
+// concatenated.
+type HybridKEM struct {
+	combiner Combiner
+	kems     []KEM
+}
+
+// NewHybridKEM returns the hybrid of kems under combiner c.
+func NewHybridKEM(c Combiner, kems ...KEM) (*HybridKEM, error) {
+	if _, ok := combinerNames[c]; !ok || len(kems) == 0 || !validKEMs(kems) {
+		return nil, ErrConfig
+	}
+	return &HybridKEM{combiner: c, kems: append([]KEM(nil), kems...)}, nil
+}
+
+// SeedSize returns the size of a private key seed.
+func (h *HybridKEM) SeedSize() int {
+	n := 0
+	for _, k := range h.kems {
+		n += k.SeedSize()
+	}
+	return n
+}
+
+// PublicKey returns the public key of the key pair derived from seed.
+func (h *HybridKEM) PublicKey(seed []byte) ([]byte, error) {
+	if len(seed) != h.SeedSize() {
+		return nil, ErrConfig
+	}
+	var pub []byte
+	for _, k := range h.kems {
+		p, err := k.PublicKey(seed[:k.SeedSize()])
+		if err != nil {
+			return nil, err
+		}
+		pub = append(pub, p...)
+		seed = seed[k.SeedSize():]
+	}
+	return pub, nil
+}
+
+// Encapsulate encapsulates to every component of pub and returns the
+// ciphertext and the combined key.
+func (h *HybridKEM) Encapsulate(pub []byte, randReader io.Reader) (ct, key []byte, err error) {
+	pubs, ok := split(pub, h.kems, KEM.PublicKeySize)
+	if !ok {
+		return nil, nil, ErrConfig
+	}
+	parts := make([]kemPart, len(h.kems))
+	for i, k := range h.kems {
+		c, ss, err := k.Encapsulate(pubs[i], randReader)
+		if err != nil {
+			return nil, nil, err
+		}
+		parts[i] = kemPart{kem: k, pub: pubs[i], ct: c, ss: ss}
+		ct = append(ct, c...)
+	}
+	return ct, h.key(parts), nil
+}
+
+// Decapsulate returns the combined key of ct under the key pair derived from
+// seed.
+func (h *HybridKEM) Decapsulate(seed, ct []byte) ([]byte, error) {
+	pub, err := h.PublicKey(seed)
+	if err != nil {
+		return nil, err
+	}
+	pubs, _ := split(pub, h.kems, KEM.PublicKeySize)
+	seeds, _ := split(seed, h.kems, KEM.SeedSize)
+	cts, ok := split(ct, h.kems, KEM.CiphertextSize)
+	if !ok {
+		return nil, ErrConfig
+	}
+	parts := make([]kemPart, len(h.kems))
+	for i, k := range h.kems {
+		ss, err := k.Decapsulate(seeds[i], cts[i])
+		if err != nil {
+			return nil, err
+		}
+		parts[i] = kemPart{kem: k, pub: pubs[i], ct: cts[i], ss: ss}
+	}
+	return h.key(parts), nil
+}
+
+func (h *HybridKEM) key(parts []kemPart) []byte {
+	ikm, raw := combine(h.combiner, parts)
+	if raw {
+		return ikm
+	}
+	return common.Extract(ikm, nil)
+}
+
+// split cuts b into one field per component, sized by size.
+func split(b []byte, kems []KEM, size func(KEM) int) ([][]byte, bool) {
+	out := make([][]byte, len(kems))
+	r := bytes.NewReader(b)
+	for i, k := range kems {
+		out[i] = make([]byte, size(k))
+		if _, err := io.ReadFull(r, out[i]); err != nil {
+			return nil, false
+		}
+	}
+	return out, r.Len() == 0
+}
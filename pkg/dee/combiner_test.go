@@ -0,0 +1,135 @@
+package dee
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+
+	"golang.org/x/crypto/sha3"
+)
+
+func TestCombinersHandshake(t *testing.T) {
+	for _, c := range Combiners() {
+		mode := Safe
+		if c == CombinerXOR {
+			mode = Naive
+		}
+		t.Run(c.String(), func(t *testing.T) {
+			opts := []Option{WithCombiner(c)}
+			initSession, respSession := sessionPair(t, mode, opts, opts)
+			sendFrames(t, initSession, respSession, 2)
+			sendFrames(t, respSession, initSession, 2)
+		})
+	}
+}
+
+func TestCombinerDefaultUnchanged(t *testing.T) {
+	initMsg, _, err := HandshakeInit(Safe, nil, WithCombiner(CombinerConcat))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(initMsg) != initFixedSize {
+		t.Fatal("the default combiner must not add an extension")
+	}
+}
+
+func TestCombinerMismatch(t *testing.T) {
+	initMsg, _, err := HandshakeInit(Safe, nil, WithCombiner(CombinerXWing))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := HandshakeResp(Safe, initMsg, nil); err != ErrHandshake {
+		t.Fatalf("default responder: got %v, want ErrHandshake", err)
+	}
+	if _, _, err := HandshakeResp(Safe, initMsg, nil, WithCombiner(CombinerCiphertextBound)); err != ErrHandshake {
+		t.Fatalf("other combiner: got %v, want ErrHandshake", err)
+	}
+}
+
+func TestCombinerXORNaiveOnly(t *testing.T) {
+	xor := WithCombiner(CombinerXOR)
+	if _, _, err := HandshakeInit(Safe, nil, xor); err != ErrConfig {
+		t.Fatalf("SAFE initiator: got %v, want ErrConfig", err)
+	}
+	if _, _, err := HandshakeInit(Naive, nil, xor, WithFallbackModes(Safe)); err != ErrConfig {
+		t.Fatalf("SAFE fallback: got %v, want ErrConfig", err)
+	}
+	// A responder must not let an XOR offer through into SAFE.
+	initMsg, _, err := HandshakeInit(Naive, nil, xor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	initMsg[1] = byte(Safe)
+	if _, _, err := HandshakeResp(Safe, initMsg, nil, xor); err != ErrHandshake {
+		t.Fatalf("SAFE responder: got %v, want ErrHandshake", err)
+	}
+	if _, _, err := HandshakeInit(Safe, nil, WithCombiner(0x7f)); err != ErrConfig {
+		t.Fatalf("unknown combiner: got %v, want ErrConfig", err)
+	}
+}
+
+func TestHybridKEMRoundTrip(t *testing.T) {
+	for _, c := range Combiners() {
+		for _, list := range [][]KEM{{KEMX25519, KEMMLKEM768}, {KEMX448, KEMMLKEM1024, KEMKyber768}} {
+			h, err := NewHybridKEM(c, list...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			seed := make([]byte, h.SeedSize())
+			rand.Read(seed)
+			pub, err := h.PublicKey(seed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ct, key, err := h.Encapsulate(pub, rand.Reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := h.Decapsulate(seed, ct)
+			if err != nil || !bytes.Equal(got, key) {
+				t.Fatalf("%v %s: decapsulation mismatch (%v)", c, kemName(list), err)
+			}
+			if _, err := h.Decapsulate(seed, ct[1:]); err != ErrConfig {
+				t.Fatalf("%v: short ciphertext: got %v", c, err)
+			}
+		}
+	}
+	if _, err := NewHybridKEM(CombinerXWing); err != ErrConfig {
+		t.Fatal("empty component list accepted")
+	}
+}
+
+// For X25519 + ML-KEM-768 the generalized combiner is exactly X-Wing's.
+func TestCombineXWingMatchesSpec(t *testing.T) {
+	x := kemPart{kem: KEMX25519, pub: []byte("pkX"), ct: []byte("ctX"), ss: []byte("ssX")}
+	m := kemPart{kem: KEMMLKEM768, pub: []byte("pkM"), ct: []byte("ctM"), ss: []byte("ssM")}
+	got, raw := combine(CombinerXWing, []kemPart{x, m})
+	want := sha3.Sum256([]byte("ssMssXctXpkX\\.//^\\"))
+	if raw || !bytes.Equal(got, want[:]) {
+		t.Fatalf("X-Wing: got %x want %x", got, want)
+	}
+}
+
+// Only the ciphertext-binding combiners notice a mauled X25519 ciphertext
+// whose top bit is ignored by the curve.
+func TestCombinerMauledX25519(t *testing.T) {
+	for _, c := range Combiners() {
+		h, _ := NewHybridKEM(c, KEMX25519, KEMMLKEM768)
+		seed := make([]byte, h.SeedSize())
+		rand.Read(seed)
+		pub, _ := h.PublicKey(seed)
+		ct, key, err := h.Encapsulate(pub, rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ct[x25519PubSize-1] ^= 0x80
+		got, err := h.Decapsulate(seed, ct)
+		if err != nil {
+			t.Fatal(err)
+		}
+		binds := c == CombinerXWing || c == CombinerCiphertextBound
+		if bytes.Equal(got, key) == binds {
+			t.Errorf("%v: mauled ciphertext gives the same key = %v", c, !binds)
+		}
+	}
+}
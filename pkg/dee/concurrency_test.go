@@ -0,0 +1,154 @@
+package dee
+
+import (
+	"sort"
+	"sync"
+	"testing"
+)
+
+// concurrentSeal has writers goroutines seal frames on from, one more send
+// key updates, and a last one open inbound on from, all at once. It returns
+// the frames sealed, sorted by counter, after checking that no counter was
+// used twice or skipped.
+func concurrentSeal(t *testing.T, from *Session, inbound [][]byte, writers, perWriter, keyUpdates int) [][]byte {
+	t.Helper()
+	var (
+		mu     sync.Mutex
+		frames [][]byte
+		wg     sync.WaitGroup
+	)
+	add := func(f []byte) {
+		mu.Lock()
+		frames = append(frames, f)
+		mu.Unlock()
+	}
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				f, err := from.EncryptToFrame([]byte("data"), nil)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				add(f)
+			}
+		}()
+	}
+	ratchet := from.ratchet != nil
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < keyUpdates; i++ {
+			f, err := from.RequestKeyUpdate()
+			if err == ErrDecrypt && ratchet {
+				continue // an offer of ours is unanswered
+			}
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			add(f)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for _, f := range inbound {
+			if _, err := from.DecryptFromFrame(f); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	wg.Wait()
+	if t.Failed() {
+		t.FailNow()
+	}
+
+	w := from.wire()
+	counter := func(f []byte) uint64 {
+		c, _, _ := w.parseHeader(f[:w.headerSize], &direction{})
+		return c
+	}
+	sort.Slice(frames, func(i, j int) bool { return counter(frames[i]) < counter(frames[j]) })
+	for i, f := range frames {
+		if c := counter(f); c != uint64(i) {
+			t.Fatalf("frame %d has counter %d: counters duplicated or skipped", i, c)
+		}
+	}
+	return frames
+}
+
+// Many goroutines seal on one session while it rekeys and opens frames from
+// the peer. Run with -race.
+func TestConcurrentSession(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		opts []Option
+	}{
+		{"strict", []Option{WithRekeyPolicy(RekeyPolicy{Messages: 16})}},
+		{"replay window", []Option{WithRekeyPolicy(RekeyPolicy{Messages: 16}), WithReplayWindow(MinReplayWindow)}},
+		{"hybrid ratchet", []Option{WithHybridRatchet(64)}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			initSession, respSession := sessionPair(t, Safe, tc.opts, tc.opts)
+			var inbound [][]byte
+			for i := 0; i < 100; i++ {
+				f, err := respSession.EncryptToFrame([]byte("data"), nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				inbound = append(inbound, f)
+			}
+			frames := concurrentSeal(t, initSession, inbound, 8, 100, 20)
+			for _, f := range frames {
+				if _, err := respSession.DecryptFromFrame(f); err != nil {
+					t.Fatal(err)
+				}
+			}
+			sendFrames(t, respSession, initSession, 2)
+			sendFrames(t, initSession, respSession, 2)
+			if initSession.ratchet == nil && initSession.tx.epoch < 20 {
+				t.Fatalf("only %d epochs", initSession.tx.epoch)
+			}
+			if initSession.ratchet != nil && initSession.RatchetSteps() == 0 {
+				t.Fatal("no ratchet step completed")
+			}
+		})
+	}
+}
+
+// Close may race with sealing and opening; calls after it fail cleanly.
+func TestConcurrentClose(t *testing.T) {
+	initSession, respSession := sessionPair(t, Safe, nil, nil)
+	frame, err := respSession.EncryptToFrame([]byte("data"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				if _, err := initSession.Encrypt([]byte("data"), nil); err != nil && err != ErrDecrypt {
+					t.Error(err)
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				initSession.DecryptFromFrame(frame)
+				initSession.SessionID()
+				initSession.RatchetSteps()
+			}
+		}()
+	}
+	initSession.Close()
+	wg.Wait()
+	if _, err := initSession.Encrypt([]byte("data"), nil); err != ErrDecrypt {
+		t.Fatalf("Encrypt after Close: got %v, want ErrDecrypt", err)
+	}
+}
@@ -0,0 +1,25 @@
+package dee
+
+import "deadend-lab/pkg/common"
+
+// finishedSize is the length of a Finished MAC.
+const finishedSize = 32
+
+// confirmKey derives the key-confirmation key from the master secret. It is
+// used only for the Finished MACs, never for traffic.
+func (ks keySchedule) confirmKey(kMs []byte) []byte {
+	return ks.expand(kMs, common.LabelConfirm, 32)
+}
+
+// finished is the Finished MAC a peer sends in its handshake message of type
+// typ (HandshakeTypeResp or HandshakeTypeFinish), so the two directions never
+// share a MAC.
+func (ks keySchedule) finished(kConfirm []byte, typ byte, transcript []byte) []byte {
+	input := append([]byte{typ}, transcript...)
+	return common.HMACTruncate(ks.params().hash, kConfirm, input, finishedSize)
+}
+
+// checkFinished reports whether got is the expected Finished MAC.
+func (ks keySchedule) checkFinished(kConfirm []byte, typ byte, transcript, got []byte) bool {
+	return len(got) == finishedSize && common.EqualConstantTime(got, ks.finished(kConfirm, typ, transcript))
+}
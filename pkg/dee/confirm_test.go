@@ -0,0 +1,90 @@
+package dee
+
+import "testing"
+
+// A corrupted Kyber ciphertext decapsulates to a pseudorandom secret (implicit
+// rejection); key confirmation turns that into a handshake failure.
+func TestConfirmCorruptedCiphertext(t *testing.T) {
+	initMsg, initSession, err := HandshakeInit(Safe, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	respMsg, _, err := HandshakeResp(Safe, initMsg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	respMsg[3+x25519PubSize] ^= 0x01
+	if err := initSession.HandshakeComplete(respMsg); err != ErrHandshake {
+		t.Fatalf("got %v, want ErrHandshake", err)
+	}
+	if initSession.established {
+		t.Fatal("initiator established without confirmation")
+	}
+}
+
+func TestConfirmResponderWaitsForFinished(t *testing.T) {
+	initMsg, initSession, _ := HandshakeInit(Safe, nil)
+	respMsg, respSession, _ := HandshakeResp(Safe, initMsg, nil)
+	if _, err := respSession.Encrypt([]byte("early"), nil); err != ErrDecrypt {
+		t.Fatalf("unconfirmed responder encrypted: %v", err)
+	}
+	if err := initSession.HandshakeComplete(respMsg); err != nil {
+		t.Fatal(err)
+	}
+	ct, _ := initSession.Encrypt([]byte("x"), nil)
+	if _, err := respSession.Decrypt(ct, respSession.WireHeader(0)); err != ErrDecrypt {
+		t.Fatalf("unconfirmed responder decrypted: %v", err)
+	}
+	finishHandshake(t, initSession, respSession)
+	if _, err := respSession.Decrypt(ct, respSession.WireHeader(0)); err != nil {
+		t.Fatalf("confirmed responder: %v", err)
+	}
+	finMsg, _ := initSession.HandshakeFinishMsg()
+	if err := respSession.HandshakeFinish(finMsg); err != ErrHandshake {
+		t.Fatalf("second Finished: got %v, want ErrHandshake", err)
+	}
+}
+
+func TestConfirmFinishedTampered(t *testing.T) {
+	finMAC := func(b []byte) []byte { return b[len(b)-finishedSize:] }
+	cases := []struct {
+		name   string
+		forge  func(resp, fin []byte) (respOut, finOut []byte)
+		atResp bool
+	}{
+		{"resp MAC flipped", func(r, f []byte) ([]byte, []byte) { finMAC(r)[0] ^= 1; return r, f }, true},
+		{"resp MAC stripped", func(r, f []byte) ([]byte, []byte) { return r[:respFixedSize], f }, true},
+		{"init MAC flipped", func(r, f []byte) ([]byte, []byte) { finMAC(f)[0] ^= 1; return r, f }, false},
+		{"init MAC stripped", func(r, f []byte) ([]byte, []byte) { return r, f[:finFixedSize] }, false},
+		{"resp MAC reflected", func(r, f []byte) ([]byte, []byte) {
+			return r, appendExtension([]byte{f[0], f[1], HandshakeTypeFinish}, extFinished, finMAC(r))
+		}, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			initMsg, initSession, _ := HandshakeInit(Safe, nil)
+			respMsg, respSession, _ := HandshakeResp(Safe, initMsg, nil)
+			if tc.atResp {
+				respMsg, _ = tc.forge(respMsg, nil)
+				if err := initSession.HandshakeComplete(respMsg); err != ErrHandshake {
+					t.Fatalf("got %v, want ErrHandshake", err)
+				}
+				return
+			}
+			if err := initSession.HandshakeComplete(respMsg); err != nil {
+				t.Fatal(err)
+			}
+			finMsg, err := initSession.HandshakeFinishMsg()
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, finMsg = tc.forge(respMsg, finMsg)
+			if err := respSession.HandshakeFinish(finMsg); err != ErrHandshake {
+				t.Fatalf("got %v, want ErrHandshake", err)
+			}
+			if respSession.established {
+				t.Fatal("responder established without confirmation")
+			}
+		})
+	}
+}
@@ -2,18 +2,50 @@ package dee
 
 const (
 	Version   = 0x01
+	Version2  = 0x02
 	ModeSafe  = 0x01
 	ModeNaive = 0x02
 
 	SessionIDSize = 32
-	NonceSize     = 12
+	NonceSize     = 12 // default suite; see Session.NonceSize
 	RekeyEvery    = 1000
 
-	HandshakeTypeInit = 0x01
-	HandshakeTypeResp = 0x02
+	HandshakeTypeInit   = 0x01
+	HandshakeTypeResp   = 0x02
+	HandshakeTypeFinish = 0x03
 
 	// Header: version(1) + mode(1) + session_id(32) + counter(8) + flags(2) = 44
 	HeaderSize = 44
 	// Frame: header + payload_len(4) = 48
 	FrameOverhead = 48
+	// v2 header adds an explicit epoch(4): 48; frame 52.
+	HeaderSizeV2    = 48
+	FrameOverheadV2 = 52
+
+	// Header flags. In v1 the high byte carries the low 8 bits of the sender's
+	// epoch; v2 has an explicit epoch field. FlagEarlyData marks replayable
+	// 0-RTT frames (EncryptEarly); session frames never carry it.
+	// FlagLastChunk ends a chunked stream (NewWriter) and FlagStream marks
+	// frames of a multiplexed stream (OpenStream); Decrypt rejects both.
+	FlagKeyUpdate      = 0x0001
+	FlagEarlyData      = 0x0002
+	FlagLastChunk      = 0x0004
+	FlagStream         = 0x0008
+	flagEpochShift     = 8
+	flagReservedMask   = 0x00fe
+	flagReservedMaskV2 = 0xfffe
+)
+
+// Replay window bounds for WithReplayWindow.
+const (
+	MinReplayWindow = 64
+	MaxReplayWindow = 1024
+
+	// maxEpochSkip bounds how many rekey epochs a receiver derives ahead for a
+	// single frame, so a forged far-future counter cannot force unbounded work.
+	maxEpochSkip = 16
+
+	// maxRetainedEpochs bounds the receive keys kept for reordered frames
+	// from earlier epochs.
+	maxRetainedEpochs = 32
 )
@@ -18,8 +18,8 @@ func TestHandshakeRoundtrip(t *testing.T) {
 	if err != nil {
 		t.Fatalf("HandshakeResp: %v", err)
 	}
-	if respSession == nil || !respSession.established {
-		t.Fatal("responder session should be established")
+	if respSession == nil || respSession.established {
+		t.Fatal("responder session should not be established before Finished")
 	}
 
 	if err := initSession.HandshakeComplete(respMsg); err != nil {
@@ -28,6 +28,10 @@ func TestHandshakeRoundtrip(t *testing.T) {
 	if !initSession.established {
 		t.Fatal("initiator session should be established")
 	}
+	finishHandshake(t, initSession, respSession)
+	if !respSession.established {
+		t.Fatal("responder session should be established")
+	}
 
 	if !bytes.Equal(initSession.SessionID(), respSession.SessionID()) {
 		t.Error("session IDs should match")
@@ -46,6 +50,7 @@ func TestEncryptDecryptRoundtrip(t *testing.T) {
 	if err := initSession.HandshakeComplete(respMsg); err != nil {
 		t.Fatalf("HandshakeComplete: %v", err)
 	}
+	finishHandshake(t, initSession, respSession)
 
 	plaintext := []byte("hello world")
 	ad := []byte("associated")
@@ -74,6 +79,7 @@ func TestEncryptDecryptBidirectional(t *testing.T) {
 	initMsg, initSession, _ := HandshakeInit(Safe, nil)
 	respMsg, respSession, _ := HandshakeResp(Safe, initMsg, nil)
 	_ = initSession.HandshakeComplete(respMsg)
+	finishHandshake(t, initSession, respSession)
 
 	msg1 := []byte("initiator to responder")
 	ct1, _ := initSession.Encrypt(msg1, nil)
@@ -96,6 +102,7 @@ func TestTamperFails(t *testing.T) {
 	initMsg, initSession, _ := HandshakeInit(Safe, nil)
 	respMsg, respSession, _ := HandshakeResp(Safe, initMsg, nil)
 	_ = initSession.HandshakeComplete(respMsg)
+	finishHandshake(t, initSession, respSession)
 
 	ct, _ := initSession.Encrypt([]byte("secret"), nil)
 	ct[16] ^= 0x01
@@ -113,6 +120,7 @@ func TestRekeyRatchet(t *testing.T) {
 	initMsg, initSession, _ := HandshakeInit(Safe, nil)
 	respMsg, respSession, _ := HandshakeResp(Safe, initMsg, nil)
 	_ = initSession.HandshakeComplete(respMsg)
+	finishHandshake(t, initSession, respSession)
 
 	for i := uint64(0); i < 8; i++ {
 		ct, err := initSession.Encrypt([]byte("msg"), nil)
@@ -140,6 +148,7 @@ func TestRekeyBoundary(t *testing.T) {
 	initMsg, initSession, _ := HandshakeInit(Safe, nil)
 	respMsg, respSession, _ := HandshakeResp(Safe, initMsg, nil)
 	_ = initSession.HandshakeComplete(respMsg)
+	finishHandshake(t, initSession, respSession)
 
 	// Send 7 messages so we cross boundary at 5: msgs 0-4 old key, 5-6 new key.
 	for i := uint64(0); i < 7; i++ {
@@ -167,6 +176,7 @@ func TestRekeyInterval32(t *testing.T) {
 	initMsg, initSession, _ := HandshakeInit(Safe, nil)
 	respMsg, respSession, _ := HandshakeResp(Safe, initMsg, nil)
 	_ = initSession.HandshakeComplete(respMsg)
+	finishHandshake(t, initSession, respSession)
 
 	for i := uint64(0); i < 70; i++ {
 		ct, err := initSession.Encrypt([]byte("x"), nil)
@@ -189,6 +199,7 @@ func TestReplayFailsSafe(t *testing.T) {
 	initMsg, initSession, _ := HandshakeInit(Safe, nil)
 	respMsg, respSession, _ := HandshakeResp(Safe, initMsg, nil)
 	_ = initSession.HandshakeComplete(respMsg)
+	finishHandshake(t, initSession, respSession)
 
 	ct, _ := initSession.Encrypt([]byte("once"), nil)
 	header := respSession.WireHeader(0)
@@ -203,3 +214,15 @@ func TestReplayFailsSafe(t *testing.T) {
 		t.Error("replay should fail in SAFE mode")
 	}
 }
+
+// finishHandshake sends the initiator's Finished message to the responder.
+func finishHandshake(t testing.TB, initSession, respSession *Session) {
+	t.Helper()
+	finMsg, err := initSession.HandshakeFinishMsg()
+	if err != nil {
+		t.Fatalf("HandshakeFinishMsg: %v", err)
+	}
+	if err := respSession.HandshakeFinish(finMsg); err != nil {
+		t.Fatalf("HandshakeFinish: %v", err)
+	}
+}
@@ -0,0 +1,70 @@
+package dee
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDirectionKeysSeparated(t *testing.T) {
+	initMsg, initSession, _ := HandshakeInit(Safe, nil)
+	respMsg, respSession, _ := HandshakeResp(Safe, initMsg, nil)
+	if err := initSession.HandshakeComplete(respMsg); err != nil {
+		t.Fatalf("HandshakeComplete: %v", err)
+	}
+	finishHandshake(t, initSession, respSession)
+
+	if !bytes.Equal(initSession.tx.keys.kAead, respSession.rx.keys.kAead) ||
+		!bytes.Equal(initSession.rx.keys.kAead, respSession.tx.keys.kAead) {
+		t.Fatal("peers must agree on per-direction keys")
+	}
+	for name, pair := range map[string][2][]byte{
+		"aead":  {initSession.tx.keys.kAead, initSession.rx.keys.kAead},
+		"nonce": {initSession.tx.keys.kNonce, initSession.rx.keys.kNonce},
+		"audit": {initSession.tx.keys.kAudit, initSession.rx.keys.kAudit},
+		"rekey": {initSession.tx.keys.kRekey, initSession.rx.keys.kRekey},
+	} {
+		if bytes.Equal(pair[0], pair[1]) {
+			t.Errorf("%s key shared between directions", name)
+		}
+	}
+
+	ad := []byte("ad")
+	for c := uint64(0); c < 64; c++ {
+		nI := initSession.ks.nonce(initSession.tx.keys.kNonce, initSession.sessionID, initSession.transcriptHash, c, ad)
+		nR := respSession.ks.nonce(respSession.tx.keys.kNonce, respSession.sessionID, respSession.transcriptHash, c, ad)
+		if bytes.Equal(nI, nR) {
+			t.Fatalf("counter %d: cross-direction nonce collision", c)
+		}
+	}
+}
+
+func TestDirectionCountersIndependent(t *testing.T) {
+	SetRekeyEveryForTest(3)
+	defer SetRekeyEveryForTest(0)
+
+	initMsg, initSession, _ := HandshakeInit(Safe, nil)
+	respMsg, respSession, _ := HandshakeResp(Safe, initMsg, nil)
+	_ = initSession.HandshakeComplete(respMsg)
+	finishHandshake(t, initSession, respSession)
+
+	// Initiator crosses a rekey boundary; responder's send direction must not move.
+	before := append([]byte(nil), respSession.tx.keys.kAead...)
+	for i := uint64(0); i < 5; i++ {
+		ct, _ := initSession.Encrypt([]byte("i2r"), nil)
+		if _, err := respSession.Decrypt(ct, respSession.WireHeader(i)); err != nil {
+			t.Fatalf("i2r %d: %v", i, err)
+		}
+	}
+	if !bytes.Equal(before, respSession.tx.keys.kAead) {
+		t.Error("receive-side rekey must not ratchet the send direction")
+	}
+	if respSession.tx.counter != 0 || respSession.rx.counter != 5 {
+		t.Errorf("counters: tx=%d rx=%d", respSession.tx.counter, respSession.rx.counter)
+	}
+
+	ct, _ := respSession.Encrypt([]byte("r2i"), nil)
+	pt, err := initSession.Decrypt(ct, initSession.WireHeader(0))
+	if err != nil || string(pt) != "r2i" {
+		t.Fatalf("r2i after i2r rekey: %v", err)
+	}
+}
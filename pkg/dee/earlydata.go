@@ -0,0 +1,179 @@
+package dee
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"time"
+
+	"deadend-lab/pkg/common"
+)
+
+// earlyIDSize is the size of an early-data identifier: the initiator's clock
+// in Unix milliseconds (8) and 16 random bytes.
+const earlyIDSize = 24
+
+// earlyKS is the key schedule of early data. The version and suite are not
+// negotiated yet when it is used, so it is fixed, like the PSK binder's.
+var earlyKS = keySchedule{version: Version, suite: SuiteChaCha20Poly1305}
+
+// WithEarlyData enables 0-RTT early data. An initiator announces it in the
+// init message and may then call EncryptEarly before the handshake completes;
+// it needs a resumption ticket (WithTicket) or the responder's KEM key
+// (WithPeerKEMKey), since the early keys come from those secrets alone. A
+// responder accepts early data only with a StrikeRegister (WithStrikeRegister)
+// or, as a lab weakness, in NAIVE mode without one. Refused early data does
+// not fail the handshake; see EarlyDataAccepted.
+//
+// Early data is replayable: an attacker can resend the first flight to the
+// responder, and it gets no forward secrecy against a later compromise of the
+// ticket or KEM key. Early frames carry FlagEarlyData.
+func WithEarlyData() Option {
+	return func(c *config) {
+		c.earlyData = true
+	}
+}
+
+// WithStrikeRegister makes a responder check each early-data identifier
+// against r before it accepts the early data that comes with it.
+func WithStrikeRegister(r *StrikeRegister) Option {
+	return func(c *config) {
+		c.strikes = r
+	}
+}
+
+// earlyState holds the early-data keys of one handshake: the initiator seals
+// with them and the responder opens.
+type earlyState struct {
+	w       *wireFormat
+	mode    byte
+	id      []byte // SHA-256 of the init body: header session ID and nonce binding
+	keys    trafficKeys
+	counter uint64
+}
+
+// newEarlyID returns a fresh early-data identifier.
+func newEarlyID(randReader io.Reader) ([]byte, error) {
+	id := binary.BigEndian.AppendUint64(make([]byte, 0, earlyIDSize), uint64(timeNow().UnixMilli()))
+	id = id[:earlyIDSize]
+	if _, err := io.ReadFull(randReader, id[8:]); err != nil {
+		return nil, err
+	}
+	return id, nil
+}
+
+// earlyIssued is the initiator's clock in an early-data identifier.
+func earlyIssued(id []byte) time.Time {
+	return time.UnixMilli(int64(binary.BigEndian.Uint64(id)))
+}
+
+// newEarlyState derives the early keys from secret and the init body, which
+// holds the early-data identifier.
+func newEarlyState(init handshakeMsg, secret []byte) *earlyState {
+	id := common.HashSHA256(init.body)
+	w, _ := wireFor(init.version)
+	kEarly := earlyKS.expand(earlyKS.extract(secret, id), common.LabelEarly, 32)
+	return &earlyState{w: w, mode: init.mode, id: id, keys: earlyKS.trafficKeys(kEarly)}
+}
+
+func (e *earlyState) header() []byte {
+	return e.w.buildHeader(e.mode, e.id, e.counter, 0, FlagEarlyData)
+}
+
+func (e *earlyState) seal(plaintext, ad []byte) ([]byte, error) {
+	aead, err := earlyKS.newAEAD(e.keys.kAead)
+	if err != nil {
+		return nil, err
+	}
+	header := e.header()
+	nonce := earlyKS.nonce(e.keys.kNonce, e.id, e.id, e.counter, ad)
+	ct := aead.Seal(nil, nonce, plaintext, append(append([]byte(nil), header...), ad...))
+	e.counter++
+	return buildFrame(header, ct), nil
+}
+
+// open accepts only the next frame in order.
+func (e *earlyState) open(frame, ad []byte) ([]byte, error) {
+	hs := e.w.headerSize
+	if len(frame) < hs+4 {
+		return nil, ErrDecrypt
+	}
+	header := frame[:hs]
+	payloadLen := binary.BigEndian.Uint32(frame[hs : hs+4])
+	if uint64(len(frame)) != uint64(hs+4)+uint64(payloadLen) || !bytes.Equal(header, e.header()) {
+		return nil, ErrDecrypt
+	}
+	aead, err := earlyKS.newAEAD(e.keys.kAead)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	nonce := earlyKS.nonce(e.keys.kNonce, e.id, e.id, e.counter, ad)
+	plaintext, err := aead.Open(nil, nonce, frame[hs+4:], append(append([]byte(nil), header...), ad...))
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	e.counter++
+	return plaintext, nil
+}
+
+// EncryptEarly returns an early-data frame. It is for an initiator with
+// WithEarlyData, between HandshakeInit and HandshakeComplete; the frames go
+// out with or right after the init message, in order.
+func (s *Session) EncryptEarly(plaintext, ad []byte) ([]byte, error) {
+	defer s.lockTx()()
+	if s.established || s.earlyTx == nil {
+		return nil, ErrConfig
+	}
+	return s.earlyTx.seal(plaintext, ad)
+}
+
+// DecryptEarly opens the next early-data frame on a responder that accepted
+// early data. It works as soon as HandshakeResp returns. Anything else,
+// including early data the responder refused, fails with ErrDecrypt.
+func (s *Session) DecryptEarly(frame, ad []byte) ([]byte, error) {
+	defer s.lockRx()()
+	if s.earlyRx == nil {
+		return nil, ErrDecrypt
+	}
+	return s.earlyRx.open(frame, ad)
+}
+
+// EarlyDataAccepted reports whether the responder accepted the initiator's
+// early data. On the initiator it is known once HandshakeComplete succeeds;
+// refused early data must be sent again over the session.
+func (s *Session) EarlyDataAccepted() bool {
+	defer s.lockBoth()()
+	return s.earlyRx != nil || s.earlyAccepted
+}
+
+// earlyPSK is the part of psk that goes into the early secret: a ticket's PSK
+// counts, a configured one does not. Both peers apply the same rule, so they
+// derive the same early keys.
+func (c config) earlyPSK(psk []byte) []byte {
+	if !c.isResumed() {
+		return nil
+	}
+	return psk
+}
+
+// acceptEarlyData returns the responder's early-data state for init, or nil
+// when there is no early data or the responder refuses it. psk counts only
+// when it came from a ticket.
+func acceptEarlyData(mode Mode, init handshakeMsg, cfg config, psk, authSecret []byte) *earlyState {
+	id, ok := init.exts[extEarlyData]
+	if !ok || !cfg.earlyData {
+		return nil
+	}
+	secret := mixedSecrets(cfg.earlyPSK(psk), authSecret)
+	if secret == nil {
+		return nil
+	}
+	if cfg.strikes == nil {
+		if mode.IsSafe() {
+			return nil
+		}
+	} else if !cfg.strikes.Check(id, earlyIssued(id)) {
+		return nil
+	}
+	return newEarlyState(init, secret)
+}
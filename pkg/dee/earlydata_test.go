@@ -0,0 +1,222 @@
+package dee
+
+import (
+	"testing"
+	"time"
+)
+
+// earlyFlight starts a handshake with early data and returns the init message
+// and the early frames carrying msgs.
+func earlyFlight(t *testing.T, mode Mode, opts []Option, msgs ...string) ([]byte, *Session, [][]byte) {
+	t.Helper()
+	initMsg, initSession, err := HandshakeInit(mode, nil, append([]Option{WithEarlyData()}, opts...)...)
+	if err != nil {
+		t.Fatalf("HandshakeInit: %v", err)
+	}
+	var frames [][]byte
+	for _, m := range msgs {
+		frame, err := initSession.EncryptEarly([]byte(m), nil)
+		if err != nil {
+			t.Fatalf("EncryptEarly: %v", err)
+		}
+		frames = append(frames, frame)
+	}
+	return initMsg, initSession, frames
+}
+
+func TestEarlyData(t *testing.T) {
+	server := newTestKEMIdentity(t)
+	keys := newTestTicketKeys(t, time.Hour, time.Hour)
+	for name, tc := range map[string]struct{ initOpts, respOpts func(t *testing.T) []Option }{
+		"kem key": {
+			func(t *testing.T) []Option { return []Option{WithPeerKEMKey(server.PublicKey())} },
+			func(t *testing.T) []Option { return []Option{WithKEMIdentity(server)} },
+		},
+		// A configured PSK is not part of the early secret.
+		"psk and kem key": {
+			func(t *testing.T) []Option {
+				return []Option{WithPSK(testPSKID, testPSK), WithPeerKEMKey(server.PublicKey())}
+			},
+			func(t *testing.T) []Option { return []Option{WithPSK(testPSKID, testPSK), WithKEMIdentity(server)} },
+		},
+		"ticket": {
+			func(t *testing.T) []Option { return []Option{WithTicket(issueTicket(t, keys))} },
+			func(t *testing.T) []Option { return ticketServer(keys) },
+		},
+		"ticket psk only": {
+			func(t *testing.T) []Option { return []Option{WithTicket(issueTicket(t, keys)), WithPSKOnly()} },
+			func(t *testing.T) []Option { return ticketServer(keys) },
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			strikes, err := NewStrikeRegister(10 * time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			initMsg, initSession, frames := earlyFlight(t, Safe, tc.initOpts(t), "GET /a", "GET /b")
+			respMsg, respSession, err := HandshakeResp(Safe, initMsg, nil, append(tc.respOpts(t), WithEarlyData(), WithStrikeRegister(strikes))...)
+			if err != nil {
+				t.Fatalf("HandshakeResp: %v", err)
+			}
+			if !respSession.EarlyDataAccepted() {
+				t.Fatal("responder refused early data")
+			}
+			for i, want := range []string{"GET /a", "GET /b"} {
+				got, err := respSession.DecryptEarly(frames[i], nil)
+				if err != nil || string(got) != want {
+					t.Fatalf("early frame %d: %q, %v", i, got, err)
+				}
+			}
+			if err := initSession.HandshakeComplete(respMsg); err != nil {
+				t.Fatal(err)
+			}
+			finishHandshake(t, initSession, respSession)
+			if !initSession.EarlyDataAccepted() {
+				t.Fatal("initiator did not see the acceptance")
+			}
+			if _, err := initSession.EncryptEarly([]byte("late"), nil); err != ErrConfig {
+				t.Fatalf("EncryptEarly after the handshake: got %v, want ErrConfig", err)
+			}
+			sendFrames(t, initSession, respSession, 2)
+		})
+	}
+}
+
+// Early frames are flagged, in order, and kept apart from session frames.
+func TestEarlyDataFrames(t *testing.T) {
+	server := newTestKEMIdentity(t)
+	strikes, err := NewStrikeRegister(10 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	initMsg, initSession, frames := earlyFlight(t, Safe, []Option{WithPeerKEMKey(server.PublicKey())}, "one", "two")
+	respMsg, respSession, err := HandshakeResp(Safe, initMsg, nil, WithKEMIdentity(server), WithEarlyData(), WithStrikeRegister(strikes))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flags := frames[0][42:44]; flags[1]&FlagEarlyData == 0 {
+		t.Fatalf("early frame flags %x", flags)
+	}
+	if _, err := respSession.DecryptEarly(frames[1], nil); err != ErrDecrypt {
+		t.Fatalf("out-of-order early frame: got %v, want ErrDecrypt", err)
+	}
+	tampered := append([]byte(nil), frames[0]...)
+	tampered[len(tampered)-1] ^= 1
+	if _, err := respSession.DecryptEarly(tampered, nil); err != ErrDecrypt {
+		t.Fatalf("tampered early frame: got %v, want ErrDecrypt", err)
+	}
+	if _, err := respSession.DecryptEarly(frames[0], []byte("ad")); err != ErrDecrypt {
+		t.Fatalf("early frame with other AD: got %v, want ErrDecrypt", err)
+	}
+	if _, err := respSession.DecryptEarly(frames[0], nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := respSession.DecryptEarly(frames[0], nil); err != ErrDecrypt {
+		t.Fatalf("repeated early frame: got %v, want ErrDecrypt", err)
+	}
+	if err := initSession.HandshakeComplete(respMsg); err != nil {
+		t.Fatal(err)
+	}
+	finishHandshake(t, initSession, respSession)
+	if _, err := respSession.DecryptFromFrame(frames[1]); err != ErrDecrypt {
+		t.Fatalf("early frame as session frame: got %v, want ErrDecrypt", err)
+	}
+}
+
+// A replayed first flight completes a handshake, but its early data is
+// refused the second time.
+func TestEarlyDataReplay(t *testing.T) {
+	server := newTestKEMIdentity(t)
+	strikes, err := NewStrikeRegister(10 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	initMsg, _, frames := earlyFlight(t, Safe, []Option{WithPeerKEMKey(server.PublicKey())}, "pay 100")
+	respOpts := []Option{WithKEMIdentity(server), WithEarlyData(), WithStrikeRegister(strikes)}
+	if _, first, err := HandshakeResp(Safe, initMsg, nil, respOpts...); err != nil || !first.EarlyDataAccepted() {
+		t.Fatalf("first flight: accepted=%v, %v", first != nil && first.EarlyDataAccepted(), err)
+	}
+	_, replay, err := HandshakeResp(Safe, initMsg, nil, respOpts...)
+	if err != nil {
+		t.Fatalf("replayed handshake: %v", err)
+	}
+	if replay.EarlyDataAccepted() {
+		t.Fatal("strike register let a replay through")
+	}
+	if _, err := replay.DecryptEarly(frames[0], nil); err != ErrDecrypt {
+		t.Fatalf("replayed early frame: got %v, want ErrDecrypt", err)
+	}
+
+	// Without a register, NAIVE accepts the replay; SAFE refuses early data.
+	naiveOpts := []Option{WithKEMIdentity(server), WithEarlyData()}
+	initMsg, _, frames = earlyFlight(t, Naive, []Option{WithPeerKEMKey(server.PublicKey())}, "pay 100")
+	for i := 0; i < 2; i++ {
+		_, s, err := HandshakeResp(Naive, initMsg, nil, naiveOpts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := s.DecryptEarly(frames[0], nil); err != nil || string(got) != "pay 100" {
+			t.Fatalf("NAIVE without register, delivery %d: %q, %v", i, got, err)
+		}
+	}
+	initMsg, _, _ = earlyFlight(t, Safe, []Option{WithPeerKEMKey(server.PublicKey())})
+	if _, s, err := HandshakeResp(Safe, initMsg, nil, naiveOpts...); err != nil || s.EarlyDataAccepted() {
+		t.Fatalf("SAFE without register: accepted=%v, %v", s != nil && s.EarlyDataAccepted(), err)
+	}
+}
+
+// Refused early data leaves a working handshake.
+func TestEarlyDataRefused(t *testing.T) {
+	server := newTestKEMIdentity(t)
+	for name, respOpts := range map[string][]Option{
+		"responder without early data": {WithKEMIdentity(server)},
+		"SAFE without strike register": {WithKEMIdentity(server), WithEarlyData()},
+	} {
+		t.Run(name, func(t *testing.T) {
+			initMsg, initSession, frames := earlyFlight(t, Safe, []Option{WithPeerKEMKey(server.PublicKey())}, "hello")
+			respMsg, respSession, err := HandshakeResp(Safe, initMsg, nil, respOpts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := respSession.DecryptEarly(frames[0], nil); err != ErrDecrypt {
+				t.Fatalf("refused early data: got %v, want ErrDecrypt", err)
+			}
+			if err := initSession.HandshakeComplete(respMsg); err != nil {
+				t.Fatal(err)
+			}
+			finishHandshake(t, initSession, respSession)
+			if initSession.EarlyDataAccepted() {
+				t.Fatal("initiator thinks early data was accepted")
+			}
+		})
+	}
+
+	// The register refuses identifiers from outside its window.
+	now := time.Now()
+	SetTimeNowForTest(func() time.Time { return now })
+	defer SetTimeNowForTest(nil)
+	strikes, err := NewStrikeRegister(10 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(time.Minute)
+	initMsg, _, _ := earlyFlight(t, Safe, []Option{WithPeerKEMKey(server.PublicKey())})
+	now = now.Add(11 * time.Second)
+	if _, s, err := HandshakeResp(Safe, initMsg, nil, WithKEMIdentity(server), WithEarlyData(), WithStrikeRegister(strikes)); err != nil || s.EarlyDataAccepted() {
+		t.Fatalf("stale early data: accepted=%v, %v", s != nil && s.EarlyDataAccepted(), err)
+	}
+}
+
+func TestEarlyDataConfig(t *testing.T) {
+	if _, _, err := HandshakeInit(Safe, nil, WithEarlyData()); err != ErrConfig {
+		t.Fatalf("early data without ticket or KEM key: got %v, want ErrConfig", err)
+	}
+	_, s, err := HandshakeInit(Safe, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.EncryptEarly([]byte("x"), nil); err != ErrConfig {
+		t.Fatalf("EncryptEarly without WithEarlyData: got %v, want ErrConfig", err)
+	}
+
+}
@@ -1,6 +1,17 @@
 package dee
 
+import "time"
+
 // SetRekeyEveryForTest sets rekey interval for testing. Call with 0 to reset.
 func SetRekeyEveryForTest(n uint64) {
 	rekeyEveryForTest = n
 }
+
+// SetTimeNowForTest replaces the clock used by time-based rekey policies. Call
+// with nil to restore time.Now.
+func SetTimeNowForTest(now func() time.Time) {
+	if now == nil {
+		now = time.Now
+	}
+	timeNow = now
+}
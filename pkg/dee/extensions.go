@@ -0,0 +1,75 @@
+package dee
+
+import "encoding/binary"
+
+// Handshake extensions follow the fixed part of a handshake message as
+// type(1) || len(2) || value. A parser that only knows the fixed layout ignores
+// them, so a handshake without extensions is byte-for-byte the original one.
+//
+// Types below extTrailer are part of the message body and bound into the
+// transcript. Types from extTrailer up authenticate the transcript (signatures,
+// MACs), so they must come last and are left out of it.
+const (
+	extIdentity  = 0x01 // sender's public identity key (see Identity)
+	extModes     = 0x02 // initiator's supported modes, most preferred first
+	extVersions  = 0x03 // initiator's supported protocol versions
+	extSuites    = 0x04 // initiator's AEAD suite offer; responder's choice
+	extCombiner  = 0x05 // initiator's non-default KEM combiner
+	extPSK       = 0x06 // initiator's PSK identifier
+	extPSKNonce  = 0x07 // fresh nonce of a PSK-only handshake message
+	extPAKE      = 0x08 // sender's CPace share
+	extSASCommit = 0x09 // initiator's commitment to its SAS nonce
+	extSASNonce  = 0x0a // responder's SAS nonce; initiator's reveal in Finish
+	extKEMAuth   = 0x0b // initiator's encapsulation to the responder's KEMIdentity
+	extEarlyData = 0x0c // initiator's early-data identifier; responder's acceptance
+
+	extTrailer   = 0x80
+	extSignature = 0x81 // hybrid signature over the transcript
+	extFinished  = 0x82 // key-confirmation MAC over the transcript
+	extPSKBinder = 0x83 // initiator's proof of PSK knowledge over the init body
+)
+
+const extHeaderSize = 3
+
+type extensions map[byte][]byte
+
+func appendExtension(b []byte, typ byte, value []byte) []byte {
+	var hdr [extHeaderSize]byte
+	hdr[0] = typ
+	binary.BigEndian.PutUint16(hdr[1:], uint16(len(value)))
+	b = append(b, hdr[:]...)
+	return append(b, value...)
+}
+
+// parseExtensions reads the extensions after the first fixed bytes of msg and
+// returns the body (everything before the first trailer extension). Duplicate
+// types, trailer extensions followed by body ones and truncated entries are
+// rejected; unknown types are skipped.
+func parseExtensions(msg []byte, fixed int) (body []byte, exts extensions, err error) {
+	if len(msg) < fixed {
+		return nil, nil, ErrHandshake
+	}
+	exts = extensions{}
+	bodyEnd := len(msg)
+	for off := fixed; off < len(msg); {
+		if len(msg)-off < extHeaderSize {
+			return nil, nil, ErrHandshake
+		}
+		typ := msg[off]
+		n := int(binary.BigEndian.Uint16(msg[off+1:]))
+		if len(msg)-off-extHeaderSize < n {
+			return nil, nil, ErrHandshake
+		}
+		if _, dup := exts[typ]; dup {
+			return nil, nil, ErrHandshake
+		}
+		if typ >= extTrailer && bodyEnd == len(msg) {
+			bodyEnd = off
+		} else if typ < extTrailer && bodyEnd != len(msg) {
+			return nil, nil, ErrHandshake
+		}
+		exts[typ] = msg[off+extHeaderSize : off+extHeaderSize+n]
+		off += extHeaderSize + n
+	}
+	return msg[:bodyEnd], exts, nil
+}
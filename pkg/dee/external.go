@@ -0,0 +1,47 @@
+package dee
+
+import (
+	"crypto/rand"
+	"io"
+
+	"deadend-lab/pkg/common"
+)
+
+// NewSession returns an established session keyed by a handshake run outside
+// this package, such as pkg/dee/noise. secret is the key material both peers
+// derived and handshakeHash the 32-byte hash that binds the whole exchange; it
+// becomes the session ID and transcript hash. The master secret is
+// HKDF-Extract(handshakeHash, secret) expanded under the master label, so each
+// handshake owns its own traffic keys.
+//
+// Nothing is negotiated: both peers must pass the same mode and options. The
+// session uses the highest configured version and the first configured suite.
+// Options that only shape the built-in handshake (signature and KEM identities,
+// pinned peer KEM keys, PSKs, passwords, SAS, KEMs, combiners, fallback modes,
+// tickets, early data) fail with ErrConfig.
+func NewSession(mode Mode, secret, handshakeHash []byte, isInitiator bool, randReader io.Reader, opts ...Option) (*Session, error) {
+	if randReader == nil {
+		randReader = rand.Reader
+	}
+	cfg, err := newConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.identity != nil || cfg.verifier != nil || cfg.kemIdentity != nil || cfg.peerKEMKey != nil || cfg.psks != nil || cfg.pskOnly || cfg.password != nil || cfg.sas ||
+		cfg.kems != nil || cfg.combiner != 0 || cfg.fallbackModes != nil || cfg.ticket != nil || cfg.ticketKeys != nil ||
+		cfg.earlyData || cfg.strikes != nil {
+		return nil, ErrConfig
+	}
+	if (mode != Safe && mode != Naive) || len(secret) < 32 || len(handshakeHash) != SessionIDSize {
+		return nil, ErrConfig
+	}
+	ks := keySchedule{version: cfg.supportedVersions()[0], suite: cfg.supportedSuites()[0]}
+	kMs := ks.expand(ks.extract(secret, handshakeHash), common.LabelMaster, 32)
+	s, err := newSessionFromKeys(mode, ks, handshakeHash, handshakeHash, kMs, isInitiator, cfg)
+	if err != nil {
+		return nil, err
+	}
+	s.rand = randReader
+	s.established = true
+	return s, nil
+}
@@ -0,0 +1,64 @@
+package dee
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestNewSession(t *testing.T) {
+	secret := bytes.Repeat([]byte{1}, 64)
+	hash := bytes.Repeat([]byte{2}, SessionIDSize)
+	opts := []Option{WithSuites(SuiteAES256GCM), WithVersions(Version2)}
+	initSession, err := NewSession(Safe, secret, hash, true, nil, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	respSession, err := NewSession(Safe, secret, hash, false, nil, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if initSession.Suite() != SuiteAES256GCM || initSession.Version() != Version2 {
+		t.Fatalf("suite %v version %d", initSession.Suite(), initSession.Version())
+	}
+	sendFrames(t, initSession, respSession, 2)
+	sendFrames(t, respSession, initSession, 2)
+
+	// The handshake hash is mixed into the master secret.
+	other, err := NewSession(Safe, secret, bytes.Repeat([]byte{3}, SessionIDSize), false, nil, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(other.rx.keys.kAead, respSession.rx.keys.kAead) {
+		t.Fatal("different handshake hashes must give different keys")
+	}
+}
+
+func TestNewSessionConfig(t *testing.T) {
+	secret := bytes.Repeat([]byte{1}, 32)
+	hash := bytes.Repeat([]byte{2}, SessionIDSize)
+	id := newTestIdentity(t)
+	kemID := newTestKEMIdentity(t)
+	cases := []struct {
+		name   string
+		mode   Mode
+		secret []byte
+		hash   []byte
+		opts   []Option
+	}{
+		{"short secret", Safe, secret[:16], hash, nil},
+		{"short hash", Safe, secret, hash[:16], nil},
+		{"bad mode", 0, secret, hash, nil},
+		{"identity", Safe, secret, hash, []Option{WithIdentity(id)}},
+		{"psk", Safe, secret, hash, []Option{WithPSK([]byte("id"), secret)}},
+		{"password", Safe, secret, hash, []Option{WithPassword([]byte("pw"))}},
+		{"sas", Safe, secret, hash, []Option{WithSAS()}},
+		{"kem identity", Safe, secret, hash, []Option{WithKEMIdentity(kemID)}},
+		{"peer kem key", Safe, secret, hash, []Option{WithPeerKEMKey(kemID.PublicKey())}},
+		{"kems", Safe, secret, hash, []Option{WithKEMs(KEMX25519), WithVersions(Version2)}},
+	}
+	for _, tc := range cases {
+		if _, err := NewSession(tc.mode, tc.secret, tc.hash, true, nil, tc.opts...); err != ErrConfig {
+			t.Errorf("%s: got %v, want ErrConfig", tc.name, err)
+		}
+	}
+}
@@ -22,6 +22,7 @@ func FuzzDEERoundtrip(f *testing.F) {
 		if err := initSession.HandshakeComplete(respMsg); err != nil {
 			t.Skip()
 		}
+		finishHandshake(t, initSession, respSession)
 		ct, err := initSession.Encrypt(plaintext, ad)
 		if err != nil {
 			t.Fatalf("Encrypt: %v", err)
@@ -47,6 +48,7 @@ func FuzzTamper(f *testing.F) {
 		initMsg, initSession, _ := HandshakeInit(Safe, nil)
 		respMsg, respSession, _ := HandshakeResp(Safe, initMsg, nil)
 		_ = initSession.HandshakeComplete(respMsg)
+		finishHandshake(t, initSession, respSession)
 		ct, err := initSession.Encrypt(plaintext, nil)
 		if err != nil {
 			t.Skip()
@@ -73,6 +75,7 @@ func FuzzReplayRejected(f *testing.F) {
 		initMsg, initSession, _ := HandshakeInit(Safe, nil)
 		respMsg, respSession, _ := HandshakeResp(Safe, initMsg, nil)
 		_ = initSession.HandshakeComplete(respMsg)
+		finishHandshake(t, initSession, respSession)
 		ct, err := initSession.Encrypt(plaintext, nil)
 		if err != nil {
 			t.Skip()
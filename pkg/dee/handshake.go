@@ -3,6 +3,7 @@ package dee
 import (
 	"crypto/ecdh"
 	"crypto/rand"
+	"crypto/sha256"
 	"errors"
 	"io"
 
@@ -15,266 +16,475 @@ const (
 	kyberPubSize  = kyber768.PublicKeySize
 	kyberCtSize   = kyber768.CiphertextSize
 	kyberSSSize   = kyber768.SharedKeySize
+
+	// v1 fixed message sizes.
+	initFixedSize = 3 + x25519PubSize + kyberPubSize
+	respFixedSize = 3 + x25519PubSize + kyberCtSize
+	finFixedSize  = 3
 )
 
 var (
 	ErrHandshake = errors.New("handshake failed")
 )
 
-// HandshakeInit starts a handshake as initiator. Sends X25519 pub + Kyber pub.
-// Responder will encapsulate to Kyber pub and send ciphertext.
-func HandshakeInit(mode Mode, randReader io.Reader) (initMsg []byte, session *Session, err error) {
+// HandshakeInit starts a handshake as initiator. Sends a public key for every
+// hybrid component (by default X25519 + Kyber768); the responder encapsulates
+// to each and sends the ciphertexts.
+func HandshakeInit(mode Mode, randReader io.Reader, opts ...Option) (initMsg []byte, session *Session, err error) {
 	if randReader == nil {
 		randReader = rand.Reader
 	}
-
-	curve := ecdh.X25519()
-	xPriv, err := curve.GenerateKey(randReader)
+	cfg, err := newConfig(opts)
 	if err != nil {
 		return nil, nil, err
 	}
-	xPub := xPriv.PublicKey().Bytes()
+	return newInitiator(mode, randReader, cfg)
+}
 
-	kyberPk, kyberSk, err := kyber768.GenerateKeyPair(randReader)
+// HandshakeResp answers initMsg as responder. Encapsulates to every public key
+// in initMsg and appends a Finished MAC over the transcript. The session is
+// established once HandshakeFinish has checked the initiator's Finished
+// message.
+func HandshakeResp(mode Mode, initMsg []byte, randReader io.Reader, opts ...Option) (respMsg []byte, session *Session, err error) {
+	if randReader == nil {
+		randReader = rand.Reader
+	}
+	cfg, err := newConfig(opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	cfg, err = respondConfig(cfg, initMsg)
 	if err != nil {
 		return nil, nil, err
 	}
-	kyberPubBytes := make([]byte, kyberPubSize)
-	kyberPk.Pack(kyberPubBytes)
-
-	initMsg = buildHandshakeInitMsg(Version, byte(mode), xPub, kyberPubBytes)
 
-	session = &Session{
-		mode:      mode,
-		xPriv:     xPriv,
-		initMsg:   initMsg,
-		kyberPriv: kyberSk,
+	init, mode, ks, psk, err := respondTo(mode, initMsg, cfg)
+	if err != nil {
+		return nil, nil, err
 	}
-	return initMsg, session, nil
+	parts, err := encapsulateAll(init.kems, init.shares, randReader, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	respMsg = buildHandshakeRespMsg(ks.version, byte(mode), init.kems, partCiphertexts(parts))
+	return handshakeRespFinish(mode, ks, initMsg, init, respMsg, parts, psk, randReader, cfg)
 }
 
-// HandshakeResp completes handshake as responder. Encapsulates to init's Kyber pub.
-func HandshakeResp(mode Mode, initMsg []byte, randReader io.Reader) (respMsg []byte, session *Session, err error) {
-	if randReader == nil {
-		randReader = rand.Reader
+// HandshakeInitDeterministic is like HandshakeInit but draws every key
+// generation seed from drbg (X25519 via NewPrivateKey, Kyber/ML-KEM via
+// NewKeyFromSeed), so the handshake is fully deterministic. Used only for
+// vector generation.
+func HandshakeInitDeterministic(mode Mode, drbg io.Reader, opts ...Option) ([]byte, *Session, error) {
+	cfg, err := newConfig(opts)
+	if err != nil {
+		return nil, nil, err
 	}
+	return newInitiator(mode, drbg, cfg)
+}
 
-	ver, m, xPubInit, kyberPubInit, err := parseHandshakeInitMsg(initMsg)
-	if err != nil || ver != Version || m != byte(mode) {
-		return nil, nil, ErrHandshake
+// HandshakeRespDeterministic is like HandshakeResp but deterministic: encSeed
+// seeds the first post-quantum encapsulation and drbg everything else.
+func HandshakeRespDeterministic(mode Mode, initMsg []byte, drbg io.Reader, encSeed []byte, opts ...Option) ([]byte, *Session, error) {
+	if drbg == nil {
+		drbg = rand.Reader
 	}
-
-	curve := ecdh.X25519()
-	xPriv, err := curve.GenerateKey(randReader)
+	cfg, err := newConfig(opts)
 	if err != nil {
 		return nil, nil, err
 	}
-	xPub := xPriv.PublicKey().Bytes()
-
-	peerXPub, err := curve.NewPublicKey(xPubInit)
+	cfg, err = respondConfig(cfg, initMsg)
 	if err != nil {
-		return nil, nil, ErrHandshake
+		return nil, nil, err
 	}
-	xShared, err := xPriv.ECDH(peerXPub)
+	init, mode, ks, psk, err := respondTo(mode, initMsg, cfg)
 	if err != nil {
-		return nil, nil, ErrHandshake
+		return nil, nil, err
 	}
-
-	var kyberPk kyber768.PublicKey
-	if len(kyberPubInit) != kyberPubSize {
-		return nil, nil, ErrHandshake
-	}
-	kyberPk.Unpack(kyberPubInit)
-	kyberCt := make([]byte, kyberCtSize)
-	kyberSS := make([]byte, kyberSSSize)
-	kyberPk.EncapsulateTo(kyberCt, kyberSS, nil)
-
-	respMsg = buildHandshakeRespMsg(Version, byte(mode), xPub, kyberCt)
-	return handshakeRespFinish(mode, initMsg, respMsg, xShared, kyberSS)
+	parts, err := encapsulateAll(init.kems, init.shares, drbg, encSeed)
+	if err != nil {
+		return nil, nil, err
+	}
+	respMsg := buildHandshakeRespMsg(ks.version, byte(mode), init.kems, partCiphertexts(parts))
+	return handshakeRespFinish(mode, ks, initMsg, init, respMsg, parts, psk, drbg, cfg)
 }
 
-// HandshakeInitDeterministic is like HandshakeInit but uses drbg io.Reader for fully
-// deterministic key generation. X25519 uses NewPrivateKey(drbg bytes); Kyber uses
-// NewKeyFromSeed(drbg bytes). Used only for vector generation.
-func HandshakeInitDeterministic(mode Mode, drbg io.Reader) ([]byte, *Session, error) {
-	curve := ecdh.X25519()
-	xBytes := make([]byte, 32)
-	if _, err := io.ReadFull(drbg, xBytes); err != nil {
-		return nil, nil, err
+// newInitiator generates the component key pairs and the init message. With a
+// PSK the message ends in a binder over its body.
+func newInitiator(mode Mode, randReader io.Reader, cfg config) ([]byte, *Session, error) {
+	for _, m := range cfg.modes(mode) {
+		if !allowsCombiner(cfg.combinerOrDefault(), m) {
+			return nil, nil, ErrConfig
+		}
+	}
+	if len(cfg.psks) > 1 || (cfg.ticket != nil && cfg.ticket.used) {
+		return nil, nil, ErrConfig
+	}
+	if cfg.ticket != nil {
+		cfg.ticket.used = true
 	}
-	xPriv, err := curve.NewPrivateKey(xBytes)
+	kems := cfg.kemList()
+	pubs, privs, err := generateKEMKeys(kems, randReader)
 	if err != nil {
 		return nil, nil, err
 	}
-	xPub := xPriv.PublicKey().Bytes()
-
-	kyberSeed := make([]byte, kyber768.KeySeedSize)
-	if _, err := io.ReadFull(drbg, kyberSeed); err != nil {
+	initMsg := appendInitExtensions(buildHandshakeInitMsg(cfg.initVersion(), byte(mode), kems, pubs), cfg)
+	if initMsg, err = appendPSKExtensions(initMsg, cfg, randReader); err != nil {
 		return nil, nil, err
 	}
-	kyberPk, kyberSk := kyber768.NewKeyFromSeed(kyberSeed)
-	kyberPubBytes := make([]byte, kyberPubSize)
-	kyberPk.Pack(kyberPubBytes)
-
-	initMsg := buildHandshakeInitMsg(Version, byte(mode), xPub, kyberPubBytes)
-	session := &Session{
-		mode:      mode,
-		xPriv:     xPriv,
-		initMsg:   initMsg,
-		kyberPriv: kyberSk,
+	var pakeKey *ecdh.PrivateKey
+	if cfg.password != nil {
+		var share []byte
+		if pakeKey, share, err = pakeShare(cfg.password, randReader); err != nil {
+			return nil, nil, err
+		}
+		initMsg = appendExtension(initMsg, extPAKE, share)
+	}
+	var sasNonce []byte
+	if cfg.sas {
+		if sasNonce, err = readSASNonce(randReader); err != nil {
+			return nil, nil, err
+		}
+		initMsg = appendExtension(initMsg, extSASCommit, sasCommit(sasNonce))
+	}
+	var kemAuthSecret []byte
+	if cfg.peerKEMKey != nil {
+		var ct []byte
+		if ct, kemAuthSecret, err = kemAuthKEM.Encapsulate(cfg.peerKEMKey, randReader); err != nil {
+			return nil, nil, err
+		}
+		initMsg = appendExtension(initMsg, extKEMAuth, ct)
+	}
+	if cfg.earlyData {
+		if cfg.ticket == nil && cfg.peerKEMKey == nil {
+			return nil, nil, ErrConfig
+		}
+		id, err := newEarlyID(randReader)
+		if err != nil {
+			return nil, nil, err
+		}
+		initMsg = appendExtension(initMsg, extEarlyData, id)
+	}
+	if key := cfg.initiatorPSK(); key != nil {
+		initMsg = appendExtension(initMsg, extPSKBinder, pskBinder(key, initMsg))
+	}
+	var early *earlyState
+	if cfg.earlyData {
+		init, err := parseHandshakeInitMsg(initMsg)
+		if err != nil {
+			return nil, nil, err
+		}
+		early = newEarlyState(init, mixedSecrets(cfg.earlyPSK(cfg.initiatorPSK()), kemAuthSecret))
 	}
+	session := &Session{mode: mode, sessionState: sessionState{
+		kemPubs:  pubs,
+		kemPrivs: privs,
+		pakeKey:  pakeKey,
+		sasInit:  sasNonce,
+		initMsg:  append([]byte(nil), initMsg...),
+		cfg:      cfg,
+		rand:     randReader,
+
+		kemAuthSecret: kemAuthSecret,
+		earlyTx:       early,
+	}}
 	return initMsg, session, nil
 }
 
-// HandshakeRespDeterministic is like HandshakeResp but uses deterministic key gen
-// (NewPrivateKey, NewKeyFromSeed) and encSeed for Kyber encapsulation.
-func HandshakeRespDeterministic(mode Mode, initMsg []byte, drbg io.Reader, encSeed []byte) ([]byte, *Session, error) {
-	if drbg == nil {
-		drbg = rand.Reader
+// respondTo parses initMsg for a responder, checks that it uses the configured
+// components, combiner, PSK and KEM authentication, and picks the version, suite and mode. psk is
+// the key to mix in, if any.
+func respondTo(mode Mode, initMsg []byte, cfg config) (handshakeMsg, Mode, keySchedule, []byte, error) {
+	init, err := parseHandshakeInitMsg(initMsg)
+	if err != nil || !equalKEMs(init.kems, cfg.kemList()) || (cfg.verifier != nil && init.exts[extIdentity] == nil) {
+		return handshakeMsg{}, 0, keySchedule{}, nil, ErrHandshake
 	}
-	ver, m, xPubInit, kyberPubInit, err := parseHandshakeInitMsg(initMsg)
-	if err != nil || ver != Version || m != byte(mode) {
-		return nil, nil, ErrHandshake
+	version, err := selectVersion(init, cfg)
+	if err != nil {
+		return handshakeMsg{}, 0, keySchedule{}, nil, err
 	}
-	curve := ecdh.X25519()
-	xBytes := make([]byte, 32)
-	if _, err := io.ReadFull(drbg, xBytes); err != nil {
-		return nil, nil, err
+	suite, err := selectSuite(init.exts, cfg)
+	if err != nil {
+		return handshakeMsg{}, 0, keySchedule{}, nil, err
 	}
-	xPriv, err := curve.NewPrivateKey(xBytes)
+	mode, err = selectMode(mode, init.mode, init.exts, cfg)
 	if err != nil {
-		return nil, nil, err
+		return handshakeMsg{}, 0, keySchedule{}, nil, err
+	}
+	if c, ok := offeredCombiner(init.exts); !ok || c != cfg.combinerOrDefault() || !allowsCombiner(c, mode) {
+		return handshakeMsg{}, 0, keySchedule{}, nil, ErrHandshake
+	}
+	if _, ok := init.exts[extPAKE]; ok != (cfg.password != nil) {
+		return handshakeMsg{}, 0, keySchedule{}, nil, ErrHandshake
+	}
+	if c, ok := init.exts[extSASCommit]; ok != cfg.sas || (ok && len(c) != sha256.Size) {
+		return handshakeMsg{}, 0, keySchedule{}, nil, ErrHandshake
+	}
+	if ct, ok := init.exts[extKEMAuth]; ok != (cfg.kemIdentity != nil) || (ok && len(ct) != kemAuthKEM.CiphertextSize()) {
+		return handshakeMsg{}, 0, keySchedule{}, nil, ErrHandshake
+	}
+	if id, ok := init.exts[extEarlyData]; ok && len(id) != earlyIDSize {
+		return handshakeMsg{}, 0, keySchedule{}, nil, ErrHandshake
+	}
+	psk, err := acceptPSK(init, cfg)
+	if err != nil {
+		return handshakeMsg{}, 0, keySchedule{}, nil, err
+	}
+	return init, mode, keySchedule{version: version, suite: suite}, psk, nil
+}
+
+// handshakeRespFinish derives the responder session. respBody is the fixed
+// response; the suite choice, identity, PSK nonce, PAKE share and SAS nonce
+// extensions are added here, then an optional signature trailer and the Finished trailer
+// over the transcript.
+func handshakeRespFinish(mode Mode, ks keySchedule, initMsg []byte, init handshakeMsg, respBody []byte, parts []kemPart, psk []byte, randReader io.Reader, cfg config) ([]byte, *Session, error) {
+	if _, ok := init.exts[extSuites]; ok {
+		respBody = appendExtension(respBody, extSuites, []byte{byte(ks.suite)})
 	}
-	xPub := xPriv.PublicKey().Bytes()
-	peerXPub, err := curve.NewPublicKey(xPubInit)
+	if cfg.identity != nil {
+		respBody = appendExtension(respBody, extIdentity, cfg.identity.public)
+	}
+	respBody, err := appendPSKNonce(respBody, cfg, randReader)
 	if err != nil {
-		return nil, nil, ErrHandshake
+		return nil, nil, err
 	}
-	xShared, err := xPriv.ECDH(peerXPub)
+	var isk []byte
+	if cfg.password != nil {
+		pakeKey, share, err := pakeShare(cfg.password, randReader)
+		if err != nil {
+			return nil, nil, err
+		}
+		respBody = appendExtension(respBody, extPAKE, share)
+		ya := init.exts[extPAKE]
+		if isk, err = pakeISK(pakeKey, ya, ya, share); err != nil {
+			return nil, nil, err
+		}
+	}
+	var sasNonce []byte
+	if cfg.sas {
+		if sasNonce, err = readSASNonce(randReader); err != nil {
+			return nil, nil, err
+		}
+		respBody = appendExtension(respBody, extSASNonce, sasNonce)
+	}
+	authSecret, err := kemAuthDecapsulate(cfg, init.exts[extKEMAuth])
 	if err != nil {
-		return nil, nil, ErrHandshake
+		return nil, nil, err
 	}
-	var kyberPk kyber768.PublicKey
-	if len(kyberPubInit) != kyberPubSize {
-		return nil, nil, ErrHandshake
+	early := acceptEarlyData(mode, init, cfg, psk, authSecret)
+	if early != nil {
+		respBody = appendExtension(respBody, extEarlyData, nil)
 	}
-	kyberPk.Unpack(kyberPubInit)
-	kyberCt := make([]byte, kyberCtSize)
-	kyberSS := make([]byte, kyberSSSize)
-	kyberPk.EncapsulateTo(kyberCt, kyberSS, encSeed)
-
-	respMsg := buildHandshakeRespMsg(Version, byte(mode), xPub, kyberCt)
-	return handshakeRespFinish(mode, initMsg, respMsg, xShared, kyberSS)
-}
+	transcript := common.TranscriptHash(init.body, respBody, []byte{byte(mode)}, []byte{ks.version})
+	sessionID := transcript
 
-func handshakeRespFinish(mode Mode, initMsg, respMsg, xShared, kyberSS []byte) ([]byte, *Session, error) {
+	kRaw := ks.fuse(cfg.combinerOrDefault(), parts, mixedSecrets(psk, isk, authSecret), transcript)
+	kMs := ks.expand(kRaw, common.LabelMaster, 32)
 
-	transcript := common.TranscriptHash(initMsg, respMsg, []byte{byte(mode)}, []byte{Version})
-	sessionID := transcript
+	kConfirm := ks.confirmKey(kMs)
 
-	kRaw := common.Extract(append(xShared, kyberSS...), transcript)
-	kMs := common.Expand(kRaw, common.LabelMaster, 32)
+	respMsg := append([]byte(nil), respBody...)
+	if cfg.identity != nil {
+		sig, err := cfg.identity.sign(ks.label(common.LabelSigResp), transcript)
+		if err != nil {
+			return nil, nil, err
+		}
+		respMsg = appendExtension(respMsg, extSignature, sig)
+	}
+	respMsg = appendExtension(respMsg, extFinished, ks.finished(kConfirm, HandshakeTypeResp, transcript))
 
-	sess, err := newSessionFromKeys(mode, sessionID, transcript, kMs)
+	sess, err := newSessionFromKeys(mode, ks, sessionID, transcript, kMs, false, cfg)
 	if err != nil {
 		return nil, nil, err
 	}
-	sess.initMsg = initMsg
-	sess.respMsg = respMsg
+	sess.initMsg = append([]byte(nil), initMsg...)
+	sess.respMsg = append([]byte(nil), respMsg...)
+	sess.rand = randReader
 	sess.transcriptHash = transcript
-	sess.isInitiator = false
-	sess.established = true
-
+	sess.confirmKey = kConfirm
+	sess.earlyRx = early
+	if cfg.sas {
+		sess.sasResp = sasNonce
+		sess.sasCommitment = append([]byte(nil), init.exts[extSASCommit]...)
+	}
+	if cfg.verifier != nil {
+		// Mutual authentication: HandshakeFinish also checks a signature.
+		sess.pendingIdentity = append([]byte(nil), init.exts[extIdentity]...)
+	}
 	return respMsg, sess, nil
 }
 
 // HandshakeComplete finishes handshake for initiator after receiving respMsg.
+// respMsg must carry the responder's Finished MAC under the same master secret,
+// so a peer that derived different keys fails here rather than on the first
+// frame. With WithPeerVerifier, respMsg must also carry a valid responder
+// signature by an identity the verifier accepts; any signature present is
+// checked regardless. The initiator then sends HandshakeFinishMsg.
 func (s *Session) HandshakeComplete(respMsg []byte) error {
-	if s.established {
+	defer s.lockBoth()()
+	if s.established || s.closed {
 		return ErrHandshake
 	}
-	ver, m, xPubResp, kyberCt, err := parseHandshakeRespMsg(respMsg)
-	if err != nil || ver != Version || m != byte(s.mode) {
+	resp, err := parseHandshakeRespMsg(respMsg)
+	if err != nil || !s.cfg.speaks(resp.version) || !s.cfg.accepts(s.mode, Mode(resp.mode)) || !equalKEMs(resp.kems, s.cfg.kemList()) {
 		return ErrHandshake
 	}
-
-	curve := ecdh.X25519()
-	peerXPub, err := curve.NewPublicKey(xPubResp)
-	if err != nil {
+	suite, ok := s.cfg.acceptSuite(resp.exts)
+	if !ok || (s.cfg.pskOnly && len(resp.exts[extPSKNonce]) != pskNonceSize) || (s.cfg.sas && len(resp.exts[extSASNonce]) != sasNonceSize) {
 		return ErrHandshake
 	}
-	xShared, err := s.xPriv.ECDH(peerXPub)
+	ack, earlyAccepted := resp.exts[extEarlyData]
+	if earlyAccepted && (s.earlyTx == nil || len(ack) != 0) {
+		return ErrHandshake
+	}
+	init, err := parseHandshakeInitMsg(s.initMsg)
 	if err != nil {
 		return ErrHandshake
 	}
+	var isk []byte
+	if s.cfg.password != nil {
+		yb := resp.exts[extPAKE]
+		if isk, err = pakeISK(s.pakeKey, yb, init.exts[extPAKE], yb); err != nil {
+			return err
+		}
+	}
+	m := resp.mode
+	ks := keySchedule{version: resp.version, suite: suite}
+
+	parts, err := decapsulateAll(resp.kems, s.kemPubs, s.kemPrivs, resp.shares)
+	if err != nil {
+		return err
+	}
 
-	sk, ok := s.kyberPriv.(*kyber768.PrivateKey)
-	if !ok {
+	transcript := common.TranscriptHash(init.body, resp.body, []byte{m}, []byte{resp.version})
+	kRaw := ks.fuse(s.cfg.combinerOrDefault(), parts, mixedSecrets(s.cfg.initiatorPSK(), isk, s.kemAuthSecret), transcript)
+	kMs := ks.expand(kRaw, common.LabelMaster, 32)
+	kConfirm := ks.confirmKey(kMs)
+	if !ks.checkFinished(kConfirm, HandshakeTypeResp, transcript, resp.exts[extFinished]) {
 		return ErrHandshake
 	}
-	kyberSS := make([]byte, kyberSSSize)
-	sk.DecapsulateTo(kyberSS, kyberCt)
 
-	transcript := common.TranscriptHash(s.initMsg, respMsg, []byte{byte(s.mode)}, []byte{Version})
+	authenticated, err := checkPeerIdentity(s.cfg, resp.exts[extIdentity], ks.label(common.LabelSigResp), transcript, resp.exts[extSignature])
+	if err != nil {
+		return err
+	}
+	if authenticated {
+		s.peerIdentity = append([]byte(nil), resp.exts[extIdentity]...)
+	}
+	s.mode = Mode(m)
+	s.ks = ks
 	s.sessionID = transcript
 	s.transcriptHash = transcript
+	s.confirmKey = kConfirm
+	if s.cfg.sas {
+		s.sasResp = append([]byte(nil), resp.exts[extSASNonce]...)
+	}
+	s.kMs = kMs
+	s.isInitiator = true
+	s.deriveKeys(kMs)
+	s.respMsg = append([]byte(nil), respMsg...)
+	s.established = true
+	s.earlyAccepted = earlyAccepted
+	s.kemPubs, s.kemPrivs, s.pakeKey, s.kemAuthSecret, s.earlyTx = nil, nil, nil, nil, nil
+	return nil
+}
 
-	kRaw := common.Extract(append(xShared, kyberSS...), transcript)
-	kMs := common.Expand(kRaw, common.LabelMaster, 32)
+// HandshakeFinishMsg returns the initiator's third flight: its Finished MAC
+// over the transcript, with WithSAS the reveal of its SAS nonce and, with
+// WithIdentity, its signature for mutual authentication. It needs a completed
+// handshake.
+func (s *Session) HandshakeFinishMsg() ([]byte, error) {
+	defer s.lockBoth()()
+	if !s.established || !s.isInitiator || s.confirmKey == nil {
+		return nil, ErrHandshake
+	}
+	msg := []byte{s.ks.version, byte(s.mode), HandshakeTypeFinish}
+	if s.cfg.sas {
+		msg = appendExtension(msg, extSASNonce, s.sasInit)
+	}
+	if s.cfg.identity != nil {
+		sig, err := s.cfg.identity.sign(s.ks.label(common.LabelSigInit), s.transcriptHash)
+		if err != nil {
+			return nil, err
+		}
+		msg = appendExtension(msg, extSignature, sig)
+	}
+	return appendExtension(msg, extFinished, s.ks.finished(s.confirmKey, HandshakeTypeFinish, s.transcriptHash)), nil
+}
 
-	s.deriveKeys(kMs)
-	s.respMsg = respMsg
-	s.isInitiator = true
+// HandshakeFinish checks the initiator's Finished message and establishes the
+// responder session. With WithSAS the revealed nonce must open the init
+// message's commitment. With mutual authentication the signature must also
+// verify under the identity announced in the init message, and the verifier
+// must accept that identity.
+func (s *Session) HandshakeFinish(finMsg []byte) error {
+	defer s.lockBoth()()
+	if s.established || s.isInitiator || s.confirmKey == nil {
+		return ErrHandshake
+	}
+	if len(finMsg) < finFixedSize || finMsg[0] != s.ks.version || finMsg[1] != byte(s.mode) || finMsg[2] != HandshakeTypeFinish {
+		return ErrHandshake
+	}
+	_, exts, err := parseExtensions(finMsg, finFixedSize)
+	if err != nil {
+		return ErrHandshake
+	}
+	if !s.ks.checkFinished(s.confirmKey, HandshakeTypeFinish, s.transcriptHash, exts[extFinished]) {
+		return ErrHandshake
+	}
+	if s.sasCommitment != nil {
+		reveal := exts[extSASNonce]
+		if len(reveal) != sasNonceSize || !common.EqualConstantTime(sasCommit(reveal), s.sasCommitment) {
+			return ErrHandshake
+		}
+		s.sasInit, s.sasCommitment = append([]byte(nil), reveal...), nil
+	}
+	if s.pendingIdentity != nil {
+		if _, err := checkPeerIdentity(s.cfg, s.pendingIdentity, s.ks.label(common.LabelSigInit), s.transcriptHash, exts[extSignature]); err != nil {
+			return err
+		}
+	}
+	s.peerIdentity, s.pendingIdentity = s.pendingIdentity, nil
+	s.confirmKey = nil
 	s.established = true
-	s.xPriv = nil
-	s.kyberPriv = nil
 	return nil
 }
 
-func buildHandshakeInitMsg(ver, mode byte, xPub, kyberPub []byte) []byte {
-	b := make([]byte, 3+x25519PubSize+kyberPubSize)
-	b[0] = ver
-	b[1] = mode
-	b[2] = HandshakeTypeInit
-	copy(b[3:], xPub)
-	copy(b[3+x25519PubSize:], kyberPub)
-	return b
+// appendInitExtensions adds the initiator's version, suite and mode offers, its
+// combiner and its identity key, if any, so the responder binds them into the
+// transcript.
+func appendInitExtensions(initMsg []byte, cfg config) []byte {
+	if versions := cfg.supportedVersions(); len(versions) > 1 {
+		initMsg = appendExtension(initMsg, extVersions, versions)
+	}
+	if offer := cfg.suiteOffer(); offer != nil {
+		initMsg = appendExtension(initMsg, extSuites, offer)
+	}
+	if c := cfg.combinerOrDefault(); c != CombinerConcat {
+		initMsg = appendExtension(initMsg, extCombiner, []byte{byte(c)})
+	}
+	if len(cfg.fallbackModes) > 0 {
+		initMsg = appendExtension(initMsg, extModes, modeBytes(cfg.modes(Mode(initMsg[1]))))
+	}
+	if cfg.identity != nil {
+		initMsg = appendExtension(initMsg, extIdentity, cfg.identity.public)
+	}
+	return initMsg
+}
+
+func buildHandshakeInitMsg(ver, mode byte, kems []KEM, pubs [][]byte) []byte {
+	w, _ := wireFor(ver)
+	return w.buildHandshake(mode, HandshakeTypeInit, kems, pubs)
 }
 
-func buildHandshakeRespMsg(ver, mode byte, xPub, kyberCt []byte) []byte {
-	b := make([]byte, 3+x25519PubSize+kyberCtSize)
-	b[0] = ver
-	b[1] = mode
-	b[2] = HandshakeTypeResp
-	copy(b[3:], xPub)
-	copy(b[3+x25519PubSize:], kyberCt)
-	return b
+func buildHandshakeRespMsg(ver, mode byte, kems []KEM, cts [][]byte) []byte {
+	w, _ := wireFor(ver)
+	return w.buildHandshake(mode, HandshakeTypeResp, kems, cts)
 }
 
-func parseHandshakeInitMsg(b []byte) (ver, mode byte, xPub, kyberPub []byte, err error) {
-	if len(b) < 3+x25519PubSize+kyberPubSize {
-		return 0, 0, nil, nil, ErrHandshake
-	}
-	if b[2] != HandshakeTypeInit {
-		return 0, 0, nil, nil, ErrHandshake
-	}
-	ver = b[0]
-	mode = b[1]
-	xPub = append([]byte(nil), b[3:3+x25519PubSize]...)
-	kyberPub = append([]byte(nil), b[3+x25519PubSize:3+x25519PubSize+kyberPubSize]...)
-	return ver, mode, xPub, kyberPub, nil
+func parseHandshakeInitMsg(b []byte) (handshakeMsg, error) {
+	return parseHandshakeAny(b, HandshakeTypeInit)
 }
 
-func parseHandshakeRespMsg(b []byte) (ver, mode byte, xPub, kyberCt []byte, err error) {
-	if len(b) < 3+x25519PubSize+kyberCtSize {
-		return 0, 0, nil, nil, ErrHandshake
-	}
-	if b[2] != HandshakeTypeResp {
-		return 0, 0, nil, nil, ErrHandshake
-	}
-	ver = b[0]
-	mode = b[1]
-	xPub = append([]byte(nil), b[3:3+x25519PubSize]...)
-	kyberCt = append([]byte(nil), b[3+x25519PubSize:3+x25519PubSize+kyberCtSize]...)
-	return ver, mode, xPub, kyberCt, nil
+func parseHandshakeRespMsg(b []byte) (handshakeMsg, error) {
+	return parseHandshakeAny(b, HandshakeTypeResp)
 }
@@ -0,0 +1,134 @@
+package dee
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+
+	"github.com/cloudflare/circl/sign/mldsa/mldsa65"
+)
+
+const (
+	// IdentityKeySize is the size of an encoded public identity key:
+	// Ed25519 public key || ML-DSA-65 public key.
+	IdentityKeySize = ed25519.PublicKeySize + mldsa65.PublicKeySize
+
+	signatureSize = ed25519.SignatureSize + mldsa65.SignatureSize
+)
+
+// Identity is a long-term hybrid signing key pair. A handshake signature is
+// valid only if both the Ed25519 and the ML-DSA-65 halves verify, so forging
+// one needs both a classical and a post-quantum break.
+type Identity struct {
+	edPriv ed25519.PrivateKey
+	pqPriv *mldsa65.PrivateKey
+	public []byte
+}
+
+// GenerateIdentity creates a new identity key pair. randReader defaults to
+// crypto/rand.
+func GenerateIdentity(randReader io.Reader) (*Identity, error) {
+	if randReader == nil {
+		randReader = rand.Reader
+	}
+	edPub, edPriv, err := ed25519.GenerateKey(randReader)
+	if err != nil {
+		return nil, err
+	}
+	pqPub, pqPriv, err := mldsa65.GenerateKey(randReader)
+	if err != nil {
+		return nil, err
+	}
+	public := make([]byte, 0, IdentityKeySize)
+	public = append(public, edPub...)
+	public = append(public, pqPub.Bytes()...)
+	return &Identity{edPriv: edPriv, pqPriv: pqPriv, public: public}, nil
+}
+
+// PublicKey returns the encoded public identity key, the value peer verifiers
+// are asked about.
+func (id *Identity) PublicKey() []byte {
+	return append([]byte(nil), id.public...)
+}
+
+// PeerVerifier decides whether a peer's public identity key is acceptable, for
+// example by comparing it against a pinned key. It is only called after the
+// peer has proven possession of the key.
+type PeerVerifier func(peerKey []byte) bool
+
+// WithIdentity makes this side sign the handshake transcript with id. A
+// responder signs its response; an initiator announces its key in the init
+// message and signs in HandshakeFinishMsg.
+func WithIdentity(id *Identity) Option {
+	return func(c *config) {
+		c.identity = id
+	}
+}
+
+// WithPeerVerifier requires the peer to authenticate with an identity key that
+// verify accepts. On the initiator this is responder authentication; on the
+// responder it asks for mutual authentication: HandshakeFinish then checks the
+// initiator's signature as well as its Finished MAC.
+func WithPeerVerifier(verify PeerVerifier) Option {
+	return func(c *config) {
+		c.verifier = verify
+	}
+}
+
+// PeerIdentity returns the peer's authenticated public identity key, or nil if
+// the peer did not authenticate.
+func (s *Session) PeerIdentity() []byte {
+	defer s.lockBoth()()
+	if s.peerIdentity == nil {
+		return nil
+	}
+	return append([]byte(nil), s.peerIdentity...)
+}
+
+// sign produces Ed25519 || ML-DSA-65 signatures over label || transcript. The
+// ML-DSA half uses the label as its context string and deterministic signing,
+// so deterministic handshakes stay reproducible.
+func (id *Identity) sign(label string, transcript []byte) ([]byte, error) {
+	msg := append([]byte(label), transcript...)
+	sig := make([]byte, signatureSize)
+	copy(sig, ed25519.Sign(id.edPriv, msg))
+	if err := mldsa65.SignTo(id.pqPriv, msg, []byte(label), false, sig[ed25519.SignatureSize:]); err != nil {
+		return nil, err
+	}
+	return sig, nil
+}
+
+// verifySignature checks both halves of a hybrid signature made by peerKey.
+func verifySignature(peerKey []byte, label string, transcript, sig []byte) bool {
+	if len(peerKey) != IdentityKeySize || len(sig) != signatureSize {
+		return false
+	}
+	var pqPub mldsa65.PublicKey
+	if err := pqPub.UnmarshalBinary(peerKey[ed25519.PublicKeySize:]); err != nil {
+		return false
+	}
+	msg := append([]byte(label), transcript...)
+	edOK := ed25519.Verify(peerKey[:ed25519.PublicKeySize], msg, sig[:ed25519.SignatureSize])
+	pqOK := mldsa65.Verify(&pqPub, msg, []byte(label), sig[ed25519.SignatureSize:])
+	return edOK && pqOK
+}
+
+// checkPeerIdentity verifies the signature in exts by the identity key peerKey
+// and, if a verifier is configured, that the key is acceptable. With a
+// verifier, a missing key or signature fails; without one, a signature that is
+// present must still be valid.
+func checkPeerIdentity(cfg config, peerKey []byte, label string, transcript, sig []byte) (authenticated bool, err error) {
+	if peerKey == nil && sig == nil {
+		if cfg.verifier != nil {
+			return false, ErrHandshake
+		}
+		return false, nil
+	}
+	if !verifySignature(peerKey, label, transcript, sig) {
+		return false, ErrHandshake
+	}
+	if cfg.verifier != nil && !cfg.verifier(append([]byte(nil), peerKey...)) {
+		return false, ErrHandshake
+	}
+	return true, nil
+}
@@ -0,0 +1,242 @@
+package dee
+
+import (
+	"bytes"
+	"testing"
+)
+
+func newTestIdentity(t *testing.T) *Identity {
+	t.Helper()
+	id, err := GenerateIdentity(nil)
+	if err != nil {
+		t.Fatalf("GenerateIdentity: %v", err)
+	}
+	return id
+}
+
+func pinned(key []byte) PeerVerifier {
+	return func(peerKey []byte) bool { return bytes.Equal(peerKey, key) }
+}
+
+func TestResponderAuth(t *testing.T) {
+	server := newTestIdentity(t)
+	initMsg, initSession, err := HandshakeInit(Safe, nil, WithPeerVerifier(pinned(server.PublicKey())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	respMsg, respSession, err := HandshakeResp(Safe, initMsg, nil, WithIdentity(server))
+	if err != nil {
+		t.Fatalf("HandshakeResp: %v", err)
+	}
+	if err := initSession.HandshakeComplete(respMsg); err != nil {
+		t.Fatalf("HandshakeComplete: %v", err)
+	}
+	finishHandshake(t, initSession, respSession)
+	if !bytes.Equal(initSession.PeerIdentity(), server.PublicKey()) {
+		t.Fatal("initiator should report the responder identity")
+	}
+	if respSession.PeerIdentity() != nil {
+		t.Fatal("responder-only auth must not report an initiator identity")
+	}
+	sendFrames(t, initSession, respSession, 2)
+	sendFrames(t, respSession, initSession, 2)
+}
+
+func TestResponderAuthFailures(t *testing.T) {
+	server := newTestIdentity(t)
+	other := newTestIdentity(t)
+
+	cases := []struct {
+		name     string
+		respOpts []Option
+		tamper   func(respMsg []byte) []byte
+	}{
+		{"no identity", nil, nil},
+		{"unknown identity", []Option{WithIdentity(other)}, nil},
+		{"signature flipped", []Option{WithIdentity(server)}, func(b []byte) []byte {
+			b[len(b)-1] ^= 1 // last byte of the ML-DSA half
+			return b
+		}},
+		{"ed25519 half flipped", []Option{WithIdentity(server)}, func(b []byte) []byte {
+			b[len(b)-signatureSize] ^= 1
+			return b
+		}},
+		{"signature stripped", []Option{WithIdentity(server)}, func(b []byte) []byte {
+			return b[:len(b)-extHeaderSize-signatureSize]
+		}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			initMsg, initSession, err := HandshakeInit(Safe, nil, WithPeerVerifier(pinned(server.PublicKey())))
+			if err != nil {
+				t.Fatal(err)
+			}
+			respMsg, _, err := HandshakeResp(Safe, initMsg, nil, tc.respOpts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.tamper != nil {
+				respMsg = tc.tamper(respMsg)
+			}
+			if err := initSession.HandshakeComplete(respMsg); err != ErrHandshake {
+				t.Fatalf("got %v, want ErrHandshake", err)
+			}
+		})
+	}
+}
+
+// A MITM that swaps the identity extension for its own, correctly signed,
+// is stopped by the verifier; one that keeps the server's key cannot sign.
+func TestResponderAuthRejectsSubstitutedIdentity(t *testing.T) {
+	server := newTestIdentity(t)
+	mitm := newTestIdentity(t)
+	initMsg, initSession, err := HandshakeInit(Safe, nil, WithPeerVerifier(pinned(server.PublicKey())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	respMsg, _, err := HandshakeResp(Safe, initMsg, nil, WithIdentity(mitm))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Splice in the server's key but keep the attacker's signature.
+	body := respFixedSize + extHeaderSize
+	forged := append([]byte(nil), respMsg...)
+	copy(forged[body:body+IdentityKeySize], server.PublicKey())
+	if err := initSession.HandshakeComplete(forged); err != ErrHandshake {
+		t.Fatalf("forged: got %v, want ErrHandshake", err)
+	}
+	if err := initSession.HandshakeComplete(respMsg); err != ErrHandshake {
+		t.Fatalf("unpinned: got %v, want ErrHandshake", err)
+	}
+}
+
+func TestMutualAuth(t *testing.T) {
+	client := newTestIdentity(t)
+	server := newTestIdentity(t)
+	initMsg, initSession, err := HandshakeInit(Safe, nil,
+		WithIdentity(client), WithPeerVerifier(pinned(server.PublicKey())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	respMsg, respSession, err := HandshakeResp(Safe, initMsg, nil,
+		WithIdentity(server), WithPeerVerifier(pinned(client.PublicKey())))
+	if err != nil {
+		t.Fatalf("HandshakeResp: %v", err)
+	}
+	if _, err := respSession.Encrypt([]byte("early"), nil); err != ErrDecrypt {
+		t.Fatal("responder must not send before the initiator authenticates")
+	}
+	if err := initSession.HandshakeComplete(respMsg); err != nil {
+		t.Fatalf("HandshakeComplete: %v", err)
+	}
+	finMsg, err := initSession.HandshakeFinishMsg()
+	if err != nil {
+		t.Fatalf("HandshakeFinishMsg: %v", err)
+	}
+	if err := respSession.HandshakeFinish(finMsg); err != nil {
+		t.Fatalf("HandshakeFinish: %v", err)
+	}
+	if !bytes.Equal(respSession.PeerIdentity(), client.PublicKey()) {
+		t.Fatal("responder should report the initiator identity")
+	}
+	sendFrames(t, initSession, respSession, 2)
+	sendFrames(t, respSession, initSession, 2)
+}
+
+func TestMutualAuthFailures(t *testing.T) {
+	client := newTestIdentity(t)
+	other := newTestIdentity(t)
+	server := newTestIdentity(t)
+
+	t.Run("initiator without identity", func(t *testing.T) {
+		initMsg, _, err := HandshakeInit(Safe, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := HandshakeResp(Safe, initMsg, nil, WithIdentity(server), WithPeerVerifier(pinned(client.PublicKey()))); err != ErrHandshake {
+			t.Fatalf("got %v, want ErrHandshake", err)
+		}
+	})
+
+	for _, tc := range []struct {
+		name   string
+		id     *Identity
+		tamper func([]byte) []byte
+	}{
+		{"unknown identity", other, nil},
+		{"signature flipped", client, func(b []byte) []byte { b[len(b)-1] ^= 1; return b }},
+		{"signature stripped", client, func(b []byte) []byte { return b[:finFixedSize] }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			initMsg, initSession, err := HandshakeInit(Safe, nil, WithIdentity(tc.id))
+			if err != nil {
+				t.Fatal(err)
+			}
+			respMsg, respSession, err := HandshakeResp(Safe, initMsg, nil, WithIdentity(server), WithPeerVerifier(pinned(client.PublicKey())))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := initSession.HandshakeComplete(respMsg); err != nil {
+				t.Fatal(err)
+			}
+			finMsg, err := initSession.HandshakeFinishMsg()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.tamper != nil {
+				finMsg = tc.tamper(finMsg)
+			}
+			if err := respSession.HandshakeFinish(finMsg); err != ErrHandshake {
+				t.Fatalf("got %v, want ErrHandshake", err)
+			}
+			if _, err := respSession.Encrypt([]byte("x"), nil); err != ErrDecrypt {
+				t.Fatal("session must stay unestablished after a failed finish")
+			}
+		})
+	}
+}
+
+func TestAnonymousHandshakeUnchanged(t *testing.T) {
+	initMsg, _, err := HandshakeInit(Safe, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(initMsg) != initFixedSize {
+		t.Fatalf("anonymous init message grew to %d bytes", len(initMsg))
+	}
+	respMsg, _, err := HandshakeResp(Safe, initMsg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(respMsg) != respFixedSize+extHeaderSize+finishedSize {
+		t.Fatalf("anonymous response grew to %d bytes", len(respMsg))
+	}
+}
+
+func TestParseExtensions(t *testing.T) {
+	fixed := []byte{1, 2, 3}
+	msg := appendExtension(append([]byte(nil), fixed...), extIdentity, []byte("key"))
+	msg = appendExtension(msg, 0x40, []byte("unknown"))
+	bodyLen := len(msg)
+	msg = appendExtension(msg, extSignature, []byte("sig"))
+
+	body, exts, err := parseExtensions(msg, len(fixed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(body) != bodyLen || string(exts[extIdentity]) != "key" || string(exts[extSignature]) != "sig" {
+		t.Fatal("extensions parsed incorrectly")
+	}
+
+	bad := [][]byte{
+		msg[:len(msg)-1], // truncated value
+		append(append([]byte(nil), msg...), 0x01),                                             // truncated header
+		appendExtension(append([]byte(nil), msg...), extIdentity, nil),                        // body after trailer
+		appendExtension(appendExtension(append([]byte(nil), fixed...), 0x40, nil), 0x40, nil), // duplicate
+	}
+	for i, b := range bad {
+		if _, _, err := parseExtensions(b, len(fixed)); err != ErrHandshake {
+			t.Errorf("case %d: got %v, want ErrHandshake", i, err)
+		}
+	}
+}
@@ -0,0 +1,375 @@
+package dee
+
+import (
+	"crypto/ecdh"
+	"io"
+
+	"github.com/cloudflare/circl/dh/x448"
+	"github.com/cloudflare/circl/kem"
+	"github.com/cloudflare/circl/kem/kyber/kyber768"
+	"github.com/cloudflare/circl/kem/mlkem/mlkem1024"
+	"github.com/cloudflare/circl/kem/mlkem/mlkem512"
+	"github.com/cloudflare/circl/kem/mlkem/mlkem768"
+)
+
+// KEM names one component of the hybrid key exchange. The handshake runs every
+// configured component and extracts the master secret from all of their shared
+// secrets, so the session stays secure while any one component holds.
+type KEM byte
+
+const (
+	KEMX25519    KEM = 0x01
+	KEMKyber768  KEM = 0x02 // round-3 Kyber, the original post-quantum component
+	KEMX448      KEM = 0x03
+	KEMMLKEM512  KEM = 0x04 // FIPS 203
+	KEMMLKEM768  KEM = 0x05 // FIPS 203
+	KEMMLKEM1024 KEM = 0x06 // FIPS 203
+)
+
+// defaultKEMs is the original X25519 + Kyber768 pair, the only list the v1
+// layout can carry.
+var defaultKEMs = []KEM{KEMX25519, KEMKyber768}
+
+// kemScheme is one hybrid component. Diffie-Hellman groups act as KEMs whose
+// ciphertext is an ephemeral public key. Keys and encapsulations are derived
+// from seeds so a deterministic reader yields vectors.
+type kemScheme interface {
+	name() string
+	postQuantum() bool
+	publicKeySize() int
+	ciphertextSize() int
+	seedSize() int
+	encapsulationSeedSize() int
+	newKey(seed []byte) (pub []byte, priv interface{}, err error)
+	encapsulate(pub, seed []byte) (ct, ss []byte, err error)
+	decapsulate(priv interface{}, ct []byte) (ss []byte, err error)
+}
+
+var kemSchemes = map[KEM]kemScheme{
+	KEMX25519:    x25519KEM{},
+	KEMKyber768:  circlKEM{kyber768.Scheme(), "Kyber768"},
+	KEMX448:      x448KEM{},
+	KEMMLKEM512:  circlKEM{mlkem512.Scheme(), "ML-KEM-512"},
+	KEMMLKEM768:  circlKEM{mlkem768.Scheme(), "ML-KEM-768"},
+	KEMMLKEM1024: circlKEM{mlkem1024.Scheme(), "ML-KEM-1024"},
+}
+
+// KEMs lists every supported component.
+func KEMs() []KEM {
+	return []KEM{KEMX25519, KEMKyber768, KEMX448, KEMMLKEM512, KEMMLKEM768, KEMMLKEM1024}
+}
+
+func (k KEM) String() string {
+	if s, ok := kemSchemes[k]; ok {
+		return s.name()
+	}
+	return "unknown"
+}
+
+// WithKEMs sets the hybrid components, in wire order; the default is X25519 +
+// Kyber768. Both peers must configure the same list. Any other list needs the
+// v2 layout, so it cannot be combined with Version in WithVersions.
+func WithKEMs(list ...KEM) Option {
+	return func(c *config) {
+		c.kems = append([]KEM(nil), list...)
+	}
+}
+
+func (c config) kemList() []KEM {
+	if c.pskOnly {
+		return []KEM{}
+	}
+	if len(c.kems) == 0 {
+		return defaultKEMs
+	}
+	return c.kems
+}
+
+// validKEMs reports whether list names known components without repeats.
+func validKEMs(list []KEM) bool {
+	for i, k := range list {
+		if _, ok := kemSchemes[k]; !ok {
+			return false
+		}
+		for _, prev := range list[:i] {
+			if prev == k {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func equalKEMs(a, b []KEM) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// shareSize is the size of a component's field in a message of type typ.
+func shareSize(s kemScheme, typ byte) int {
+	if typ == HandshakeTypeInit {
+		return s.publicKeySize()
+	}
+	return s.ciphertextSize()
+}
+
+// kemPart is one component's contribution to a handshake.
+type kemPart struct {
+	kem KEM
+	pub []byte
+	ct  []byte
+	ss  []byte
+}
+
+// generateKEMKeys creates a key pair for every component from randReader.
+func generateKEMKeys(list []KEM, randReader io.Reader) (pubs [][]byte, privs []interface{}, err error) {
+	for _, k := range list {
+		s := kemSchemes[k]
+		seed := make([]byte, s.seedSize())
+		if _, err := io.ReadFull(randReader, seed); err != nil {
+			return nil, nil, err
+		}
+		pub, priv, err := s.newKey(seed)
+		if err != nil {
+			return nil, nil, err
+		}
+		pubs = append(pubs, pub)
+		privs = append(privs, priv)
+	}
+	return pubs, privs, nil
+}
+
+// encapsulateAll encapsulates to every public key in pubs. Seeds come from
+// randReader, except that pqSeed, if set, seeds the first post-quantum
+// component.
+func encapsulateAll(list []KEM, pubs [][]byte, randReader io.Reader, pqSeed []byte) ([]kemPart, error) {
+	parts := make([]kemPart, 0, len(list))
+	for i, k := range list {
+		s := kemSchemes[k]
+		seed := pqSeed
+		if s.postQuantum() && len(pqSeed) == s.encapsulationSeedSize() {
+			pqSeed = nil
+		} else {
+			seed = make([]byte, s.encapsulationSeedSize())
+			if _, err := io.ReadFull(randReader, seed); err != nil {
+				return nil, err
+			}
+		}
+		ct, ss, err := s.encapsulate(pubs[i], seed)
+		if err != nil {
+			return nil, ErrHandshake
+		}
+		parts = append(parts, kemPart{kem: k, pub: pubs[i], ct: ct, ss: ss})
+	}
+	return parts, nil
+}
+
+// decapsulateAll recovers every component's shared secret from cts.
+func decapsulateAll(list []KEM, pubs [][]byte, privs []interface{}, cts [][]byte) ([]kemPart, error) {
+	if len(privs) != len(list) || len(pubs) != len(list) || len(cts) != len(list) {
+		return nil, ErrHandshake
+	}
+	parts := make([]kemPart, 0, len(list))
+	for i, k := range list {
+		ss, err := kemSchemes[k].decapsulate(privs[i], cts[i])
+		if err != nil {
+			return nil, ErrHandshake
+		}
+		parts = append(parts, kemPart{kem: k, pub: pubs[i], ct: cts[i], ss: ss})
+	}
+	return parts, nil
+}
+
+func partCiphertexts(parts []kemPart) [][]byte {
+	cts := make([][]byte, len(parts))
+	for i, p := range parts {
+		cts[i] = p.ct
+	}
+	return cts
+}
+
+// PublicKeySize returns the size of the component's public key.
+func (k KEM) PublicKeySize() int {
+	if s, ok := kemSchemes[k]; ok {
+		return s.publicKeySize()
+	}
+	return 0
+}
+
+// CiphertextSize returns the size of the component's ciphertext.
+func (k KEM) CiphertextSize() int {
+	if s, ok := kemSchemes[k]; ok {
+		return s.ciphertextSize()
+	}
+	return 0
+}
+
+// SeedSize returns the size of the seed a key pair is derived from.
+func (k KEM) SeedSize() int {
+	if s, ok := kemSchemes[k]; ok {
+		return s.seedSize()
+	}
+	return 0
+}
+
+// PublicKey returns the public key of the key pair derived from seed.
+func (k KEM) PublicKey(seed []byte) ([]byte, error) {
+	s, ok := kemSchemes[k]
+	if !ok || len(seed) != s.seedSize() {
+		return nil, ErrConfig
+	}
+	pub, _, err := s.newKey(seed)
+	return pub, err
+}
+
+// Encapsulate runs the component on its own and returns its ciphertext and
+// raw shared secret. The handshake never uses a component alone; this is for
+// combiner experiments (see HybridKEM).
+func (k KEM) Encapsulate(pub []byte, randReader io.Reader) (ct, ss []byte, err error) {
+	s, ok := kemSchemes[k]
+	if !ok || len(pub) != s.publicKeySize() {
+		return nil, nil, ErrConfig
+	}
+	seed := make([]byte, s.encapsulationSeedSize())
+	if _, err := io.ReadFull(randReader, seed); err != nil {
+		return nil, nil, err
+	}
+	return s.encapsulate(pub, seed)
+}
+
+// Decapsulate returns the raw shared secret of ct under the key pair derived
+// from seed.
+func (k KEM) Decapsulate(seed, ct []byte) ([]byte, error) {
+	s, ok := kemSchemes[k]
+	if !ok || len(seed) != s.seedSize() || len(ct) != s.ciphertextSize() {
+		return nil, ErrConfig
+	}
+	_, priv, err := s.newKey(seed)
+	if err != nil {
+		return nil, err
+	}
+	return s.decapsulate(priv, ct)
+}
+
+// circlKEM adapts a circl KEM.
+type circlKEM struct {
+	scheme kem.Scheme
+	label  string
+}
+
+func (c circlKEM) name() string               { return c.label }
+func (c circlKEM) postQuantum() bool          { return true }
+func (c circlKEM) publicKeySize() int         { return c.scheme.PublicKeySize() }
+func (c circlKEM) ciphertextSize() int        { return c.scheme.CiphertextSize() }
+func (c circlKEM) seedSize() int              { return c.scheme.SeedSize() }
+func (c circlKEM) encapsulationSeedSize() int { return c.scheme.EncapsulationSeedSize() }
+
+func (c circlKEM) newKey(seed []byte) ([]byte, interface{}, error) {
+	pk, sk := c.scheme.DeriveKeyPair(seed)
+	pub, err := pk.MarshalBinary()
+	if err != nil {
+		return nil, nil, err
+	}
+	return pub, sk, nil
+}
+
+func (c circlKEM) encapsulate(pub, seed []byte) ([]byte, []byte, error) {
+	pk, err := c.scheme.UnmarshalBinaryPublicKey(pub)
+	if err != nil {
+		return nil, nil, err
+	}
+	return c.scheme.EncapsulateDeterministically(pk, seed)
+}
+
+func (c circlKEM) decapsulate(priv interface{}, ct []byte) ([]byte, error) {
+	sk, ok := priv.(kem.PrivateKey)
+	if !ok {
+		return nil, ErrHandshake
+	}
+	return c.scheme.Decapsulate(sk, ct)
+}
+
+// x25519KEM is X25519 used as a KEM.
+type x25519KEM struct{}
+
+func (x25519KEM) name() string               { return "X25519" }
+func (x25519KEM) postQuantum() bool          { return false }
+func (x25519KEM) publicKeySize() int         { return x25519PubSize }
+func (x25519KEM) ciphertextSize() int        { return x25519PubSize }
+func (x25519KEM) seedSize() int              { return 32 }
+func (x25519KEM) encapsulationSeedSize() int { return 32 }
+
+func (x25519KEM) newKey(seed []byte) ([]byte, interface{}, error) {
+	priv, err := ecdh.X25519().NewPrivateKey(seed)
+	if err != nil {
+		return nil, nil, err
+	}
+	return priv.PublicKey().Bytes(), priv, nil
+}
+
+func (k x25519KEM) encapsulate(pub, seed []byte) ([]byte, []byte, error) {
+	ct, eph, err := k.newKey(seed)
+	if err != nil {
+		return nil, nil, err
+	}
+	ss, err := k.decapsulate(eph, pub)
+	return ct, ss, err
+}
+
+func (x25519KEM) decapsulate(priv interface{}, ct []byte) ([]byte, error) {
+	sk, ok := priv.(*ecdh.PrivateKey)
+	if !ok {
+		return nil, ErrHandshake
+	}
+	peer, err := ecdh.X25519().NewPublicKey(ct)
+	if err != nil {
+		return nil, ErrHandshake
+	}
+	return sk.ECDH(peer)
+}
+
+// x448KEM is X448 used as a KEM.
+type x448KEM struct{}
+
+func (x448KEM) name() string               { return "X448" }
+func (x448KEM) postQuantum() bool          { return false }
+func (x448KEM) publicKeySize() int         { return x448.Size }
+func (x448KEM) ciphertextSize() int        { return x448.Size }
+func (x448KEM) seedSize() int              { return x448.Size }
+func (x448KEM) encapsulationSeedSize() int { return x448.Size }
+
+func (x448KEM) newKey(seed []byte) ([]byte, interface{}, error) {
+	var priv, pub x448.Key
+	copy(priv[:], seed)
+	x448.KeyGen(&pub, &priv)
+	return pub[:], &priv, nil
+}
+
+func (k x448KEM) encapsulate(pub, seed []byte) ([]byte, []byte, error) {
+	ct, eph, err := k.newKey(seed)
+	if err != nil {
+		return nil, nil, err
+	}
+	ss, err := k.decapsulate(eph, pub)
+	return ct, ss, err
+}
+
+func (x448KEM) decapsulate(priv interface{}, ct []byte) ([]byte, error) {
+	sk, ok := priv.(*x448.Key)
+	if !ok || len(ct) != x448.Size {
+		return nil, ErrHandshake
+	}
+	var peer, ss x448.Key
+	copy(peer[:], ct)
+	if !x448.Shared(&ss, sk, &peer) {
+		return nil, ErrHandshake
+	}
+	return ss[:], nil
+}
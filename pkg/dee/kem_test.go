@@ -0,0 +1,146 @@
+package dee
+
+import (
+	"bytes"
+	"testing"
+)
+
+// kemCombinations returns every non-empty subset of KEMs(), in list order.
+func kemCombinations() [][]KEM {
+	all := KEMs()
+	var out [][]KEM
+	for mask := 1; mask < 1<<len(all); mask++ {
+		var list []KEM
+		for i, k := range all {
+			if mask&(1<<i) != 0 {
+				list = append(list, k)
+			}
+		}
+		out = append(out, list)
+	}
+	return out
+}
+
+func kemName(list []KEM) string {
+	var b bytes.Buffer
+	for i, k := range list {
+		if i > 0 {
+			b.WriteByte('+')
+		}
+		b.WriteString(k.String())
+	}
+	return b.String()
+}
+
+func TestKEMCombinationsRoundTrip(t *testing.T) {
+	for _, list := range kemCombinations() {
+		t.Run(kemName(list), func(t *testing.T) {
+			opts := []Option{WithVersions(Version2), WithKEMs(list...)}
+			initSession, respSession := sessionPair(t, Safe, opts, opts)
+			if !bytes.Equal(initSession.kMs, respSession.kMs) {
+				t.Fatal("master secrets differ")
+			}
+			sendFrames(t, initSession, respSession, 2)
+			sendFrames(t, respSession, initSession, 2)
+		})
+	}
+}
+
+// The default pair keeps the v1 layout; the same pair in v2 is length-prefixed
+// per component.
+func TestKEMLayout(t *testing.T) {
+	initMsg, _, err := HandshakeInit(Safe, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(initMsg) != initFixedSize {
+		t.Fatalf("v1 init length %d", len(initMsg))
+	}
+
+	list := []KEM{KEMX448, KEMMLKEM1024}
+	initMsg, _, err = HandshakeInit(Safe, nil, WithVersions(Version2), WithKEMs(list...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := parseHandshakeInitMsg(initMsg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equalKEMs(m.kems, list) || initMsg[3] != 2 || KEM(initMsg[4]) != KEMX448 {
+		t.Fatalf("components %v, header % x", m.kems, initMsg[:5])
+	}
+	for i, k := range list {
+		if len(m.shares[i]) != kemSchemes[k].publicKeySize() {
+			t.Fatalf("%v share size %d", k, len(m.shares[i]))
+		}
+	}
+}
+
+func TestKEMMismatch(t *testing.T) {
+	initMsg, _, err := HandshakeInit(Safe, nil, WithVersions(Version2), WithKEMs(KEMX25519, KEMMLKEM768))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := HandshakeResp(Safe, initMsg, nil, WithVersions(Version2)); err != ErrHandshake {
+		t.Fatalf("responder with default pair: got %v, want ErrHandshake", err)
+	}
+	if _, _, err := HandshakeResp(Safe, initMsg, nil, WithVersions(Version2), WithKEMs(KEMMLKEM768, KEMX25519)); err != ErrHandshake {
+		t.Fatalf("reordered components: got %v, want ErrHandshake", err)
+	}
+}
+
+func TestKEMConfig(t *testing.T) {
+	for _, opts := range [][]Option{
+		{WithKEMs(KEMX25519, KEMMLKEM768)}, // needs v2
+		{WithVersions(Version, Version2), WithKEMs(KEMMLKEM768)},
+		{WithVersions(Version2), WithKEMs(KEMX25519, KEMX25519)},
+		{WithVersions(Version2), WithKEMs(0x7f)},
+	} {
+		if _, _, err := HandshakeInit(Safe, nil, opts...); err != ErrConfig {
+			t.Errorf("got %v, want ErrConfig", err)
+		}
+	}
+	if _, _, err := HandshakeInit(Safe, nil, WithKEMs(defaultKEMs...)); err != nil {
+		t.Errorf("default pair over v1: %v", err)
+	}
+}
+
+func TestKEMParseMalformed(t *testing.T) {
+	opts := []Option{WithVersions(Version2), WithKEMs(KEMX25519, KEMMLKEM512)}
+	initMsg, _, err := HandshakeInit(Safe, nil, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mutate := func(f func(b []byte) []byte) []byte {
+		return f(append([]byte(nil), initMsg...))
+	}
+	bad := map[string][]byte{
+		"unknown kem":    mutate(func(b []byte) []byte { b[4] = 0x7f; return b }),
+		"wrong length":   mutate(func(b []byte) []byte { b[6]++; return b }),
+		"truncated":      initMsg[:len(initMsg)-1],
+		"count too high": mutate(func(b []byte) []byte { b[3] = 3; return b }),
+	}
+	for name, b := range bad {
+		if _, err := parseHandshakeInitMsg(b); err != ErrHandshake {
+			t.Errorf("%s: got %v, want ErrHandshake", name, err)
+		}
+	}
+	// A zero count is the PSK-only layout; a responder with components rejects it.
+	zero := mutate(func(b []byte) []byte { b[3] = 0; return b[:4] })
+	if _, _, err := HandshakeResp(Safe, zero, nil, opts...); err != ErrHandshake {
+		t.Errorf("zero count: got %v, want ErrHandshake", err)
+	}
+}
+
+// A low-order X25519 share must fail the handshake, not yield an all-zero
+// component secret.
+func TestKEMRejectsLowOrderShare(t *testing.T) {
+	initMsg, _, err := HandshakeInit(Safe, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(initMsg[3:3+x25519PubSize], make([]byte, x25519PubSize))
+	if _, _, err := HandshakeResp(Safe, initMsg, nil); err != ErrHandshake {
+		t.Fatalf("got %v, want ErrHandshake", err)
+	}
+}
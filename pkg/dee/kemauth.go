@@ -0,0 +1,86 @@
+package dee
+
+import (
+	"crypto/rand"
+	"io"
+
+	"github.com/cloudflare/circl/kem/mlkem/mlkem768"
+)
+
+const (
+	// KEMIdentityKeySize is the size of a KEMIdentity public key.
+	KEMIdentityKeySize = mlkem768.PublicKeySize
+
+	// kemAuthKEM is the component a KEMIdentity uses.
+	kemAuthKEM = KEMMLKEM768
+)
+
+// KEMIdentity is a responder's long-term ML-KEM-768 key pair, the KEMTLS-style
+// alternative to a signing Identity. An initiator that already knows the
+// public key encapsulates to it in the init message, and both sides mix the
+// shared secret into the master secret. Only the holder of the private key
+// can then produce the responder's Finished MAC, so the responder is
+// authenticated without a signature: nothing in the transcript proves to a
+// third party that it took part.
+type KEMIdentity struct {
+	priv   interface{}
+	public []byte
+}
+
+// GenerateKEMIdentity creates a new KEM identity key pair. randReader defaults
+// to crypto/rand.
+func GenerateKEMIdentity(randReader io.Reader) (*KEMIdentity, error) {
+	if randReader == nil {
+		randReader = rand.Reader
+	}
+	seed := make([]byte, kemAuthKEM.SeedSize())
+	if _, err := io.ReadFull(randReader, seed); err != nil {
+		return nil, err
+	}
+	public, priv, err := kemSchemes[kemAuthKEM].newKey(seed)
+	if err != nil {
+		return nil, err
+	}
+	return &KEMIdentity{priv: priv, public: public}, nil
+}
+
+// PublicKey returns the public key initiators pass to WithPeerKEMKey.
+func (id *KEMIdentity) PublicKey() []byte {
+	return append([]byte(nil), id.public...)
+}
+
+// WithKEMIdentity makes a responder authenticate by decapsulating the
+// initiator's ciphertext to id. Every init message must then carry one; an
+// initiator without the peer key fails the handshake.
+func WithKEMIdentity(id *KEMIdentity) Option {
+	return func(c *config) {
+		c.kemIdentity = id
+	}
+}
+
+// WithPeerKEMKey makes an initiator authenticate the responder by
+// encapsulating to its KEMIdentity public key. A responder without the
+// matching private key derives a different master secret, so
+// HandshakeComplete fails with ErrHandshake.
+func WithPeerKEMKey(pub []byte) Option {
+	return func(c *config) {
+		c.peerKEMKey = append([]byte{}, pub...)
+	}
+}
+
+// KEMAuthenticated reports whether the peer proved possession of a
+// KEMIdentity: on the initiator, the responder's Finished MAC verified under a
+// master secret that includes the encapsulation to WithPeerKEMKey.
+func (s *Session) KEMAuthenticated() bool {
+	defer s.lockBoth()()
+	return s.established && s.isInitiator && s.cfg.peerKEMKey != nil
+}
+
+// kemAuthDecapsulate returns the responder's shared secret for the init
+// message's ciphertext. respondTo has checked its presence and size.
+func kemAuthDecapsulate(cfg config, ct []byte) ([]byte, error) {
+	if cfg.kemIdentity == nil {
+		return nil, nil
+	}
+	return kemSchemes[kemAuthKEM].decapsulate(cfg.kemIdentity.priv, ct)
+}
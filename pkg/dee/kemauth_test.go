@@ -0,0 +1,157 @@
+package dee
+
+import (
+	"testing"
+)
+
+func newTestKEMIdentity(t testing.TB) *KEMIdentity {
+	t.Helper()
+	id, err := GenerateKEMIdentity(nil)
+	if err != nil {
+		t.Fatalf("GenerateKEMIdentity: %v", err)
+	}
+	return id
+}
+
+func TestKEMAuth(t *testing.T) {
+	server := newTestKEMIdentity(t)
+	for name, extra := range map[string][]Option{
+		"default":   nil,
+		"ML-KEM v2": {WithVersions(Version2), WithKEMs(KEMX25519, KEMMLKEM768)},
+		"with PSK":  {WithPSK(testPSKID, testPSK)},
+		"with PAKE": {WithPassword(testPassword)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			initOpts := append([]Option{WithPeerKEMKey(server.PublicKey())}, extra...)
+			respOpts := append([]Option{WithKEMIdentity(server)}, extra...)
+			initSession, respSession := sessionPair(t, Safe, initOpts, respOpts)
+			if !initSession.KEMAuthenticated() {
+				t.Fatal("initiator should report the responder as authenticated")
+			}
+			if respSession.KEMAuthenticated() || respSession.PeerIdentity() != nil {
+				t.Fatal("the initiator stays anonymous")
+			}
+			sendFrames(t, initSession, respSession, 2)
+			sendFrames(t, respSession, initSession, 2)
+		})
+	}
+}
+
+// The responder pays nothing on the wire: only the init message grows, by one
+// ML-KEM-768 ciphertext. Signature authentication instead adds the identity
+// key and both signatures to the response.
+func TestKEMAuthOverhead(t *testing.T) {
+	server := newTestKEMIdentity(t)
+	initMsg, _, err := HandshakeInit(Safe, nil, WithPeerKEMKey(server.PublicKey()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := initFixedSize + extHeaderSize + kemAuthKEM.CiphertextSize(); len(initMsg) != want {
+		t.Fatalf("init message %d bytes, want %d", len(initMsg), want)
+	}
+	respMsg, _, err := HandshakeResp(Safe, initMsg, nil, WithKEMIdentity(server))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := respFixedSize + extHeaderSize + finishedSize; len(respMsg) != want {
+		t.Fatalf("response %d bytes, want %d", len(respMsg), want)
+	}
+}
+
+func TestKEMAuthFailures(t *testing.T) {
+	server := newTestKEMIdentity(t)
+	other := newTestKEMIdentity(t)
+
+	// The wrong key decapsulates to an implicit-rejection secret, so the
+	// response looks normal and only its Finished MAC fails.
+	initMsg, initSession, err := HandshakeInit(Safe, nil, WithPeerKEMKey(server.PublicKey()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	respMsg, _, err := HandshakeResp(Safe, initMsg, nil, WithKEMIdentity(other))
+	if err != nil {
+		t.Fatalf("responder must not notice the wrong key: %v", err)
+	}
+	if err := initSession.HandshakeComplete(respMsg); err != ErrHandshake {
+		t.Fatalf("wrong key: got %v, want ErrHandshake", err)
+	}
+
+	// A MITM that swaps in its own ciphertext cannot make the initiator
+	// accept: the responder it talks to is not the pinned one.
+	initMsg, initSession, err = HandshakeInit(Safe, nil, WithPeerKEMKey(server.PublicKey()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged := append([]byte(nil), initMsg...)
+	forged[initFixedSize+extHeaderSize] ^= 1
+	respMsg, _, err = HandshakeResp(Safe, forged, nil, WithKEMIdentity(server))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := initSession.HandshakeComplete(respMsg); err != ErrHandshake {
+		t.Fatalf("tampered ciphertext: got %v, want ErrHandshake", err)
+	}
+
+	for name, tc := range map[string]struct{ initOpts, respOpts []Option }{
+		"initiator without peer key": {nil, []Option{WithKEMIdentity(server)}},
+		"responder without identity": {[]Option{WithPeerKEMKey(server.PublicKey())}, nil},
+	} {
+		initMsg, _, err := HandshakeInit(Safe, nil, tc.initOpts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := HandshakeResp(Safe, initMsg, nil, tc.respOpts...); err != ErrHandshake {
+			t.Errorf("%s: got %v, want ErrHandshake", name, err)
+		}
+	}
+
+	if _, _, err := HandshakeInit(Safe, nil, WithPeerKEMKey(server.PublicKey()[:10])); err != ErrConfig {
+		t.Fatalf("short peer key: got %v, want ErrConfig", err)
+	}
+}
+
+// BenchmarkHandshakeAuth compares a full three-flight handshake without
+// authentication, with KEM authentication and with signature authentication
+// of the responder. wire-B/op is the total handshake size.
+func BenchmarkHandshakeAuth(b *testing.B) {
+	kemID := newTestKEMIdentity(b)
+	sigID, err := GenerateIdentity(nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	cases := []struct {
+		name               string
+		initOpts, respOpts []Option
+	}{
+		{"anonymous", nil, nil},
+		{"kem", []Option{WithPeerKEMKey(kemID.PublicKey())}, []Option{WithKEMIdentity(kemID)}},
+		{"signature", []Option{WithPeerVerifier(pinned(sigID.PublicKey()))}, []Option{WithIdentity(sigID)}},
+	}
+	for _, tc := range cases {
+		b.Run(tc.name, func(b *testing.B) {
+			wire := 0
+			for i := 0; i < b.N; i++ {
+				initMsg, initSession, err := HandshakeInit(Safe, nil, tc.initOpts...)
+				if err != nil {
+					b.Fatal(err)
+				}
+				respMsg, respSession, err := HandshakeResp(Safe, initMsg, nil, tc.respOpts...)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if err := initSession.HandshakeComplete(respMsg); err != nil {
+					b.Fatal(err)
+				}
+				finMsg, err := initSession.HandshakeFinishMsg()
+				if err != nil {
+					b.Fatal(err)
+				}
+				if err := respSession.HandshakeFinish(finMsg); err != nil {
+					b.Fatal(err)
+				}
+				wire = len(initMsg) + len(respMsg) + len(finMsg)
+			}
+			b.ReportMetric(float64(wire), "wire-B/op")
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package dee
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// CounterLease bounds what a sending session may have used: every frame it
+// sealed had a counter below Counter and an epoch no later than Epoch.
+type CounterLease struct {
+	Counter uint64
+	Epoch   uint64
+}
+
+// CounterStore durably keeps the counter lease of one session's send
+// direction. StoreLease must not return nil before the lease would survive a
+// crash; a session seals nothing it has not leased.
+type CounterStore interface {
+	// LoadLease returns the last stored lease, or ok false if there is none.
+	LoadLease() (lease CounterLease, ok bool, err error)
+	// StoreLease replaces the stored lease.
+	StoreLease(lease CounterLease) error
+}
+
+// counterLease is a session's attached store and the lease it holds.
+type counterLease struct {
+	store CounterStore
+	block uint64
+	held  CounterLease
+}
+
+// LeaseCounters makes the send side reserve counters from store in blocks of
+// block before using them, so a session restored from an older snapshot never
+// reuses a counter, and with it a nonce, that the crashed process may have
+// sent.
+//
+// If store already holds a lease, the session moves past it: its counter to
+// the lease's end and its epoch, by hash ratchet, to the one after the
+// lease's. A peer that received frames from the crashed process then derives
+// the new epoch and sees a gap in the counters, which a SAFE peer accepts only
+// with WithReplayWindow. Call LeaseCounters on every restore, after
+// UnmarshalBinary, with the store of the original session. It does not work
+// with hybrid ratchet mode, whose keys a snapshot cannot catch up with, and
+// fails with ErrConfig there.
+//
+// Every block costs one StoreLease call, as does every new send epoch. If
+// StoreLease fails, the message is not sealed and the error is returned; the
+// session is left as it was and the call may be retried.
+func (s *Session) LeaseCounters(store CounterStore, block uint64) error {
+	defer s.lockTx()()
+	if !s.established || s.ratchet != nil || store == nil || block == 0 {
+		return ErrConfig
+	}
+	held, ok, err := store.LoadLease()
+	if err != nil {
+		return err
+	}
+	counter, epoch := s.tx.counter, s.tx.epoch
+	if ok {
+		if held.Counter > counter {
+			counter = held.Counter
+		}
+		if held.Epoch >= epoch {
+			epoch = held.Epoch + 1
+		}
+	}
+	if counter > ^uint64(0)-block {
+		return ErrConfig
+	}
+	next := CounterLease{Counter: counter + block, Epoch: epoch}
+	if err := store.StoreLease(next); err != nil {
+		return err
+	}
+	for s.tx.epoch < epoch {
+		s.tx.ratchetForward(s.ks, counter)
+	}
+	s.tx.counter = counter
+	s.lease = &counterLease{store: store, block: block, held: next}
+	return nil
+}
+
+// reserveCounters extends the lease when the next frame, at the current send
+// counter and epoch, would fall outside it.
+func (s *Session) reserveCounters() error {
+	l := s.lease
+	if l == nil || (s.tx.counter < l.held.Counter && s.tx.epoch <= l.held.Epoch) {
+		return nil
+	}
+	if s.tx.counter > ^uint64(0)-l.block {
+		return ErrLimit
+	}
+	next := CounterLease{Counter: s.tx.counter + l.block, Epoch: s.tx.epoch}
+	if err := l.store.StoreLease(next); err != nil {
+		return err
+	}
+	l.held = next
+	return nil
+}
+
+// FileCounterStore is a CounterStore kept in a file: 16 bytes holding the
+// counter and epoch. Each lease is written to a temporary file, synced and
+// renamed over the old one, so a crash leaves the old or the new lease.
+type FileCounterStore struct {
+	path string
+}
+
+// NewFileCounterStore returns a store persisted to path. The file is created
+// on the first StoreLease.
+func NewFileCounterStore(path string) *FileCounterStore {
+	return &FileCounterStore{path: path}
+}
+
+func (f *FileCounterStore) LoadLease() (CounterLease, bool, error) {
+	data, err := os.ReadFile(f.path)
+	if os.IsNotExist(err) {
+		return CounterLease{}, false, nil
+	}
+	if err != nil {
+		return CounterLease{}, false, err
+	}
+	if len(data) != 16 {
+		return CounterLease{}, false, errors.New("malformed counter lease file")
+	}
+	return CounterLease{Counter: binary.BigEndian.Uint64(data), Epoch: binary.BigEndian.Uint64(data[8:])}, true, nil
+}
+
+func (f *FileCounterStore) StoreLease(lease CounterLease) error {
+	data := binary.BigEndian.AppendUint64(nil, lease.Counter)
+	data = binary.BigEndian.AppendUint64(data, lease.Epoch)
+	tmp := f.path + ".tmp"
+	w, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Sync(); err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, f.path); err != nil {
+		return err
+	}
+	// Sync the directory so the rename itself is durable.
+	dir, err := os.Open(filepath.Dir(f.path))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
@@ -0,0 +1,179 @@
+package dee
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var errCrash = errors.New("crash")
+
+// crashStore is an in-memory CounterStore that crashes at a numbered point:
+// each StoreLease has one point before and one after the lease becomes
+// durable, and the test adds one after every frame it sends.
+type crashStore struct {
+	lease   CounterLease
+	ok      bool
+	point   int
+	crashAt int
+	writes  int
+}
+
+func (c *crashStore) step() bool {
+	c.point++
+	return c.point == c.crashAt
+}
+
+func (c *crashStore) LoadLease() (CounterLease, bool, error) {
+	return c.lease, c.ok, nil
+}
+
+func (c *crashStore) StoreLease(lease CounterLease) error {
+	if c.step() {
+		return errCrash
+	}
+	c.lease, c.ok = lease, true
+	c.writes++
+	if c.step() {
+		return errCrash
+	}
+	return nil
+}
+
+// Crash the sender at every point of a run that rekeys and crosses several
+// lease blocks, then restore it from a snapshot taken before the run: no
+// epoch and counter is ever sealed twice, and the peer opens everything the
+// restored sender sends.
+func TestLeaseCrashRecovery(t *testing.T) {
+	const frames = 12
+	opts := []Option{WithRekeyPolicy(RekeyPolicy{Messages: 5}), WithReplayWindow(MinReplayWindow)}
+	for crashAt := 1; ; crashAt++ {
+		initSession, respSession := sessionPair(t, Safe, opts, opts)
+		sendFrames(t, initSession, respSession, 2)
+		snapshot, err := initSession.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		store := &crashStore{crashAt: crashAt}
+		sealed := map[[2]uint64]bool{}
+		var lastEpoch uint64
+		send := func(s *Session) error {
+			at := [2]uint64{s.tx.epoch, s.tx.counter}
+			frame, err := s.EncryptToFrame([]byte("data"), nil)
+			if err != nil {
+				return err
+			}
+			if sealed[at] {
+				t.Fatalf("crash at %d: epoch %d counter %d sealed twice", crashAt, at[0], at[1])
+			}
+			sealed[at] = true
+			lastEpoch = at[0]
+			if _, err := respSession.DecryptFromFrame(frame); err != nil {
+				t.Fatalf("crash at %d: peer refused epoch %d counter %d", crashAt, at[0], at[1])
+			}
+			return nil
+		}
+
+		crashed := initSession.LeaseCounters(store, 4) == errCrash
+		for i := 0; i < frames && !crashed; i++ {
+			if err := send(initSession); err == errCrash {
+				crashed = true
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			crashed = crashed || store.step()
+		}
+		if !crashed {
+			if store.writes < 4 {
+				t.Fatalf("run took only %d leases", store.writes)
+			}
+			return
+		}
+
+		// Restart from the snapshot, which predates every frame above.
+		store.crashAt = 0
+		var restored Session
+		if err := restored.UnmarshalBinary(snapshot); err != nil {
+			t.Fatal(err)
+		}
+		if err := restored.LeaseCounters(store, 4); err != nil {
+			t.Fatal(err)
+		}
+		// Epochs the crashed process used may be gone from the peer already.
+		if len(sealed) > 0 && restored.tx.epoch <= lastEpoch {
+			t.Fatalf("crash at %d: restored at epoch %d, crashed process used %d", crashAt, restored.tx.epoch, lastEpoch)
+		}
+		for i := 0; i < frames; i++ {
+			if err := send(&restored); err != nil {
+				t.Fatal(err)
+			}
+		}
+		sendFrames(t, respSession, &restored, 2)
+	}
+}
+
+// A failed StoreLease leaves the session as it was; the next call retries.
+func TestLeaseStoreFailure(t *testing.T) {
+	initSession, respSession := sessionPair(t, Naive, nil, nil)
+	store := &crashStore{}
+	if err := initSession.LeaseCounters(store, 2); err != nil {
+		t.Fatal(err)
+	}
+	sendFrames(t, initSession, respSession, 2)
+	store.crashAt = store.point + 1
+	if _, err := initSession.EncryptToFrame([]byte("data"), nil); err != errCrash {
+		t.Fatalf("seal beyond the lease: got %v, want the store's error", err)
+	}
+	if initSession.tx.counter != 2 {
+		t.Fatalf("failed seal moved the counter to %d", initSession.tx.counter)
+	}
+	sendFrames(t, initSession, respSession, 3)
+	if store.lease.Counter <= initSession.tx.counter-1 {
+		t.Fatalf("lease %d does not cover counter %d", store.lease.Counter, initSession.tx.counter-1)
+	}
+}
+
+func TestLeaseConfig(t *testing.T) {
+	initSession, _ := sessionPair(t, Safe, nil, nil)
+	if err := initSession.LeaseCounters(&crashStore{}, 0); err != ErrConfig {
+		t.Fatalf("zero block: got %v, want ErrConfig", err)
+	}
+	if err := initSession.LeaseCounters(nil, 8); err != ErrConfig {
+		t.Fatalf("nil store: got %v, want ErrConfig", err)
+	}
+	opts := []Option{WithHybridRatchet(0)}
+	ratchet, _ := sessionPair(t, Safe, opts, opts)
+	if err := ratchet.LeaseCounters(&crashStore{}, 8); err != ErrConfig {
+		t.Fatalf("hybrid ratchet: got %v, want ErrConfig", err)
+	}
+	_, pending, err := HandshakeInit(Safe, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pending.LeaseCounters(&crashStore{}, 8); err != ErrConfig {
+		t.Fatalf("before the handshake: got %v, want ErrConfig", err)
+	}
+}
+
+func TestFileCounterStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lease")
+	store := NewFileCounterStore(path)
+	if _, ok, err := store.LoadLease(); ok || err != nil {
+		t.Fatalf("missing file: ok=%v, %v", ok, err)
+	}
+	want := CounterLease{Counter: 1 << 40, Epoch: 3}
+	if err := store.StoreLease(want); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok, err := NewFileCounterStore(path).LoadLease(); !ok || err != nil || got != want {
+		t.Fatalf("reloaded lease %+v, ok=%v, %v", got, ok, err)
+	}
+	if err := os.WriteFile(path, []byte{1, 2, 3}, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := store.LoadLease(); err == nil {
+		t.Fatal("malformed lease file accepted")
+	}
+}
@@ -0,0 +1,113 @@
+package dee
+
+import (
+	"errors"
+	"math"
+	"time"
+)
+
+// ErrLimit is returned when a session refuses to seal because a usage limit
+// is reached. It reads like ErrDecrypt; once a session is exhausted, Decrypt
+// fails with ErrDecrypt as well.
+var ErrLimit = errors.New("decryption failed")
+
+// maxCounter is the last counter a session may use. The replay window treats
+// the all-ones counter as invalid, so sealing stops one short of it.
+const maxCounter = math.MaxUint64 - 1
+
+// AEADLimits are the usage bounds of one key of a suite. Messages and Bytes
+// are confidentiality limits: a sender moves to a new epoch before it
+// exceeds either, whatever its RekeyPolicy. Forgeries is the integrity limit:
+// a session that has seen that many frames fail authentication refuses all
+// further use. Zero means no limit.
+type AEADLimits struct {
+	Messages  uint64
+	Bytes     uint64
+	Forgeries uint64
+}
+
+// Suite limits follow the AEAD usage limits of the CFRG analysis QUIC uses
+// (RFC 9001, section 6.6), at an attacker advantage of about 2^-57. AES-GCM
+// counts messages of up to 2^16 bytes. AES-GCM-SIV conservatively keeps the
+// AES-GCM bounds. ChaCha20-Poly1305 has no practical confidentiality limit.
+var suiteLimits = map[Suite]AEADLimits{
+	SuiteChaCha20Poly1305:  {Forgeries: 1 << 36},
+	SuiteAES256GCM:         {Messages: 1 << 23, Bytes: 1 << 39, Forgeries: 1 << 52},
+	SuiteXChaCha20Poly1305: {Forgeries: 1 << 36},
+	SuiteAES256GCMSIV:      {Messages: 1 << 23, Bytes: 1 << 39, Forgeries: 1 << 52},
+}
+
+// Limits returns the per-key usage limits of the suite.
+func (s Suite) Limits() AEADLimits {
+	return suiteLimits[s]
+}
+
+// SessionLimits bound a whole session rather than one epoch. Once a limit is
+// reached the session refuses to seal with ErrLimit; past Lifetime it also
+// refuses to open, with ErrDecrypt. Zero means no limit.
+type SessionLimits struct {
+	Messages uint64        // frames sent, key updates included
+	Bytes    uint64        // plaintext bytes sent
+	Lifetime time.Duration // from the end of the handshake
+}
+
+// WithSessionLimits sets limits on the session's total use. The peer needs
+// its own limits; they are not negotiated.
+func WithSessionLimits(l SessionLimits) Option {
+	return func(c *config) {
+		c.limits = l
+	}
+}
+
+// usage tracks what the session limits are checked against. Failed frames
+// are counted in Session.forgeries.
+type usage struct {
+	messages uint64
+	bytes    uint64
+	since    time.Time
+}
+
+// exhausted reports whether the session may no longer be used in either
+// direction: too many forgery attempts or past its lifetime.
+func (s *Session) exhausted() bool {
+	if l := s.ks.suite.Limits().Forgeries; l > 0 && s.forgeries.Load() >= l {
+		return true
+	}
+	return s.cfg.limits.Lifetime > 0 && timeNow().Sub(s.usage.since) >= s.cfg.limits.Lifetime
+}
+
+// checkSend returns ErrLimit if sealing n more plaintext bytes at the current
+// counter and epoch of d would cross a limit.
+func (s *Session) checkSend(d *direction, n int) error {
+	l := s.cfg.limits
+	switch {
+	case s.exhausted(),
+		d.counter > maxCounter,
+		s.ks.version != Version && d.epoch > math.MaxUint32,
+		l.Messages > 0 && s.usage.messages >= l.Messages,
+		l.Bytes > 0 && (uint64(n) > l.Bytes || s.usage.bytes > l.Bytes-uint64(n)):
+		return ErrLimit
+	}
+	return nil
+}
+
+// noteSent records a frame of n plaintext bytes sealed on d.
+func (s *Session) noteSent(d *direction, n int) {
+	d.counter++
+	d.bytes += uint64(n)
+	s.usage.messages++
+	s.usage.bytes += uint64(n)
+}
+
+// noteForgery records a frame that failed authentication.
+func (s *Session) noteForgery() {
+	s.forgeries.Add(1)
+}
+
+// minLimit returns the smaller of two limits where zero means none.
+func minLimit(a, b uint64) uint64 {
+	if a == 0 || (b != 0 && b < a) {
+		return b
+	}
+	return a
+}
@@ -0,0 +1,140 @@
+package dee
+
+import (
+	"testing"
+	"time"
+)
+
+// A suite's confidentiality limits force a new epoch even with automatic
+// rekeying turned off.
+func TestSuiteLimitsRekey(t *testing.T) {
+	off := WithRekeyPolicy(RekeyPolicy{})
+	for _, suite := range Suites() {
+		t.Run(suite.String(), func(t *testing.T) {
+			opts := []Option{WithSuites(suite), off}
+			initSession, respSession := sessionPair(t, Safe, opts, opts)
+			l := suite.Limits()
+			if l.Forgeries == 0 {
+				t.Fatal("every suite has an integrity limit")
+			}
+			sendFrames(t, initSession, respSession, 1)
+
+			// Well past the message limit of the AES suites.
+			initSession.tx.counter, respSession.rx.counter = 1<<40, 1<<40
+			sendFrames(t, initSession, respSession, 1)
+			wantEpoch := uint64(0)
+			if l.Messages > 0 {
+				wantEpoch = 1
+			}
+			if initSession.tx.epoch != wantEpoch {
+				t.Fatalf("epoch %d after %d messages, want %d", initSession.tx.epoch, 1<<40, wantEpoch)
+			}
+
+			// One message short of the byte limit.
+			if l.Bytes > 0 {
+				initSession.tx.bytes = l.Bytes - 3
+				sendFrames(t, initSession, respSession, 1)
+				if initSession.tx.epoch != wantEpoch+1 || initSession.tx.bytes != 4 {
+					t.Fatalf("epoch %d with %d bytes after crossing the byte limit", initSession.tx.epoch, initSession.tx.bytes)
+				}
+			}
+		})
+	}
+}
+
+func TestSessionLimits(t *testing.T) {
+	opts := []Option{WithSessionLimits(SessionLimits{Messages: 4})}
+	initSession, respSession := sessionPair(t, Safe, opts, opts)
+	sendFrames(t, initSession, respSession, 2)
+	ku, err := initSession.RequestKeyUpdate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := respSession.DecryptFromFrame(ku); err != nil {
+		t.Fatal(err)
+	}
+	restored := restoreSession(t, initSession)
+	sendFrames(t, restored, respSession, 1)
+	if _, err := restored.Encrypt([]byte("data"), nil); err != ErrLimit {
+		t.Fatalf("fifth message: got %v, want ErrLimit", err)
+	}
+	if _, err := restored.RequestKeyUpdate(); err != ErrLimit {
+		t.Fatalf("key update past the limit: got %v, want ErrLimit", err)
+	}
+	sendFrames(t, respSession, restored, 2)
+
+	opts = []Option{WithSessionLimits(SessionLimits{Bytes: 10})}
+	initSession, respSession = sessionPair(t, Naive, opts, opts)
+	sendFrames(t, initSession, respSession, 2)
+	if _, err := initSession.Encrypt([]byte("data"), nil); err != ErrLimit {
+		t.Fatalf("past the byte limit: got %v, want ErrLimit", err)
+	}
+	if _, err := initSession.EncryptNaiveWithNonce([]byte("data"), nil, make([]byte, NonceSize)); err != ErrLimit {
+		t.Fatalf("caller nonce past the byte limit: got %v, want ErrLimit", err)
+	}
+	if _, err := initSession.Encrypt([]byte("xy"), nil); err != nil {
+		t.Fatalf("message that fits: %v", err)
+	}
+}
+
+func TestSessionLifetime(t *testing.T) {
+	now := time.Now()
+	SetTimeNowForTest(func() time.Time { return now })
+	defer SetTimeNowForTest(nil)
+
+	opts := []Option{WithSessionLimits(SessionLimits{Lifetime: time.Hour})}
+	initSession, respSession := sessionPair(t, Safe, opts, opts)
+	now = now.Add(59 * time.Minute)
+	sendFrames(t, initSession, respSession, 1)
+	frame, err := initSession.EncryptToFrame([]byte("data"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(time.Minute)
+	if _, err := initSession.Encrypt([]byte("data"), nil); err != ErrLimit {
+		t.Fatalf("Encrypt past the lifetime: got %v, want ErrLimit", err)
+	}
+	if _, err := respSession.DecryptFromFrame(frame); err != ErrDecrypt {
+		t.Fatalf("Decrypt past the lifetime: got %v, want ErrDecrypt", err)
+	}
+}
+
+// After the suite's integrity limit of failed frames the session is done,
+// even for genuine frames.
+func TestForgeryLimit(t *testing.T) {
+	initSession, respSession := sessionPair(t, Safe, nil, nil)
+	frame, err := initSession.EncryptToFrame([]byte("data"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged := append([]byte(nil), frame...)
+	forged[len(forged)-1] ^= 1
+	if _, err := respSession.DecryptFromFrame(forged); err != ErrDecrypt {
+		t.Fatal(err)
+	}
+	if n := respSession.forgeries.Load(); n != 1 {
+		t.Fatalf("%d forgeries counted, want 1", n)
+	}
+	respSession.forgeries.Store(SuiteChaCha20Poly1305.Limits().Forgeries - 1)
+	if _, err := respSession.DecryptFromFrame(forged); err != ErrDecrypt {
+		t.Fatal(err)
+	}
+	if _, err := respSession.DecryptFromFrame(frame); err != ErrDecrypt {
+		t.Fatalf("genuine frame after the forgery limit: got %v, want ErrDecrypt", err)
+	}
+	if _, err := respSession.Encrypt([]byte("data"), nil); err != ErrLimit {
+		t.Fatalf("Encrypt after the forgery limit: got %v, want ErrLimit", err)
+	}
+}
+
+func TestCounterExhaustion(t *testing.T) {
+	initSession, respSession := sessionPair(t, Safe, nil, nil)
+	initSession.tx.counter, respSession.rx.counter = maxCounter, maxCounter
+	sendFrames(t, initSession, respSession, 1)
+	if _, err := initSession.Encrypt([]byte("data"), nil); err != ErrLimit {
+		t.Fatalf("sealing past the last counter: got %v, want ErrLimit", err)
+	}
+	if _, err := initSession.RequestKeyUpdate(); err != ErrLimit {
+		t.Fatalf("key update past the last counter: got %v, want ErrLimit", err)
+	}
+}
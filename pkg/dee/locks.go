@@ -0,0 +1,37 @@
+package dee
+
+// lockTx locks the send half of the session. In hybrid ratchet mode receiving
+// moves the send keys, so it locks both halves.
+func (s *Session) lockTx() (unlock func()) {
+	s.txMu.Lock()
+	if s.ratchet == nil {
+		return s.txMu.Unlock
+	}
+	s.rxMu.Lock()
+	return s.unlockBoth
+}
+
+// lockRx locks the receive half of the session, or both halves in hybrid
+// ratchet mode. The ratchet check needs a lock, and txMu must be taken first,
+// so in that mode it drops rxMu and starts over.
+func (s *Session) lockRx() (unlock func()) {
+	s.rxMu.Lock()
+	if s.ratchet == nil {
+		return s.rxMu.Unlock
+	}
+	s.rxMu.Unlock()
+	return s.lockBoth()
+}
+
+// lockBoth locks both halves, for calls that read or replace state shared
+// between them.
+func (s *Session) lockBoth() (unlock func()) {
+	s.txMu.Lock()
+	s.rxMu.Lock()
+	return s.unlockBoth
+}
+
+func (s *Session) unlockBoth() {
+	s.rxMu.Unlock()
+	s.txMu.Unlock()
+}
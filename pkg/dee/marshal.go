@@ -0,0 +1,494 @@
+package dee
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"time"
+
+	"deadend-lab/pkg/common"
+	"github.com/cloudflare/circl/kem/mlkem/mlkem768"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Session state format. A snapshot is magic(4) || format(1) || sealed(1)
+// followed by the state, or for a sealed snapshot by nonce(24) and the state
+// under XChaCha20-Poly1305 with the first six bytes as AD.
+const (
+	stateMagic  = "DEES"
+	stateFormat = 1
+	statePrefix = len(stateMagic) + 2
+
+	// Flags of the state body.
+	stateInitiator     = 1 << 0
+	stateEarlyAccepted = 1 << 1
+	stateResumed       = 1 << 2
+	stateRekeyPolicy   = 1 << 3
+	stateRatchet       = 1 << 4
+	stateReplayWindow  = 1 << 5
+	stateSessionLimits = 1 << 6
+)
+
+// WrapKeySize is the size of the key MarshalSealed and UnmarshalSealed take.
+const WrapKeySize = chacha20poly1305.KeySize
+
+// MarshalBinary returns a snapshot of an established session: its mode,
+// version and suite, role, master and traffic keys, counters, epochs, replay
+// window, rekey policy, usage limits and hybrid ratchet state. UnmarshalBinary restores it
+// and the session carries on from the exact counter and epoch it was at.
+//
+// The snapshot holds every key of the session in the clear; see MarshalSealed.
+// It does not include options that only shape the handshake, nor the
+// handshake's third flight, so take it after HandshakeFinishMsg. A restored
+// session draws randomness from crypto/rand. A snapshot restored after the
+// session sent more frames reuses their counters unless the session leases
+// them; see LeaseCounters. Streams have counters of their own that no lease
+// covers, so a session that has used streams cannot be saved.
+func (s *Session) MarshalBinary() ([]byte, error) {
+	return s.marshalState(nil)
+}
+
+// MarshalSealed is MarshalBinary with the snapshot encrypted under wrapKey,
+// which must be WrapKeySize bytes.
+func (s *Session) MarshalSealed(wrapKey []byte) ([]byte, error) {
+	if len(wrapKey) != WrapKeySize {
+		return nil, ErrConfig
+	}
+	return s.marshalState(wrapKey)
+}
+
+// UnmarshalBinary replaces s with the session in an unsealed snapshot. A
+// malformed snapshot, one of an unknown format and a sealed one all fail with
+// ErrDecrypt. s must be a zero Session or a closed one: restoring over a
+// session that holds keys would drop them unwiped, along with its counter
+// lease, so it fails with ErrConfig.
+func (s *Session) UnmarshalBinary(data []byte) error {
+	return s.unmarshalState(data, nil)
+}
+
+// UnmarshalSealed replaces s with the session in a snapshot sealed under
+// wrapKey. A wrong key fails with ErrDecrypt, like a damaged snapshot.
+func (s *Session) UnmarshalSealed(data, wrapKey []byte) error {
+	if len(wrapKey) != WrapKeySize {
+		return ErrConfig
+	}
+	return s.unmarshalState(data, wrapKey)
+}
+
+func (s *Session) marshalState(wrapKey []byte) ([]byte, error) {
+	defer s.lockBoth()()
+	if !s.established || s.streams != nil {
+		return nil, ErrConfig
+	}
+	prefix := append([]byte(stateMagic), stateFormat, 0)
+	body := s.appendState(nil)
+	if wrapKey == nil {
+		return append(prefix, body...), nil
+	}
+	prefix[statePrefix-1] = 1
+	aead, err := chacha20poly1305.NewX(wrapKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, chacha20poly1305.NonceSizeX)
+	if _, err := io.ReadFull(s.randReader(), nonce); err != nil {
+		return nil, err
+	}
+	out := append(prefix, nonce...)
+	return aead.Seal(out, nonce, body, prefix), nil
+}
+
+func (s *Session) unmarshalState(data, wrapKey []byte) error {
+	if len(data) < statePrefix || string(data[:len(stateMagic)]) != stateMagic || data[len(stateMagic)] != stateFormat {
+		return ErrDecrypt
+	}
+	prefix, body := data[:statePrefix], data[statePrefix:]
+	switch sealed := prefix[statePrefix-1]; {
+	case sealed == 0 && wrapKey == nil:
+	case sealed == 1 && wrapKey != nil:
+		aead, err := chacha20poly1305.NewX(wrapKey)
+		if err != nil || len(body) < chacha20poly1305.NonceSizeX {
+			return ErrDecrypt
+		}
+		nonce := body[:chacha20poly1305.NonceSizeX]
+		if body, err = aead.Open(nil, nonce, body[len(nonce):], prefix); err != nil {
+			return ErrDecrypt
+		}
+	default:
+		return ErrDecrypt
+	}
+	restored, ok := parseState(body)
+	if !ok {
+		return ErrDecrypt
+	}
+	defer s.lockBoth()()
+	// Only a zero or closed session has no keys or handshake state to lose.
+	if s.established || s.kMs != nil || s.initMsg != nil {
+		return ErrConfig
+	}
+	s.mode, s.ks, s.sessionID, s.isInitiator = restored.mode, restored.ks, restored.sessionID, restored.isInitiator
+	s.forgeries.Store(restored.forgeries.Load())
+	s.sessionState = restored.sessionState
+	return nil
+}
+
+func (s *Session) randReader() io.Reader {
+	if s.rand == nil {
+		return rand.Reader
+	}
+	return s.rand
+}
+
+func (s *Session) appendState(b []byte) []byte {
+	w := stateWriter{b: b}
+	var flags byte
+	if s.isInitiator {
+		flags |= stateInitiator
+	}
+	if s.earlyAccepted {
+		flags |= stateEarlyAccepted
+	}
+	if s.cfg.isResumed() {
+		flags |= stateResumed
+	}
+	if s.cfg.rekey != nil {
+		flags |= stateRekeyPolicy
+	}
+	if s.ratchet != nil {
+		flags |= stateRatchet
+	}
+	if s.rx.window != nil {
+		flags |= stateReplayWindow
+	}
+	if s.cfg.limits != (SessionLimits{}) {
+		flags |= stateSessionLimits
+	}
+	w.u8(flags)
+	w.u8(byte(s.mode))
+	w.u8(s.ks.version)
+	w.u8(byte(s.ks.suite))
+	w.bytes(s.sessionID)
+	w.bytes(s.transcriptHash)
+	w.bytes(s.kMs)
+	w.bytes(s.resumptionSecret)
+	w.bytes(s.peerIdentity)
+	w.bytes(s.cfg.peerKEMKey)
+	w.bytes(s.sasInit)
+	w.bytes(s.sasResp)
+	if p := s.cfg.rekey; p != nil {
+		w.u64(p.Messages)
+		w.u64(p.Bytes)
+		w.u64(uint64(p.Interval))
+	}
+	if l := s.cfg.limits; l != (SessionLimits{}) {
+		w.u64(l.Messages)
+		w.u64(l.Bytes)
+		w.u64(uint64(l.Lifetime))
+	}
+	w.u64(s.usage.messages)
+	w.u64(s.usage.bytes)
+	w.u64(uint64(s.usage.since.UnixNano()))
+	w.u64(s.forgeries.Load())
+	w.direction(&s.tx)
+	w.direction(&s.rx)
+	if win := s.rx.window; win != nil {
+		w.u64(win.size)
+		w.u64(win.top)
+		for _, word := range win.bitmap {
+			w.u64(word)
+		}
+		w.u8(byte(len(s.rx.retained)))
+		for _, r := range s.rx.retained {
+			w.u64(r.epoch)
+			w.u64(r.start)
+			w.keys(r.keys)
+		}
+	}
+	if r := s.ratchet; r != nil {
+		w.u64(r.every)
+		w.u64(r.sinceStep)
+		w.bool(r.wantOffer)
+		w.u64(r.steps)
+		w.u64(r.staleBefore)
+		var xPriv, kemPriv []byte
+		if r.xPriv != nil {
+			xPriv = r.xPriv.Bytes()
+			kemPriv = make([]byte, mlkem768.PrivateKeySize)
+			r.kemPriv.Pack(kemPriv)
+		}
+		w.bytes(xPriv)
+		w.bytes(kemPriv)
+		w.bytes(r.answer)
+		w.bytes(r.txSecret)
+		w.bool(r.rxPending)
+		w.u64(r.rxEpoch)
+		w.bytes(r.rxSecret)
+	}
+	return w.b
+}
+
+// parseState is the inverse of appendState. It checks the version, suite,
+// mode and field sizes; the restored session is established.
+func parseState(b []byte) (*Session, bool) {
+	r := stateReader{b: b}
+	flags := r.u8()
+	s := &Session{
+		mode:         Mode(r.u8()),
+		ks:           keySchedule{version: r.u8(), suite: Suite(r.u8())},
+		isInitiator:  flags&stateInitiator != 0,
+		sessionState: sessionState{established: true, rand: rand.Reader},
+	}
+	if _, ok := wireFor(s.ks.version); !ok {
+		return nil, false
+	}
+	if _, ok := suites[s.ks.suite]; !ok || (s.mode != Safe && s.mode != Naive) {
+		return nil, false
+	}
+	s.earlyAccepted = flags&stateEarlyAccepted != 0
+	s.sessionID = r.bytes()
+	if len(s.sessionID) != SessionIDSize {
+		return nil, false
+	}
+	s.transcriptHash = r.bytes()
+	s.kMs = r.bytes()
+	s.resumptionSecret = r.bytes()
+	s.peerIdentity = r.bytes()
+	s.cfg.peerKEMKey = r.bytes()
+	s.sasInit = r.bytes()
+	s.sasResp = r.bytes()
+	if flags&stateResumed != 0 {
+		// Marks the session resumed; the ticket was redeemed in the handshake.
+		s.cfg.resumed = &resumption{}
+	}
+	if flags&stateRekeyPolicy != 0 {
+		s.cfg.rekey = &RekeyPolicy{Messages: r.u64(), Bytes: r.u64(), Interval: time.Duration(r.u64())}
+	}
+	if flags&stateSessionLimits != 0 {
+		s.cfg.limits = SessionLimits{Messages: r.u64(), Bytes: r.u64(), Lifetime: time.Duration(r.u64())}
+	}
+	s.usage = usage{messages: r.u64(), bytes: r.u64(), since: time.Unix(0, int64(r.u64()))}
+	s.forgeries.Store(r.u64())
+	r.direction(s.ks, &s.tx)
+	r.direction(s.ks, &s.rx)
+	if flags&stateReplayWindow != 0 {
+		size := r.u64()
+		if size < MinReplayWindow || size > MaxReplayWindow {
+			return nil, false
+		}
+		s.cfg.replayWindow = int(size)
+		win := newReplayWindow(int(size))
+		win.top = r.u64()
+		for i := range win.bitmap {
+			win.bitmap[i] = r.u64()
+		}
+		s.rx.window = win
+		n := int(r.u8())
+		if n > maxRetainedEpochs {
+			return nil, false
+		}
+		for i := 0; i < n; i++ {
+			s.rx.retained = append(s.rx.retained, epochKeys{epoch: r.u64(), start: r.u64(), keys: r.keys(s.ks)})
+		}
+	}
+	if flags&stateRatchet != 0 {
+		h := &hybridRatchet{every: r.u64(), sinceStep: r.u64(), wantOffer: r.bool(), steps: r.u64(), staleBefore: r.u64()}
+		xPriv, kemPriv := r.bytes(), r.bytes()
+		if xPriv != nil {
+			var err error
+			if h.xPriv, err = ecdh.X25519().NewPrivateKey(xPriv); err != nil {
+				return nil, false
+			}
+			h.kemPriv = new(mlkem768.PrivateKey)
+			if h.kemPriv.Unpack(kemPriv) != nil {
+				return nil, false
+			}
+		}
+		h.answer = r.bytes()
+		h.txSecret = r.bytes()
+		h.rxPending = r.bool()
+		h.rxEpoch = r.u64()
+		h.rxSecret = r.bytes()
+		s.ratchet = h
+		s.cfg.ratchet, s.cfg.ratchetEvery = true, h.every
+	}
+	return s, !r.bad && len(r.b) == 0
+}
+
+// stateWriter appends fixed-width integers and length-prefixed byte strings.
+type stateWriter struct {
+	b []byte
+}
+
+func (w *stateWriter) u8(v byte) {
+	w.b = append(w.b, v)
+}
+
+func (w *stateWriter) bool(v bool) {
+	if v {
+		w.u8(1)
+	} else {
+		w.u8(0)
+	}
+}
+
+func (w *stateWriter) u64(v uint64) {
+	w.b = binary.BigEndian.AppendUint64(w.b, v)
+}
+
+func (w *stateWriter) bytes(v []byte) {
+	w.b = binary.BigEndian.AppendUint32(w.b, uint32(len(v)))
+	w.b = append(w.b, v...)
+}
+
+// keys writes the secret a key set is split from.
+func (w *stateWriter) keys(k trafficKeys) {
+	w.bytes(k.secret)
+}
+
+func (w *stateWriter) direction(d *direction) {
+	w.keys(d.keys)
+	w.u64(d.epoch)
+	w.u64(d.start)
+	w.u64(d.counter)
+	w.u64(d.bytes)
+	w.u64(uint64(d.started.UnixNano()))
+}
+
+// stateReader reads what stateWriter wrote. After the first short read every
+// read returns zero and bad is set.
+type stateReader struct {
+	b   []byte
+	bad bool
+}
+
+func (r *stateReader) next(n int) []byte {
+	if r.bad || n > len(r.b) {
+		r.bad = true
+		return nil
+	}
+	v := r.b[:n]
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *stateReader) u8() byte {
+	if v := r.next(1); v != nil {
+		return v[0]
+	}
+	return 0
+}
+
+func (r *stateReader) bool() bool {
+	switch r.u8() {
+	case 0:
+		return false
+	case 1:
+		return true
+	}
+	r.bad = true
+	return false
+}
+
+func (r *stateReader) u64() uint64 {
+	if v := r.next(8); v != nil {
+		return binary.BigEndian.Uint64(v)
+	}
+	return 0
+}
+
+// bytes returns a copy, or nil for an empty string.
+func (r *stateReader) bytes() []byte {
+	n := r.next(4)
+	if n == nil {
+		return nil
+	}
+	v := r.next(int(binary.BigEndian.Uint32(n)))
+	if len(v) == 0 {
+		return nil
+	}
+	return append([]byte(nil), v...)
+}
+
+// keys splits a key set from its secret. Every secret is 32 bytes.
+func (r *stateReader) keys(ks keySchedule) trafficKeys {
+	secret := r.bytes()
+	if len(secret) != 32 {
+		r.bad = true
+		return trafficKeys{}
+	}
+	return ks.trafficKeys(secret)
+}
+
+func (r *stateReader) direction(ks keySchedule, d *direction) {
+	d.keys = r.keys(ks)
+	d.epoch = r.u64()
+	d.start = r.u64()
+	d.counter = r.u64()
+	d.bytes = r.u64()
+	d.started = time.Unix(0, int64(r.u64()))
+}
+
+// sessionDebug is the JSON debug form of a session. Keys appear only as
+// fingerprints, so the form is safe to log but cannot be restored.
+type sessionDebug struct {
+	Format       int            `json:"format"`
+	Role         string         `json:"role"`
+	Mode         string         `json:"mode"`
+	Version      uint8          `json:"version"`
+	Suite        string         `json:"suite"`
+	SessionID    string         `json:"session_id"`
+	Established  bool           `json:"established"`
+	Resumed      bool           `json:"resumed,omitempty"`
+	Tx           directionDebug `json:"tx"`
+	Rx           directionDebug `json:"rx"`
+	RatchetSteps uint64         `json:"ratchet_steps,omitempty"`
+}
+
+type directionDebug struct {
+	Epoch       uint64   `json:"epoch"`
+	Start       uint64   `json:"start"`
+	Counter     uint64   `json:"counter"`
+	Bytes       uint64   `json:"bytes,omitempty"`
+	Fingerprint string   `json:"fingerprint"`
+	Retained    []uint64 `json:"retained_epochs,omitempty"`
+}
+
+// MarshalJSON returns a debug view of the session: role, mode, version,
+// suite, session ID and per-direction epochs and counters. Traffic keys show
+// as the first 8 bytes of the SHA-256 of their secret, so one side's tx
+// fingerprint matches the peer's rx fingerprint; no key material is included.
+func (s *Session) MarshalJSON() ([]byte, error) {
+	defer s.lockBoth()()
+	role := "responder"
+	if s.isInitiator {
+		role = "initiator"
+	}
+	v := sessionDebug{
+		Format:       stateFormat,
+		Role:         role,
+		Mode:         s.mode.String(),
+		Version:      s.ks.version,
+		Suite:        s.ks.suite.String(),
+		SessionID:    hex.EncodeToString(s.sessionID),
+		Established:  s.established,
+		Resumed:      s.cfg.isResumed(),
+		Tx:           debugDirection(&s.tx),
+		Rx:           debugDirection(&s.rx),
+		RatchetSteps: s.ratchetSteps(),
+	}
+	return json.Marshal(v)
+}
+
+func debugDirection(d *direction) directionDebug {
+	v := directionDebug{Epoch: d.epoch, Start: d.start, Counter: d.counter, Bytes: d.bytes}
+	if d.keys.secret != nil {
+		v.Fingerprint = hex.EncodeToString(common.HashSHA256(d.keys.secret)[:8])
+	}
+	for _, r := range d.retained {
+		v.Retained = append(v.Retained, r.epoch)
+	}
+	return v
+}
@@ -0,0 +1,247 @@
+package dee
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// restoreSession round-trips s through MarshalBinary and UnmarshalBinary.
+func restoreSession(t *testing.T, s *Session) *Session {
+	t.Helper()
+	data, err := s.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary: %v", err)
+	}
+	var restored Session
+	if err := restored.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary: %v", err)
+	}
+	return &restored
+}
+
+// A restored session picks up at the same counter and epoch: it seals the
+// very frames the original would have, rekeys at the same points, and its
+// peer opens them.
+func TestSessionMarshal(t *testing.T) {
+	SetRekeyEveryForTest(3)
+	defer SetRekeyEveryForTest(0)
+	for name, opts := range map[string][]Option{
+		"safe":           nil,
+		"replay window":  {WithReplayWindow(MinReplayWindow)},
+		"rekey policy":   {WithRekeyPolicy(RekeyPolicy{Messages: 2, Bytes: 10})},
+		"hybrid ratchet": {WithHybridRatchet(0)},
+		"xchacha":        {WithSuites(SuiteXChaCha20Poly1305)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			initSession, respSession := sessionPair(t, Safe, opts, opts)
+			sendFrames(t, initSession, respSession, 4)
+			sendFrames(t, respSession, initSession, 5)
+
+			initCopy, respCopy := restoreSession(t, initSession), restoreSession(t, respSession)
+			if initCopy.tx.epoch == 0 || initCopy.rx.epoch == 0 {
+				t.Fatalf("restored epochs %d/%d, want both past 0", initCopy.tx.epoch, initCopy.rx.epoch)
+			}
+			for i := 0; i < 7; i++ {
+				want, err := initSession.EncryptToFrame([]byte("next"), nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, err := initCopy.EncryptToFrame([]byte("next"), nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, want) {
+					t.Fatalf("frame %d after restore differs from the original's", i)
+				}
+				if pt, err := respCopy.DecryptFromFrame(got); err != nil || string(pt) != "next" {
+					t.Fatalf("restored peer, frame %d: %q, %v", i, pt, err)
+				}
+			}
+			if initCopy.tx.epoch != initSession.tx.epoch || initCopy.tx.counter != initSession.tx.counter {
+				t.Fatalf("restored sender at epoch %d counter %d, original at %d/%d",
+					initCopy.tx.epoch, initCopy.tx.counter, initSession.tx.epoch, initSession.tx.counter)
+			}
+			sendFrames(t, respCopy, initCopy, 4)
+		})
+	}
+}
+
+// An offer outstanding at snapshot time is still answered afterwards.
+func TestSessionMarshalRatchetOffer(t *testing.T) {
+	opts := []Option{WithHybridRatchet(0)}
+	initSession, respSession := sessionPair(t, Safe, opts, opts)
+	if err := initSession.RequestRatchet(); err != nil {
+		t.Fatal(err)
+	}
+	offer, err := initSession.EncryptToFrame([]byte("offer"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	initCopy := restoreSession(t, initSession)
+	if _, err := respSession.DecryptFromFrame(offer); err != nil {
+		t.Fatal(err)
+	}
+	sendFrames(t, respSession, initCopy, 1)
+	if initCopy.RatchetSteps() != 1 || respSession.RatchetSteps() != 1 {
+		t.Fatalf("ratchet steps %d/%d, want 1/1", initCopy.RatchetSteps(), respSession.RatchetSteps())
+	}
+	sendFrames(t, initCopy, respSession, 2)
+	sendFrames(t, respSession, initCopy, 2)
+}
+
+func TestSessionMarshalSealed(t *testing.T) {
+	initSession, respSession := sessionPair(t, Safe, nil, nil)
+	sendFrames(t, initSession, respSession, 2)
+	wrapKey := bytes.Repeat([]byte{7}, WrapKeySize)
+	sealed, err := initSession.MarshalSealed(wrapKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := initSession.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(sealed, initSession.tx.keys.secret) || !bytes.Contains(plain, initSession.tx.keys.secret) {
+		t.Fatal("sealed snapshot should hide the traffic secrets")
+	}
+
+	var restored Session
+	if err := restored.UnmarshalSealed(sealed, wrapKey); err != nil {
+		t.Fatal(err)
+	}
+	sendFrames(t, &restored, respSession, 2)
+
+	otherKey := bytes.Repeat([]byte{8}, WrapKeySize)
+	if err := restored.UnmarshalSealed(sealed, otherKey); err != ErrDecrypt {
+		t.Fatalf("wrong wrapping key: got %v, want ErrDecrypt", err)
+	}
+	if err := restored.UnmarshalBinary(sealed); err != ErrDecrypt {
+		t.Fatalf("sealed snapshot without key: got %v, want ErrDecrypt", err)
+	}
+	if err := restored.UnmarshalSealed(plain, wrapKey); err != ErrDecrypt {
+		t.Fatalf("unsealed snapshot with key: got %v, want ErrDecrypt", err)
+	}
+	tampered := append([]byte(nil), sealed...)
+	tampered[len(tampered)-1] ^= 1
+	if err := restored.UnmarshalSealed(tampered, wrapKey); err != ErrDecrypt {
+		t.Fatalf("tampered snapshot: got %v, want ErrDecrypt", err)
+	}
+	if _, err := initSession.MarshalSealed(wrapKey[:16]); err != ErrConfig {
+		t.Fatalf("short wrapping key: got %v, want ErrConfig", err)
+	}
+	if err := restored.UnmarshalSealed(sealed, nil); err != ErrConfig {
+		t.Fatalf("missing wrapping key: got %v, want ErrConfig", err)
+	}
+}
+
+func TestSessionUnmarshalMalformed(t *testing.T) {
+	initSession, _ := sessionPair(t, Safe, []Option{WithReplayWindow(MinReplayWindow), WithHybridRatchet(4)}, []Option{WithHybridRatchet(4)})
+	data, err := initSession.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s Session
+	for n := 0; n < len(data); n++ {
+		if err := s.UnmarshalBinary(data[:n]); err != ErrDecrypt {
+			t.Fatalf("snapshot cut at %d of %d bytes: got %v, want ErrDecrypt", n, len(data), err)
+		}
+	}
+	if err := s.UnmarshalBinary(append(data, 0)); err != ErrDecrypt {
+		t.Fatalf("trailing byte: got %v, want ErrDecrypt", err)
+	}
+	future := append([]byte(nil), data...)
+	future[len(stateMagic)] = stateFormat + 1
+	if err := s.UnmarshalBinary(future); err != ErrDecrypt {
+		t.Fatalf("unknown format: got %v, want ErrDecrypt", err)
+	}
+	if s.established {
+		t.Fatal("failed restore changed the session")
+	}
+
+	_, pending, err := HandshakeInit(Safe, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pending.MarshalBinary(); err != ErrConfig {
+		t.Fatalf("snapshot before the handshake: got %v, want ErrConfig", err)
+	}
+}
+
+// Restoring over a live session would rewind its counters under its lease;
+// it fails and the session carries on. Once closed, the session can be
+// restored and leased again.
+func TestSessionUnmarshalLive(t *testing.T) {
+	opts := []Option{WithReplayWindow(MinReplayWindow)}
+	initSession, respSession := sessionPair(t, Safe, opts, opts)
+	snapshot, err := initSession.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &crashStore{}
+	if err := initSession.LeaseCounters(store, 4); err != nil {
+		t.Fatal(err)
+	}
+	sendFrames(t, initSession, respSession, 3)
+	if err := initSession.UnmarshalBinary(snapshot); err != ErrConfig {
+		t.Fatalf("restore over an established session: got %v, want ErrConfig", err)
+	}
+	if initSession.tx.counter != 3 || initSession.lease == nil || initSession.lease.held != store.lease {
+		t.Fatalf("failed restore changed the session: counter %d, lease %+v", initSession.tx.counter, initSession.lease)
+	}
+	sendFrames(t, initSession, respSession, 2)
+
+	_, pending, err := HandshakeInit(Safe, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pending.UnmarshalBinary(snapshot); err != ErrConfig {
+		t.Fatalf("restore over a pending handshake: got %v, want ErrConfig", err)
+	}
+
+	if err := initSession.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := initSession.UnmarshalBinary(snapshot); err != nil {
+		t.Fatalf("restore over a closed session: %v", err)
+	}
+	if err := initSession.LeaseCounters(store, 4); err != nil {
+		t.Fatal(err)
+	}
+	if initSession.tx.counter < 5 {
+		t.Fatalf("restored session at counter %d, below the frames already sent", initSession.tx.counter)
+	}
+	sendFrames(t, initSession, respSession, 2)
+}
+
+func TestSessionMarshalJSON(t *testing.T) {
+	initSession, respSession := sessionPair(t, Safe, nil, nil)
+	sendFrames(t, initSession, respSession, 3)
+	var views [2]sessionDebug
+	for i, s := range []*Session{initSession, respSession} {
+		data, err := json.Marshal(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, secret := range [][]byte{s.kMs, s.tx.keys.secret, s.tx.keys.kAead, s.rx.keys.kAead} {
+			if strings.Contains(string(data), hex.EncodeToString(secret)) {
+				t.Fatalf("debug form leaks key material: %s", data)
+			}
+		}
+		if err := json.Unmarshal(data, &views[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	init, resp := views[0], views[1]
+	if init.Role != "initiator" || resp.Role != "responder" || init.Mode != "SAFE" {
+		t.Fatalf("roles %q/%q, mode %q", init.Role, resp.Role, init.Mode)
+	}
+	if init.Tx.Counter != 3 || resp.Rx.Counter != 3 {
+		t.Fatalf("counters %d/%d, want 3/3", init.Tx.Counter, resp.Rx.Counter)
+	}
+	if init.Tx.Fingerprint == "" || init.Tx.Fingerprint != resp.Rx.Fingerprint || init.Tx.Fingerprint == init.Rx.Fingerprint {
+		t.Fatalf("fingerprints: initiator tx %s rx %s, responder rx %s", init.Tx.Fingerprint, init.Rx.Fingerprint, resp.Rx.Fingerprint)
+	}
+}
@@ -0,0 +1,161 @@
+package dee
+
+import (
+	"runtime"
+	"sync"
+)
+
+// Locked key memory. Every session key is 32 bytes, so the region is carved
+// into 32-byte cells. lockedRegionSize covers the worst case of a session: two
+// directions mid-ratchet, 32 retained and 16 freshly derived receive epochs,
+// the master and resumption secrets. Stream keys take cells too; with many
+// streams open the region can run out, and further keys stay in ordinary
+// memory.
+const (
+	lockedCellSize   = 32
+	lockedRegionSize = 16 << 10
+)
+
+// WithLockedMemory keeps the session's keys in memory locked into RAM
+// (mlock), so they are never written to swap. It is supported on Linux only;
+// elsewhere, or if the process may not lock 16 KiB (RLIMIT_MEMLOCK), the
+// handshake fails with ErrConfig. Keys are wiped when they are replaced and on
+// Close either way.
+func WithLockedMemory() Option {
+	return func(c *config) {
+		c.lockMemory = true
+	}
+}
+
+// lockedMem hands out 32-byte cells of a locked region. A nil *lockedMem is
+// ordinary memory: keep returns its argument and free only wipes. Both halves
+// of a session draw from it, so it has a lock of its own.
+type lockedMem struct {
+	mu     sync.Mutex
+	region []byte
+	cells  [][]byte
+	owned  map[*byte]bool
+}
+
+func newLockedMem() (*lockedMem, error) {
+	region, err := lockRegion(lockedRegionSize)
+	if err != nil {
+		return nil, ErrConfig
+	}
+	m := &lockedMem{region: region, owned: map[*byte]bool{}}
+	for off := 0; off < len(region); off += lockedCellSize {
+		cell := region[off : off+lockedCellSize : off+lockedCellSize]
+		m.cells = append(m.cells, cell)
+		m.owned[&cell[0]] = true
+	}
+	runtime.SetFinalizer(m, (*lockedMem).release)
+	return m, nil
+}
+
+// keep moves b into a locked cell and wipes the original. If the region is
+// exhausted, which only stream keys can do, b stays where it is.
+func (m *lockedMem) keep(b []byte) []byte {
+	if m == nil {
+		return b
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(b) == 0 || len(b) > lockedCellSize || m.owned[&b[0]] || len(m.cells) == 0 {
+		return b
+	}
+	cell := m.cells[len(m.cells)-1][:len(b)]
+	m.cells = m.cells[:len(m.cells)-1]
+	copy(cell, b)
+	wipe(b)
+	return cell
+}
+
+// free wipes b and returns its cell, if it has one, to the region.
+func (m *lockedMem) free(b []byte) {
+	wipe(b)
+	if m == nil || len(b) == 0 {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.owned[&b[0]] {
+		m.cells = append(m.cells, b[:lockedCellSize:lockedCellSize])
+	}
+}
+
+func (m *lockedMem) keepKeys(k trafficKeys) trafficKeys {
+	return trafficKeys{secret: m.keep(k.secret), kAead: m.keep(k.kAead), kNonce: m.keep(k.kNonce), kAudit: m.keep(k.kAudit), kRekey: m.keep(k.kRekey)}
+}
+
+func (m *lockedMem) freeKeys(k trafficKeys) {
+	for _, b := range [][]byte{k.secret, k.kAead, k.kNonce, k.kAudit, k.kRekey} {
+		m.free(b)
+	}
+}
+
+// release wipes, unlocks and unmaps the region.
+func (m *lockedMem) release() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.region == nil {
+		return
+	}
+	wipe(m.region)
+	unlockRegion(m.region)
+	m.region, m.cells, m.owned = nil, nil, nil
+	runtime.SetFinalizer(m, nil)
+}
+
+// wipe overwrites b with zeros.
+func wipe(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+// Close wipes the session's keys, secrets and its own copies of the handshake
+// messages, drops any outstanding ratchet offer's private keys and releases
+// its locked memory. Every later call fails as on a session that never
+// completed its handshake: Encrypt and Decrypt with ErrDecrypt, the others
+// with ErrConfig or ErrHandshake. Close always returns nil and may be called
+// more than once.
+func (s *Session) Close() error {
+	defer s.lockBoth()()
+	mem := s.cfg.mem
+	s.tx.wipe()
+	s.rx.wipe()
+	for _, b := range [][]byte{s.kMs, s.resumptionSecret, s.confirmKey, s.kemAuthSecret, s.initMsg, s.respMsg, s.sasInit, s.sasResp, s.sasCommitment} {
+		mem.free(b)
+	}
+	if r := s.ratchet; r != nil {
+		for _, b := range [][]byte{r.answer, r.txSecret, r.rxSecret} {
+			wipe(b)
+		}
+		r.xPriv, r.kemPriv = nil, nil
+	}
+	for _, e := range []*earlyState{s.earlyTx, s.earlyRx} {
+		if e != nil {
+			mem.freeKeys(e.keys)
+		}
+	}
+	s.streamMu.Lock()
+	defer s.streamMu.Unlock()
+	if s.streams != nil {
+		s.streams.wipe(mem)
+	}
+	mem.release()
+	s.sessionState = sessionState{closed: true}
+	return nil
+}
+
+// wipe frees the keys of the direction and of its retained epochs.
+func (d *direction) wipe() {
+	d.mem.freeKeys(d.keys)
+	for _, r := range d.retained {
+		d.mem.freeKeys(r.keys)
+	}
+	d.keys, d.retained = trafficKeys{}, nil
+}
@@ -0,0 +1,178 @@
+package dee
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func isZero(b []byte) bool {
+	return len(b) > 0 && bytes.Count(b, []byte{0}) == len(b)
+}
+
+func TestSessionClose(t *testing.T) {
+	keys := newTestTicketKeys(t, time.Hour, time.Hour)
+	initSession, respSession := sessionPair(t, Safe, nil, nil)
+	sendFrames(t, initSession, respSession, 2)
+	frame, err := respSession.EncryptToFrame([]byte("late"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secrets := [][]byte{initSession.kMs, initSession.resumptionSecret, initSession.tx.keys.kAead, initSession.tx.keys.kRekey, initSession.rx.keys.kNonce, initSession.respMsg}
+	if err := initSession.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for i, b := range secrets {
+		if !isZero(b) {
+			t.Fatalf("secret %d not wiped", i)
+		}
+	}
+	if err := initSession.Close(); err != nil {
+		t.Fatalf("second Close: %v", err)
+	}
+	if _, err := initSession.Encrypt([]byte("x"), nil); err != ErrDecrypt {
+		t.Fatalf("Encrypt after Close: got %v, want ErrDecrypt", err)
+	}
+	if _, err := initSession.DecryptFromFrame(frame); err != ErrDecrypt {
+		t.Fatalf("Decrypt after Close: got %v, want ErrDecrypt", err)
+	}
+	if _, err := initSession.RequestKeyUpdate(); err != ErrDecrypt {
+		t.Fatalf("RequestKeyUpdate after Close: got %v, want ErrDecrypt", err)
+	}
+	if _, err := initSession.MarshalBinary(); err != ErrConfig {
+		t.Fatalf("MarshalBinary after Close: got %v, want ErrConfig", err)
+	}
+	if _, err := initSession.ReceiveTicket(nil); err != ErrConfig {
+		t.Fatalf("ReceiveTicket after Close: got %v, want ErrConfig", err)
+	}
+	respSession.Close()
+	if _, err := respSession.IssueTicket(keys); err != ErrConfig {
+		t.Fatalf("IssueTicket after Close: got %v, want ErrConfig", err)
+	}
+
+	// A session closed before its handshake completes cannot complete it.
+	initMsg, pending, err := HandshakeInit(Safe, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	respMsg, _, err := HandshakeResp(Safe, initMsg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending.Close()
+	if err := pending.HandshakeComplete(respMsg); err != ErrHandshake {
+		t.Fatalf("HandshakeComplete after Close: got %v, want ErrHandshake", err)
+	}
+}
+
+// Close wipes only the session's own state, never the handshake messages the
+// caller passed in or got back.
+func TestCloseKeepsCallerMessages(t *testing.T) {
+	initMsg, initSession, err := HandshakeInit(Safe, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	respMsg, respSession, err := HandshakeResp(Safe, initMsg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := initSession.HandshakeComplete(respMsg); err != nil {
+		t.Fatal(err)
+	}
+	finishHandshake(t, initSession, respSession)
+	wantInit, wantResp := append([]byte(nil), initMsg...), append([]byte(nil), respMsg...)
+	initSession.Close()
+	respSession.Close()
+	if !bytes.Equal(initMsg, wantInit) || !bytes.Equal(respMsg, wantResp) {
+		t.Fatal("Close wiped the caller's handshake messages")
+	}
+}
+
+// Close drops the private keys of a ratchet offer still waiting for its answer.
+func TestCloseDropsRatchetOffer(t *testing.T) {
+	opts := []Option{WithHybridRatchet(0)}
+	initSession, _ := sessionPair(t, Safe, opts, opts)
+	if err := initSession.RequestRatchet(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := initSession.EncryptToFrame([]byte("offer"), nil); err != nil {
+		t.Fatal(err)
+	}
+	r := initSession.ratchet
+	if r.xPriv == nil || r.kemPriv == nil {
+		t.Fatal("no outstanding offer")
+	}
+	initSession.Close()
+	if r.xPriv != nil || r.kemPriv != nil {
+		t.Fatal("Close kept the offer's private keys")
+	}
+}
+
+// Keys an epoch no longer needs are wiped when the direction moves on.
+func TestRekeyWipesOldKeys(t *testing.T) {
+	initSession, respSession := sessionPair(t, Safe, nil, nil)
+	sendFrames(t, initSession, respSession, 1)
+	oldTx, oldRx := initSession.tx.keys, respSession.rx.keys
+	ku, err := initSession.RequestKeyUpdate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isZero(oldTx.kAead) || !isZero(oldTx.kRekey) {
+		t.Fatal("sender kept the old epoch's keys")
+	}
+	if _, err := respSession.DecryptFromFrame(ku); err != nil {
+		t.Fatal(err)
+	}
+	if !isZero(oldRx.kAead) || !isZero(oldRx.secret) {
+		t.Fatal("receiver kept the old epoch's keys")
+	}
+
+	// With a replay window the receiver keeps an epoch until the window has
+	// passed it.
+	opts := []Option{WithReplayWindow(MinReplayWindow)}
+	initSession, respSession = sessionPair(t, Safe, opts, opts)
+	sendFrames(t, initSession, respSession, 1)
+	oldRx = respSession.rx.keys
+	if ku, err = initSession.RequestKeyUpdate(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := respSession.DecryptFromFrame(ku); err != nil {
+		t.Fatal(err)
+	}
+	if isZero(oldRx.kAead) {
+		t.Fatal("retained epoch wiped while the window still reaches it")
+	}
+	sendFrames(t, initSession, respSession, MinReplayWindow+1)
+	if len(respSession.rx.retained) != 0 || !isZero(oldRx.kAead) {
+		t.Fatal("epoch below the window not wiped")
+	}
+}
+
+func TestLockedMemory(t *testing.T) {
+	if _, err := newLockedMem(); err != nil {
+		t.Skipf("locked memory unavailable: %v", err)
+	}
+	opts := []Option{WithLockedMemory(), WithReplayWindow(MinReplayWindow), WithRekeyPolicy(RekeyPolicy{Messages: 3})}
+	initSession, respSession := sessionPair(t, Safe, opts, opts)
+	mem := initSession.cfg.mem
+	locked := func(b []byte) bool { return mem.owned[&b[0]] }
+	if !locked(initSession.kMs) || !locked(initSession.tx.keys.kAead) || !locked(initSession.rx.keys.kRekey) {
+		t.Fatal("session keys outside the locked region")
+	}
+	free := len(mem.cells)
+	for i := 0; i < 20; i++ {
+		sendFrames(t, initSession, respSession, 7)
+		sendFrames(t, respSession, initSession, 7)
+	}
+	if initSession.tx.epoch < 40 || !locked(initSession.tx.keys.kAead) {
+		t.Fatalf("epoch %d, keys locked %v", initSession.tx.epoch, locked(initSession.tx.keys.kAead))
+	}
+	// Only the retained receive epochs still hold cells.
+	if used := free - len(mem.cells); used != 5*len(initSession.rx.retained) {
+		t.Fatalf("%d cells in use for %d retained epochs", used, len(initSession.rx.retained))
+	}
+	initSession.Close()
+	if mem.region != nil {
+		t.Fatal("Close kept the locked region")
+	}
+}
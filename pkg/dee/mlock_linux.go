@@ -0,0 +1,26 @@
+//go:build linux
+
+package dee
+
+import "golang.org/x/sys/unix"
+
+// lockRegion maps n bytes of anonymous memory, locks them into RAM and keeps
+// them out of core dumps.
+func lockRegion(n int) ([]byte, error) {
+	b, err := unix.Mmap(-1, 0, n, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANONYMOUS)
+	if err != nil {
+		return nil, err
+	}
+	if err := unix.Mlock(b); err != nil {
+		unix.Munmap(b)
+		return nil, err
+	}
+	// Best effort: older kernels do not know MADV_DONTDUMP.
+	_ = unix.Madvise(b, unix.MADV_DONTDUMP)
+	return b, nil
+}
+
+func unlockRegion(b []byte) {
+	unix.Munlock(b)
+	unix.Munmap(b)
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package dee
+
+import "errors"
+
+func lockRegion(n int) ([]byte, error) {
+	return nil, errors.New("locked memory is only supported on linux")
+}
+
+func unlockRegion(b []byte) {}
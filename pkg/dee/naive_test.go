@@ -9,6 +9,7 @@ func TestNAIVEAcceptsReplay(t *testing.T) {
 	initMsg, initSession, _ := HandshakeInit(Naive, nil)
 	respMsg, respSession, _ := HandshakeResp(Naive, initMsg, nil)
 	_ = initSession.HandshakeComplete(respMsg)
+	finishHandshake(t, initSession, respSession)
 
 	ct, _ := initSession.Encrypt([]byte("once"), nil)
 	header := respSession.WireHeader(0)
@@ -30,6 +31,7 @@ func TestSAFERejectsCallerNonce(t *testing.T) {
 	initMsg, initSession, _ := HandshakeInit(Safe, nil)
 	respMsg, respSession, _ := HandshakeResp(Safe, initMsg, nil)
 	_ = initSession.HandshakeComplete(respMsg)
+	finishHandshake(t, initSession, respSession)
 
 	nonce := make([]byte, NonceSize)
 	_, err := initSession.EncryptNaiveWithNonce([]byte("x"), nil, nonce)
@@ -0,0 +1,80 @@
+package dee
+
+// WithFallbackModes lists modes this side also supports after the mode passed
+// to the handshake, in order of preference. An initiator sends the whole list
+// as its offer; a responder picks the first offered mode it supports. The offer
+// is part of the init message and therefore of the transcript, so a rewritten
+// offer leaves the peers with different keys.
+func WithFallbackModes(modes ...Mode) Option {
+	return func(c *config) {
+		c.fallbackModes = append([]Mode(nil), modes...)
+	}
+}
+
+// Mode returns the session's mode. After a negotiated handshake this is the
+// mode the responder selected.
+func (s *Session) Mode() Mode {
+	return s.mode
+}
+
+// modes returns the supported modes: mode first, then the fallbacks without
+// duplicates.
+func (c config) modes(mode Mode) []Mode {
+	list := []Mode{mode}
+	for _, m := range c.fallbackModes {
+		if !containsMode(list, m) {
+			list = append(list, m)
+		}
+	}
+	return list
+}
+
+// accepts reports whether an initiator that started in mode may end up in got.
+func (c config) accepts(mode, got Mode) bool {
+	return containsMode(c.modes(mode), got)
+}
+
+// selectMode picks the responder's mode: the first entry of the initiator's
+// offer that it supports. An init message without an offer offers only the
+// mode in its fixed header, which must also head any explicit offer.
+func selectMode(mode Mode, fixedMode byte, initExts extensions, cfg config) (Mode, error) {
+	offer := []byte{fixedMode}
+	if v, ok := initExts[extModes]; ok {
+		if len(v) == 0 || v[0] != fixedMode {
+			return 0, ErrHandshake
+		}
+		offer = v
+	}
+	seen := make([]Mode, 0, len(offer))
+	for _, b := range offer {
+		m := Mode(b)
+		if (m != Safe && m != Naive) || containsMode(seen, m) {
+			return 0, ErrHandshake
+		}
+		seen = append(seen, m)
+	}
+	supported := cfg.modes(mode)
+	for _, m := range seen {
+		if containsMode(supported, m) {
+			return m, nil
+		}
+	}
+	return 0, ErrHandshake
+}
+
+func containsMode(list []Mode, m Mode) bool {
+	for _, x := range list {
+		if x == m {
+			return true
+		}
+	}
+	return false
+}
+
+func modeBytes(modes []Mode) []byte {
+	b := make([]byte, len(modes))
+	for i, m := range modes {
+		b[i] = byte(m)
+	}
+	return b
+}
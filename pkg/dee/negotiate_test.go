@@ -0,0 +1,76 @@
+package dee
+
+import "testing"
+
+func TestModeNegotiation(t *testing.T) {
+	cases := []struct {
+		name      string
+		initMode  Mode
+		initOpts  []Option
+		respMode  Mode
+		respOpts  []Option
+		want      Mode
+		wantError bool
+	}{
+		{"both prefer safe", Safe, []Option{WithFallbackModes(Naive)}, Safe, []Option{WithFallbackModes(Naive)}, Safe, false},
+		{"responder naive only", Safe, []Option{WithFallbackModes(Naive)}, Naive, nil, Naive, false},
+		{"initiator preference wins", Naive, []Option{WithFallbackModes(Safe)}, Safe, []Option{WithFallbackModes(Naive)}, Naive, false},
+		{"legacy initiator", Safe, nil, Naive, []Option{WithFallbackModes(Safe)}, Safe, false},
+		{"no common mode", Safe, nil, Naive, nil, 0, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			initMsg, initSession, err := HandshakeInit(tc.initMode, nil, tc.initOpts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			respMsg, respSession, err := HandshakeResp(tc.respMode, initMsg, nil, tc.respOpts...)
+			if tc.wantError {
+				if err != ErrHandshake {
+					t.Fatalf("got %v, want ErrHandshake", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("HandshakeResp: %v", err)
+			}
+			if err := initSession.HandshakeComplete(respMsg); err != nil {
+				t.Fatalf("HandshakeComplete: %v", err)
+			}
+			finishHandshake(t, initSession, respSession)
+			if initSession.Mode() != tc.want || respSession.Mode() != tc.want {
+				t.Fatalf("modes: initiator %v responder %v, want %v", initSession.Mode(), respSession.Mode(), tc.want)
+			}
+			sendFrames(t, initSession, respSession, 2)
+		})
+	}
+}
+
+func TestModeNegotiationRejectsUnofferedChoice(t *testing.T) {
+	initMsg, initSession, err := HandshakeInit(Safe, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	respMsg, _, err := HandshakeResp(Safe, initMsg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	respMsg[1] = byte(Naive)
+	if err := initSession.HandshakeComplete(respMsg); err != ErrHandshake {
+		t.Fatalf("got %v, want ErrHandshake", err)
+	}
+}
+
+func TestSelectModeMalformedOffer(t *testing.T) {
+	cfg := config{fallbackModes: []Mode{Naive}}
+	for _, offer := range [][]byte{
+		{},
+		{byte(Naive), byte(Safe)}, // does not start with the header mode
+		{byte(Safe), byte(Safe)},
+		{byte(Safe), 0x7f},
+	} {
+		if _, err := selectMode(Safe, byte(Safe), extensions{extModes: offer}, cfg); err != ErrHandshake {
+			t.Errorf("offer %x: got %v, want ErrHandshake", offer, err)
+		}
+	}
+}
@@ -0,0 +1,325 @@
+// Package noise runs Noise-framework handshakes with hybrid post-quantum KEMs
+// in place of Diffie-Hellman (PQNoise) and turns the result into a dee.Session.
+//
+// The symmetric state is the Noise one over SHA-256 and ChaCha20-Poly1305
+// (MixHash, MixKey, EncryptAndHash, Split). Every KEM is a dee.HybridKEM over
+// the configured components, so a pattern stays secure while any component
+// holds. Patterns are plain data; NN, NK, XX and IK are built in.
+package noise
+
+import (
+	"crypto/rand"
+	"io"
+	"strings"
+
+	"deadend-lab/pkg/dee"
+)
+
+// defaultKEMs is X25519 + ML-KEM-768.
+var defaultKEMs = []dee.KEM{dee.KEMX25519, dee.KEMMLKEM768}
+
+// Keypair is a static KEM key pair. Private is the dee.HybridKEM seed.
+type Keypair struct {
+	Private []byte
+	Public  []byte
+}
+
+// GenerateKeypair returns a static key pair for kems (the default when empty)
+// drawn from randReader (crypto/rand when nil).
+func GenerateKeypair(kems []dee.KEM, randReader io.Reader) (*Keypair, error) {
+	if randReader == nil {
+		randReader = rand.Reader
+	}
+	if len(kems) == 0 {
+		kems = defaultKEMs
+	}
+	h, err := dee.NewHybridKEM(dee.CombinerConcat, kems...)
+	if err != nil {
+		return nil, err
+	}
+	return newKeypair(h, randReader)
+}
+
+func newKeypair(h *dee.HybridKEM, randReader io.Reader) (*Keypair, error) {
+	seed := make([]byte, h.SeedSize())
+	if _, err := io.ReadFull(randReader, seed); err != nil {
+		return nil, err
+	}
+	pub, err := h.PublicKey(seed)
+	if err != nil {
+		return nil, err
+	}
+	return &Keypair{Private: seed, Public: pub}, nil
+}
+
+// Config describes one side of a handshake.
+type Config struct {
+	Pattern   Pattern
+	Initiator bool
+	// Mode is the mode of the resulting session; the default is dee.Safe.
+	Mode dee.Mode
+	// KEMs are the hybrid components, X25519 + ML-KEM-768 by default, and
+	// Combiner fuses them (dee.CombinerConcat by default). Both are part of
+	// the protocol name, so peers that disagree fail the first encrypted
+	// payload.
+	KEMs     []dee.KEM
+	Combiner dee.Combiner
+	Prologue []byte
+	// Static is the local static key pair, required when the pattern sends
+	// or pre-shares it. PeerStatic is the peer's static public key from a
+	// pre-message.
+	Static     *Keypair
+	PeerStatic []byte
+	Rand       io.Reader
+	// SessionOptions configure the dee.Session; see dee.NewSession.
+	SessionOptions []dee.Option
+}
+
+// HandshakeState runs one side of a handshake. Call WriteMessage and
+// ReadMessage in the pattern's order, then Session.
+type HandshakeState struct {
+	cfg      Config
+	kem      *dee.HybridKEM
+	pubSize  int
+	ctSize   int
+	ss       *symmetricState
+	role     int // 0 initiator, 1 responder
+	next     int // index of the next message
+	e        *Keypair
+	re       []byte
+	rs       []byte
+	failed   bool
+	finished bool
+}
+
+// NewHandshake checks cfg against its pattern and initializes the symmetric
+// state with the protocol name, the prologue and any pre-message keys.
+func NewHandshake(cfg Config) (*HandshakeState, error) {
+	if cfg.Rand == nil {
+		cfg.Rand = rand.Reader
+	}
+	if cfg.Mode == 0 {
+		cfg.Mode = dee.Safe
+	}
+	if len(cfg.KEMs) == 0 {
+		cfg.KEMs = defaultKEMs
+	}
+	if cfg.Combiner == 0 {
+		cfg.Combiner = dee.CombinerConcat
+	}
+	if !cfg.Pattern.valid() || (cfg.Combiner == dee.CombinerXOR && cfg.Mode.IsSafe()) {
+		return nil, dee.ErrConfig
+	}
+	kem, err := dee.NewHybridKEM(cfg.Combiner, cfg.KEMs...)
+	if err != nil {
+		return nil, err
+	}
+	hs := &HandshakeState{cfg: cfg, kem: kem}
+	if !cfg.Initiator {
+		hs.role = 1
+	}
+	for _, k := range cfg.KEMs {
+		hs.pubSize += k.PublicKeySize()
+		hs.ctSize += k.CiphertextSize()
+	}
+	if needs := cfg.Pattern.NeedsStatic(cfg.Initiator); needs != (cfg.Static != nil) ||
+		(needs && (len(cfg.Static.Private) != kem.SeedSize() || len(cfg.Static.Public) != hs.pubSize)) {
+		return nil, dee.ErrConfig
+	}
+	peerPre := cfg.Pattern.ResponderPre
+	if hs.role == 1 {
+		peerPre = cfg.Pattern.InitiatorPre
+	}
+	if (len(peerPre) > 0) != (cfg.PeerStatic != nil) || (cfg.PeerStatic != nil && len(cfg.PeerStatic) != hs.pubSize) {
+		return nil, dee.ErrConfig
+	}
+
+	hs.ss = newSymmetricState(protocolName(cfg))
+	hs.ss.mixHash(cfg.Prologue)
+	// Pre-messages, initiator's first.
+	for i, pre := range [][]Token{cfg.Pattern.InitiatorPre, cfg.Pattern.ResponderPre} {
+		if len(pre) == 0 {
+			continue
+		}
+		if i == hs.role {
+			hs.ss.mixHash(cfg.Static.Public)
+		} else {
+			hs.rs = append([]byte(nil), cfg.PeerStatic...)
+			hs.ss.mixHash(hs.rs)
+		}
+	}
+	return hs, nil
+}
+
+// protocolName is Noise_<pattern>_<kem>_ChaChaPoly_SHA256, where <kem> joins
+// the components with "+" and names a non-default combiner in parentheses.
+func protocolName(cfg Config) string {
+	names := make([]string, len(cfg.KEMs))
+	for i, k := range cfg.KEMs {
+		names[i] = k.String()
+	}
+	kem := strings.Join(names, "+")
+	if cfg.Combiner != dee.CombinerConcat {
+		kem += "(" + cfg.Combiner.String() + ")"
+	}
+	return "Noise_" + cfg.Pattern.Name + "_" + kem + "_ChaChaPoly_SHA256"
+}
+
+// myTurn reports whether the next message is this side's to write.
+func (hs *HandshakeState) myTurn() bool {
+	return hs.next%2 == hs.role
+}
+
+// WriteMessage returns the next handshake message, carrying payload
+// encrypted once the pattern has mixed in a key. Payloads of early messages
+// travel in the clear.
+func (hs *HandshakeState) WriteMessage(payload []byte) ([]byte, error) {
+	if hs.failed || hs.finished || !hs.myTurn() {
+		return nil, dee.ErrHandshake
+	}
+	var msg []byte
+	for _, t := range hs.cfg.Pattern.Messages[hs.next] {
+		switch t {
+		case TokenE:
+			e, err := newKeypair(hs.kem, hs.cfg.Rand)
+			if err != nil {
+				return nil, hs.fail(err)
+			}
+			hs.e = e
+			msg = append(msg, e.Public...)
+			hs.ss.mixHash(e.Public)
+		case TokenS:
+			msg = append(msg, hs.ss.encryptAndHash(hs.cfg.Static.Public)...)
+		case TokenEKEM:
+			ct, k, err := hs.kem.Encapsulate(hs.re, hs.cfg.Rand)
+			if err != nil {
+				return nil, hs.fail(err)
+			}
+			msg = append(msg, ct...)
+			hs.ss.mixHash(ct)
+			hs.ss.mixKey(k)
+		case TokenSKEM:
+			ct, k, err := hs.kem.Encapsulate(hs.rs, hs.cfg.Rand)
+			if err != nil {
+				return nil, hs.fail(err)
+			}
+			msg = append(msg, hs.ss.encryptAndHash(ct)...)
+			hs.ss.mixKey(k)
+		}
+	}
+	msg = append(msg, hs.ss.encryptAndHash(payload)...)
+	hs.advance()
+	return msg, nil
+}
+
+// ReadMessage processes the peer's next handshake message and returns its
+// payload. Any failure is ErrHandshake and ends the handshake.
+func (hs *HandshakeState) ReadMessage(msg []byte) ([]byte, error) {
+	if hs.failed || hs.finished || hs.myTurn() {
+		return nil, dee.ErrHandshake
+	}
+	take := func(n int) ([]byte, bool) {
+		if len(msg) < n {
+			return nil, false
+		}
+		b := msg[:n]
+		msg = msg[n:]
+		return b, true
+	}
+	for _, t := range hs.cfg.Pattern.Messages[hs.next] {
+		switch t {
+		case TokenE:
+			pub, ok := take(hs.pubSize)
+			if !ok {
+				return nil, hs.fail(dee.ErrHandshake)
+			}
+			hs.re = append([]byte(nil), pub...)
+			hs.ss.mixHash(hs.re)
+		case TokenS:
+			ct, ok := take(hs.pubSize + hs.ss.cs.overhead())
+			if !ok {
+				return nil, hs.fail(dee.ErrHandshake)
+			}
+			pub, err := hs.ss.decryptAndHash(ct)
+			if err != nil {
+				return nil, hs.fail(err)
+			}
+			hs.rs = pub
+		case TokenEKEM:
+			ct, ok := take(hs.ctSize)
+			if !ok {
+				return nil, hs.fail(dee.ErrHandshake)
+			}
+			hs.ss.mixHash(ct)
+			k, err := hs.kem.Decapsulate(hs.e.Private, ct)
+			if err != nil {
+				return nil, hs.fail(dee.ErrHandshake)
+			}
+			hs.ss.mixKey(k)
+		case TokenSKEM:
+			enc, ok := take(hs.ctSize + hs.ss.cs.overhead())
+			if !ok {
+				return nil, hs.fail(dee.ErrHandshake)
+			}
+			ct, err := hs.ss.decryptAndHash(enc)
+			if err != nil {
+				return nil, hs.fail(err)
+			}
+			k, err := hs.kem.Decapsulate(hs.cfg.Static.Private, ct)
+			if err != nil {
+				return nil, hs.fail(dee.ErrHandshake)
+			}
+			hs.ss.mixKey(k)
+		}
+	}
+	if len(msg) < hs.ss.cs.overhead() {
+		return nil, hs.fail(dee.ErrHandshake)
+	}
+	payload, err := hs.ss.decryptAndHash(msg)
+	if err != nil {
+		return nil, hs.fail(err)
+	}
+	hs.advance()
+	return payload, nil
+}
+
+func (hs *HandshakeState) advance() {
+	hs.next++
+	if hs.next == len(hs.cfg.Pattern.Messages) {
+		hs.finished = true
+		hs.e = nil
+	}
+}
+
+func (hs *HandshakeState) fail(err error) error {
+	hs.failed = true
+	return err
+}
+
+// Finished reports whether every message of the pattern has been processed.
+func (hs *HandshakeState) Finished() bool {
+	return hs.finished
+}
+
+// HandshakeHash returns h, the hash of everything the handshake sent. It
+// becomes the session ID.
+func (hs *HandshakeState) HandshakeHash() []byte {
+	return append([]byte(nil), hs.ss.h...)
+}
+
+// PeerStatic returns the peer's static public key, if the pattern carried or
+// pre-shared one. Authenticating it (e.g. against a pinned key) is up to the
+// caller.
+func (hs *HandshakeState) PeerStatic() []byte {
+	return append([]byte(nil), hs.rs...)
+}
+
+// Session returns the dee.Session keyed by the finished handshake: the
+// secret is both Split outputs, and the handshake hash is the session ID.
+func (hs *HandshakeState) Session() (*dee.Session, error) {
+	if !hs.finished || hs.failed {
+		return nil, dee.ErrHandshake
+	}
+	k1, k2 := hs.ss.split()
+	return dee.NewSession(hs.cfg.Mode, append(k1, k2...), hs.ss.h, hs.role == 0, hs.cfg.Rand, hs.cfg.SessionOptions...)
+}
@@ -0,0 +1,258 @@
+package noise
+
+import (
+	"bytes"
+	"testing"
+
+	"deadend-lab/pkg/dee"
+)
+
+// pair returns initiator and responder configs for p with the static keys the
+// pattern needs.
+func pair(t *testing.T, p Pattern) (Config, Config) {
+	t.Helper()
+	initCfg := Config{Pattern: p, Initiator: true, Prologue: []byte("prologue")}
+	respCfg := Config{Pattern: p, Prologue: []byte("prologue")}
+	if p.NeedsStatic(true) {
+		initCfg.Static = mustKeypair(t)
+	}
+	if p.NeedsStatic(false) {
+		respCfg.Static = mustKeypair(t)
+	}
+	if len(p.ResponderPre) > 0 {
+		initCfg.PeerStatic = respCfg.Static.Public
+	}
+	return initCfg, respCfg
+}
+
+func mustKeypair(t *testing.T) *Keypair {
+	t.Helper()
+	kp, err := GenerateKeypair(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return kp
+}
+
+// run drives the handshake to completion, writing payload i in message i.
+func run(t *testing.T, initCfg, respCfg Config) (*HandshakeState, *HandshakeState) {
+	t.Helper()
+	hi, err := NewHandshake(initCfg)
+	if err != nil {
+		t.Fatalf("initiator: %v", err)
+	}
+	hr, err := NewHandshake(respCfg)
+	if err != nil {
+		t.Fatalf("responder: %v", err)
+	}
+	sender, receiver := hi, hr
+	for i := range initCfg.Pattern.Messages {
+		payload := []byte{'p', byte('0' + i)}
+		msg, err := sender.WriteMessage(payload)
+		if err != nil {
+			t.Fatalf("message %d: WriteMessage: %v", i, err)
+		}
+		got, err := receiver.ReadMessage(msg)
+		if err != nil {
+			t.Fatalf("message %d: ReadMessage: %v", i, err)
+		}
+		if !bytes.Equal(got, payload) {
+			t.Fatalf("message %d: payload %q", i, got)
+		}
+		sender, receiver = receiver, sender
+	}
+	return hi, hr
+}
+
+func TestPatternsRoundTrip(t *testing.T) {
+	for _, p := range Patterns() {
+		t.Run(p.Name, func(t *testing.T) {
+			initCfg, respCfg := pair(t, p)
+			hi, hr := run(t, initCfg, respCfg)
+			if !hi.Finished() || !hr.Finished() {
+				t.Fatal("handshake should be finished")
+			}
+			if !bytes.Equal(hi.HandshakeHash(), hr.HandshakeHash()) {
+				t.Fatal("handshake hashes differ")
+			}
+			if respCfg.Static != nil && !bytes.Equal(hi.PeerStatic(), respCfg.Static.Public) {
+				t.Fatal("initiator should learn the responder's static key")
+			}
+			if initCfg.Static != nil && !bytes.Equal(hr.PeerStatic(), initCfg.Static.Public) {
+				t.Fatal("responder should learn the initiator's static key")
+			}
+			initSession, err := hi.Session()
+			if err != nil {
+				t.Fatal(err)
+			}
+			respSession, err := hr.Session()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(initSession.SessionID(), hi.HandshakeHash()) {
+				t.Fatal("session ID should be the handshake hash")
+			}
+			for _, d := range [][2]*dee.Session{{initSession, respSession}, {respSession, initSession}} {
+				f, err := d[0].EncryptToFrame([]byte("data"), nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if pt, err := d[1].DecryptFromFrame(f); err != nil || string(pt) != "data" {
+					t.Fatalf("frame: %v", err)
+				}
+			}
+		})
+	}
+}
+
+// A static key that encrypts under the pattern's first key is not readable
+// by a passive observer.
+func TestStaticKeysEncrypted(t *testing.T) {
+	initCfg, respCfg := pair(t, XX)
+	hi, _ := NewHandshake(initCfg)
+	hr, _ := NewHandshake(respCfg)
+	m1, _ := hi.WriteMessage(nil)
+	if _, err := hr.ReadMessage(m1); err != nil {
+		t.Fatal(err)
+	}
+	m2, err := hr.WriteMessage(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(m2, respCfg.Static.Public[:64]) {
+		t.Fatal("responder static key sent in the clear")
+	}
+}
+
+func TestWrongResponderStatic(t *testing.T) {
+	for _, p := range []Pattern{NK, IK} {
+		t.Run(p.Name, func(t *testing.T) {
+			initCfg, respCfg := pair(t, p)
+			initCfg.PeerStatic = mustKeypair(t).Public
+			hi, _ := NewHandshake(initCfg)
+			hr, _ := NewHandshake(respCfg)
+			msg, err := hi.WriteMessage([]byte("payload"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := hr.ReadMessage(msg); err != dee.ErrHandshake {
+				t.Fatalf("got %v, want ErrHandshake", err)
+			}
+			if _, err := hr.WriteMessage(nil); err != dee.ErrHandshake {
+				t.Fatal("a failed handshake must not continue")
+			}
+		})
+	}
+}
+
+// The response always carries an ekem, so its payload is encrypted and bound
+// to every byte before it.
+func TestTamperedResponse(t *testing.T) {
+	for _, p := range Patterns() {
+		t.Run(p.Name, func(t *testing.T) {
+			initCfg, respCfg := pair(t, p)
+			for _, tamper := range []func([]byte) []byte{
+				func(b []byte) []byte { b[0] ^= 1; return b },
+				func(b []byte) []byte { b[len(b)/2] ^= 1; return b },
+				func(b []byte) []byte { b[len(b)-1] ^= 1; return b },
+				func(b []byte) []byte { return b[:len(b)-1] },
+			} {
+				hi, _ := NewHandshake(initCfg)
+				hr, _ := NewHandshake(respCfg)
+				msg, err := hi.WriteMessage([]byte("payload"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := hr.ReadMessage(msg); err != nil {
+					t.Fatal(err)
+				}
+				resp, err := hr.WriteMessage([]byte("payload"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := hi.ReadMessage(tamper(resp)); err != dee.ErrHandshake {
+					t.Fatalf("got %v, want ErrHandshake", err)
+				}
+			}
+		})
+	}
+}
+
+func TestMismatchedConfigs(t *testing.T) {
+	cases := []struct {
+		name string
+		edit func(resp *Config)
+	}{
+		{"prologue", func(c *Config) { c.Prologue = []byte("other") }},
+		{"combiner", func(c *Config) { c.Combiner = dee.CombinerXWing }},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			initCfg, respCfg := pair(t, NN)
+			tc.edit(&respCfg)
+			hi, _ := NewHandshake(initCfg)
+			hr, err := NewHandshake(respCfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			m1, _ := hi.WriteMessage(nil)
+			if _, err := hr.ReadMessage(m1); err != nil {
+				t.Fatal(err)
+			}
+			m2, _ := hr.WriteMessage([]byte("payload"))
+			if _, err := hi.ReadMessage(m2); err != dee.ErrHandshake {
+				t.Fatalf("got %v, want ErrHandshake", err)
+			}
+		})
+	}
+}
+
+func TestOutOfTurn(t *testing.T) {
+	initCfg, respCfg := pair(t, NN)
+	hi, _ := NewHandshake(initCfg)
+	hr, _ := NewHandshake(respCfg)
+	if _, err := hr.WriteMessage(nil); err != dee.ErrHandshake {
+		t.Fatal("responder wrote first")
+	}
+	if _, err := hi.ReadMessage(nil); err != dee.ErrHandshake {
+		t.Fatal("initiator read first")
+	}
+	if _, err := hi.Session(); err != dee.ErrHandshake {
+		t.Fatal("session before the handshake finished")
+	}
+}
+
+func TestConfigValidation(t *testing.T) {
+	kp := mustKeypair(t)
+	bad := []Config{
+		{Pattern: Pattern{Name: "empty"}, Initiator: true},
+		{Pattern: Pattern{Name: "ekem first", Messages: [][]Token{{TokenEKEM}}}, Initiator: true},
+		{Pattern: Pattern{Name: "e twice", Messages: [][]Token{{TokenE, TokenE}}}, Initiator: true},
+		{Pattern: Pattern{Name: "pre e", InitiatorPre: []Token{TokenE}, Messages: [][]Token{{TokenE}}}, Initiator: true},
+		{Pattern: NK, Initiator: true},                             // no peer static
+		{Pattern: NK},                                              // no static
+		{Pattern: NN, Initiator: true, Static: kp},                 // unused static
+		{Pattern: NK, Initiator: true, PeerStatic: kp.Public[:10]}, // short peer static
+		{Pattern: NN, Initiator: true, Combiner: dee.CombinerXOR},  // XOR needs NAIVE
+		{Pattern: NN, Initiator: true, KEMs: []dee.KEM{0x7f}},      // unknown component
+	}
+	for i, cfg := range bad {
+		if _, err := NewHandshake(cfg); err != dee.ErrConfig {
+			t.Errorf("case %d: got %v, want ErrConfig", i, err)
+		}
+	}
+	if _, err := NewHandshake(Config{Pattern: NN, Initiator: true, Mode: dee.Naive, Combiner: dee.CombinerXOR}); err != nil {
+		t.Errorf("NAIVE XOR: %v", err)
+	}
+}
+
+func TestProtocolName(t *testing.T) {
+	got := protocolName(Config{Pattern: XX, KEMs: defaultKEMs, Combiner: dee.CombinerConcat})
+	if want := "Noise_pqXX_X25519+ML-KEM-768_ChaChaPoly_SHA256"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	got = protocolName(Config{Pattern: NN, KEMs: defaultKEMs, Combiner: dee.CombinerXWing})
+	if want := "Noise_pqNN_X25519+ML-KEM-768(X-Wing)_ChaChaPoly_SHA256"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,153 @@
+package noise
+
+// Token is one step of a PQNoise message pattern. KEM tokens replace the
+// Diffie-Hellman tokens of classic Noise: instead of ee or es, the sender
+// encapsulates to a public key the receiver already sent.
+type Token byte
+
+const (
+	// TokenE sends a fresh ephemeral KEM public key in the clear.
+	TokenE Token = iota + 1
+	// TokenS sends the sender's static KEM public key, encrypted once a key
+	// is available.
+	TokenS
+	// TokenEKEM encapsulates to the peer's ephemeral key and sends the
+	// ciphertext in the clear.
+	TokenEKEM
+	// TokenSKEM encapsulates to the peer's static key and sends the
+	// ciphertext, encrypted once a key is available.
+	TokenSKEM
+)
+
+func (t Token) String() string {
+	switch t {
+	case TokenE:
+		return "e"
+	case TokenS:
+		return "s"
+	case TokenEKEM:
+		return "ekem"
+	case TokenSKEM:
+		return "skem"
+	default:
+		return "unknown"
+	}
+}
+
+// Pattern is a handshake pattern. Messages alternate between the initiator
+// and the responder, starting with the initiator. A pre-message may only hold
+// TokenS: a static key the other side knows before the handshake.
+type Pattern struct {
+	Name         string
+	InitiatorPre []Token
+	ResponderPre []Token
+	Messages     [][]Token
+}
+
+// The PQNoise patterns from Angel et al., "Post Quantum Noise" (CCS 2022).
+var (
+	// NN: no static keys.
+	//  -> e
+	//  <- ekem
+	NN = Pattern{
+		Name:     "pqNN",
+		Messages: [][]Token{{TokenE}, {TokenEKEM}},
+	}
+	// NK: the initiator knows the responder's static key.
+	//  <- s
+	//  ...
+	//  -> skem, e
+	//  <- ekem
+	NK = Pattern{
+		Name:         "pqNK",
+		ResponderPre: []Token{TokenS},
+		Messages:     [][]Token{{TokenSKEM, TokenE}, {TokenEKEM}},
+	}
+	// XX: both static keys are sent during the handshake.
+	//  -> e
+	//  <- ekem, s
+	//  -> skem, s
+	//  <- skem
+	XX = Pattern{
+		Name:     "pqXX",
+		Messages: [][]Token{{TokenE}, {TokenEKEM, TokenS}, {TokenSKEM, TokenS}, {TokenSKEM}},
+	}
+	// IK: the initiator knows the responder's static key and sends its own
+	// in the first message.
+	//  <- s
+	//  ...
+	//  -> skem, e, s
+	//  <- ekem, skem
+	IK = Pattern{
+		Name:         "pqIK",
+		ResponderPre: []Token{TokenS},
+		Messages:     [][]Token{{TokenSKEM, TokenE, TokenS}, {TokenEKEM, TokenSKEM}},
+	}
+)
+
+// Patterns lists the built-in patterns.
+func Patterns() []Pattern {
+	return []Pattern{NN, NK, XX, IK}
+}
+
+// valid reports whether every KEM token targets a key its sender has already
+// received, no key is sent twice, and pre-messages hold only static keys.
+func (p Pattern) valid() bool {
+	if p.Name == "" || len(p.Messages) == 0 {
+		return false
+	}
+	// sent[0] are the initiator's keys, sent[1] the responder's.
+	var sent [2]map[Token]bool
+	for i, pre := range [][]Token{p.InitiatorPre, p.ResponderPre} {
+		sent[i] = map[Token]bool{}
+		for _, t := range pre {
+			if t != TokenS || sent[i][t] {
+				return false
+			}
+			sent[i][t] = true
+		}
+	}
+	for i, msg := range p.Messages {
+		from, to := i%2, 1-i%2
+		for _, t := range msg {
+			switch t {
+			case TokenE, TokenS:
+				if sent[from][t] {
+					return false
+				}
+				sent[from][t] = true
+			case TokenEKEM:
+				if !sent[to][TokenE] {
+					return false
+				}
+			case TokenSKEM:
+				if !sent[to][TokenS] {
+					return false
+				}
+			default:
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// NeedsStatic reports whether the initiator or the responder must hold a
+// static key pair.
+func (p Pattern) NeedsStatic(initiator bool) bool {
+	pre, role := p.InitiatorPre, 0
+	if !initiator {
+		pre, role = p.ResponderPre, 1
+	}
+	if len(pre) > 0 {
+		return true
+	}
+	for i := role; i < len(p.Messages); i += 2 {
+		for _, t := range p.Messages[i] {
+			if t == TokenS {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,119 @@
+package noise
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+
+	"deadend-lab/pkg/common"
+	"deadend-lab/pkg/dee"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// hashSize is HASHLEN for SHA-256, the only hash this package uses.
+const hashSize = sha256.Size
+
+// cipherState is the Noise CipherState over ChaCha20-Poly1305. Without a key
+// it passes data through unchanged.
+type cipherState struct {
+	k []byte
+	n uint64
+}
+
+// nonce is the Noise ChaChaPoly nonce: 32 zero bits, then n little-endian.
+func (c *cipherState) nonce() []byte {
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	binary.LittleEndian.PutUint64(nonce[4:], c.n)
+	return nonce
+}
+
+func (c *cipherState) encrypt(ad, plaintext []byte) []byte {
+	if c.k == nil {
+		return append([]byte(nil), plaintext...)
+	}
+	aead, _ := chacha20poly1305.New(c.k)
+	ct := aead.Seal(nil, c.nonce(), plaintext, ad)
+	c.n++
+	return ct
+}
+
+func (c *cipherState) decrypt(ad, ciphertext []byte) ([]byte, error) {
+	if c.k == nil {
+		return append([]byte(nil), ciphertext...), nil
+	}
+	aead, _ := chacha20poly1305.New(c.k)
+	pt, err := aead.Open(nil, c.nonce(), ciphertext, ad)
+	if err != nil {
+		return nil, dee.ErrHandshake
+	}
+	c.n++
+	return pt, nil
+}
+
+// overhead is the tag size added by encrypt once a key is set.
+func (c *cipherState) overhead() int {
+	if c.k == nil {
+		return 0
+	}
+	return chacha20poly1305.Overhead
+}
+
+// symmetricState is the Noise SymmetricState: the chaining key ck, the
+// handshake hash h and the cipher keyed from ck.
+type symmetricState struct {
+	cs cipherState
+	ck []byte
+	h  []byte
+}
+
+func newSymmetricState(protocolName string) *symmetricState {
+	var h []byte
+	if len(protocolName) <= hashSize {
+		h = make([]byte, hashSize)
+		copy(h, protocolName)
+	} else {
+		sum := sha256.Sum256([]byte(protocolName))
+		h = sum[:]
+	}
+	return &symmetricState{ck: append([]byte(nil), h...), h: h}
+}
+
+func (s *symmetricState) mixKey(ikm []byte) {
+	var k []byte
+	s.ck, k = hkdf2(s.ck, ikm)
+	s.cs = cipherState{k: k}
+}
+
+func (s *symmetricState) mixHash(data []byte) {
+	h := sha256.New()
+	h.Write(s.h)
+	h.Write(data)
+	s.h = h.Sum(nil)
+}
+
+func (s *symmetricState) encryptAndHash(plaintext []byte) []byte {
+	ct := s.cs.encrypt(s.h, plaintext)
+	s.mixHash(ct)
+	return ct
+}
+
+func (s *symmetricState) decryptAndHash(ciphertext []byte) ([]byte, error) {
+	pt, err := s.cs.decrypt(s.h, ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	s.mixHash(ciphertext)
+	return pt, nil
+}
+
+// split returns the two keys Noise would give the transport cipher states.
+func (s *symmetricState) split() (k1, k2 []byte) {
+	return hkdf2(s.ck, nil)
+}
+
+// hkdf2 is the Noise HKDF with two outputs.
+func hkdf2(ck, ikm []byte) (out1, out2 []byte) {
+	temp := common.HMAC256(ck, ikm)
+	out1 = common.HMAC256(temp, []byte{0x01})
+	out2 = common.HMAC256(temp, append(append([]byte(nil), out1...), 0x02))
+	return out1, out2
+}
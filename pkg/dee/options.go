@@ -0,0 +1,95 @@
+package dee
+
+import "errors"
+
+// ErrConfig is returned when handshake options are out of range or conflict.
+var ErrConfig = errors.New("invalid configuration")
+
+// Option configures a handshake and the session it produces. Options are local
+// to the peer that passes them; they are not negotiated.
+type Option func(*config)
+
+type config struct {
+	replayWindow int
+	rekey        *RekeyPolicy
+	ratchet      bool
+	ratchetEvery uint64
+	identity     *Identity
+	verifier     PeerVerifier
+
+	fallbackModes []Mode
+	versions      []byte
+	suites        []Suite
+	kems          []KEM
+	combiner      Combiner
+	psks          []psk
+	pskOnly       bool
+	password      []byte
+	sas           bool
+	kemIdentity   *KEMIdentity
+	peerKEMKey    []byte
+	ticket        *Ticket
+	ticketKeys    *TicketKeys
+	resumed       *resumption
+	earlyData     bool
+	strikes       *StrikeRegister
+	lockMemory    bool
+	mem           *lockedMem
+	limits        SessionLimits
+}
+
+// WithReplayWindow enables a sliding anti-replay window of size counters on the
+// receive side of a SAFE session. Frames may then arrive out of order or be
+// dropped; each counter inside the window is accepted at most once. size must be
+// between MinReplayWindow and MaxReplayWindow. Without this option SAFE sessions
+// accept only the next expected counter.
+func WithReplayWindow(size int) Option {
+	return func(c *config) {
+		c.replayWindow = size
+	}
+}
+
+func newConfig(opts []Option) (config, error) {
+	var c config
+	for _, opt := range opts {
+		if opt != nil {
+			opt(&c)
+		}
+	}
+	if c.replayWindow != 0 && (c.replayWindow < MinReplayWindow || c.replayWindow > MaxReplayWindow) {
+		return config{}, ErrConfig
+	}
+	if c.ticket != nil && !c.applyTicket() {
+		return config{}, ErrConfig
+	}
+	for _, v := range c.versions {
+		if _, ok := wireFor(v); !ok {
+			return config{}, ErrConfig
+		}
+	}
+	if !validKEMs(c.kems) || (!equalKEMs(c.kemList(), defaultKEMs) && c.speaks(Version)) || !validPSKs(c) {
+		return config{}, ErrConfig
+	}
+	if c.peerKEMKey != nil && len(c.peerKEMKey) != KEMIdentityKeySize {
+		return config{}, ErrConfig
+	}
+	if c.password != nil && len(c.password) == 0 {
+		return config{}, ErrConfig
+	}
+	if _, ok := combinerNames[c.combiner]; c.combiner != 0 && !ok {
+		return config{}, ErrConfig
+	}
+	for _, s := range c.suites {
+		if _, ok := suites[s]; !ok {
+			return config{}, ErrConfig
+		}
+	}
+	if c.lockMemory {
+		mem, err := newLockedMem()
+		if err != nil {
+			return config{}, err
+		}
+		c.mem = mem
+	}
+	return c, nil
+}
@@ -0,0 +1,163 @@
+package dee
+
+import (
+	"crypto/ecdh"
+	"crypto/sha512"
+	"io"
+
+	"deadend-lab/pkg/common"
+	"github.com/cloudflare/circl/math/fp25519"
+)
+
+// The password exchange is CPace on X25519: both peers map the password to a
+// secret generator, send an ephemeral multiple of it, and hash the shared point
+// into an intermediate session key (ISK) that is mixed into the master secret
+// next to the component secrets. A captured handshake holds only the two
+// ephemeral points, which are uniformly distributed and reveal nothing a
+// password guess could be checked against; an active attacker gets one guess
+// per handshake, and a wrong guess fails the Finished MACs like any other key
+// mismatch.
+
+const (
+	pakeShareSize = 32
+	pakeDSI       = "CPace255"
+	pakeHashBlock = sha512.BlockSize
+)
+
+// curve25519A is the Montgomery coefficient A = 486662.
+var curve25519A = fp25519.Elt{0x06, 0x6d, 0x07}
+
+// WithPassword authenticates the handshake with a password shared by both
+// peers. Both must set it; a peer without it fails the handshake against one
+// with it, and a wrong password fails it through the same ErrHandshake as any
+// other mismatch. password must not be empty.
+func WithPassword(password []byte) Option {
+	return func(c *config) {
+		c.password = append([]byte{}, password...)
+	}
+}
+
+// pakeGenerator maps the password to the CPace generator: SHA-512 over the
+// length-prefixed DSI, password, zero padding to a hash block and channel
+// identifier, truncated to a field element and mapped with Elligator2.
+func pakeGenerator(password []byte) []byte {
+	dsi, ci := []byte(pakeDSI), []byte(common.LabelPAKE)
+	zpad := pakeHashBlock - 1 - len(prependLen(password)) - len(prependLen(dsi))
+	if zpad < 0 {
+		zpad = 0
+	}
+	h := sha512.Sum512(lvCat(dsi, password, make([]byte, zpad), ci, nil))
+	var r fp25519.Elt
+	copy(r[:], h[:fp25519.Size])
+	r[fp25519.Size-1] &= 0x7f
+	u := elligator2(&r)
+	out := make([]byte, fp25519.Size)
+	_ = fp25519.ToBytes(out, &u)
+	return out
+}
+
+// elligator2 maps r to the u-coordinate of a point on Curve25519 (RFC 9380,
+// section 6.7.1, with Z = 2), using circl's constant-time field arithmetic.
+func elligator2(r *fp25519.Elt) fp25519.Elt {
+	var one, t, d, x1, x2, gx, tmp fp25519.Elt
+	fp25519.SetOne(&one)
+	fp25519.Sqr(&t, r)
+	fp25519.Add(&t, &t, &t)
+	fp25519.Add(&d, &one, &t) // 1 + 2r^2; zero only for exceptional r
+	zero := uint(0)
+	if fp25519.IsZero(&d) {
+		zero = 1
+	}
+	fp25519.Cmov(&d, &one, zero)
+	fp25519.Inv(&d, &d)
+	fp25519.Neg(&x1, &curve25519A)
+	fp25519.Mul(&x1, &x1, &d) // x1 = -A / (1 + 2r^2)
+	fp25519.Add(&gx, &x1, &curve25519A)
+	fp25519.Mul(&gx, &gx, &x1)
+	fp25519.Add(&gx, &gx, &one)
+	fp25519.Mul(&gx, &gx, &x1) // g(x1) = x1^3 + A x1^2 + x1
+	fp25519.Neg(&x2, &x1)
+	fp25519.Sub(&x2, &x2, &curve25519A) // x2 = -x1 - A
+	square := uint(0)
+	if fp25519.InvSqrt(&tmp, &gx, &one) {
+		square = 1
+	}
+	fp25519.Cmov(&x1, &x2, 1-square)
+	fp25519.Modp(&x1)
+	return x1
+}
+
+// pakeShare picks an ephemeral scalar and returns it with its share, the
+// scalar times the password generator.
+func pakeShare(password []byte, randReader io.Reader) (*ecdh.PrivateKey, []byte, error) {
+	scalar := make([]byte, 32)
+	if _, err := io.ReadFull(randReader, scalar); err != nil {
+		return nil, nil, err
+	}
+	priv, err := ecdh.X25519().NewPrivateKey(scalar)
+	if err != nil {
+		return nil, nil, err
+	}
+	gen, err := ecdh.X25519().NewPublicKey(pakeGenerator(password))
+	if err != nil {
+		return nil, nil, err
+	}
+	share, err := priv.ECDH(gen)
+	if err != nil {
+		return nil, nil, err
+	}
+	return priv, share, nil
+}
+
+// pakeISK is the intermediate session key of the exchange between the
+// initiator's share ya and the responder's share yb. A low-order peer share
+// fails the handshake.
+func pakeISK(priv *ecdh.PrivateKey, peer, ya, yb []byte) ([]byte, error) {
+	if len(peer) != pakeShareSize {
+		return nil, ErrHandshake
+	}
+	pub, err := ecdh.X25519().NewPublicKey(peer)
+	if err != nil {
+		return nil, ErrHandshake
+	}
+	k, err := priv.ECDH(pub)
+	if err != nil {
+		return nil, ErrHandshake
+	}
+	h := sha512.New()
+	h.Write(lvCat([]byte(pakeDSI+"_ISK"), nil, k))
+	h.Write(lvCat(ya, nil))
+	h.Write(lvCat(yb, nil))
+	return h.Sum(nil), nil
+}
+
+// lvCat concatenates its arguments, each prefixed with its LEB128 length.
+func lvCat(parts ...[]byte) []byte {
+	var b []byte
+	for _, p := range parts {
+		b = append(b, prependLen(p)...)
+	}
+	return b
+}
+
+func prependLen(p []byte) []byte {
+	var b []byte
+	n := len(p)
+	for n >= 0x80 {
+		b = append(b, byte(n)|0x80)
+		n >>= 7
+	}
+	return append(append(b, byte(n)), p...)
+}
+
+// mixedSecrets is what follows the component secrets in the extract: the PSK,
+// the PAKE's ISK, then the KEM authentication secret, each if present.
+func mixedSecrets(secrets ...[]byte) []byte {
+	var out []byte
+	for _, s := range secrets {
+		if s != nil {
+			out = append(out, s...)
+		}
+	}
+	return out
+}
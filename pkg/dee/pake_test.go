@@ -0,0 +1,131 @@
+package dee
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+
+	"github.com/cloudflare/circl/math/fp25519"
+)
+
+var testPassword = []byte("correct horse")
+
+func TestPAKEHandshake(t *testing.T) {
+	pw := WithPassword(testPassword)
+	for name, opts := range map[string][]Option{
+		"default":   {pw},
+		"ML-KEM v2": {pw, WithVersions(Version2), WithKEMs(KEMX25519, KEMMLKEM768)},
+		"with PSK":  {pw, WithPSK(testPSKID, testPSK)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			initSession, respSession := sessionPair(t, Safe, opts, opts)
+			sendFrames(t, initSession, respSession, 2)
+			sendFrames(t, respSession, initSession, 2)
+		})
+	}
+}
+
+// A wrong password is only noticed through the Finished MAC, exactly like a
+// corrupted component ciphertext: the response looks the same and the
+// initiator gets the same error at the same step.
+func TestPAKEWrongPassword(t *testing.T) {
+	run := func(respPassword []byte, corrupt bool) (int, error) {
+		initMsg, initSession, err := HandshakeInit(Safe, nil, WithPassword(testPassword))
+		if err != nil {
+			t.Fatal(err)
+		}
+		respMsg, _, err := HandshakeResp(Safe, initMsg, nil, WithPassword(respPassword))
+		if err != nil {
+			t.Fatalf("responder must not reject before confirmation: %v", err)
+		}
+		if corrupt {
+			respMsg[3+x25519PubSize] ^= 1
+		}
+		return len(respMsg), initSession.HandshakeComplete(respMsg)
+	}
+	goodLen, err := run(testPassword, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrongLen, wrongErr := run([]byte("correct horsf"), false)
+	_, corruptErr := run(testPassword, true)
+	if wrongLen != goodLen {
+		t.Fatalf("response length depends on the password: %d vs %d", wrongLen, goodLen)
+	}
+	if wrongErr != ErrHandshake || corruptErr != wrongErr {
+		t.Fatalf("wrong password: %v, corrupted ciphertext: %v", wrongErr, corruptErr)
+	}
+}
+
+func TestPAKEOneSided(t *testing.T) {
+	pw := WithPassword(testPassword)
+	for name, sides := range map[string][2][]Option{
+		"initiator only": {{pw}, nil},
+		"responder only": {nil, {pw}},
+	} {
+		initMsg, _, err := HandshakeInit(Safe, nil, sides[0]...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := HandshakeResp(Safe, initMsg, nil, sides[1]...); err != ErrHandshake {
+			t.Errorf("%s: got %v, want ErrHandshake", name, err)
+		}
+	}
+	if _, _, err := HandshakeInit(Safe, nil, WithPassword(nil)); err != ErrConfig {
+		t.Errorf("empty password: got %v, want ErrConfig", err)
+	}
+}
+
+// The shares are fresh per handshake and never the password generator itself,
+// so a captured handshake holds nothing a guess could be tested against.
+func TestPAKESharesFresh(t *testing.T) {
+	a, _, _ := HandshakeInit(Safe, nil, WithPassword(testPassword))
+	b, _, _ := HandshakeInit(Safe, nil, WithPassword(testPassword))
+	shareOf := func(msg []byte) []byte {
+		_, exts, err := parseExtensions(msg, initFixedSize)
+		if err != nil || len(exts[extPAKE]) != pakeShareSize {
+			t.Fatal("missing PAKE share")
+		}
+		return exts[extPAKE]
+	}
+	gen := pakeGenerator(testPassword)
+	if bytes.Equal(shareOf(a), shareOf(b)) || bytes.Equal(shareOf(a), gen) {
+		t.Fatal("PAKE share repeats across handshakes or reveals the generator")
+	}
+	if bytes.Equal(gen, pakeGenerator([]byte("correct horsf"))) {
+		t.Fatal("generator does not depend on the password")
+	}
+}
+
+func TestPAKELowOrderShare(t *testing.T) {
+	initMsg, initSession, _ := HandshakeInit(Safe, nil, WithPassword(testPassword))
+	respMsg, _, err := HandshakeResp(Safe, initMsg, nil, WithPassword(testPassword))
+	if err != nil {
+		t.Fatal(err)
+	}
+	share := bytes.Index(respMsg, []byte{extPAKE, 0, pakeShareSize}) + extHeaderSize
+	copy(respMsg[share:], make([]byte, pakeShareSize))
+	if err := initSession.HandshakeComplete(respMsg); err != ErrHandshake {
+		t.Fatalf("got %v, want ErrHandshake", err)
+	}
+}
+
+// Elligator2 must land on the curve, not its twist: u^3 + A u^2 + u is a square.
+func TestElligator2OnCurve(t *testing.T) {
+	var one fp25519.Elt
+	fp25519.SetOne(&one)
+	for i := 0; i < 256; i++ {
+		var r fp25519.Elt
+		rand.Read(r[:])
+		r[31] &= 0x7f
+		u := elligator2(&r)
+		var gx, tmp fp25519.Elt
+		fp25519.Add(&gx, &u, &curve25519A)
+		fp25519.Mul(&gx, &gx, &u)
+		fp25519.Add(&gx, &gx, &one)
+		fp25519.Mul(&gx, &gx, &u)
+		if !fp25519.InvSqrt(&tmp, &gx, &one) {
+			t.Fatalf("r=%x maps to the twist", r)
+		}
+	}
+}
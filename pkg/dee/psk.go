@@ -0,0 +1,130 @@
+package dee
+
+import (
+	"bytes"
+	"io"
+
+	"deadend-lab/pkg/common"
+)
+
+const (
+	// MinPSKSize is the smallest accepted pre-shared key.
+	MinPSKSize = 32
+	// MaxPSKIDSize bounds a PSK identifier.
+	MaxPSKIDSize = 255
+
+	binderSize   = 32
+	pskNonceSize = 32
+)
+
+// psk is a pre-shared key and the identifier it is announced under.
+type psk struct {
+	id  []byte
+	key []byte
+}
+
+// WithPSK adds a pre-shared key known to the peer as id. An initiator sends id
+// in its init message, proves knowledge of key with a binder, and mixes key
+// into the master secret next to the component secrets, like WireGuard's PSK
+// slot; it takes exactly one PSK. A responder may hold several, looks the
+// initiator's up by id, and then requires one: an init message without a PSK,
+// with an unknown id or with a wrong binder fails the handshake. key must be
+// at least MinPSKSize bytes and id 1 to MaxPSKIDSize bytes.
+func WithPSK(id, key []byte) Option {
+	return func(c *config) {
+		c.psks = append(c.psks, psk{id: append([]byte(nil), id...), key: append([]byte(nil), key...)})
+	}
+}
+
+// WithPSKOnly runs the handshake with no hybrid components: the session keys
+// come from the PSK and fresh nonces alone. It is the fallback for a lab
+// "quantum attacker" that breaks every public-key component; it gives no
+// forward secrecy against a later PSK compromise. Both peers must set it, it
+// needs WithPSK, and like any non-default component list it needs the v2
+// layout.
+func WithPSKOnly() Option {
+	return func(c *config) {
+		c.pskOnly = true
+	}
+}
+
+func validPSKs(c config) bool {
+	for _, p := range c.psks {
+		if len(p.id) == 0 || len(p.id) > MaxPSKIDSize || len(p.key) < MinPSKSize {
+			return false
+		}
+	}
+	return !c.pskOnly || len(c.psks) > 0
+}
+
+// lookupPSK returns the responder's key for id.
+func (c config) lookupPSK(id []byte) ([]byte, bool) {
+	for _, p := range c.psks {
+		if bytes.Equal(p.id, id) {
+			return p.key, true
+		}
+	}
+	return nil, false
+}
+
+// pskBinder proves knowledge of key over the init message body. It is keyed
+// independently of the version and suite, which are not chosen yet.
+func pskBinder(key, initBody []byte) []byte {
+	binderKey := common.ExpandLabel(common.Extract(key, nil), common.LabelPSKBinder, 32)
+	return common.HMAC256Truncate(binderKey, initBody, binderSize)
+}
+
+// appendPSKExtensions adds the initiator's PSK id and, for a PSK-only
+// handshake, its nonce to the init message body.
+func appendPSKExtensions(initMsg []byte, cfg config, randReader io.Reader) ([]byte, error) {
+	if len(cfg.psks) == 0 {
+		return initMsg, nil
+	}
+	initMsg = appendExtension(initMsg, extPSK, cfg.psks[0].id)
+	return appendPSKNonce(initMsg, cfg, randReader)
+}
+
+// appendPSKNonce adds a fresh nonce to a PSK-only handshake message, so that
+// every session gets its own transcript and keys.
+func appendPSKNonce(msg []byte, cfg config, randReader io.Reader) ([]byte, error) {
+	if !cfg.pskOnly {
+		return msg, nil
+	}
+	nonce := make([]byte, pskNonceSize)
+	if _, err := io.ReadFull(randReader, nonce); err != nil {
+		return nil, err
+	}
+	return appendExtension(msg, extPSKNonce, nonce), nil
+}
+
+// acceptPSK checks the PSK part of an init message on a responder and returns
+// the key to mix in, or nil when neither side uses a PSK.
+func acceptPSK(init handshakeMsg, cfg config) ([]byte, error) {
+	id, offered := init.exts[extPSK]
+	if !offered && len(cfg.psks) == 0 {
+		return nil, nil
+	}
+	if !offered {
+		return nil, ErrHandshake
+	}
+	key, ok := cfg.lookupPSK(id)
+	binder := init.exts[extPSKBinder]
+	if !ok || len(binder) != binderSize || !common.EqualConstantTime(binder, pskBinder(key, init.body)) {
+		return nil, ErrHandshake
+	}
+	if cfg.pskOnly && len(init.exts[extPSKNonce]) != pskNonceSize {
+		return nil, ErrHandshake
+	}
+	if r := cfg.resumed; r != nil && !r.keys.redeem(r.nonce, r.expires) {
+		return nil, ErrHandshake
+	}
+	return key, nil
+}
+
+// initiatorPSK is the key an initiator mixes in, or nil.
+func (c config) initiatorPSK() []byte {
+	if len(c.psks) == 0 {
+		return nil
+	}
+	return c.psks[0].key
+}
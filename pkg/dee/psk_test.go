@@ -0,0 +1,113 @@
+package dee
+
+import (
+	"bytes"
+	"testing"
+)
+
+var (
+	testPSKID  = []byte("lab-psk-1")
+	testPSK    = bytes.Repeat([]byte{0x42}, MinPSKSize)
+	otherPSKID = []byte("lab-psk-2")
+	otherPSK   = bytes.Repeat([]byte{0x24}, MinPSKSize)
+)
+
+func TestPSKHandshake(t *testing.T) {
+	cases := []struct {
+		name string
+		init []Option
+		resp []Option
+	}{
+		{"hybrid", []Option{WithPSK(testPSKID, testPSK)}, []Option{WithPSK(otherPSKID, otherPSK), WithPSK(testPSKID, testPSK)}},
+		{"hybrid v2", []Option{WithVersions(Version2), WithPSK(testPSKID, testPSK)}, []Option{WithVersions(Version2), WithPSK(testPSKID, testPSK)}},
+		{"psk only", []Option{WithVersions(Version2), WithPSKOnly(), WithPSK(testPSKID, testPSK)}, []Option{WithVersions(Version2), WithPSKOnly(), WithPSK(testPSKID, testPSK)}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			initSession, respSession := sessionPair(t, Safe, tc.init, tc.resp)
+			sendFrames(t, initSession, respSession, 2)
+			sendFrames(t, respSession, initSession, 2)
+		})
+	}
+}
+
+// Without components, the nonces alone keep PSK-only sessions apart.
+func TestPSKOnlyFresh(t *testing.T) {
+	opts := []Option{WithVersions(Version2), WithPSKOnly(), WithPSK(testPSKID, testPSK)}
+	a, _ := sessionPair(t, Safe, opts, opts)
+	b, _ := sessionPair(t, Safe, opts, opts)
+	if bytes.Equal(a.SessionID(), b.SessionID()) {
+		t.Fatal("two PSK-only sessions share a session ID")
+	}
+	initMsg, _, err := HandshakeInit(Safe, nil, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := 4 + 2*extHeaderSize + len(testPSKID) + pskNonceSize + extHeaderSize + binderSize; len(initMsg) != want {
+		t.Fatalf("PSK-only init is %d bytes, want %d", len(initMsg), want)
+	}
+}
+
+// Every PSK mismatch fails at the responder through the same error.
+func TestPSKMismatch(t *testing.T) {
+	withPSK := []Option{WithPSK(testPSKID, testPSK)}
+	cases := []struct {
+		name  string
+		init  []Option
+		resp  []Option
+		forge func(b []byte) []byte
+	}{
+		{"wrong key", []Option{WithPSK(testPSKID, otherPSK)}, withPSK, nil},
+		{"unknown id", []Option{WithPSK(otherPSKID, testPSK)}, withPSK, nil},
+		{"missing on initiator", nil, withPSK, nil},
+		{"missing on responder", withPSK, nil, nil},
+		{"binder stripped", withPSK, withPSK, func(b []byte) []byte { return b[:len(b)-extHeaderSize-binderSize] }},
+		{"binder flipped", withPSK, withPSK, func(b []byte) []byte { b[len(b)-1] ^= 1; return b }},
+		{"id rewritten", withPSK, []Option{WithPSK(testPSKID, testPSK), WithPSK([]byte("lab-psk-9"), otherPSK)}, func(b []byte) []byte {
+			return bytes.Replace(b, testPSKID, []byte("lab-psk-9"), 1)
+		}},
+		{"psk only vs hybrid", []Option{WithVersions(Version2), WithPSKOnly(), WithPSK(testPSKID, testPSK)}, []Option{WithVersions(Version2), WithPSK(testPSKID, testPSK)}, nil},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			initMsg, _, err := HandshakeInit(Safe, nil, tc.init...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.forge != nil {
+				initMsg = tc.forge(initMsg)
+			}
+			if _, _, err := HandshakeResp(Safe, initMsg, nil, tc.resp...); err != ErrHandshake {
+				t.Fatalf("got %v, want ErrHandshake", err)
+			}
+		})
+	}
+}
+
+func TestPSKOnlyNonceStripped(t *testing.T) {
+	opts := []Option{WithVersions(Version2), WithPSKOnly(), WithPSK(testPSKID, testPSK)}
+	initMsg, initSession, _ := HandshakeInit(Safe, nil, opts...)
+	respMsg, _, err := HandshakeResp(Safe, initMsg, nil, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stripped := append(respMsg[:4:4], respMsg[4+extHeaderSize+pskNonceSize:]...)
+	if err := initSession.HandshakeComplete(stripped); err != ErrHandshake {
+		t.Fatalf("got %v, want ErrHandshake", err)
+	}
+}
+
+func TestPSKConfig(t *testing.T) {
+	for name, opts := range map[string][]Option{
+		"short key":        {WithPSK(testPSKID, testPSK[:MinPSKSize-1])},
+		"empty id":         {WithPSK(nil, testPSK)},
+		"long id":          {WithPSK(make([]byte, MaxPSKIDSize+1), testPSK)},
+		"only, no psk":     {WithVersions(Version2), WithPSKOnly()},
+		"only over v1":     {WithPSKOnly(), WithPSK(testPSKID, testPSK)},
+		"two on initiator": {WithPSK(testPSKID, testPSK), WithPSK(otherPSKID, otherPSK)},
+	} {
+		if _, _, err := HandshakeInit(Safe, nil, opts...); err != ErrConfig {
+			t.Errorf("%s: got %v, want ErrConfig", name, err)
+		}
+	}
+}
@@ -0,0 +1,308 @@
+package dee
+
+import (
+	"crypto/ecdh"
+	"io"
+
+	"deadend-lab/pkg/common"
+	"github.com/cloudflare/circl/kem/mlkem/mlkem768"
+)
+
+// Inner ratchet header types. In hybrid ratchet mode every plaintext is
+// prefixed with one of these, followed by the step payload if any.
+const (
+	ratchetNone   = 0x00
+	ratchetOffer  = 0x01 // x25519_pub(32) || mlkem_pub
+	ratchetAnswer = 0x02 // x25519_pub(32) || mlkem_ct
+
+	ratchetOfferSize  = 1 + x25519PubSize + mlkem768.PublicKeySize
+	ratchetAnswerSize = 1 + x25519PubSize + mlkem768.CiphertextSize
+)
+
+// hybridRatchet is the public-key half of hybrid ratchet mode. One peer offers
+// fresh X25519 and ML-KEM-768 (FIPS 203) public keys; the other answers with
+// its own X25519 public key and an ML-KEM ciphertext. Both fold the two new shared secrets into
+// kMs, so an attacker holding an old kMs loses track after one step.
+type hybridRatchet struct {
+	every     uint64 // offer after this many sent messages; 0 = RequestRatchet only
+	sinceStep uint64
+	wantOffer bool
+	steps     uint64
+
+	// Outstanding offer: our fresh private keys until the answer arrives.
+	xPriv   *ecdh.PrivateKey
+	kemPriv *mlkem768.PrivateKey
+
+	// Answer to attach to the next message, and the send secret to switch to
+	// once it has gone out.
+	answer   []byte
+	txSecret []byte
+
+	// The peer's next receive epoch comes from the new root, not the hash ratchet.
+	rxPending bool
+	rxEpoch   uint64
+	rxSecret  []byte
+
+	// Offers sealed before the peer's last answer are stale: they crossed
+	// ours and the peer abandoned them.
+	staleBefore uint64
+}
+
+// WithHybridRatchet enables hybrid ratchet mode. Every every messages (or on
+// RequestRatchet) this side offers a fresh X25519 + ML-KEM-768 key pair inside
+// an encrypted frame; the peer answers in its next frame and both mix the new
+// shared secrets into kMs with HKDF-Extract. Both peers must enable the mode;
+// a mismatch fails every frame with ErrDecrypt.
+func WithHybridRatchet(every uint64) Option {
+	return func(c *config) {
+		c.ratchet = true
+		c.ratchetEvery = every
+	}
+}
+
+// RequestRatchet asks for a public-key ratchet step: the offer rides on the
+// next message this side sends.
+func (s *Session) RequestRatchet() error {
+	defer s.lockTx()()
+	if !s.established || s.ratchet == nil {
+		return ErrDecrypt
+	}
+	s.ratchet.wantOffer = true
+	return nil
+}
+
+// RatchetSteps returns how many public-key ratchet steps this session has
+// completed.
+func (s *Session) RatchetSteps() uint64 {
+	defer s.lockBoth()()
+	return s.ratchetSteps()
+}
+
+func (s *Session) ratchetSteps() uint64 {
+	if s.ratchet == nil {
+		return 0
+	}
+	return s.ratchet.steps
+}
+
+// ratchetRoot returns the secret traffic keys are split from. Ratchet mode
+// starts from a separate root so a peer without the mode cannot open frames.
+func (s *Session) ratchetRoot(kMs []byte) []byte {
+	if s.cfg.ratchet {
+		return s.ks.expand(kMs, common.LabelRatchetRoot, 32)
+	}
+	return kMs
+}
+
+// ratchetPrefix returns the inner header for the next outgoing message,
+// generating a fresh offer when one is due.
+func (s *Session) ratchetPrefix() ([]byte, error) {
+	r := s.ratchet
+	if r.answer != nil {
+		return r.answer, nil
+	}
+	if r.xPriv != nil || !(r.wantOffer || (r.every > 0 && r.sinceStep >= r.every)) {
+		return []byte{ratchetNone}, nil
+	}
+	xPriv, kemPk, kemSk, err := ratchetKeyPair(s.rand)
+	if err != nil {
+		return nil, err
+	}
+	offer := make([]byte, ratchetOfferSize)
+	offer[0] = ratchetOffer
+	copy(offer[1:], xPriv.PublicKey().Bytes())
+	kemPk.Pack(offer[1+x25519PubSize:])
+	r.xPriv, r.kemPriv = xPriv, kemSk
+	r.wantOffer = false
+	return offer, nil
+}
+
+// ratchetSent runs after a message carrying prefix was sealed. Sending an
+// answer completes our half of the step: the send side moves to the new root.
+func (s *Session) ratchetSent(prefix []byte) {
+	r := s.ratchet
+	r.sinceStep++
+	if prefix[0] == ratchetAnswer {
+		s.tx.ratchetTo(s.ks.trafficKeys(r.txSecret), s.tx.counter)
+		r.answer, r.txSecret = nil, nil
+		r.sinceStep = 0
+		r.steps++
+	}
+}
+
+// ratchetOutstanding reports whether this side is waiting for an answer. The
+// send epoch must not move by hash ratchet meanwhile: the peer expects the
+// epoch after the offer to come from the new root.
+func (s *Session) ratchetOutstanding() bool {
+	return s.ratchet != nil && s.ratchet.xPriv != nil
+}
+
+// ratchetReceived processes the inner header of an authenticated message sealed
+// under epoch at counter and returns the application plaintext.
+func (s *Session) ratchetReceived(epoch, counter uint64, inner []byte) ([]byte, error) {
+	if len(inner) < 1 {
+		return nil, ErrDecrypt
+	}
+	r := s.ratchet
+	switch inner[0] {
+	case ratchetNone:
+		return inner[1:], nil
+	case ratchetOffer:
+		if len(inner) < ratchetOfferSize {
+			return nil, ErrDecrypt
+		}
+		if counter < r.staleBefore {
+			return inner[ratchetOfferSize:], nil
+		}
+		if r.xPriv != nil {
+			// Crossed offers: the initiator's offer wins and the responder
+			// drops its own.
+			if s.isInitiator {
+				return inner[ratchetOfferSize:], nil
+			}
+			r.xPriv, r.kemPriv = nil, nil
+		}
+		if err := s.answerOffer(epoch, inner[1:ratchetOfferSize]); err != nil {
+			return nil, err
+		}
+		return inner[ratchetOfferSize:], nil
+	case ratchetAnswer:
+		if len(inner) < ratchetAnswerSize || r.xPriv == nil {
+			return nil, ErrDecrypt
+		}
+		if err := s.completeOffer(epoch, inner[1:ratchetAnswerSize]); err != nil {
+			return nil, err
+		}
+		r.staleBefore = counter
+		return inner[ratchetAnswerSize:], nil
+	}
+	return nil, ErrDecrypt
+}
+
+func (s *Session) answerOffer(epoch uint64, offer []byte) error {
+	curve := ecdh.X25519()
+	peerX, err := curve.NewPublicKey(offer[:x25519PubSize])
+	if err != nil {
+		return ErrDecrypt
+	}
+	var peerKem mlkem768.PublicKey
+	if err := peerKem.Unpack(offer[x25519PubSize:]); err != nil {
+		return ErrDecrypt
+	}
+
+	xPriv, err := newX25519Key(s.rand)
+	if err != nil {
+		return err
+	}
+	xShared, err := xPriv.ECDH(peerX)
+	if err != nil {
+		return ErrDecrypt
+	}
+	encSeed := make([]byte, mlkem768.EncapsulationSeedSize)
+	if _, err := io.ReadFull(s.rand, encSeed); err != nil {
+		return err
+	}
+	answer := make([]byte, ratchetAnswerSize)
+	answer[0] = ratchetAnswer
+	copy(answer[1:], xPriv.PublicKey().Bytes())
+	kemSS := make([]byte, mlkem768.SharedKeySize)
+	peerKem.EncapsulateTo(answer[1+x25519PubSize:], kemSS, encSeed)
+
+	txSecret, rxSecret := s.ratchetStep(xShared, kemSS)
+	s.ratchet.answer, s.ratchet.txSecret = answer, txSecret
+	s.setRxPending(epoch+1, rxSecret)
+	return nil
+}
+
+func (s *Session) completeOffer(epoch uint64, answer []byte) error {
+	r := s.ratchet
+	curve := ecdh.X25519()
+	peerX, err := curve.NewPublicKey(answer[:x25519PubSize])
+	if err != nil {
+		return ErrDecrypt
+	}
+	xShared, err := r.xPriv.ECDH(peerX)
+	if err != nil {
+		return ErrDecrypt
+	}
+	kemSS := make([]byte, mlkem768.SharedKeySize)
+	r.kemPriv.DecapsulateTo(kemSS, answer[x25519PubSize:])
+
+	txSecret, rxSecret := s.ratchetStep(xShared, kemSS)
+	s.tx.ratchetTo(s.ks.trafficKeys(txSecret), s.tx.counter)
+	s.setRxPending(epoch+1, rxSecret)
+	r.xPriv, r.kemPriv = nil, nil
+	r.sinceStep = 0
+	r.steps++
+	return nil
+}
+
+// ratchetStep folds fresh shared secrets into kMs and returns the new send and
+// receive traffic secrets for this side.
+func (s *Session) ratchetStep(xShared, kemSS []byte) (txSecret, rxSecret []byte) {
+	root := s.ks.expand(s.ks.extract(append(xShared, kemSS...), s.kMs), common.LabelRatchetRoot, 32)
+	s.cfg.mem.free(s.kMs)
+	s.kMs = s.cfg.mem.keep(root)
+	i2r := s.ks.expand(s.kMs, common.LabelTrafficI2R, 32)
+	r2i := s.ks.expand(s.kMs, common.LabelTrafficR2I, 32)
+	if s.isInitiator {
+		return i2r, r2i
+	}
+	return r2i, i2r
+}
+
+func (s *Session) setRxPending(epoch uint64, secret []byte) {
+	r := s.ratchet
+	r.rxPending, r.rxEpoch, r.rxSecret = true, epoch, secret
+}
+
+// nextRxKeys derives the receive keys for epoch from the keys of epoch-1:
+// from the new root if a ratchet step scheduled it, else by hash ratchet.
+func (s *Session) nextRxKeys(prev trafficKeys, epoch uint64) trafficKeys {
+	if r := s.ratchet; r != nil && r.rxPending && r.rxEpoch == epoch {
+		return s.ks.trafficKeys(r.rxSecret)
+	}
+	return s.ks.ratchetKeys(prev, epoch)
+}
+
+// noteRxEpoch clears a scheduled root switch once the receive side has
+// installed that epoch.
+func (s *Session) noteRxEpoch() {
+	if r := s.ratchet; r != nil && r.rxPending && s.rx.epoch >= r.rxEpoch {
+		r.rxPending, r.rxSecret = false, nil
+	}
+}
+
+// ratchetKeyPair draws fresh X25519 and ML-KEM-768 keys from randReader. Key
+// generation goes through seeds so a deterministic reader yields vectors.
+func ratchetKeyPair(randReader io.Reader) (*ecdh.PrivateKey, *mlkem768.PublicKey, *mlkem768.PrivateKey, error) {
+	xPriv, err := newX25519Key(randReader)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	seed := make([]byte, mlkem768.KeySeedSize)
+	if _, err := io.ReadFull(randReader, seed); err != nil {
+		return nil, nil, nil, err
+	}
+	pk, sk := mlkem768.NewKeyFromSeed(seed)
+	return xPriv, pk, sk, nil
+}
+
+func newX25519Key(randReader io.Reader) (*ecdh.PrivateKey, error) {
+	b := make([]byte, 32)
+	if _, err := io.ReadFull(randReader, b); err != nil {
+		return nil, err
+	}
+	return ecdh.X25519().NewPrivateKey(b)
+}
+
+// ratchetTo moves this direction to the next epoch with keys split from a new
+// root secret instead of the hash ratchet.
+func (d *direction) ratchetTo(keys trafficKeys, counter uint64) {
+	d.epoch++
+	d.mem.freeKeys(d.keys)
+	d.keys = d.mem.keepKeys(keys)
+	d.start = counter
+	d.bytes = 0
+	d.started = timeNow()
+}
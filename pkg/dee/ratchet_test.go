@@ -0,0 +1,219 @@
+package dee
+
+import (
+	"bytes"
+	"testing"
+)
+
+func ratchetPair(t *testing.T, every uint64, extra ...Option) (*Session, *Session) {
+	t.Helper()
+	opts := append([]Option{WithHybridRatchet(every)}, extra...)
+	return sessionPair(t, Safe, opts, opts)
+}
+
+func TestHybridRatchetStep(t *testing.T) {
+	initSession, respSession := ratchetPair(t, 0)
+	if err := initSession.RequestRatchet(); err != nil {
+		t.Fatalf("RequestRatchet: %v", err)
+	}
+	oldKMs := append([]byte(nil), initSession.kMs...)
+
+	sendFrames(t, initSession, respSession, 1) // offer
+	sendFrames(t, respSession, initSession, 1) // answer
+	sendFrames(t, initSession, respSession, 2)
+	sendFrames(t, respSession, initSession, 2)
+
+	if initSession.RatchetSteps() != 1 || respSession.RatchetSteps() != 1 {
+		t.Fatalf("steps: initiator %d responder %d, want 1", initSession.RatchetSteps(), respSession.RatchetSteps())
+	}
+	if !bytes.Equal(initSession.kMs, respSession.kMs) {
+		t.Fatal("peers disagree on kMs after a ratchet step")
+	}
+	if bytes.Equal(initSession.kMs, oldKMs) {
+		t.Fatal("kMs must change on a ratchet step")
+	}
+	if initSession.tx.epoch != 1 || respSession.tx.epoch != 1 {
+		t.Fatalf("epochs: initiator %d responder %d, want 1", initSession.tx.epoch, respSession.tx.epoch)
+	}
+}
+
+// A full copy of the initiator's state taken before a ratchet step reads
+// traffic until the step, then loses track. Without the ratchet the same copy
+// follows every key update.
+func TestHybridRatchetHealsAfterCompromise(t *testing.T) {
+	initSession, respSession := ratchetPair(t, 0)
+	sendFrames(t, respSession, initSession, 1)
+
+	// Copy the bytes, not the slices: the session wipes keys it replaces.
+	stolen := restoreSession(t, initSession)
+
+	if err := initSession.RequestRatchet(); err != nil {
+		t.Fatal(err)
+	}
+	sendFrames(t, initSession, respSession, 1)
+	answer, err := respSession.EncryptToFrame([]byte("answer"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := initSession.DecryptFromFrame(answer); err != nil {
+		t.Fatalf("answer: %v", err)
+	}
+	if _, err := stolen.DecryptFromFrame(answer); err != ErrDecrypt {
+		t.Fatal("stolen state must not complete a step it did not offer")
+	}
+	for i := 0; i < 3; i++ {
+		f, err := respSession.EncryptToFrame([]byte("after"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := initSession.DecryptFromFrame(f); err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+		if _, err := stolen.DecryptFromFrame(f); err == nil {
+			t.Fatalf("frame %d: stolen state decrypted traffic after the step", i)
+		}
+	}
+
+	// Control: hash-ratchet key updates alone do not heal.
+	plainInit, plainResp := sessionPair(t, Safe, nil, nil)
+	copied := restoreSession(t, plainInit)
+	ku, err := plainResp.RequestKeyUpdate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := plainInit.DecryptFromFrame(ku); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := copied.DecryptFromFrame(ku); err != nil {
+		t.Fatal(err)
+	}
+	f, err := plainResp.EncryptToFrame([]byte("after"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := copied.DecryptFromFrame(f); err != nil {
+		t.Fatal("copied state should follow a hash-ratchet key update")
+	}
+}
+
+func TestHybridRatchetCrossedOffers(t *testing.T) {
+	initSession, respSession := ratchetPair(t, 0)
+	if err := initSession.RequestRatchet(); err != nil {
+		t.Fatal(err)
+	}
+	if err := respSession.RequestRatchet(); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := initSession.EncryptToFrame([]byte("data"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fr, err := respSession.EncryptToFrame([]byte("data"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := respSession.DecryptFromFrame(fi); err != nil {
+		t.Fatalf("responder: %v", err)
+	}
+	if _, err := initSession.DecryptFromFrame(fr); err != nil {
+		t.Fatalf("initiator: %v", err)
+	}
+	sendFrames(t, respSession, initSession, 2)
+	sendFrames(t, initSession, respSession, 2)
+	if initSession.RatchetSteps() != 1 || respSession.RatchetSteps() != 1 {
+		t.Fatalf("steps: initiator %d responder %d, want 1", initSession.RatchetSteps(), respSession.RatchetSteps())
+	}
+}
+
+func TestHybridRatchetPeriodic(t *testing.T) {
+	initSession, respSession := ratchetPair(t, 4)
+	for i := 0; i < 20; i++ {
+		sendFrames(t, initSession, respSession, 3)
+		sendFrames(t, respSession, initSession, 2)
+	}
+	if initSession.RatchetSteps() < 4 || initSession.RatchetSteps() != respSession.RatchetSteps() {
+		t.Fatalf("steps: initiator %d responder %d", initSession.RatchetSteps(), respSession.RatchetSteps())
+	}
+	if !bytes.Equal(initSession.kMs, respSession.kMs) {
+		t.Fatal("peers disagree on kMs")
+	}
+}
+
+func TestHybridRatchetWithReplayWindow(t *testing.T) {
+	initSession, respSession := ratchetPair(t, 0, WithReplayWindow(MinReplayWindow))
+	if err := initSession.RequestRatchet(); err != nil {
+		t.Fatal(err)
+	}
+	sendFrames(t, initSession, respSession, 1)
+	sendFrames(t, respSession, initSession, 1)
+
+	// Frames from the old and new epoch arrive out of order.
+	late, err := respSession.EncryptToFrame([]byte("data"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := respSession.RequestKeyUpdate(); err != nil {
+		t.Fatal(err)
+	}
+	early, err := respSession.EncryptToFrame([]byte("data"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range [][]byte{early, late} {
+		if pt, err := initSession.DecryptFromFrame(f); err != nil || string(pt) != "data" {
+			t.Fatalf("reordered frame: %v", err)
+		}
+	}
+}
+
+func TestHybridRatchetMismatchFails(t *testing.T) {
+	initSession, respSession := sessionPair(t, Safe, []Option{WithHybridRatchet(0)}, nil)
+	f, err := initSession.EncryptToFrame([]byte("data"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := respSession.DecryptFromFrame(f); err != ErrDecrypt {
+		t.Fatalf("got %v, want ErrDecrypt", err)
+	}
+}
+
+func TestHybridRatchetOutstandingOfferHoldsEpoch(t *testing.T) {
+	initSession, respSession := ratchetPair(t, 0, WithRekeyPolicy(RekeyPolicy{Messages: 2}))
+	if err := initSession.RequestRatchet(); err != nil {
+		t.Fatal(err)
+	}
+	sendFrames(t, initSession, respSession, 5)
+	if initSession.tx.epoch != 0 {
+		t.Fatalf("send epoch moved to %d with an offer outstanding", initSession.tx.epoch)
+	}
+	if _, err := initSession.RequestKeyUpdate(); err != ErrDecrypt {
+		t.Fatal("RequestKeyUpdate must fail while an offer is outstanding")
+	}
+	sendFrames(t, respSession, initSession, 1)
+	if _, err := initSession.RequestKeyUpdate(); err != nil {
+		t.Fatalf("RequestKeyUpdate after answer: %v", err)
+	}
+}
+
+func TestRetainedEpochsBounded(t *testing.T) {
+	initSession, respSession := sessionPair(t, Safe, nil, []Option{WithReplayWindow(MaxReplayWindow)})
+	held, err := initSession.EncryptToFrame([]byte("data"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < maxRetainedEpochs+8; i++ {
+		ku, err := initSession.RequestKeyUpdate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := respSession.DecryptFromFrame(ku); err != nil {
+			t.Fatalf("key update %d: %v", i, err)
+		}
+	}
+	if n := len(respSession.rx.retained); n > maxRetainedEpochs {
+		t.Fatalf("retained %d epochs, bound is %d", n, maxRetainedEpochs)
+	}
+	if _, err := respSession.DecryptFromFrame(held); err != ErrDecrypt {
+		t.Fatal("frame from an evicted epoch must fail")
+	}
+}
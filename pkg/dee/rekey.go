@@ -0,0 +1,261 @@
+package dee
+
+import "time"
+
+// RekeyPolicy decides when the sending side of a session moves to a new epoch.
+// Each non-zero limit is checked before every message; reaching any one of
+// them ratchets the send keys. The receiver follows the epoch carried in the
+// frame header, so peers may use different policies.
+type RekeyPolicy struct {
+	Messages uint64        // messages per epoch
+	Bytes    uint64        // plaintext bytes per epoch
+	Interval time.Duration // wall-clock lifetime of an epoch
+}
+
+// WithRekeyPolicy replaces the default policy of rekeying every RekeyEvery
+// messages. A zero RekeyPolicy disables automatic rekeying; RequestKeyUpdate
+// still works.
+func WithRekeyPolicy(p RekeyPolicy) Option {
+	return func(c *config) {
+		c.rekey = &p
+	}
+}
+
+// rekeyPolicy is the configured policy with its message limit capped by the
+// suite's.
+func (s *Session) rekeyPolicy() RekeyPolicy {
+	p := RekeyPolicy{Messages: s.rekeyInterval()}
+	if s.cfg.rekey != nil {
+		p = *s.cfg.rekey
+	}
+	p.Messages = minLimit(p.Messages, s.ks.suite.Limits().Messages)
+	return p
+}
+
+func (s *Session) rekeyInterval() uint64 {
+	if rekeyEveryForTest > 0 {
+		return rekeyEveryForTest
+	}
+	return RekeyEvery
+}
+
+// maybeRekey moves the send side to a new epoch if the policy says so or if
+// sealing n more bytes would cross the suite's byte limit.
+func (s *Session) maybeRekey(n int) {
+	if !s.ratchetOutstanding() && s.rekeyDue(&s.tx, n) {
+		s.tx.ratchetForward(s.ks, s.tx.counter)
+	}
+}
+
+// rekeyDue reports whether the sending direction d has used up its epoch.
+func (s *Session) rekeyDue(d *direction, n int) bool {
+	p := s.rekeyPolicy()
+	used := d.counter - d.start
+	if used == 0 {
+		return false
+	}
+	limit := s.ks.suite.Limits().Bytes
+	return (p.Messages > 0 && used >= p.Messages) ||
+		(p.Bytes > 0 && d.bytes >= p.Bytes) ||
+		(limit > 0 && d.bytes+uint64(n) > limit) ||
+		(p.Interval > 0 && timeNow().Sub(d.started) >= p.Interval)
+}
+
+// RequestKeyUpdate returns an authenticated key-update frame and moves the send
+// direction to the next epoch. The frame carries the new epoch number; the peer
+// ratchets its receive keys when it accepts the frame. Every later frame is
+// sealed under the new epoch's keys. It fails while a hybrid ratchet offer from
+// this side is unanswered.
+func (s *Session) RequestKeyUpdate() (frame []byte, err error) {
+	defer s.lockTx()()
+	if !s.established || s.ratchetOutstanding() {
+		return nil, ErrDecrypt
+	}
+	s.maybeRekey(8)
+	next := uint64ToBytes(s.tx.epoch + 1)
+	header, ct, err := s.sealCurrent(next, nil, FlagKeyUpdate)
+	if err != nil {
+		return nil, err
+	}
+	s.tx.ratchetForward(s.ks, s.tx.counter)
+	return buildFrame(header, ct), nil
+}
+
+// fullEpoch reconstructs an epoch from the low byte carried in a header by
+// picking the candidate closest to the current receive epoch.
+func (d *direction) fullEpoch(low uint8) uint64 {
+	e := d.epoch&^0xff | uint64(low)
+	switch {
+	case e > d.epoch && e-d.epoch > 128 && e >= 256:
+		e -= 256
+	case e < d.epoch && d.epoch-e > 128:
+		e += 256
+	}
+	return e
+}
+
+// rxEpochFor predicts the epoch a peer used for counter: an older epoch if the
+// counter falls before the current one started, otherwise the current epoch
+// advanced by the message policy.
+func (s *Session) rxEpochFor(counter uint64) uint64 {
+	if counter >= s.rx.start {
+		e := s.rx.epoch
+		if n := s.rekeyPolicy().Messages; n > 0 {
+			e += (counter - s.rx.start) / n
+		}
+		return e
+	}
+	e, start, found := s.rx.epoch, uint64(0), false
+	for _, r := range s.rx.retained {
+		if r.start <= counter && (!found || r.start >= start) {
+			e, start, found = r.epoch, r.start, true
+		}
+	}
+	return e
+}
+
+// rxKeysFor returns the receive keys for epoch. Keys for later epochs are
+// derived into chain but not installed; advanceRx installs them once the frame
+// has authenticated, so a forged header cannot move the ratchet.
+func (s *Session) rxKeysFor(epoch, counter uint64) (trafficKeys, []epochKeys, bool) {
+	if epoch == s.rx.epoch {
+		return s.rx.keys, nil, true
+	}
+	if epoch < s.rx.epoch {
+		for _, r := range s.rx.retained {
+			if r.epoch == epoch {
+				return r.keys, nil, true
+			}
+		}
+		return trafficKeys{}, nil, false
+	}
+	if epoch-s.rx.epoch > maxEpochSkip {
+		return trafficKeys{}, nil, false
+	}
+	chain := make([]epochKeys, 0, epoch-s.rx.epoch)
+	keys := s.rx.keys
+	for e := s.rx.epoch + 1; e <= epoch; e++ {
+		keys = s.nextRxKeys(keys, e)
+		chain = append(chain, epochKeys{epoch: e, start: counter, keys: keys})
+	}
+	return keys, chain, true
+}
+
+// advanceRx records an authenticated frame from epoch: installs any newly
+// derived epoch, updates the counter or replay window, and drops epochs the
+// window has passed.
+func (s *Session) advanceRx(epoch, counter uint64, chain []epochKeys) {
+	if len(chain) > 0 {
+		for i := range chain {
+			chain[i].keys = s.rx.mem.keepKeys(chain[i].keys)
+		}
+		s.retainRx()
+		if s.rx.window != nil {
+			s.rx.retained = append(s.rx.retained, chain[:len(chain)-1]...)
+		} else {
+			s.rx.mem.freeKeys(s.rx.keys)
+			for _, r := range chain[:len(chain)-1] {
+				s.rx.mem.freeKeys(r.keys)
+			}
+		}
+		last := chain[len(chain)-1]
+		s.rx.epoch, s.rx.start, s.rx.keys = last.epoch, last.start, last.keys
+		s.boundRetained()
+		s.noteRxEpoch()
+	}
+	s.noteEpochStart(epoch, counter)
+	if s.rx.window == nil {
+		if counter >= s.rx.counter {
+			s.rx.counter = counter + 1
+		}
+		return
+	}
+	s.rx.window.accept(counter)
+	s.rx.counter = s.rx.window.top
+	s.pruneRetained()
+}
+
+// acceptKeyUpdate ratchets the receive side after an authenticated key-update
+// frame sealed under epoch at counter. If later frames already moved the
+// receiver past that epoch there is nothing left to do.
+func (s *Session) acceptKeyUpdate(epoch, counter uint64) {
+	if epoch != s.rx.epoch {
+		return
+	}
+	next := s.rx.mem.keepKeys(s.nextRxKeys(s.rx.keys, s.rx.epoch+1))
+	if s.rx.window != nil {
+		s.retainRx()
+	} else {
+		s.rx.mem.freeKeys(s.rx.keys)
+	}
+	s.rx.keys = next
+	s.rx.epoch++
+	s.rx.start = counter + 1
+	s.noteRxEpoch()
+}
+
+// retainRx keeps the current receive epoch for reordered frames when a replay
+// window is in use.
+func (s *Session) retainRx() {
+	if s.rx.window != nil {
+		s.rx.retained = append(s.rx.retained, epochKeys{epoch: s.rx.epoch, start: s.rx.start, keys: s.rx.keys})
+		s.boundRetained()
+	}
+}
+
+// boundRetained caps the skipped-epoch store at maxRetainedEpochs, dropping the
+// oldest. Frames from a dropped epoch fail to decrypt.
+func (s *Session) boundRetained() {
+	if n := len(s.rx.retained) - maxRetainedEpochs; n > 0 {
+		for _, r := range s.rx.retained[:n] {
+			s.rx.mem.freeKeys(r.keys)
+		}
+		s.rx.retained = append(s.rx.retained[:0], s.rx.retained[n:]...)
+	}
+}
+
+func (s *Session) noteEpochStart(epoch, counter uint64) {
+	if epoch == s.rx.epoch {
+		if counter < s.rx.start {
+			s.rx.start = counter
+		}
+		return
+	}
+	for i := range s.rx.retained {
+		if s.rx.retained[i].epoch == epoch && counter < s.rx.retained[i].start {
+			s.rx.retained[i].start = counter
+		}
+	}
+}
+
+// pruneRetained drops retained epochs whose every counter is below the window.
+// An epoch ends where the next one starts.
+func (s *Session) pruneRetained() {
+	lowest := s.rx.window.lowest()
+	kept := s.rx.retained[:0]
+	for i, r := range s.rx.retained {
+		next := s.rx.start
+		if i+1 < len(s.rx.retained) {
+			next = s.rx.retained[i+1].start
+		}
+		if next > lowest {
+			kept = append(kept, r)
+		} else {
+			s.rx.mem.freeKeys(r.keys)
+		}
+	}
+	s.rx.retained = kept
+}
+
+// ratchetForward replaces this direction's keys with the next epoch's, derived
+// from its current rekey key. counter is the first counter of the new epoch.
+// The other direction is not affected.
+func (d *direction) ratchetForward(ks keySchedule, counter uint64) {
+	d.epoch++
+	next := ks.ratchetKeys(d.keys, d.epoch)
+	d.mem.freeKeys(d.keys)
+	d.keys = d.mem.keepKeys(next)
+	d.start = counter
+	d.bytes = 0
+	d.started = timeNow()
+}
@@ -0,0 +1,140 @@
+package dee
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func sendFrames(t *testing.T, from, to *Session, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		f, err := from.EncryptToFrame([]byte("data"), nil)
+		if err != nil {
+			t.Fatalf("EncryptToFrame: %v", err)
+		}
+		pt, err := to.DecryptFromFrame(f)
+		if err != nil || string(pt) != "data" {
+			t.Fatalf("DecryptFromFrame %d: %v", i, err)
+		}
+	}
+}
+
+func TestRequestKeyUpdate(t *testing.T) {
+	initSession, respSession := sessionPair(t, Safe, nil, nil)
+	sendFrames(t, initSession, respSession, 3)
+
+	oldKey := append([]byte(nil), respSession.rx.keys.kAead...)
+	ku, err := initSession.RequestKeyUpdate()
+	if err != nil {
+		t.Fatalf("RequestKeyUpdate: %v", err)
+	}
+	if initSession.tx.epoch != 1 {
+		t.Fatalf("sender epoch: got %d want 1", initSession.tx.epoch)
+	}
+	pt, err := respSession.DecryptFromFrame(ku)
+	if err != nil {
+		t.Fatalf("key-update frame: %v", err)
+	}
+	if len(pt) != 0 {
+		t.Errorf("key-update frame must decrypt to empty plaintext, got %d bytes", len(pt))
+	}
+	if respSession.rx.epoch != 1 || bytes.Equal(oldKey, respSession.rx.keys.kAead) {
+		t.Fatal("receiver must ratchet on an accepted key update")
+	}
+	if !bytes.Equal(initSession.tx.keys.kAead, respSession.rx.keys.kAead) {
+		t.Fatal("peers disagree on the new epoch's keys")
+	}
+	if initSession.rx.epoch != 0 || respSession.tx.epoch != 0 {
+		t.Error("key update must only move the requesting direction")
+	}
+	sendFrames(t, initSession, respSession, 3)
+	sendFrames(t, respSession, initSession, 3)
+
+	if _, err := respSession.DecryptFromFrame(ku); err != ErrDecrypt {
+		t.Errorf("replayed key update: want ErrDecrypt, got %v", err)
+	}
+}
+
+func TestKeyUpdateEpochWraps(t *testing.T) {
+	initSession, respSession := sessionPair(t, Safe, nil, nil)
+	// Past 256 epochs the header only carries the low byte.
+	for i := 0; i < 300; i++ {
+		ku, err := initSession.RequestKeyUpdate()
+		if err != nil {
+			t.Fatalf("RequestKeyUpdate %d: %v", i, err)
+		}
+		if _, err := respSession.DecryptFromFrame(ku); err != nil {
+			t.Fatalf("key update %d: %v", i, err)
+		}
+	}
+	if respSession.rx.epoch != 300 {
+		t.Fatalf("receiver epoch: got %d want 300", respSession.rx.epoch)
+	}
+	sendFrames(t, initSession, respSession, 2)
+}
+
+func TestKeyUpdateReorderedWithWindow(t *testing.T) {
+	initSession, respSession := sessionPair(t, Safe, nil, []Option{WithReplayWindow(MinReplayWindow)})
+
+	before, _ := initSession.EncryptToFrame([]byte("before"), nil)
+	ku, _ := initSession.RequestKeyUpdate()
+	after, _ := initSession.EncryptToFrame([]byte("after"), nil)
+
+	// The new-epoch frame overtakes the key update and the frame before it.
+	for _, f := range [][]byte{after, ku, before} {
+		if _, err := respSession.DecryptFromFrame(f); err != nil {
+			t.Fatalf("reordered frame: %v", err)
+		}
+	}
+	if respSession.rx.epoch != 1 {
+		t.Errorf("receiver epoch: got %d want 1", respSession.rx.epoch)
+	}
+}
+
+func TestRekeyPolicyBytes(t *testing.T) {
+	policy := RekeyPolicy{Bytes: 10}
+	initSession, respSession := sessionPair(t, Safe, []Option{WithRekeyPolicy(policy)}, nil)
+
+	// "data" is 4 bytes: the epoch fills after three messages.
+	sendFrames(t, initSession, respSession, 3)
+	if initSession.tx.epoch != 0 {
+		t.Fatalf("epoch moved early: %d", initSession.tx.epoch)
+	}
+	sendFrames(t, initSession, respSession, 1)
+	if initSession.tx.epoch != 1 || respSession.rx.epoch != 1 {
+		t.Fatalf("byte limit must rekey: tx=%d rx=%d", initSession.tx.epoch, respSession.rx.epoch)
+	}
+}
+
+func TestRekeyPolicyInterval(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	SetTimeNowForTest(func() time.Time { return now })
+	defer SetTimeNowForTest(nil)
+
+	policy := RekeyPolicy{Interval: time.Minute}
+	initSession, respSession := sessionPair(t, Safe, []Option{WithRekeyPolicy(policy)}, nil)
+
+	sendFrames(t, initSession, respSession, 2)
+	now = now.Add(59 * time.Second)
+	sendFrames(t, initSession, respSession, 1)
+	if initSession.tx.epoch != 0 {
+		t.Fatal("epoch moved before the interval elapsed")
+	}
+	now = now.Add(time.Second)
+	sendFrames(t, initSession, respSession, 1)
+	if initSession.tx.epoch != 1 || respSession.rx.epoch != 1 {
+		t.Fatalf("interval must rekey: tx=%d rx=%d", initSession.tx.epoch, respSession.rx.epoch)
+	}
+}
+
+func TestRekeyPolicyDisabled(t *testing.T) {
+	SetRekeyEveryForTest(2)
+	defer SetRekeyEveryForTest(0)
+
+	initSession, respSession := sessionPair(t, Safe, []Option{WithRekeyPolicy(RekeyPolicy{})}, nil)
+	sendFrames(t, initSession, respSession, 10)
+	if initSession.tx.epoch != 0 {
+		t.Errorf("zero policy must not rekey, epoch %d", initSession.tx.epoch)
+	}
+}
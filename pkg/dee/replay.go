@@ -0,0 +1,70 @@
+package dee
+
+// replayWindow is an IPsec/DTLS-style sliding bitmap over received counters.
+// Bit i records whether counter top-1-i has been accepted.
+type replayWindow struct {
+	size   uint64
+	top    uint64 // one past the highest accepted counter
+	bitmap []uint64
+}
+
+func newReplayWindow(size int) *replayWindow {
+	return &replayWindow{
+		size:   uint64(size),
+		bitmap: make([]uint64, (size+63)/64),
+	}
+}
+
+// check reports whether counter is ahead of the window or inside it and not
+// yet seen. It does not modify the window.
+func (w *replayWindow) check(counter uint64) bool {
+	if counter >= w.top {
+		return counter != ^uint64(0)
+	}
+	diff := w.top - 1 - counter
+	if diff >= w.size {
+		return false
+	}
+	return w.bitmap[diff/64]&(1<<(diff%64)) == 0
+}
+
+// accept marks counter as received. Call only after check and successful
+// authentication.
+func (w *replayWindow) accept(counter uint64) {
+	if counter >= w.top {
+		w.shift(counter + 1 - w.top)
+		w.top = counter + 1
+	}
+	diff := w.top - 1 - counter
+	w.bitmap[diff/64] |= 1 << (diff % 64)
+}
+
+// lowest returns the smallest counter the window can still accept.
+func (w *replayWindow) lowest() uint64 {
+	if w.top <= w.size {
+		return 0
+	}
+	return w.top - w.size
+}
+
+func (w *replayWindow) shift(k uint64) {
+	n := uint64(len(w.bitmap))
+	if k >= n*64 {
+		for i := range w.bitmap {
+			w.bitmap[i] = 0
+		}
+		return
+	}
+	words, bits := k/64, k%64
+	for i := n; i > 0; i-- {
+		j := i - 1
+		var v uint64
+		if j >= words {
+			v = w.bitmap[j-words] << bits
+			if bits > 0 && j > words {
+				v |= w.bitmap[j-words-1] >> (64 - bits)
+			}
+		}
+		w.bitmap[j] = v
+	}
+}
@@ -0,0 +1,161 @@
+package dee
+
+import (
+	"testing"
+)
+
+// sessionPair runs a full handshake in mode with the given options on each
+// side and returns the established initiator and responder sessions.
+func sessionPair(t testing.TB, mode Mode, initOpts, respOpts []Option) (*Session, *Session) {
+	t.Helper()
+	initMsg, initSession, err := HandshakeInit(mode, nil, initOpts...)
+	if err != nil {
+		t.Fatalf("HandshakeInit: %v", err)
+	}
+	respMsg, respSession, err := HandshakeResp(mode, initMsg, nil, respOpts...)
+	if err != nil {
+		t.Fatalf("HandshakeResp: %v", err)
+	}
+	if err := initSession.HandshakeComplete(respMsg); err != nil {
+		t.Fatalf("HandshakeComplete: %v", err)
+	}
+	finishHandshake(t, initSession, respSession)
+	return initSession, respSession
+}
+
+func TestReplayWindowBitmap(t *testing.T) {
+	w := newReplayWindow(MinReplayWindow)
+	for _, c := range []uint64{5, 3, 0, 63, 70} {
+		if !w.check(c) {
+			t.Fatalf("counter %d should be acceptable", c)
+		}
+		w.accept(c)
+		if w.check(c) {
+			t.Fatalf("counter %d accepted twice", c)
+		}
+	}
+	// top is 71; counters below 7 have fallen out of the 64-wide window.
+	if w.check(6) {
+		t.Error("stale counter 6 must be rejected")
+	}
+	if !w.check(7) || !w.check(69) {
+		t.Error("unseen counters inside the window must be acceptable")
+	}
+	if w.check(63) {
+		t.Error("counter 63 seen before shift must still be marked")
+	}
+	w.accept(70 + 200)
+	if w.check(63) || w.check(70) {
+		t.Error("large jump must age out everything below the window")
+	}
+}
+
+func TestReplayWindowConfigBounds(t *testing.T) {
+	for _, size := range []int{1, MinReplayWindow - 1, MaxReplayWindow + 1, -64} {
+		if _, _, err := HandshakeInit(Safe, nil, WithReplayWindow(size)); err != ErrConfig {
+			t.Errorf("window %d: want ErrConfig, got %v", size, err)
+		}
+	}
+	for _, size := range []int{MinReplayWindow, 100, MaxReplayWindow} {
+		if _, _, err := HandshakeInit(Safe, nil, WithReplayWindow(size)); err != nil {
+			t.Errorf("window %d: %v", size, err)
+		}
+	}
+}
+
+func TestReplayWindowReorderAndDrop(t *testing.T) {
+	initSession, respSession := sessionPair(t, Safe, nil, []Option{WithReplayWindow(MinReplayWindow)})
+
+	frames := make([][]byte, 10)
+	for i := range frames {
+		f, err := initSession.EncryptToFrame([]byte{byte(i)}, nil)
+		if err != nil {
+			t.Fatalf("EncryptToFrame: %v", err)
+		}
+		frames[i] = f
+	}
+	// Frame 2 is dropped, the rest arrive shuffled.
+	for _, i := range []int{1, 0, 4, 3, 9, 5, 8, 6, 7} {
+		pt, err := respSession.DecryptFromFrame(frames[i])
+		if err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+		if pt[0] != byte(i) {
+			t.Fatalf("frame %d: got payload %d", i, pt[0])
+		}
+	}
+	for _, i := range []int{0, 4, 9} {
+		if _, err := respSession.DecryptFromFrame(frames[i]); err != ErrDecrypt {
+			t.Errorf("duplicate frame %d: want ErrDecrypt, got %v", i, err)
+		}
+	}
+	// The dropped frame is late but still inside the window.
+	if _, err := respSession.DecryptFromFrame(frames[2]); err != nil {
+		t.Errorf("late frame 2: %v", err)
+	}
+}
+
+func TestReplayWindowStaleRejected(t *testing.T) {
+	initSession, respSession := sessionPair(t, Safe, nil, []Option{WithReplayWindow(MinReplayWindow)})
+
+	first, _ := initSession.EncryptToFrame([]byte("old"), nil)
+	var last []byte
+	for i := 0; i < MinReplayWindow+1; i++ {
+		last, _ = initSession.EncryptToFrame([]byte("new"), nil)
+	}
+	if _, err := respSession.DecryptFromFrame(last); err != nil {
+		t.Fatalf("newest frame: %v", err)
+	}
+	if _, err := respSession.DecryptFromFrame(first); err != ErrDecrypt {
+		t.Errorf("frame behind the window: want ErrDecrypt, got %v", err)
+	}
+}
+
+// TestReplayWindowAcrossRekey reorders frames around a RekeyEvery boundary: the
+// receiver must open each under its own epoch's keys and not lose old epochs
+// early.
+func TestReplayWindowAcrossRekey(t *testing.T) {
+	SetRekeyEveryForTest(5)
+	defer SetRekeyEveryForTest(0)
+
+	initSession, respSession := sessionPair(t, Safe, nil, []Option{WithReplayWindow(MinReplayWindow)})
+
+	frames := make([][]byte, 23)
+	for i := range frames {
+		frames[i], _ = initSession.EncryptToFrame([]byte{byte(i)}, nil)
+	}
+	// Jump three epochs ahead first, then backfill across every boundary.
+	order := []int{16, 6, 5, 0, 4, 3, 1, 2, 9, 7, 8, 10, 14, 12, 11, 13, 15, 22, 17, 21, 18, 20, 19}
+	for _, i := range order {
+		pt, err := respSession.DecryptFromFrame(frames[i])
+		if err != nil {
+			t.Fatalf("frame %d (epoch %d): %v", i, i/5, err)
+		}
+		if pt[0] != byte(i) {
+			t.Fatalf("frame %d: got payload %d", i, pt[0])
+		}
+	}
+	for _, i := range order {
+		if _, err := respSession.DecryptFromFrame(frames[i]); err != ErrDecrypt {
+			t.Fatalf("replayed frame %d: want ErrDecrypt, got %v", i, err)
+		}
+	}
+}
+
+func TestReplayWindowEpochSkipBounded(t *testing.T) {
+	SetRekeyEveryForTest(2)
+	defer SetRekeyEveryForTest(0)
+
+	initSession, respSession := sessionPair(t, Safe, nil, []Option{WithReplayWindow(MaxReplayWindow)})
+
+	var frame []byte
+	for i := 0; i < 2*(maxEpochSkip+2); i++ {
+		frame, _ = initSession.EncryptToFrame([]byte("far"), nil)
+	}
+	if _, err := respSession.DecryptFromFrame(frame); err != ErrDecrypt {
+		t.Errorf("frame beyond maxEpochSkip: want ErrDecrypt, got %v", err)
+	}
+	if respSession.rx.epoch != 0 {
+		t.Errorf("rejected frame must not advance the receive epoch, got %d", respSession.rx.epoch)
+	}
+}
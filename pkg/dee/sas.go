@@ -0,0 +1,125 @@
+package dee
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+
+	"deadend-lab/pkg/common"
+)
+
+// A short authentication string lets two humans detect a MITM on an
+// unauthenticated handshake by reading a few digits or words to each other.
+// SAS values are short, so a MITM that could choose its messages after seeing
+// both peers' contributions would grind for a collision. Commit-then-reveal
+// prevents that: the initiator commits to a nonce in Init, the responder
+// answers with its own nonce in Resp, and the initiator reveals its nonce in
+// Finish. Each side's SAS input is fixed before the MITM learns the other
+// nonce, so its two SAS values match with probability about 2^-20 per attempt.
+
+const (
+	sasNonceSize = 32
+	sasSize      = 4
+	sasDigits    = 1000000
+	sasWords     = 3
+)
+
+// SAS is a session's short authentication string. Compare either rendering
+// out of band; equal sessions have equal SAS values.
+type SAS struct {
+	b [sasSize]byte
+}
+
+// WithSAS runs the commit-then-reveal exchange that Session.SAS needs. Both
+// peers must set it; otherwise the handshake fails.
+func WithSAS() Option {
+	return func(c *config) {
+		c.sas = true
+	}
+}
+
+// SAS returns the short authentication string of an established session that
+// was set up WithSAS.
+func (s *Session) SAS() (SAS, error) {
+	defer s.lockBoth()()
+	if !s.established || s.sasInit == nil || s.sasResp == nil {
+		return SAS{}, ErrHandshake
+	}
+	h := sha256.New()
+	h.Write([]byte(s.ks.label(common.LabelSAS)))
+	h.Write(s.transcriptHash)
+	h.Write(s.sasInit)
+	h.Write(s.sasResp)
+	var sas SAS
+	copy(sas.b[:], h.Sum(nil))
+	return sas, nil
+}
+
+// Decimal renders the SAS as six decimal digits (about 20 bits).
+func (a SAS) Decimal() string {
+	return fmt.Sprintf("%06d", binary.BigEndian.Uint32(a.b[:])%sasDigits)
+}
+
+// Words renders the SAS as three words (24 bits).
+func (a SAS) Words() string {
+	w := make([]string, sasWords)
+	for i := range w {
+		w[i] = sasWordList[a.b[i]]
+	}
+	return strings.Join(w, " ")
+}
+
+func (a SAS) String() string {
+	return a.Decimal() + " (" + a.Words() + ")"
+}
+
+// sasCommit is the initiator's commitment to its nonce.
+func sasCommit(nonce []byte) []byte {
+	h := sha256.Sum256(append([]byte(common.LabelSASCommit), nonce...))
+	return h[:]
+}
+
+func readSASNonce(randReader io.Reader) ([]byte, error) {
+	nonce := make([]byte, sasNonceSize)
+	if _, err := io.ReadFull(randReader, nonce); err != nil {
+		return nil, err
+	}
+	return nonce, nil
+}
+
+var sasWordList = [256]string{
+	"acid", "actor", "adobe", "agent", "alarm", "album", "alley", "amber",
+	"angle", "ankle", "apple", "arena", "armor", "arrow", "aspen", "atlas",
+	"attic", "award", "bacon", "badge", "bagel", "baker", "barn", "basil",
+	"batch", "beach", "beard", "berry", "bison", "blade", "blank", "blimp",
+	"blond", "board", "boots", "bough", "brain", "brass", "brick", "bride",
+	"brook", "broom", "brush", "cabin", "cable", "cacao", "camel", "canal",
+	"candy", "cargo", "carol", "cedar", "chalk", "charm", "chili", "chime",
+	"cider", "civic", "clamp", "cliff", "clock", "cloud", "clown", "coast",
+	"cobra", "comet", "coral", "couch", "crane", "crate", "crown", "crust",
+	"cubic", "curry", "daisy", "delta", "depot", "diary", "disco", "diver",
+	"dodge", "draft", "drama", "drill", "drum", "dusk", "easel", "ebony",
+	"elbow", "elder", "ember", "envoy", "epoch", "fable", "fairy", "fancy",
+	"ferry", "fiber", "field", "flame", "flask", "fleet", "flute", "focus",
+	"foggy", "forge", "fossil", "fudge", "gavel", "gecko", "ghost", "giant",
+	"ginger", "globe", "glove", "goose", "gourd", "grain", "gravy", "grill",
+	"guava", "guild", "habit", "hazel", "heron", "hinge", "hippo", "honey",
+	"hotel", "igloo", "inlet", "ivory", "jelly", "jewel", "juice", "kayak",
+	"kebab", "kettle", "koala", "ladle", "lapel", "latch", "lemon", "lever",
+	"lilac", "llama", "lobby", "lotus", "lunar", "lyric", "magic", "mango",
+	"maple", "marsh", "melon", "minnow", "mocha", "model", "molar", "moose",
+	"motor", "nacho", "navel", "nectar", "noble", "noodle", "oasis", "ocean",
+	"olive", "omega", "onion", "orbit", "otter", "oxide", "paddle", "panda",
+	"paper", "peach", "pearl", "pecan", "penny", "pepper", "pilot", "pixel",
+	"plaza", "plume", "polar", "prism", "pulse", "quail", "quartz", "quest",
+	"quill", "radio", "raven", "relay", "rhino", "ribbon", "robin", "rocket",
+	"rodeo", "rover", "ruby", "salad", "salsa", "satin", "scarf", "scout",
+	"shark", "sierra", "silk", "siren", "skate", "slate", "solar", "sonic",
+	"spade", "spice", "spoon", "stamp", "steam", "stork", "sugar", "sushi",
+	"swamp", "table", "taco", "talon", "tango", "tiger", "topaz", "torch",
+	"tower", "trout", "tulip", "tundra", "turtle", "ultra", "umbra", "urban",
+	"valve", "velvet", "viola", "viper", "vivid", "wafer", "walnut", "whale",
+	"wheat", "willow", "wizard", "yodel", "zebra", "zesty", "zinc", "zipper",
+}
@@ -0,0 +1,102 @@
+package dee
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSASMatches(t *testing.T) {
+	opts := []Option{WithSAS()}
+	initSession, respSession := sessionPair(t, Safe, opts, opts)
+	a, err := initSession.SAS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := respSession.SAS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a != b || a.String() != b.String() {
+		t.Fatalf("SAS differs: %v / %v", a, b)
+	}
+	if d := a.Decimal(); len(d) != 6 || strings.Trim(d, "0123456789") != "" {
+		t.Fatalf("decimal SAS %q", d)
+	}
+	if w := strings.Fields(a.Words()); len(w) != sasWords {
+		t.Fatalf("word SAS %q", a.Words())
+	}
+}
+
+func TestSASUnavailable(t *testing.T) {
+	initSession, respSession := sessionPair(t, Safe, nil, nil)
+	if _, err := initSession.SAS(); err != ErrHandshake {
+		t.Fatalf("without WithSAS: got %v", err)
+	}
+	initMsg, initSession, _ := HandshakeInit(Safe, nil, WithSAS())
+	respMsg, respSession, _ := HandshakeResp(Safe, initMsg, nil, WithSAS())
+	if _, err := respSession.SAS(); err != ErrHandshake {
+		t.Fatalf("before the reveal: got %v", err)
+	}
+	if err := initSession.HandshakeComplete(respMsg); err != nil {
+		t.Fatal(err)
+	}
+	finishHandshake(t, initSession, respSession)
+	if _, err := respSession.SAS(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSASOneSided(t *testing.T) {
+	for name, sides := range map[string][2][]Option{
+		"initiator only": {{WithSAS()}, nil},
+		"responder only": {nil, {WithSAS()}},
+	} {
+		initMsg, _, _ := HandshakeInit(Safe, nil, sides[0]...)
+		if _, _, err := HandshakeResp(Safe, initMsg, nil, sides[1]...); err != ErrHandshake {
+			t.Errorf("%s: got %v, want ErrHandshake", name, err)
+		}
+	}
+}
+
+// The initiator cannot change its nonce after seeing the responder's.
+func TestSASRevealMustOpenCommitment(t *testing.T) {
+	initMsg, initSession, _ := HandshakeInit(Safe, nil, WithSAS())
+	respMsg, respSession, _ := HandshakeResp(Safe, initMsg, nil, WithSAS())
+	if err := initSession.HandshakeComplete(respMsg); err != nil {
+		t.Fatal(err)
+	}
+	finMsg, err := initSession.HandshakeFinishMsg()
+	if err != nil {
+		t.Fatal(err)
+	}
+	finMsg[finFixedSize+extHeaderSize] ^= 1
+	if err := respSession.HandshakeFinish(finMsg); err != ErrHandshake {
+		t.Fatalf("got %v, want ErrHandshake", err)
+	}
+}
+
+// A MITM on HandshakeResp runs one handshake with each peer: as responder to
+// Alice and as initiator to Bob. The two SAS values differ, because each
+// side's nonces are fixed before the MITM sees the other's.
+func TestSASDetectsMITM(t *testing.T) {
+	opts := []Option{WithSAS()}
+	for i := 0; i < 64; i++ {
+		alice, _ := sessionPair(t, Safe, opts, opts)
+		_, bob := sessionPair(t, Safe, opts, opts)
+		a, _ := alice.SAS()
+		b, _ := bob.SAS()
+		if a == b {
+			t.Fatalf("trial %d: MITM produced matching SAS %v", i, a)
+		}
+	}
+}
+
+func TestSASWordListDistinct(t *testing.T) {
+	seen := map[string]bool{}
+	for _, w := range sasWordList {
+		if w == "" || seen[w] {
+			t.Fatalf("word list entry %q empty or repeated", w)
+		}
+		seen[w] = true
+	}
+}
@@ -4,9 +4,12 @@ import (
 	"crypto/ecdh"
 	"encoding/binary"
 	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"deadend-lab/pkg/common"
-	"golang.org/x/crypto/chacha20poly1305"
 )
 
 var (
@@ -16,48 +19,156 @@ var (
 	ErrInvalidMode = errors.New("invalid mode")
 
 	rekeyEveryForTest uint64
+	timeNow           = time.Now
 )
 
-// Session holds DEE session state.
+// trafficKeys holds the keys for one direction of a session. Both peers derive
+// the same initiator-to-responder and responder-to-initiator sets; each side
+// sends with one and receives with the other.
+type trafficKeys struct {
+	secret []byte
+	kAead  []byte
+	kNonce []byte
+	kAudit []byte
+	kRekey []byte
+}
+
+// direction is the send or receive half of a session: its keys, counter and
+// rekey ratchet advance independently of the other half.
+type direction struct {
+	keys    trafficKeys
+	epoch   uint64
+	start   uint64 // first counter of the current epoch
+	counter uint64
+
+	// Send side only: usage of the current epoch, checked against RekeyPolicy.
+	bytes   uint64
+	started time.Time
+
+	// Receive side only. window is nil for strict in-order delivery; retained
+	// holds older epochs that reordered frames inside the window may still need.
+	window   *replayWindow
+	retained []epochKeys
+
+	// mem holds the keys when the session uses locked memory.
+	mem *lockedMem
+}
+
+// epochKeys is a receive epoch kept alive for reordered frames.
+type epochKeys struct {
+	epoch uint64
+	start uint64
+	keys  trafficKeys
+}
+
+// Session holds DEE session state. An established session is safe for
+// concurrent use: one goroutine may seal while others open, or many may seal
+// at once. The send and receive halves have separate locks, so in the default
+// mode they do not wait on each other; each direction rekeys on its own. In
+// hybrid ratchet mode receiving an answer moves the send keys, so there every
+// call takes both locks. Handshake methods and UnmarshalBinary must not run
+// concurrently with other calls.
 type Session struct {
-	mode           Mode
-	sessionID      []byte
+	// Set by the handshake; Close keeps them.
+	mode        Mode
+	ks          keySchedule
+	sessionID   []byte
+	isInitiator bool
+
+	// txMu guards tx, lease, earlyTx and the send counts in usage; rxMu
+	// guards rx and earlyRx. The rest of sessionState changes only under
+	// both, taken txMu first. streamMu guards the streams and is taken
+	// after either. forgeries is read by both halves.
+	txMu      sync.Mutex
+	rxMu      sync.Mutex
+	streamMu  sync.Mutex
+	forgeries atomic.Uint64
+
+	sessionState
+}
+
+// sessionState is the part of a Session that Close discards.
+type sessionState struct {
 	transcriptHash []byte
-	kAead          []byte
-	kNonce         []byte
-	kAudit         []byte
-	kRekey         []byte
 	kMs            []byte
-	counterTx      uint64
-	counterRx      uint64
+	tx             direction
+	rx             direction
 	initMsg        []byte
 	respMsg        []byte
 	established    bool
-	isInitiator    bool
-
-	// Pre-handshake (cleared after)
-	xPriv     *ecdh.PrivateKey
-	kyberPriv interface{}
+	cfg            config
+	rand           io.Reader
+	ratchet        *hybridRatchet
+	peerIdentity   []byte
+	sasInit        []byte // SAS nonces (WithSAS)
+	sasResp        []byte
+	// resumptionSecret keys the PSKs of tickets issued on this session.
+	resumptionSecret []byte
+	// earlyAccepted is the initiator's view of EarlyDataAccepted.
+	earlyAccepted bool
+	// lease, if set, reserves send counters before they are used.
+	lease *counterLease
+	// streams is set by the first OpenStream or DecryptStream.
+	streams *streamTable
+	// closed is set by Close.
+	closed bool
+	usage  usage
+
+	// Pre-handshake (cleared after). pendingIdentity is the initiator's
+	// announced identity while a responder waits for HandshakeFinish;
+	// confirmKey keys the Finished MACs until both have been exchanged.
+	kemPubs         [][]byte
+	kemPrivs        []interface{}
+	pendingIdentity []byte
+	confirmKey      []byte
+	pakeKey         *ecdh.PrivateKey
+	sasCommitment   []byte
+	kemAuthSecret   []byte
+	earlyTx         *earlyState
+	earlyRx         *earlyState
 }
 
-func newSessionFromKeys(mode Mode, sessionID, transcriptHash, kMs []byte) (*Session, error) {
+func newSessionFromKeys(mode Mode, ks keySchedule, sessionID, transcriptHash, kMs []byte, isInitiator bool, cfg config) (*Session, error) {
 	s := &Session{
-		mode:           mode,
-		sessionID:      append([]byte(nil), sessionID...),
-		transcriptHash: append([]byte(nil), transcriptHash...),
-		kMs:            append([]byte(nil), kMs...),
-		counterRx:      0,
-		established:    false,
+		mode:        mode,
+		ks:          ks,
+		sessionID:   append([]byte(nil), sessionID...),
+		isInitiator: isInitiator,
+		sessionState: sessionState{
+			transcriptHash: append([]byte(nil), transcriptHash...),
+			kMs:            append([]byte(nil), kMs...),
+			established:    false,
+			cfg:            cfg,
+		},
 	}
 	s.deriveKeys(kMs)
 	return s, nil
 }
 
+// deriveKeys splits kMs into the initiator-to-responder and
+// responder-to-initiator key sets and assigns them to tx/rx by role.
 func (s *Session) deriveKeys(kMs []byte) {
-	s.kAead = common.Expand(kMs, common.LabelAEADKey, 32)
-	s.kNonce = common.Expand(kMs, common.LabelNonceBase, 32)
-	s.kAudit = common.Expand(kMs, common.LabelAuditTag, 32)
-	s.kRekey = common.Expand(kMs, common.LabelRekey, 32)
+	mem := s.cfg.mem
+	root := s.ratchetRoot(kMs)
+	s.resumptionSecret = mem.keep(s.ks.expand(kMs, common.LabelResumption, 32))
+	i2r := mem.keepKeys(s.ks.trafficKeys(s.ks.expand(root, common.LabelTrafficI2R, 32)))
+	r2i := mem.keepKeys(s.ks.trafficKeys(s.ks.expand(root, common.LabelTrafficR2I, 32)))
+	if s.isInitiator {
+		s.tx.keys, s.rx.keys = i2r, r2i
+	} else {
+		s.tx.keys, s.rx.keys = r2i, i2r
+	}
+	s.tx.mem, s.rx.mem = mem, mem
+	if s.cfg.replayWindow > 0 && s.mode.IsSafe() {
+		s.rx.window = newReplayWindow(s.cfg.replayWindow)
+	}
+	if s.cfg.ratchet {
+		s.kMs = root
+		s.ratchet = &hybridRatchet{every: s.cfg.ratchetEvery}
+	}
+	s.kMs = mem.keep(s.kMs)
+	s.tx.started = timeNow()
+	s.usage.since = s.tx.started
 }
 
 // SessionID returns the session identifier.
@@ -65,206 +176,253 @@ func (s *Session) SessionID() []byte {
 	return append([]byte(nil), s.sessionID...)
 }
 
-// WireHeader returns the wire header for the given counter. Used for building AD.
+// WireHeader returns the wire header the peer is expected to use for counter,
+// as seen from this (receiving) side. Used for building AD. The epoch bits are
+// predicted from the epochs received so far and the message rekey policy;
+// peers that rekey on bytes or time should exchange whole frames instead.
 func (s *Session) WireHeader(counter uint64) []byte {
-	return s.buildHeader(counter, 0)
+	defer s.lockRx()()
+	return s.buildHeader(counter, s.rxEpochFor(counter), 0)
 }
 
 // Encrypt encrypts plaintext with optional associated data.
 func (s *Session) Encrypt(plaintext, ad []byte) (ciphertext []byte, err error) {
+	defer s.lockTx()()
+	_, ciphertext, err = s.seal(plaintext, ad, 0)
+	return ciphertext, err
+}
+
+// seal encrypts one message at the next send counter and returns the header it
+// was bound to alongside the ciphertext.
+func (s *Session) seal(plaintext, ad []byte, flags uint16) (header, ciphertext []byte, err error) {
 	if !s.established {
-		return nil, ErrDecrypt
+		return nil, nil, ErrDecrypt
+	}
+	s.maybeRekey(len(plaintext))
+	if s.ratchet == nil {
+		return s.sealCurrent(plaintext, ad, flags)
 	}
+	prefix, err := s.ratchetPrefix()
+	if err != nil {
+		return nil, nil, err
+	}
+	header, ciphertext, err = s.sealCurrent(append(append([]byte(nil), prefix...), plaintext...), ad, flags)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.ratchetSent(prefix)
+	return header, ciphertext, nil
+}
 
-	s.maybeRekey()
+// sealCurrent is seal without the rekey policy check.
+func (s *Session) sealCurrent(plaintext, ad []byte, flags uint16) (header, ciphertext []byte, err error) {
+	if err := s.checkSend(&s.tx, len(plaintext)); err != nil {
+		return nil, nil, err
+	}
+	if err := s.reserveCounters(); err != nil {
+		return nil, nil, err
+	}
+	header = s.buildHeader(s.tx.counter, s.tx.epoch, flags)
+	ciphertext, err = s.sealWith(s.tx.keys, header, s.tx.counter, plaintext, ad)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.noteSent(&s.tx, len(plaintext))
+	return header, ciphertext, nil
+}
 
+// sealWith encrypts plaintext under keys at counter, bound to header and ad.
+// In SAFE mode the audit tag is prepended.
+func (s *Session) sealWith(keys trafficKeys, header []byte, counter uint64, plaintext, ad []byte) ([]byte, error) {
 	var nonce []byte
 	if s.mode.IsSafe() {
-		nonce = s.deriveNonce(ad)
+		nonce = s.ks.nonce(keys.kNonce, s.sessionID, s.transcriptHash, counter, ad)
 	} else {
-		nonce = make([]byte, NonceSize)
-		binary.BigEndian.PutUint64(nonce[4:], s.counterTx)
+		nonce = s.ks.naiveNonce(counter)
 	}
 
-	aead, err := chacha20poly1305.New(s.kAead)
+	aead, err := s.ks.newAEAD(keys.kAead)
 	if err != nil {
 		return nil, err
 	}
-
-	header := s.buildHeader(s.counterTx, 0)
-	additionalData := append(header, ad...)
+	additionalData := append(append([]byte(nil), header...), ad...)
 	ct := aead.Seal(nil, nonce, plaintext, additionalData)
-
-	if s.mode.IsSafe() {
-		auditInput := common.TranscriptHash(s.transcriptHash, header, uint64ToBytes(s.counterTx))
-		auditTag := common.HMAC256Truncate(s.kAudit, auditInput, 16)
-		ciphertext = make([]byte, 16+len(ct))
-		copy(ciphertext, auditTag)
-		copy(ciphertext[16:], ct)
-	} else {
-		ciphertext = ct
+	if !s.mode.IsSafe() {
+		return ct, nil
 	}
-
-	s.counterTx++
+	auditTag := s.ks.auditTag(keys.kAudit, s.transcriptHash, header, counter)
+	ciphertext := make([]byte, 16+len(ct))
+	copy(ciphertext, auditTag)
+	copy(ciphertext[16:], ct)
 	return ciphertext, nil
 }
 
-// EncryptNaiveWithNonce allows caller-supplied nonce in NAIVE mode only.
+// EncryptNaiveWithNonce allows caller-supplied nonce in NAIVE mode only. The
+// nonce must be Session.NonceSize bytes.
 func (s *Session) EncryptNaiveWithNonce(plaintext, ad, callerNonce []byte) (ciphertext []byte, err error) {
+	defer s.lockTx()()
 	if !s.established || s.mode.IsSafe() {
 		return nil, ErrDecrypt
 	}
-	if len(callerNonce) != NonceSize {
+	if len(callerNonce) != s.NonceSize() {
 		return nil, ErrDecrypt
 	}
+	if err := s.checkSend(&s.tx, len(plaintext)); err != nil {
+		return nil, err
+	}
+	if err := s.reserveCounters(); err != nil {
+		return nil, err
+	}
 
-	aead, err := chacha20poly1305.New(s.kAead)
+	aead, err := s.ks.newAEAD(s.tx.keys.kAead)
 	if err != nil {
 		return nil, err
 	}
-	header := s.buildHeader(s.counterTx, 0)
+	header := s.buildHeader(s.tx.counter, s.tx.epoch, 0)
 	additionalData := append(header, ad...)
 	ciphertext = aead.Seal(nil, callerNonce, plaintext, additionalData)
-	s.counterTx++
+	s.noteSent(&s.tx, len(plaintext))
 	return ciphertext, nil
 }
 
-// Decrypt decrypts ciphertext. ad must contain the full header (44 bytes) then optional user AD.
+// Decrypt decrypts ciphertext. ad must contain the full header (HeaderSize bytes
+// in v1, HeaderSizeV2 in v2) then optional user AD.
+// A key-update frame (see RequestKeyUpdate) decrypts to an empty plaintext. In
+// hybrid ratchet mode the inner ratchet header is consumed and stripped.
 func (s *Session) Decrypt(ciphertext, ad []byte) (plaintext []byte, err error) {
-	if !s.established {
-		return nil, ErrDecrypt
-	}
+	defer s.lockRx()()
+	plaintext, _, err = s.decrypt(ciphertext, ad, 0)
+	return plaintext, err
+}
 
-	aead, err := chacha20poly1305.New(s.kAead)
-	if err != nil {
-		return nil, err
+// decrypt is Decrypt for callers holding the receive lock. allow lists the
+// reserved flags the caller handles; decrypt returns the frame's flags.
+func (s *Session) decrypt(ciphertext, ad []byte, allow uint16) (plaintext []byte, flags uint16, err error) {
+	if !s.established || s.exhausted() {
+		return nil, 0, ErrDecrypt
 	}
 
-	if len(ciphertext) < aead.Overhead() {
-		return nil, ErrDecrypt
+	if len(ciphertext) < tagSize {
+		return nil, 0, ErrDecrypt
 	}
-	if len(ad) < HeaderSize {
-		return nil, ErrDecrypt
+	w := s.wire()
+	if len(ad) < w.headerSize || ad[0] != w.version {
+		return nil, 0, ErrDecrypt
+	}
+	header := ad[:w.headerSize]
+	counter, flags, epoch := w.parseHeader(header, &s.rx)
+	actualAD := ad[w.headerSize:]
+	if flags&w.reservedMask&^allow != 0 {
+		return nil, 0, ErrDecrypt
 	}
-	header := ad[:HeaderSize]
-	counter := binary.BigEndian.Uint64(header[34:42])
-	actualAD := ad[HeaderSize:]
 
 	if s.mode.IsSafe() {
-		// Strict monotonic: only accept counter == expectedRx, then increment by 1.
-		if counter != s.counterRx {
-			return nil, ErrDecrypt
+		// Strict monotonic: only accept counter == expectedRx. With a replay
+		// window, accept any unseen counter inside the window instead.
+		if s.rx.window != nil {
+			if !s.rx.window.check(counter) {
+				return nil, 0, ErrDecrypt
+			}
+		} else if counter != s.rx.counter {
+			return nil, 0, ErrDecrypt
+		}
+	}
+	keys, chain, ok := s.rxKeysFor(epoch, counter)
+	if !ok {
+		return nil, 0, ErrDecrypt
+	}
+	plaintext, err = s.openWith(keys, header, counter, ciphertext, actualAD)
+	if err != nil {
+		return nil, 0, err
+	}
+	if flags&FlagKeyUpdate != 0 {
+		if len(plaintext) != 8 || binary.BigEndian.Uint64(plaintext) != epoch+1 {
+			return nil, 0, ErrDecrypt
 		}
-		auditInput := common.TranscriptHash(s.transcriptHash, header, uint64ToBytes(counter))
-		expectedAudit := common.HMAC256Truncate(s.kAudit, auditInput, 16)
+		s.advanceRx(epoch, counter, chain)
+		s.acceptKeyUpdate(epoch, counter)
+		return []byte{}, flags, nil
+	}
+	s.advanceRx(epoch, counter, chain)
+	if s.ratchet != nil {
+		plaintext, err = s.ratchetReceived(epoch, counter, plaintext)
+		return plaintext, flags, err
+	}
+	return plaintext, flags, nil
+}
+
+// openWith authenticates and decrypts ciphertext sealed by sealWith. A frame
+// that fails authentication counts as a forgery.
+func (s *Session) openWith(keys trafficKeys, header []byte, counter uint64, ciphertext, ad []byte) ([]byte, error) {
+	aead, err := s.ks.newAEAD(keys.kAead)
+	if err != nil {
+		return nil, err
+	}
+	additionalData := append(append([]byte(nil), header...), ad...)
+	var plaintext []byte
+	if s.mode.IsSafe() {
 		if len(ciphertext) < 16+aead.Overhead() {
 			return nil, ErrDecrypt
 		}
-		gotAudit := ciphertext[:16]
-		ct := ciphertext[16:]
-		if !common.EqualConstantTime(gotAudit, expectedAudit) {
+		expectedAudit := s.ks.auditTag(keys.kAudit, s.transcriptHash, header, counter)
+		if !common.EqualConstantTime(ciphertext[:16], expectedAudit) {
+			s.noteForgery()
 			return nil, ErrDecrypt
 		}
-		nonce := s.deriveNonceForCounter(counter, actualAD)
-		additionalData := append(header, actualAD...)
-		plaintext, err = aead.Open(nil, nonce, ct, additionalData)
+		nonce := s.ks.nonce(keys.kNonce, s.sessionID, s.transcriptHash, counter, ad)
+		plaintext, err = aead.Open(nil, nonce, ciphertext[16:], additionalData)
 	} else {
-		nonce := make([]byte, NonceSize)
-		binary.BigEndian.PutUint64(nonce[4:], counter)
-		additionalData := append(header, actualAD...)
-		plaintext, err = aead.Open(nil, nonce, ciphertext, additionalData)
+		plaintext, err = aead.Open(nil, s.ks.naiveNonce(counter), ciphertext, additionalData)
 	}
 	if err != nil {
+		s.noteForgery()
 		return nil, ErrDecrypt
 	}
-	s.counterRx++
-	s.maybeRekeyRx(s.counterRx)
 	return plaintext, nil
 }
 
 // DecryptFromFrame parses a framed message and decrypts.
 func (s *Session) DecryptFromFrame(frame []byte) (plaintext []byte, err error) {
-	if len(frame) < FrameOverhead {
+	defer s.lockRx()()
+	hs := s.wire().headerSize
+	if len(frame) < hs+4 {
 		return nil, ErrDecrypt
 	}
-	header := frame[:HeaderSize]
-	payloadLen := binary.BigEndian.Uint32(frame[44:48])
-	if uint64(len(frame)) < 48+uint64(payloadLen) {
+	header := frame[:hs]
+	payloadLen := binary.BigEndian.Uint32(frame[hs : hs+4])
+	if uint64(len(frame)) < uint64(hs+4)+uint64(payloadLen) {
 		return nil, ErrDecrypt
 	}
-	payload := frame[48 : 48+payloadLen]
-	return s.Decrypt(payload, header)
+	payload := frame[hs+4 : hs+4+int(payloadLen)]
+	plaintext, _, err = s.decrypt(payload, header, 0)
+	return plaintext, err
 }
 
 // EncryptToFrame encrypts and returns a full frame.
 func (s *Session) EncryptToFrame(plaintext, ad []byte) (frame []byte, err error) {
-	ct, err := s.Encrypt(plaintext, ad)
+	defer s.lockTx()()
+	header, ct, err := s.seal(plaintext, ad, 0)
 	if err != nil {
 		return nil, err
 	}
-	header := s.buildHeader(s.counterTx-1, 0)
-	frame = make([]byte, 48+len(ct))
-	copy(frame, header)
-	binary.BigEndian.PutUint32(frame[44:48], uint32(len(ct)))
-	copy(frame[48:], ct)
-	return frame, nil
-}
-
-func (s *Session) buildHeader(counter uint64, flags uint16) []byte {
-	b := make([]byte, HeaderSize)
-	b[0] = Version
-	b[1] = byte(s.mode)
-	copy(b[2:34], s.sessionID)
-	binary.BigEndian.PutUint64(b[34:42], counter)
-	binary.BigEndian.PutUint16(b[42:44], flags)
-	return b
+	return buildFrame(header, ct), nil
 }
 
-func (s *Session) deriveNonce(ad []byte) []byte {
-	return s.deriveNonceForCounter(s.counterTx, ad)
-}
-
-func (s *Session) deriveNonceForCounter(counter uint64, ad []byte) []byte {
-	adHash := common.HashSHA256(ad)
-	counterBytes := uint64ToBytes(counter)
-	input := common.TranscriptHash(s.sessionID, s.transcriptHash, counterBytes, adHash)
-	return common.HMAC256Truncate(s.kNonce, input, NonceSize)
-}
-
-func (s *Session) rekeyInterval() uint64 {
-	if rekeyEveryForTest > 0 {
-		return rekeyEveryForTest
-	}
-	return RekeyEvery
-}
-
-func (s *Session) maybeRekey() {
-	n := s.rekeyInterval()
-	if n == 0 {
-		return
-	}
-	if s.counterTx > 0 && s.counterTx%n == 0 {
-		s.ratchetForward(s.counterTx)
-	}
-}
-
-func (s *Session) maybeRekeyRx(counterRx uint64) {
-	n := s.rekeyInterval()
-	if n == 0 {
-		return
-	}
-	// Rekey after receiving last message of a block; both sides use same boundary.
-	if counterRx > 0 && counterRx%n == 0 {
-		s.ratchetForward(counterRx)
-	}
+// buildFrame appends payload_len(4) and the ciphertext to header.
+func buildFrame(header, ct []byte) []byte {
+	hs := len(header)
+	frame := make([]byte, hs+4+len(ct))
+	copy(frame, header)
+	binary.BigEndian.PutUint32(frame[hs:hs+4], uint32(len(ct)))
+	copy(frame[hs+4:], ct)
+	return frame
 }
 
-func (s *Session) ratchetForward(counter uint64) {
-	ctrBytes := make([]byte, 8)
-	binary.BigEndian.PutUint64(ctrBytes, counter)
-	info := common.LabelRekeyRatchet + string(ctrBytes)
-	s.kMs = common.Expand(s.kRekey, info, 32)
-	s.deriveKeys(s.kMs)
+// buildHeader writes the frame header. The epoch rides in the header (v1: its
+// low byte in the high byte of flags) so a receiver can pick the right keys for
+// reordered frames.
+func (s *Session) buildHeader(counter, epoch uint64, flags uint16) []byte {
+	return s.wire().buildHeader(byte(s.mode), s.sessionID, counter, epoch, flags)
 }
 
 func uint64ToBytes(v uint64) []byte {
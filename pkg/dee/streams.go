@@ -0,0 +1,440 @@
+package dee
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+
+	"deadend-lab/pkg/common"
+)
+
+// Stream limits.
+const (
+	// StreamWindow is the flow-control window of each direction of a stream:
+	// the data bytes a sender may have outstanding before the receiver grants
+	// more with WindowUpdate.
+	StreamWindow = 256 << 10
+
+	// MaxStreams bounds the streams each side may have open at once.
+	MaxStreams = 256
+)
+
+var (
+	// ErrStreamBlocked is returned when data does not fit the stream's send
+	// window. Nothing is sent; retry after the peer's next window update.
+	ErrStreamBlocked = errors.New("stream window exhausted")
+
+	// ErrStreamClosed is returned for sends on a stream this side has closed
+	// and for frames of a stream both sides have closed. The peer may send a
+	// window update before it sees our close that arrives only after; such
+	// frames can be ignored.
+	ErrStreamClosed = errors.New("stream closed")
+)
+
+// Stream frame types: the first plaintext byte of a stream frame.
+const (
+	streamFrameData   = 0x00
+	streamFrameWindow = 0x01 // increment(8)
+	streamFrameClose  = 0x02
+)
+
+// Stream is one logical channel multiplexed over a session. Each stream has
+// its own keys, counters and epochs in each direction, derived from the
+// session's master secret and the stream ID, so its frames are ordered
+// independently of the session's other frames and of other streams. Frames
+// of one stream must be opened in the order they were sealed.
+//
+// Opening a stream sends nothing; the peer learns of it from its first
+// frame. Close ends this side's data; the stream is gone once both sides
+// have closed, and its ID is never used again in the session.
+type Stream struct {
+	s  *Session
+	id uint32
+
+	// Guarded by Session.streamMu.
+	tx, rx       direction
+	sent, credit uint64 // data bytes sent and the total the peer allows
+	recv, grant  uint64 // data bytes received and the total allowed to the peer
+
+	localClosed, remoteClosed bool
+}
+
+// streamTable holds a session's streams. It is created with the first one.
+type streamTable struct {
+	root      []byte // keys every stream of the session
+	open      map[uint32]*Stream
+	nextLocal uint64 // next ID this side opens
+	nextPeer  uint64 // lowest peer ID not yet opened
+	local     int    // streams open from each side
+	peer      int
+}
+
+// OpenStream opens a new stream. The initiator's streams have odd IDs and the
+// responder's even ones, so both sides may open streams at once. It fails
+// with ErrConfig in hybrid ratchet mode, whose healing the stream keys would
+// not follow, and with ErrLimit once MaxStreams of this side's streams are
+// open or the IDs run out.
+func (s *Session) OpenStream() (*Stream, error) {
+	defer s.lockTx()()
+	s.streamMu.Lock()
+	defer s.streamMu.Unlock()
+	t, err := s.streamTable()
+	if err != nil {
+		return nil, err
+	}
+	if t.local >= MaxStreams || t.nextLocal > math.MaxUint32 {
+		return nil, ErrLimit
+	}
+	st := s.newStream(uint32(t.nextLocal))
+	t.add(st)
+	t.nextLocal += 2
+	t.local++
+	return st, nil
+}
+
+// ID returns the stream ID.
+func (st *Stream) ID() uint32 {
+	return st.id
+}
+
+// EncryptToFrame seals data as the stream's next frame. If data does not fit
+// the send window nothing is sent and it fails with ErrStreamBlocked.
+func (st *Stream) EncryptToFrame(data []byte) (frame []byte, err error) {
+	s := st.s
+	defer s.lockTx()()
+	s.streamMu.Lock()
+	defer s.streamMu.Unlock()
+	if err := st.usable(); err != nil {
+		return nil, err
+	}
+	if st.localClosed {
+		return nil, ErrStreamClosed
+	}
+	if uint64(len(data)) > st.credit-st.sent {
+		return nil, ErrStreamBlocked
+	}
+	frame, err = st.seal(streamFrameData, data)
+	if err != nil {
+		return nil, err
+	}
+	st.sent += uint64(len(data))
+	return frame, nil
+}
+
+// SendWindow returns how many more data bytes the peer currently allows.
+func (st *Stream) SendWindow() uint64 {
+	st.s.streamMu.Lock()
+	defer st.s.streamMu.Unlock()
+	return st.credit - st.sent
+}
+
+// WindowUpdate returns a frame that grants the peer a full StreamWindow past
+// the data received so far; call it once that data has been processed. It
+// returns no frame if there is nothing to grant or the peer has closed.
+func (st *Stream) WindowUpdate() (frame []byte, err error) {
+	s := st.s
+	defer s.lockTx()()
+	s.streamMu.Lock()
+	defer s.streamMu.Unlock()
+	if err := st.usable(); err != nil {
+		return nil, err
+	}
+	grant := st.recv + StreamWindow
+	if st.remoteClosed || grant <= st.grant {
+		return nil, nil
+	}
+	frame, err = st.seal(streamFrameWindow, uint64ToBytes(grant-st.grant))
+	if err != nil {
+		return nil, err
+	}
+	st.grant = grant
+	return frame, nil
+}
+
+// Close returns a frame that ends this side's data. This side may still
+// receive, and grant window, until the peer closes too.
+func (st *Stream) Close() (frame []byte, err error) {
+	s := st.s
+	defer s.lockTx()()
+	s.streamMu.Lock()
+	defer s.streamMu.Unlock()
+	if err := st.usable(); err != nil {
+		return nil, err
+	}
+	if st.localClosed {
+		return nil, ErrStreamClosed
+	}
+	frame, err = st.seal(streamFrameClose, nil)
+	if err != nil {
+		return nil, err
+	}
+	st.localClosed = true
+	s.streams.closed(st)
+	return frame, nil
+}
+
+// DecryptStream opens a stream frame and returns the stream it belongs to
+// with its data. The first frame of a peer stream opens it, along with any
+// lower peer IDs not yet seen, whose frames may still be on their way. A
+// window update returns empty data; the peer's close returns io.EOF. Frames
+// that fail authentication, arrive out of order within their stream or break
+// the flow-control window fail with ErrDecrypt and change nothing.
+func (s *Session) DecryptStream(frame []byte) (st *Stream, data []byte, err error) {
+	defer s.lockRx()()
+	s.streamMu.Lock()
+	defer s.streamMu.Unlock()
+	if s.exhausted() {
+		return nil, nil, ErrDecrypt
+	}
+	t, err := s.streamTable()
+	if err != nil {
+		return nil, nil, ErrDecrypt
+	}
+	w := s.wire()
+	hs := w.headerSize
+	if len(frame) < hs+4 || frame[0] != w.version {
+		return nil, nil, ErrDecrypt
+	}
+	header := frame[:hs]
+	payloadLen := binary.BigEndian.Uint32(frame[hs : hs+4])
+	if uint64(len(frame)) < uint64(hs+4)+uint64(payloadLen) || payloadLen < 4+tagSize {
+		return nil, nil, ErrDecrypt
+	}
+	payload := frame[hs+4 : hs+4+int(payloadLen)]
+	st, fresh, err := s.streamFor(binary.BigEndian.Uint32(payload))
+	if err != nil {
+		return nil, nil, err
+	}
+	if fresh {
+		defer func(c *Stream) {
+			if err != nil {
+				c.tx.wipe()
+				c.rx.wipe()
+			}
+		}(st)
+	}
+
+	counter, flags, epoch := w.parseHeader(header, &st.rx)
+	if flags&w.reservedMask != FlagStream || flags&FlagKeyUpdate != 0 || counter != st.rx.counter {
+		return nil, nil, ErrDecrypt
+	}
+	keys, chain, ok := st.rxKeysFor(s.ks, epoch)
+	if !ok {
+		return nil, nil, ErrDecrypt
+	}
+	plaintext, err := s.openWith(keys, header, counter, payload[4:], payload[:4])
+	if err != nil {
+		return nil, nil, err
+	}
+	if !st.accepts(plaintext) {
+		return nil, nil, ErrDecrypt
+	}
+
+	if fresh {
+		t.addPeer(s, st)
+	}
+	st.advanceRx(epoch, counter, chain)
+	switch plaintext[0] {
+	case streamFrameWindow:
+		st.credit += binary.BigEndian.Uint64(plaintext[1:])
+		return st, []byte{}, nil
+	case streamFrameClose:
+		st.remoteClosed = true
+		t.closed(st)
+		return st, nil, io.EOF
+	}
+	data = plaintext[1:]
+	st.recv += uint64(len(data))
+	return st, data, nil
+}
+
+// streamTable returns the session's streams, creating the table if needed.
+func (s *Session) streamTable() (*streamTable, error) {
+	if !s.established {
+		return nil, ErrDecrypt
+	}
+	if s.ratchet != nil {
+		return nil, ErrConfig
+	}
+	if s.streams == nil {
+		t := &streamTable{
+			root:      s.cfg.mem.keep(s.ks.expand(s.kMs, common.LabelStream, 32)),
+			open:      map[uint32]*Stream{},
+			nextLocal: 1,
+			nextPeer:  2,
+		}
+		if !s.isInitiator {
+			t.nextLocal, t.nextPeer = 2, 1
+		}
+		s.streams = t
+	}
+	return s.streams, nil
+}
+
+// newStream derives the keys of stream id. They are not yet kept in locked
+// memory; streamTable.add does that.
+func (s *Session) newStream(id uint32) *Stream {
+	i2r := s.streamKeys(id, common.LabelTrafficI2R)
+	r2i := s.streamKeys(id, common.LabelTrafficR2I)
+	st := &Stream{s: s, id: id, credit: StreamWindow, grant: StreamWindow}
+	if s.isInitiator {
+		st.tx.keys, st.rx.keys = i2r, r2i
+	} else {
+		st.tx.keys, st.rx.keys = r2i, i2r
+	}
+	st.tx.mem, st.rx.mem = s.cfg.mem, s.cfg.mem
+	st.tx.started = timeNow()
+	return st
+}
+
+// streamKeys expands the stream root with the direction's traffic label and
+// the stream ID, so every stream and direction has its own keys and nonces.
+func (s *Session) streamKeys(id uint32, label string) trafficKeys {
+	info := s.ks.label(label) + string(binary.BigEndian.AppendUint32(nil, id))
+	return s.ks.trafficKeys(common.ExpandWith(s.ks.params().hash, s.streams.root, info, 32))
+}
+
+// streamFor returns the stream a frame with id belongs to. A peer ID above
+// those seen so far yields a new stream that is added only once its frame
+// authenticates.
+func (s *Session) streamFor(id uint32) (st *Stream, fresh bool, err error) {
+	t := s.streams
+	if st := t.open[id]; st != nil {
+		return st, false, nil
+	}
+	local := uint64(id)%2 == t.nextLocal%2
+	switch {
+	case id == 0, local && uint64(id) >= t.nextLocal:
+		return nil, false, ErrDecrypt
+	case local || uint64(id) < t.nextPeer:
+		return nil, false, ErrStreamClosed
+	case t.peer+int((uint64(id)-t.nextPeer)/2) >= MaxStreams:
+		return nil, false, ErrDecrypt
+	}
+	return s.newStream(id), true, nil
+}
+
+// usable returns an error if the session or the stream is gone.
+func (st *Stream) usable() error {
+	s := st.s
+	if !s.established {
+		return ErrDecrypt
+	}
+	if s.streams == nil || s.streams.open[st.id] != st {
+		return ErrStreamClosed
+	}
+	return nil
+}
+
+// seal encrypts a frame of type typ on the stream. The stream ID goes in
+// front of the ciphertext and is bound in as associated data.
+func (st *Stream) seal(typ byte, body []byte) ([]byte, error) {
+	s := st.s
+	plaintext := append([]byte{typ}, body...)
+	if s.rekeyDue(&st.tx, len(plaintext)) {
+		st.tx.ratchetForward(s.ks, st.tx.counter)
+	}
+	if err := s.checkSend(&st.tx, len(plaintext)); err != nil {
+		return nil, err
+	}
+	header := s.buildHeader(st.tx.counter, st.tx.epoch, FlagStream)
+	id := binary.BigEndian.AppendUint32(nil, st.id)
+	ct, err := s.sealWith(st.tx.keys, header, st.tx.counter, plaintext, id)
+	if err != nil {
+		return nil, err
+	}
+	s.noteSent(&st.tx, len(plaintext))
+	return buildFrame(header, append(id, ct...)), nil
+}
+
+// accepts reports whether an authenticated plaintext is valid at this point
+// of the stream. After its close the peer may only grant window.
+func (st *Stream) accepts(plaintext []byte) bool {
+	if len(plaintext) == 0 {
+		return false
+	}
+	switch plaintext[0] {
+	case streamFrameData:
+		return !st.remoteClosed && uint64(len(plaintext)-1) <= st.grant-st.recv
+	case streamFrameWindow:
+		return len(plaintext) == 9 && binary.BigEndian.Uint64(plaintext[1:]) <= math.MaxUint64-st.credit
+	case streamFrameClose:
+		return len(plaintext) == 1 && !st.remoteClosed
+	}
+	return false
+}
+
+// rxKeysFor returns the stream's receive keys for epoch. Frames arrive in
+// order, so only later epochs need deriving; they are returned in chain and
+// installed by advanceRx once the frame has authenticated.
+func (st *Stream) rxKeysFor(ks keySchedule, epoch uint64) (trafficKeys, []trafficKeys, bool) {
+	if epoch == st.rx.epoch {
+		return st.rx.keys, nil, true
+	}
+	if epoch < st.rx.epoch || epoch-st.rx.epoch > maxEpochSkip {
+		return trafficKeys{}, nil, false
+	}
+	keys := st.rx.keys
+	var chain []trafficKeys
+	for e := st.rx.epoch + 1; e <= epoch; e++ {
+		keys = ks.ratchetKeys(keys, e)
+		chain = append(chain, keys)
+	}
+	return keys, chain, true
+}
+
+func (st *Stream) advanceRx(epoch, counter uint64, chain []trafficKeys) {
+	if len(chain) > 0 {
+		st.rx.mem.freeKeys(st.rx.keys)
+		for _, k := range chain[:len(chain)-1] {
+			st.rx.mem.freeKeys(k)
+		}
+		st.rx.keys = st.rx.mem.keepKeys(chain[len(chain)-1])
+		st.rx.epoch, st.rx.start = epoch, counter
+	}
+	st.rx.counter = counter + 1
+}
+
+// add puts st in the table, moving its keys to locked memory.
+func (t *streamTable) add(st *Stream) {
+	st.tx.keys = st.tx.mem.keepKeys(st.tx.keys)
+	st.rx.keys = st.rx.mem.keepKeys(st.rx.keys)
+	t.open[st.id] = st
+}
+
+// addPeer adds a peer stream whose first frame authenticated, and the lower
+// peer IDs it implicitly opens.
+func (t *streamTable) addPeer(s *Session, st *Stream) {
+	for id := t.nextPeer; id < uint64(st.id); id += 2 {
+		t.add(s.newStream(uint32(id)))
+		t.peer++
+	}
+	t.add(st)
+	t.peer++
+	t.nextPeer = uint64(st.id) + 2
+}
+
+// closed drops st once both sides have closed it.
+func (t *streamTable) closed(st *Stream) {
+	if !st.localClosed || !st.remoteClosed {
+		return
+	}
+	st.tx.wipe()
+	st.rx.wipe()
+	delete(t.open, st.id)
+	if uint64(st.id)%2 == t.nextLocal%2 {
+		t.local--
+	} else {
+		t.peer--
+	}
+}
+
+// wipe frees the keys of every open stream and the stream root.
+func (t *streamTable) wipe(mem *lockedMem) {
+	for _, st := range t.open {
+		st.tx.wipe()
+		st.rx.wipe()
+	}
+	mem.free(t.root)
+}
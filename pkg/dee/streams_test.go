@@ -0,0 +1,357 @@
+package dee
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+)
+
+func openStream(t *testing.T, s *Session) *Stream {
+	t.Helper()
+	st, err := s.OpenStream()
+	if err != nil {
+		t.Fatalf("OpenStream: %v", err)
+	}
+	return st
+}
+
+func streamFrame(t *testing.T, st *Stream, data string) []byte {
+	t.Helper()
+	f, err := st.EncryptToFrame([]byte(data))
+	if err != nil {
+		t.Fatalf("stream %d: %v", st.ID(), err)
+	}
+	return f
+}
+
+// receive opens f on s and checks it is data on stream id.
+func receive(t *testing.T, s *Session, f []byte, id uint32, want string) *Stream {
+	t.Helper()
+	st, data, err := s.DecryptStream(f)
+	if err != nil || st.ID() != id || string(data) != want {
+		t.Fatalf("DecryptStream: stream %v, %q, %v; want stream %d, %q", st, data, err, id, want)
+	}
+	return st
+}
+
+// Frames of different streams and plain session frames interleave in any
+// order; each stream only needs its own frames in order.
+func TestStreamsRoundTrip(t *testing.T) {
+	v2 := []Option{WithVersions(Version2)}
+	for _, tc := range []struct {
+		name string
+		mode Mode
+		opts []Option
+	}{
+		{"safe", Safe, nil},
+		{"naive", Naive, nil},
+		{"v2", Safe, v2},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			initSession, respSession := sessionPair(t, tc.mode, tc.opts, tc.opts)
+			control, bulk := openStream(t, initSession), openStream(t, initSession)
+			telemetry := openStream(t, respSession)
+			if control.ID() != 1 || bulk.ID() != 3 || telemetry.ID() != 2 {
+				t.Fatalf("stream IDs %d, %d, %d", control.ID(), bulk.ID(), telemetry.ID())
+			}
+
+			var controlFrames, bulkFrames [][]byte
+			for i := 0; i < 3; i++ {
+				controlFrames = append(controlFrames, streamFrame(t, control, fmt.Sprint("control ", i)))
+				bulkFrames = append(bulkFrames, streamFrame(t, bulk, fmt.Sprint("bulk ", i)))
+			}
+			sendFrames(t, initSession, respSession, 2)
+			for i, f := range bulkFrames {
+				receive(t, respSession, f, 3, fmt.Sprint("bulk ", i))
+			}
+			peerControl := receive(t, respSession, controlFrames[0], 1, "control 0")
+			receive(t, initSession, streamFrame(t, telemetry, "up"), 2, "up")
+			for i, f := range controlFrames[1:] {
+				receive(t, respSession, f, 1, fmt.Sprint("control ", i+1))
+			}
+			receive(t, initSession, streamFrame(t, telemetry, "up again"), 2, "up again")
+			receive(t, initSession, streamFrame(t, peerControl, "ack"), 1, "ack")
+			sendFrames(t, respSession, initSession, 1)
+		})
+	}
+}
+
+func TestStreamOrdering(t *testing.T) {
+	initSession, respSession := sessionPair(t, Safe, nil, nil)
+	first, second := openStream(t, initSession), openStream(t, initSession)
+	a0, a1 := streamFrame(t, first, "a0"), streamFrame(t, first, "a1")
+	b0 := streamFrame(t, second, "b0")
+
+	// Within a stream, order is strict and a rejected frame changes nothing.
+	if _, _, err := respSession.DecryptStream(a1); err != ErrDecrypt {
+		t.Fatalf("frame ahead of its stream: got %v, want ErrDecrypt", err)
+	}
+	// The first frame of stream 3 opens stream 1 as well.
+	receive(t, respSession, b0, 3, "b0")
+	receive(t, respSession, a0, 1, "a0")
+	if _, _, err := respSession.DecryptStream(a0); err != ErrDecrypt {
+		t.Fatalf("replayed frame: got %v, want ErrDecrypt", err)
+	}
+
+	// A frame moved to another stream does not authenticate.
+	moved := append([]byte(nil), a1...)
+	moved[initSession.HeaderSize()+4+3] = 3
+	if _, _, err := respSession.DecryptStream(moved); err != ErrDecrypt {
+		t.Fatalf("frame moved to stream 3: got %v, want ErrDecrypt", err)
+	}
+	receive(t, respSession, a1, 1, "a1")
+
+	// Stream frames and session frames do not mix.
+	if _, err := respSession.DecryptFromFrame(streamFrame(t, first, "a2")); err != ErrDecrypt {
+		t.Fatalf("stream frame as a session frame: got %v, want ErrDecrypt", err)
+	}
+	session, err := initSession.EncryptToFrame([]byte("data"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := respSession.DecryptStream(session); err != ErrDecrypt {
+		t.Fatalf("session frame as a stream frame: got %v, want ErrDecrypt", err)
+	}
+
+	// A peer cannot open streams of our parity, or far beyond MaxStreams.
+	for _, id := range []uint32{0, 2, 2*MaxStreams + 5} {
+		forged := append([]byte(nil), b0...)
+		copy(forged[initSession.HeaderSize()+4:], uint64ToBytes(uint64(id))[4:])
+		if _, _, err := respSession.DecryptStream(forged); err != ErrDecrypt {
+			t.Fatalf("stream %d: got %v, want ErrDecrypt", id, err)
+		}
+	}
+}
+
+// Every stream and direction has its own keys, so equal counters and
+// plaintexts never share a nonce, even in NAIVE mode.
+func TestStreamNonceSeparation(t *testing.T) {
+	for _, mode := range []Mode{Safe, Naive} {
+		initSession, respSession := sessionPair(t, mode, nil, nil)
+		hs := initSession.HeaderSize()
+		session, err := initSession.EncryptToFrame([]byte{streamFrameData, 'x'}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen := map[string]bool{string(session[hs+4:]): true}
+		for _, s := range []*Session{initSession, respSession, initSession, respSession} {
+			st := openStream(t, s)
+			ct := string(streamFrame(t, st, "x")[hs+8:])
+			if seen[ct] {
+				t.Fatalf("%v: stream %d repeats a ciphertext", mode, st.ID())
+			}
+			seen[ct] = true
+		}
+	}
+}
+
+func TestStreamFlowControl(t *testing.T) {
+	initSession, respSession := sessionPair(t, Safe, nil, nil)
+	st := openStream(t, initSession)
+	chunk := make([]byte, StreamWindow/4)
+	var peer *Stream
+	for i := 0; i < 4; i++ {
+		f, err := st.EncryptToFrame(chunk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if peer, _, err = respSession.DecryptStream(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := st.EncryptToFrame([]byte("x")); err != ErrStreamBlocked {
+		t.Fatalf("past the window: got %v, want ErrStreamBlocked", err)
+	}
+	if n := st.SendWindow(); n != 0 {
+		t.Fatalf("send window %d, want 0", n)
+	}
+	// Other streams are not blocked.
+	other := openStream(t, initSession)
+	receive(t, respSession, streamFrame(t, other, "x"), 3, "x")
+
+	update, err := peer.WindowUpdate()
+	if err != nil || update == nil {
+		t.Fatalf("WindowUpdate: %v", err)
+	}
+	if again, err := peer.WindowUpdate(); again != nil || err != nil {
+		t.Fatalf("second WindowUpdate: %d bytes, %v; want none", len(again), err)
+	}
+	if _, data, err := initSession.DecryptStream(update); err != nil || len(data) != 0 {
+		t.Fatalf("window update: %q, %v", data, err)
+	}
+	if n := st.SendWindow(); n != StreamWindow {
+		t.Fatalf("send window %d after the update, want %d", n, StreamWindow)
+	}
+
+	// A sender that ignores the window is caught by the receiver.
+	st.credit += StreamWindow
+	f, err := st.EncryptToFrame(make([]byte, StreamWindow+1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := respSession.DecryptStream(f); err != ErrDecrypt {
+		t.Fatalf("frame past the window: got %v, want ErrDecrypt", err)
+	}
+}
+
+func TestStreamClose(t *testing.T) {
+	initSession, respSession := sessionPair(t, Safe, nil, nil)
+	st := openStream(t, initSession)
+	peer := receive(t, respSession, streamFrame(t, st, "request"), 1, "request")
+	fin, err := st.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, data, err := respSession.DecryptStream(fin); err != io.EOF || data != nil {
+		t.Fatalf("close: %q, %v; want io.EOF", data, err)
+	}
+	if _, err := st.EncryptToFrame([]byte("late")); err != ErrStreamClosed {
+		t.Fatalf("send after Close: got %v, want ErrStreamClosed", err)
+	}
+
+	// The half-closed stream still carries the reply and window updates.
+	reply := streamFrame(t, peer, "reply")
+	receive(t, initSession, reply, 1, "reply")
+	update, err := st.WindowUpdate()
+	if err != nil || update == nil {
+		t.Fatalf("WindowUpdate after Close: %v", err)
+	}
+	if _, _, err := respSession.DecryptStream(update); err != nil {
+		t.Fatal(err)
+	}
+
+	// Data after the close is rejected.
+	st.localClosed = false
+	late := streamFrame(t, st, "late")
+	st.localClosed = true
+	if _, _, err := respSession.DecryptStream(late); err != ErrDecrypt {
+		t.Fatalf("data after close: got %v, want ErrDecrypt", err)
+	}
+
+	// Once both sides have closed the stream is gone, its keys are wiped and
+	// its ID is not reused.
+	key := st.rx.keys.kAead
+	peerFin, err := peer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := initSession.DecryptStream(peerFin); err != io.EOF {
+		t.Fatalf("peer close: got %v, want io.EOF", err)
+	}
+	if len(initSession.streams.open) != 0 || len(respSession.streams.open) != 0 {
+		t.Fatal("closed streams kept")
+	}
+	if !isZero(key) {
+		t.Fatal("stream keys not wiped")
+	}
+	if _, err := st.WindowUpdate(); err != ErrStreamClosed {
+		t.Fatalf("WindowUpdate on a closed stream: got %v, want ErrStreamClosed", err)
+	}
+	if _, _, err := initSession.DecryptStream(reply); err != ErrStreamClosed {
+		t.Fatalf("frame of a closed stream: got %v, want ErrStreamClosed", err)
+	}
+	if next := openStream(t, initSession); next.ID() != 3 {
+		t.Fatalf("next stream %d, want 3", next.ID())
+	}
+}
+
+func TestStreamLimits(t *testing.T) {
+	initSession, respSession := sessionPair(t, Safe, nil, nil)
+	for i := 0; i < MaxStreams; i++ {
+		openStream(t, initSession)
+	}
+	if _, err := initSession.OpenStream(); err != ErrLimit {
+		t.Fatalf("stream %d: got %v, want ErrLimit", MaxStreams+1, err)
+	}
+	if _, err := initSession.MarshalBinary(); err != ErrConfig {
+		t.Fatalf("MarshalBinary with streams: got %v, want ErrConfig", err)
+	}
+	last := initSession.streams.open[2*MaxStreams-1]
+	receive(t, respSession, streamFrame(t, last, "x"), 2*MaxStreams-1, "x")
+	if n := len(respSession.streams.open); n != MaxStreams {
+		t.Fatalf("%d peer streams opened, want %d", n, MaxStreams)
+	}
+
+	ratchet := []Option{WithHybridRatchet(2)}
+	initSession, _ = sessionPair(t, Safe, ratchet, ratchet)
+	if _, err := initSession.OpenStream(); err != ErrConfig {
+		t.Fatalf("OpenStream with a hybrid ratchet: got %v, want ErrConfig", err)
+	}
+
+	// Session Close wipes the stream keys.
+	initSession, _ = sessionPair(t, Safe, nil, nil)
+	st := openStream(t, initSession)
+	key := st.tx.keys.kAead
+	initSession.Close()
+	if !isZero(key) {
+		t.Fatal("stream keys survive Close")
+	}
+	if _, err := st.EncryptToFrame([]byte("x")); err != ErrDecrypt {
+		t.Fatalf("stream of a closed session: got %v, want ErrDecrypt", err)
+	}
+}
+
+// Each stream direction rekeys on its own epochs under the session's policy.
+func TestStreamRekey(t *testing.T) {
+	for _, version := range []byte{Version, Version2} {
+		opts := []Option{WithVersions(version), WithRekeyPolicy(RekeyPolicy{Messages: 3})}
+		initSession, respSession := sessionPair(t, Safe, opts, opts)
+		st := openStream(t, initSession)
+		for i := 0; i < 1000; i++ {
+			receive(t, respSession, streamFrame(t, st, "data"), 1, "data")
+		}
+		if st.tx.epoch != 333 || initSession.tx.epoch != 0 {
+			t.Fatalf("v%d: stream epoch %d, session epoch %d", version, st.tx.epoch, initSession.tx.epoch)
+		}
+	}
+}
+
+// Goroutines seal on their own streams while another opens the peer's
+// stream frames. Run with -race.
+func TestConcurrentStreams(t *testing.T) {
+	initSession, respSession := sessionPair(t, Safe, nil, nil)
+	peerStream := openStream(t, respSession)
+	var inbound [][]byte
+	for i := 0; i < 100; i++ {
+		inbound = append(inbound, streamFrame(t, peerStream, "data"))
+	}
+	const writers = 8
+	frames := make([][][]byte, writers)
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		st := openStream(t, initSession)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				f, err := st.EncryptToFrame([]byte(fmt.Sprint(st.ID(), " ", i)))
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				frames[w] = append(frames[w], f)
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for _, f := range inbound {
+			if _, _, err := initSession.DecryptStream(f); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	wg.Wait()
+	if t.Failed() {
+		t.FailNow()
+	}
+	for i := 0; i < 100; i++ {
+		for w := writers - 1; w >= 0; w-- {
+			id := uint32(2*w + 1)
+			receive(t, respSession, frames[w][i], id, fmt.Sprint(id, " ", i))
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package dee
+
+import (
+	"encoding/binary"
+	"os"
+	"sync"
+	"time"
+)
+
+// StrikeRegister remembers the early-data identifiers a server has accepted,
+// so a replayed first flight cannot deliver its early data twice. It is time
+// bounded: an identifier carries the client's issue time, anything issued more
+// than the window away from the server clock is refused outright, and an entry
+// is forgotten once its identifier would be refused anyway.
+//
+// A register also refuses identifiers issued before its horizon: the start of
+// the process for an in-memory register, since an earlier process may have
+// accepted them. A file-backed register loads its entries instead and keeps
+// the horizon at zero unless the file was damaged. It is safe for concurrent
+// use.
+type StrikeRegister struct {
+	mu      sync.Mutex
+	window  time.Duration
+	horizon time.Time
+	seen    map[string]time.Time // identifier -> expiry
+	pruned  time.Time
+	file    *os.File
+}
+
+// A file record is expiry(8) || len(1) || id.
+const strikeRecordHeader = 9
+
+// NewStrikeRegister returns an in-memory register accepting identifiers
+// issued within window of the current time.
+func NewStrikeRegister(window time.Duration) (*StrikeRegister, error) {
+	if window <= 0 {
+		return nil, ErrConfig
+	}
+	now := timeNow()
+	return &StrikeRegister{window: window, horizon: now.Truncate(time.Millisecond), seen: map[string]time.Time{}, pruned: now}, nil
+}
+
+// OpenStrikeRegister returns a register persisted to path, creating the file
+// if needed. Unexpired entries are loaded and the file is compacted. Every
+// accepted identifier is appended and synced before Check returns. A
+// truncated or malformed file moves the horizon to now, like a fresh
+// in-memory register.
+func OpenStrikeRegister(path string, window time.Duration) (*StrikeRegister, error) {
+	r, err := NewStrikeRegister(window)
+	if err != nil {
+		return nil, err
+	}
+	r.horizon = time.Time{}
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	now := timeNow()
+	for len(data) > 0 {
+		if len(data) < strikeRecordHeader || len(data) < strikeRecordHeader+int(data[8]) {
+			r.horizon = now
+			break
+		}
+		expires := time.Unix(int64(binary.BigEndian.Uint64(data)), 0)
+		id := data[strikeRecordHeader : strikeRecordHeader+int(data[8])]
+		if now.Before(expires) {
+			r.seen[string(id)] = expires
+		}
+		data = data[strikeRecordHeader+len(id):]
+	}
+
+	// Compact: write the live entries to a temporary file and rename it over
+	// path, so a crash leaves either the old or the new file.
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	for id, expires := range r.seen {
+		if _, err := f.Write(strikeRecord([]byte(id), expires)); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return nil, err
+	}
+	if r.file, err = os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o600); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func strikeRecord(id []byte, expires time.Time) []byte {
+	b := binary.BigEndian.AppendUint64(nil, uint64(expires.Unix()))
+	b = append(b, byte(len(id)))
+	return append(b, id...)
+}
+
+// Check records id, issued at issued, and reports whether it is fresh: inside
+// the window, not before the horizon and not seen before. It fails closed: an
+// identifier that cannot be persisted is refused.
+func (r *StrikeRegister) Check(id []byte, issued time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := timeNow()
+	if len(id) == 0 || len(id) > 255 || issued.Before(r.horizon) || issued.Before(now.Add(-r.window)) || issued.After(now.Add(r.window)) {
+		return false
+	}
+	if now.Sub(r.pruned) >= r.window {
+		for k, expires := range r.seen {
+			if !now.Before(expires) {
+				delete(r.seen, k)
+			}
+		}
+		r.pruned = now
+	}
+	if _, ok := r.seen[string(id)]; ok {
+		return false
+	}
+	// Round up so the entry outlives the window at one-second resolution.
+	expires := issued.Add(r.window + time.Second)
+	if r.file != nil {
+		if _, err := r.file.Write(strikeRecord(id, expires)); err != nil {
+			return false
+		}
+		if err := r.file.Sync(); err != nil {
+			return false
+		}
+	}
+	r.seen[string(id)] = expires
+	return true
+}
+
+// Close closes the file of a persisted register. It is a no-op for an
+// in-memory one.
+func (r *StrikeRegister) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
@@ -0,0 +1,113 @@
+package dee
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStrikeRegister(t *testing.T) {
+	now := time.Now()
+	SetTimeNowForTest(func() time.Time { return now })
+	defer SetTimeNowForTest(nil)
+
+	r, err := NewStrikeRegister(10 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Check([]byte("before start"), now.Add(-time.Second)) {
+		t.Fatal("accepted an identifier from before the horizon")
+	}
+	now = now.Add(time.Minute)
+	id := []byte("id-1")
+	if !r.Check(id, now) {
+		t.Fatal("fresh identifier refused")
+	}
+	if r.Check(id, now) {
+		t.Fatal("repeated identifier accepted")
+	}
+	if r.Check([]byte("old"), now.Add(-11*time.Second)) || r.Check([]byte("future"), now.Add(11*time.Second)) {
+		t.Fatal("identifier outside the window accepted")
+	}
+
+	// Entries go once their identifiers are out of the window anyway.
+	now = now.Add(12 * time.Second)
+	r.Check([]byte("id-2"), now)
+	if _, ok := r.seen[string(id)]; ok {
+		t.Fatal("expired entry kept")
+	}
+	if r.Check(id, now.Add(-12*time.Second)) {
+		t.Fatal("expired identifier accepted")
+	}
+
+	if _, err := NewStrikeRegister(0); err != ErrConfig {
+		t.Fatalf("zero window: got %v, want ErrConfig", err)
+	}
+}
+
+func TestStrikeRegisterFile(t *testing.T) {
+	now := time.Now()
+	SetTimeNowForTest(func() time.Time { return now })
+	defer SetTimeNowForTest(nil)
+
+	path := filepath.Join(t.TempDir(), "strikes")
+	r, err := OpenStrikeRegister(path, 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.Check([]byte("a"), now) || !r.Check([]byte("b"), now.Add(-9*time.Second)) {
+		t.Fatal("fresh identifiers refused")
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A restarted server still knows both; unlike a fresh in-memory register
+	// it accepts new identifiers issued before it started.
+	now = now.Add(5 * time.Second)
+	r, err = OpenStrikeRegister(path, 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Check([]byte("a"), now.Add(-5*time.Second)) {
+		t.Fatal("identifier accepted again after a restart")
+	}
+	if !r.Check([]byte("c"), now.Add(-time.Second)) {
+		t.Fatal("fresh identifier refused after a restart")
+	}
+	r.Close()
+
+	// Reopening compacts away expired entries.
+	now = now.Add(7 * time.Second)
+	if r, err = OpenStrikeRegister(path, 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+	if _, ok := r.seen["c"]; !ok || len(r.seen) != 1 {
+		t.Fatalf("%d entries after compaction, want only c", len(r.seen))
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := strikeRecordHeader + 1; len(data) != want {
+		t.Fatalf("compacted file is %d bytes, want %d", len(data), want)
+	}
+
+	// A torn last record means lost state: the register falls back to a
+	// horizon of now.
+	if err := os.WriteFile(path, append(data, 0, 0, 0), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if r, err = OpenStrikeRegister(path, 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if r.Check([]byte("d"), now.Add(-time.Second)) {
+		t.Fatal("damaged register accepted an identifier from before it opened")
+	}
+	if r.Check([]byte("c"), now) {
+		t.Fatal("damaged register forgot an intact entry")
+	}
+}
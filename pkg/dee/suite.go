@@ -0,0 +1,186 @@
+package dee
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"hash"
+
+	"deadend-lab/internal/aesgcmsiv"
+	"deadend-lab/pkg/common"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Suite names the AEAD that protects a session's frames together with the hash
+// its key schedule runs on.
+type Suite byte
+
+const (
+	SuiteChaCha20Poly1305  Suite = 0x01 // default; SHA-256
+	SuiteAES256GCM         Suite = 0x02 // SHA-384
+	SuiteXChaCha20Poly1305 Suite = 0x03 // 24-byte nonces; SHA-256
+	SuiteAES256GCMSIV      Suite = 0x04 // nonce-misuse resistant (RFC 8452); SHA-384
+)
+
+// tagSize is the authentication tag size, the same for every suite.
+const tagSize = 16
+
+type suiteParams struct {
+	name      string
+	keySize   int
+	nonceSize int
+	hash      func() hash.Hash
+	newAEAD   func(key []byte) (cipher.AEAD, error)
+}
+
+var suites = map[Suite]*suiteParams{
+	SuiteChaCha20Poly1305: {
+		name: "ChaCha20-Poly1305", keySize: chacha20poly1305.KeySize, nonceSize: chacha20poly1305.NonceSize,
+		hash: sha256.New, newAEAD: chacha20poly1305.New,
+	},
+	SuiteAES256GCM: {
+		name: "AES-256-GCM", keySize: 32, nonceSize: 12,
+		hash: sha512.New384, newAEAD: newAESGCM,
+	},
+	SuiteXChaCha20Poly1305: {
+		name: "XChaCha20-Poly1305", keySize: chacha20poly1305.KeySize, nonceSize: chacha20poly1305.NonceSizeX,
+		hash: sha256.New, newAEAD: chacha20poly1305.NewX,
+	},
+	SuiteAES256GCMSIV: {
+		name: "AES-256-GCM-SIV", keySize: aesgcmsiv.KeySize, nonceSize: aesgcmsiv.NonceSize,
+		hash: sha512.New384, newAEAD: aesgcmsiv.New,
+	},
+}
+
+func newAESGCM(key []byte) (cipher.AEAD, error) {
+	b, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(b)
+}
+
+// Suites lists every supported suite, default first.
+func Suites() []Suite {
+	return []Suite{SuiteChaCha20Poly1305, SuiteAES256GCM, SuiteXChaCha20Poly1305, SuiteAES256GCMSIV}
+}
+
+func (s Suite) String() string {
+	if p, ok := suites[s]; ok {
+		return p.name
+	}
+	return "unknown"
+}
+
+// WithSuites sets the suites this side supports, most preferred first; the
+// default is SuiteChaCha20Poly1305 only. An initiator offers the list in the
+// init message and the responder picks the first entry it supports, echoing
+// its choice in the response. Both lists are bound into the transcript, so a
+// rewritten offer breaks key agreement.
+func WithSuites(list ...Suite) Option {
+	return func(c *config) {
+		c.suites = append([]Suite(nil), list...)
+	}
+}
+
+// Suite returns the session's AEAD suite.
+func (s *Session) Suite() Suite {
+	return s.ks.suite
+}
+
+// NonceSize returns the AEAD nonce size of the session's suite, the length
+// EncryptNaiveWithNonce expects.
+func (s *Session) NonceSize() int {
+	return s.ks.params().nonceSize
+}
+
+func (c config) supportedSuites() []Suite {
+	if len(c.suites) == 0 {
+		return []Suite{SuiteChaCha20Poly1305}
+	}
+	return c.suites
+}
+
+// suiteOffer returns the initiator's suite extension, or nil when it supports
+// only the default suite and the init message can stay unchanged.
+func (c config) suiteOffer() []byte {
+	list := c.supportedSuites()
+	if len(list) == 1 && list[0] == SuiteChaCha20Poly1305 {
+		return nil
+	}
+	b := make([]byte, len(list))
+	for i, s := range list {
+		b[i] = byte(s)
+	}
+	return b
+}
+
+// selectSuite picks the responder's suite: the first entry of the initiator's
+// offer that it supports. An init message without an offer offers only the
+// default suite.
+func selectSuite(initExts extensions, cfg config) (Suite, error) {
+	offer := []byte{byte(SuiteChaCha20Poly1305)}
+	if v, ok := initExts[extSuites]; ok {
+		if len(v) == 0 {
+			return 0, ErrHandshake
+		}
+		offer = v
+	}
+	for _, b := range offer {
+		for _, s := range cfg.supportedSuites() {
+			if Suite(b) == s {
+				return s, nil
+			}
+		}
+	}
+	return 0, ErrHandshake
+}
+
+// acceptSuite checks the responder's choice on the initiator. A response
+// without a choice (a responder that ignores the offer) means the default.
+func (c config) acceptSuite(respExts extensions) (Suite, bool) {
+	chosen := SuiteChaCha20Poly1305
+	if v, ok := respExts[extSuites]; ok {
+		if len(v) != 1 {
+			return 0, false
+		}
+		chosen = Suite(v[0])
+	}
+	for _, s := range c.supportedSuites() {
+		if s == chosen {
+			return s, true
+		}
+	}
+	return 0, false
+}
+
+func (ks keySchedule) params() *suiteParams {
+	return suites[ks.suite]
+}
+
+func (ks keySchedule) newAEAD(key []byte) (cipher.AEAD, error) {
+	return ks.params().newAEAD(key)
+}
+
+// nonce derives the SAFE-mode nonce for counter, bound to the session and the
+// hash of the user AD.
+func (ks keySchedule) nonce(kNonce, sessionID, transcriptHash []byte, counter uint64, ad []byte) []byte {
+	adHash := common.HashSHA256(ad)
+	input := common.TranscriptHash(sessionID, transcriptHash, uint64ToBytes(counter), adHash)
+	return common.HMACTruncate(ks.params().hash, kNonce, input, ks.params().nonceSize)
+}
+
+// naiveNonce is the NAIVE-mode nonce: the counter in the last 8 bytes.
+func (ks keySchedule) naiveNonce(counter uint64) []byte {
+	nonce := make([]byte, ks.params().nonceSize)
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], counter)
+	return nonce
+}
+
+// auditTag is the SAFE-mode audit tag over the transcript, header and counter.
+func (ks keySchedule) auditTag(kAudit, transcriptHash, header []byte, counter uint64) []byte {
+	input := common.TranscriptHash(transcriptHash, header, uint64ToBytes(counter))
+	return common.HMACTruncate(ks.params().hash, kAudit, input, tagSize)
+}
@@ -0,0 +1,160 @@
+package dee
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSuitesRoundTrip(t *testing.T) {
+	for _, suite := range Suites() {
+		for _, mode := range []Mode{Safe, Naive} {
+			t.Run(suite.String()+"/"+mode.String(), func(t *testing.T) {
+				opts := []Option{WithSuites(suite)}
+				initSession, respSession := sessionPair(t, mode, opts, opts)
+				if initSession.Suite() != suite || respSession.Suite() != suite {
+					t.Fatalf("suites: %v / %v, want %v", initSession.Suite(), respSession.Suite(), suite)
+				}
+				if len(initSession.tx.keys.kAead) != suites[suite].keySize {
+					t.Fatalf("AEAD key size %d", len(initSession.tx.keys.kAead))
+				}
+				sendFrames(t, initSession, respSession, 3)
+				sendFrames(t, respSession, initSession, 3)
+
+				ct, err := initSession.Encrypt([]byte("with ad"), []byte("ad"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				ad := append(initSession.buildHeader(3, 0, 0), 'x', 'x')
+				if _, err := respSession.Decrypt(ct, ad); err != ErrDecrypt {
+					t.Fatalf("wrong AD: got %v, want ErrDecrypt", err)
+				}
+			})
+		}
+	}
+}
+
+func TestSuiteNegotiation(t *testing.T) {
+	aes := SuiteAES256GCM
+	siv := SuiteAES256GCMSIV
+	cc := SuiteChaCha20Poly1305
+	cases := []struct {
+		name      string
+		initOpts  []Option
+		respOpts  []Option
+		want      Suite
+		wantError bool
+	}{
+		{"default", nil, nil, cc, false},
+		{"initiator preference wins", []Option{WithSuites(siv, aes)}, []Option{WithSuites(aes, siv)}, siv, false},
+		{"first common", []Option{WithSuites(siv, aes, cc)}, []Option{WithSuites(aes)}, aes, false},
+		{"legacy responder", []Option{WithSuites(aes, cc)}, nil, cc, false},
+		{"legacy initiator", nil, []Option{WithSuites(aes, cc)}, cc, false},
+		{"no common suite", []Option{WithSuites(aes)}, []Option{WithSuites(siv)}, 0, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			initMsg, initSession, err := HandshakeInit(Safe, nil, tc.initOpts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			respMsg, respSession, err := HandshakeResp(Safe, initMsg, nil, tc.respOpts...)
+			if tc.wantError {
+				if err != ErrHandshake {
+					t.Fatalf("got %v, want ErrHandshake", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("HandshakeResp: %v", err)
+			}
+			if err := initSession.HandshakeComplete(respMsg); err != nil {
+				t.Fatalf("HandshakeComplete: %v", err)
+			}
+			finishHandshake(t, initSession, respSession)
+			if initSession.Suite() != tc.want || respSession.Suite() != tc.want {
+				t.Fatalf("suites: %v / %v, want %v", initSession.Suite(), respSession.Suite(), tc.want)
+			}
+			sendFrames(t, initSession, respSession, 2)
+		})
+	}
+}
+
+// A choice outside the offer is rejected outright; a rewritten choice inside
+// it changes the transcript, so the responder's Finished MAC no longer
+// verifies.
+func TestSuiteChoiceTampered(t *testing.T) {
+	opts := []Option{WithSuites(SuiteAES256GCM, SuiteChaCha20Poly1305)}
+	initMsg, initSession, err := HandshakeInit(Safe, nil, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	respMsg, _, err := HandshakeResp(Safe, initMsg, nil, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged := append([]byte(nil), respMsg...)
+	choice := len(forged) - extHeaderSize - finishedSize - 1
+	forged[choice] = byte(SuiteAES256GCMSIV)
+	if err := initSession.HandshakeComplete(forged); err != ErrHandshake {
+		t.Fatalf("unoffered suite: got %v, want ErrHandshake", err)
+	}
+	forged[choice] = byte(SuiteChaCha20Poly1305)
+	if err := initSession.HandshakeComplete(forged); err != ErrHandshake {
+		t.Fatalf("rewritten suite: got %v, want ErrHandshake", err)
+	}
+	if err := initSession.HandshakeComplete(respMsg); err != nil {
+		t.Fatalf("genuine response: %v", err)
+	}
+}
+
+func TestSuiteOfferStrippedBreaksKeys(t *testing.T) {
+	initMsg, initSession, err := HandshakeInit(Safe, nil, WithSuites(SuiteAES256GCM, SuiteChaCha20Poly1305))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stripped := initMsg[:initFixedSize]
+	respMsg, respSession, err := HandshakeResp(Safe, stripped, nil, WithSuites(SuiteAES256GCM, SuiteChaCha20Poly1305))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if respSession.Suite() != SuiteChaCha20Poly1305 {
+		t.Fatalf("stripped offer: got %v", respSession.Suite())
+	}
+	if err := initSession.HandshakeComplete(respMsg); err != ErrHandshake {
+		t.Fatalf("got %v, want ErrHandshake", err)
+	}
+}
+
+func TestSuiteConfig(t *testing.T) {
+	if _, _, err := HandshakeInit(Safe, nil, WithSuites(0x7f)); err != ErrConfig {
+		t.Fatalf("unknown suite: got %v, want ErrConfig", err)
+	}
+	initMsg, _, err := HandshakeInit(Safe, nil, WithSuites(SuiteChaCha20Poly1305))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(initMsg) != initFixedSize {
+		t.Fatal("default-only suite list must not add an offer")
+	}
+}
+
+func TestNaiveNonceSizePerSuite(t *testing.T) {
+	for _, suite := range Suites() {
+		opts := []Option{WithSuites(suite)}
+		initSession, _ := sessionPair(t, Naive, opts, opts)
+		n := initSession.NonceSize()
+		if n != suites[suite].nonceSize {
+			t.Fatalf("%v: NonceSize %d", suite, n)
+		}
+		if _, err := initSession.EncryptNaiveWithNonce([]byte("x"), nil, make([]byte, n+1)); err != ErrDecrypt {
+			t.Fatalf("%v: wrong nonce length accepted", suite)
+		}
+		nonce := bytes.Repeat([]byte{0x41}, n)
+		c1, _ := initSession.EncryptNaiveWithNonce([]byte("AAAA"), nil, nonce)
+		c2, _ := initSession.EncryptNaiveWithNonce([]byte("BBBB"), nil, nonce)
+		leak := c1[0]^c2[0] == 'A'^'B'
+		if leak == (suite == SuiteAES256GCMSIV) {
+			t.Fatalf("%v: keystream reuse leak = %v", suite, leak)
+		}
+	}
+}
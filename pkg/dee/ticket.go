@@ -0,0 +1,323 @@
+package dee
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"sync"
+	"time"
+
+	"deadend-lab/pkg/common"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// A resumption ticket lets a client skip the post-quantum key generation and
+// encapsulation of a full handshake. After a handshake the server seals a
+// per-ticket PSK, derived from the session's resumption secret, under its
+// current ticket key and hands the ticket to the client. The client presents
+// it as the PSK id of a later handshake with X25519 only, or with no
+// components at all (PSK-only), and both sides mix the PSK in as usual.
+//
+// Ticket message (server to client): lifetime_seconds(4) || ticket
+// Ticket:       key_id(8) || nonce(24) || XChaCha20-Poly1305(issued(8) || psk(32))
+//
+// The nonce identifies the ticket: the server accepts each one at most once.
+const (
+	ticketKeyIDSize = 8
+	ticketNonceSize = chacha20poly1305.NonceSizeX
+	ticketPSKSize   = 32
+	ticketPlainSize = 8 + ticketPSKSize
+	ticketSize      = ticketKeyIDSize + ticketNonceSize + ticketPlainSize + chacha20poly1305.Overhead
+)
+
+// TicketKeys is a server's rotating set of ticket keys and its record of
+// redeemed tickets. It is safe for concurrent use.
+type TicketKeys struct {
+	mu          sync.Mutex
+	lifetime    time.Duration
+	rotateEvery time.Duration
+	rand        io.Reader
+	keys        []ticketKey          // newest first
+	redeemed    map[string]time.Time // ticket nonce -> ticket expiry
+}
+
+type ticketKey struct {
+	id      []byte
+	key     []byte
+	created time.Time
+}
+
+// NewTicketKeys returns a ticket key set. Tickets expire lifetime after they
+// are issued. A new ticket key takes over every rotateEvery; an old key is
+// kept only while tickets it issued may still be valid. randReader defaults
+// to crypto/rand.
+func NewTicketKeys(lifetime, rotateEvery time.Duration, randReader io.Reader) (*TicketKeys, error) {
+	if randReader == nil {
+		randReader = rand.Reader
+	}
+	if lifetime <= 0 || lifetime > time.Duration(1<<32-1)*time.Second || rotateEvery <= 0 {
+		return nil, ErrConfig
+	}
+	tk := &TicketKeys{lifetime: lifetime, rotateEvery: rotateEvery, rand: randReader, redeemed: map[string]time.Time{}}
+	if err := tk.Rotate(); err != nil {
+		return nil, err
+	}
+	return tk, nil
+}
+
+// Rotate makes a fresh key current and drops keys whose tickets have all
+// expired.
+func (tk *TicketKeys) Rotate() error {
+	tk.mu.Lock()
+	defer tk.mu.Unlock()
+	return tk.rotate(timeNow())
+}
+
+func (tk *TicketKeys) rotate(now time.Time) error {
+	k := ticketKey{id: make([]byte, ticketKeyIDSize), key: make([]byte, chacha20poly1305.KeySize), created: now}
+	if _, err := io.ReadFull(tk.rand, k.id); err != nil {
+		return err
+	}
+	if _, err := io.ReadFull(tk.rand, k.key); err != nil {
+		return err
+	}
+	tk.keys = append([]ticketKey{k}, tk.keys...)
+	tk.prune(now)
+	return nil
+}
+
+// prune drops every key that was replaced more than lifetime ago.
+func (tk *TicketKeys) prune(now time.Time) {
+	for i := 1; i < len(tk.keys); i++ {
+		if now.Sub(tk.keys[i-1].created) > tk.lifetime {
+			tk.keys = tk.keys[:i]
+			return
+		}
+	}
+}
+
+// seal issues the ticket with nonce, carrying psk, under the current key,
+// rotating first if it is due.
+func (tk *TicketKeys) seal(nonce, psk []byte) ([]byte, error) {
+	tk.mu.Lock()
+	defer tk.mu.Unlock()
+	now := timeNow()
+	if now.Sub(tk.keys[0].created) >= tk.rotateEvery {
+		if err := tk.rotate(now); err != nil {
+			return nil, err
+		}
+	}
+	k := tk.keys[0]
+	ticket := make([]byte, ticketKeyIDSize+ticketNonceSize, ticketSize)
+	copy(ticket, k.id)
+	copy(ticket[ticketKeyIDSize:], nonce)
+	plain := binary.BigEndian.AppendUint64(nil, uint64(now.Unix()))
+	plain = append(plain, psk...)
+	aead, _ := chacha20poly1305.NewX(k.key)
+	return aead.Seal(ticket, nonce, plain, k.id), nil
+}
+
+// open returns the PSK of an unexpired ticket sealed under a key still held,
+// and when the ticket expires.
+func (tk *TicketKeys) open(ticket []byte) (psk []byte, expires time.Time, ok bool) {
+	if len(ticket) != ticketSize {
+		return nil, time.Time{}, false
+	}
+	id, nonce := ticket[:ticketKeyIDSize], ticket[ticketKeyIDSize:ticketKeyIDSize+ticketNonceSize]
+	tk.mu.Lock()
+	defer tk.mu.Unlock()
+	now := timeNow()
+	tk.prune(now)
+	for _, k := range tk.keys {
+		if !bytes.Equal(k.id, id) {
+			continue
+		}
+		aead, _ := chacha20poly1305.NewX(k.key)
+		plain, err := aead.Open(nil, nonce, ticket[ticketKeyIDSize+ticketNonceSize:], id)
+		if err != nil {
+			return nil, time.Time{}, false
+		}
+		expires = time.Unix(int64(binary.BigEndian.Uint64(plain)), 0).Add(tk.lifetime)
+		if !now.Before(expires) {
+			return nil, time.Time{}, false
+		}
+		return plain[8:], expires, true
+	}
+	return nil, time.Time{}, false
+}
+
+// redeem marks the ticket with nonce as used. It fails if it already was.
+// Entries are forgotten once their ticket has expired anyway.
+func (tk *TicketKeys) redeem(nonce []byte, expires time.Time) bool {
+	tk.mu.Lock()
+	defer tk.mu.Unlock()
+	now := timeNow()
+	for n, exp := range tk.redeemed {
+		if !now.Before(exp) {
+			delete(tk.redeemed, n)
+		}
+	}
+	if _, used := tk.redeemed[string(nonce)]; used {
+		return false
+	}
+	tk.redeemed[string(nonce)] = expires
+	return true
+}
+
+// Ticket is a client's resumption ticket, good for one handshake before it
+// expires.
+type Ticket struct {
+	ticket  []byte
+	psk     []byte
+	expires time.Time
+	used    bool
+}
+
+// Expires returns when the server stops accepting the ticket.
+func (t *Ticket) Expires() time.Time {
+	return t.expires
+}
+
+// resumption is a ticket the responder accepted for the handshake in
+// progress; it is redeemed once the binder verifies.
+type resumption struct {
+	keys    *TicketKeys
+	nonce   []byte
+	expires time.Time
+}
+
+// WithTicketKeys lets a responder accept resumption tickets sealed under
+// keys. An init message whose PSK id is a valid ticket is answered with the
+// ticket's PSK. The resumption needs Version2 in the responder's WithVersions,
+// and the initiator's component list (X25519 alone or empty) must be drawn
+// from the responder's own, in order. Configured PSKs are looked up first.
+func WithTicketKeys(keys *TicketKeys) Option {
+	return func(c *config) {
+		c.ticketKeys = keys
+	}
+}
+
+// WithTicket resumes with t: it becomes the initiator's PSK. Unless other
+// options say otherwise, the handshake uses X25519 as its only component and
+// the v2 layout that such a list needs; add WithPSKOnly to skip X25519 too. A
+// ticket is used up by the first HandshakeInit that sends it, and an expired
+// or used ticket fails with ErrConfig.
+func WithTicket(t *Ticket) Option {
+	return func(c *config) {
+		c.ticket = t
+	}
+}
+
+// IssueTicket returns a ticket message for the client of an established
+// responder session. The client passes it to ReceiveTicket; send it over the
+// session, e.g. with EncryptToFrame.
+func (s *Session) IssueTicket(keys *TicketKeys) ([]byte, error) {
+	defer s.lockBoth()()
+	if !s.established || s.isInitiator || keys == nil {
+		return nil, ErrConfig
+	}
+	nonce := make([]byte, ticketNonceSize)
+	if _, err := io.ReadFull(keys.rand, nonce); err != nil {
+		return nil, err
+	}
+	ticket, err := keys.seal(nonce, s.ticketPSK(nonce))
+	if err != nil {
+		return nil, err
+	}
+	msg := binary.BigEndian.AppendUint32(nil, uint32(keys.lifetime/time.Second))
+	return append(msg, ticket...), nil
+}
+
+// ReceiveTicket turns a ticket message from the server into a Ticket. It needs
+// the initiator session the ticket was issued on.
+func (s *Session) ReceiveTicket(msg []byte) (*Ticket, error) {
+	defer s.lockBoth()()
+	if !s.established || !s.isInitiator {
+		return nil, ErrConfig
+	}
+	if len(msg) != 4+ticketSize {
+		return nil, ErrHandshake
+	}
+	lifetime := time.Duration(binary.BigEndian.Uint32(msg)) * time.Second
+	ticket := append([]byte(nil), msg[4:]...)
+	nonce := ticket[ticketKeyIDSize : ticketKeyIDSize+ticketNonceSize]
+	return &Ticket{ticket: ticket, psk: s.ticketPSK(nonce), expires: timeNow().Add(lifetime)}, nil
+}
+
+// ticketPSK derives the PSK of the ticket with nonce from the session's
+// resumption secret.
+func (s *Session) ticketPSK(nonce []byte) []byte {
+	info := s.ks.label(common.LabelTicket) + string(nonce)
+	return common.ExpandWith(s.ks.params().hash, s.resumptionSecret, info, ticketPSKSize)
+}
+
+// Resumed reports whether the session was set up from a resumption ticket.
+func (s *Session) Resumed() bool {
+	defer s.lockBoth()()
+	return s.cfg.isResumed()
+}
+
+func (c config) isResumed() bool {
+	return c.ticket != nil || c.resumed != nil
+}
+
+// applyTicket makes an initiator's ticket its PSK and picks the resumed
+// component list and version unless they were configured.
+func (c *config) applyTicket() bool {
+	t := c.ticket
+	if t.used || !timeNow().Before(t.expires) || len(c.psks) > 0 {
+		return false
+	}
+	c.psks = []psk{{id: t.ticket, key: t.psk}}
+	if c.kems == nil && !c.pskOnly {
+		c.kems = []KEM{KEMX25519}
+	}
+	if c.versions == nil {
+		c.versions = []byte{Version2}
+	}
+	return true
+}
+
+// respondConfig is cfg for answering initMsg. With WithTicketKeys, a PSK id
+// that is not a configured PSK but a valid ticket adds the ticket's PSK and
+// narrows the responder's component list to the initiator's; respondTo then
+// checks the binder and redeems the ticket. A ticket the responder's versions
+// or components cannot serve fails with ErrHandshake.
+func respondConfig(cfg config, initMsg []byte) (config, error) {
+	if cfg.ticketKeys == nil {
+		return cfg, nil
+	}
+	init, err := parseHandshakeInitMsg(initMsg)
+	if err != nil {
+		return cfg, nil
+	}
+	id, ok := init.exts[extPSK]
+	if _, known := cfg.lookupPSK(id); !ok || known {
+		return cfg, nil
+	}
+	key, expires, ok := cfg.ticketKeys.open(id)
+	if !ok {
+		return cfg, nil
+	}
+	if !cfg.speaks(Version2) || !orderedSubset(init.kems, cfg.kemList()) {
+		return cfg, ErrHandshake
+	}
+	cfg.psks = append(append([]psk(nil), cfg.psks...), psk{id: id, key: key})
+	cfg.kems = append([]KEM{}, init.kems...)
+	cfg.pskOnly = len(init.kems) == 0
+	cfg.resumed = &resumption{keys: cfg.ticketKeys, nonce: id[ticketKeyIDSize : ticketKeyIDSize+ticketNonceSize], expires: expires}
+	return cfg, nil
+}
+
+// orderedSubset reports whether every component of list appears in of, in the
+// same relative order.
+func orderedSubset(list, of []KEM) bool {
+	i := 0
+	for _, k := range of {
+		if i < len(list) && list[i] == k {
+			i++
+		}
+	}
+	return i == len(list)
+}
@@ -0,0 +1,242 @@
+package dee
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestTicketKeys(t *testing.T, lifetime, rotateEvery time.Duration) *TicketKeys {
+	t.Helper()
+	keys, err := NewTicketKeys(lifetime, rotateEvery, nil)
+	if err != nil {
+		t.Fatalf("NewTicketKeys: %v", err)
+	}
+	return keys
+}
+
+// issueTicket runs a full handshake and hands the client a ticket for it.
+func issueTicket(t *testing.T, keys *TicketKeys) *Ticket {
+	t.Helper()
+	client, server := sessionPair(t, Safe, nil, nil)
+	msg, err := server.IssueTicket(keys)
+	if err != nil {
+		t.Fatalf("IssueTicket: %v", err)
+	}
+	ticket, err := client.ReceiveTicket(msg)
+	if err != nil {
+		t.Fatalf("ReceiveTicket: %v", err)
+	}
+	return ticket
+}
+
+// ticketServer is the responder configuration for resuming tickets sealed
+// under keys.
+func ticketServer(keys *TicketKeys) []Option {
+	return []Option{WithTicketKeys(keys), WithVersions(Version, Version2)}
+}
+
+// resume runs a handshake with ticket and returns the responder's error.
+func resume(t *testing.T, ticket *Ticket, keys *TicketKeys, initOpts ...Option) error {
+	t.Helper()
+	initMsg, _, err := HandshakeInit(Safe, nil, append([]Option{WithTicket(ticket)}, initOpts...)...)
+	if err != nil {
+		t.Fatalf("HandshakeInit: %v", err)
+	}
+	_, _, err = HandshakeResp(Safe, initMsg, nil, ticketServer(keys)...)
+	return err
+}
+
+func TestTicketResume(t *testing.T) {
+	keys := newTestTicketKeys(t, time.Hour, time.Hour)
+	for name, tc := range map[string]struct {
+		initOpts []Option
+		kems     int
+	}{
+		"x25519":   {nil, 1},
+		"psk only": {[]Option{WithPSKOnly()}, 0},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ticket := issueTicket(t, keys)
+			initOpts := append([]Option{WithTicket(ticket)}, tc.initOpts...)
+			initSession, respSession := sessionPair(t, Safe, initOpts, ticketServer(keys))
+			if !initSession.Resumed() || !respSession.Resumed() {
+				t.Fatal("both sides should report a resumed session")
+			}
+			if got := len(respSession.cfg.kemList()); got != tc.kems {
+				t.Fatalf("resumed with %d components, want %d", got, tc.kems)
+			}
+			if !ticket.used {
+				t.Fatal("ticket not marked used")
+			}
+			sendFrames(t, initSession, respSession, 2)
+			sendFrames(t, respSession, initSession, 2)
+		})
+	}
+}
+
+// A resumed session can hand out a ticket of its own.
+func TestTicketChain(t *testing.T) {
+	keys := newTestTicketKeys(t, time.Hour, time.Hour)
+	ticket := issueTicket(t, keys)
+	client, server := sessionPair(t, Safe, []Option{WithTicket(ticket)}, ticketServer(keys))
+	msg, err := server.IssueTicket(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next, err := client.ReceiveTicket(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessionPair(t, Safe, []Option{WithTicket(next)}, ticketServer(keys))
+}
+
+func TestTicketSingleUse(t *testing.T) {
+	keys := newTestTicketKeys(t, time.Hour, time.Hour)
+	ticket := issueTicket(t, keys)
+	initMsg, _, err := HandshakeInit(Safe, nil, WithTicket(ticket))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := HandshakeResp(Safe, initMsg, nil, ticketServer(keys)...); err != nil {
+		t.Fatalf("first use: %v", err)
+	}
+	if _, _, err := HandshakeResp(Safe, initMsg, nil, ticketServer(keys)...); err != ErrHandshake {
+		t.Fatalf("replayed init message: got %v, want ErrHandshake", err)
+	}
+	if _, _, err := HandshakeInit(Safe, nil, WithTicket(ticket)); err != ErrConfig {
+		t.Fatalf("client reuse: got %v, want ErrConfig", err)
+	}
+}
+
+func TestTicketExpiry(t *testing.T) {
+	now := time.Now()
+	SetTimeNowForTest(func() time.Time { return now })
+	defer SetTimeNowForTest(nil)
+
+	keys := newTestTicketKeys(t, time.Hour, time.Hour)
+	ticket := issueTicket(t, keys)
+	late := issueTicket(t, keys)
+	now = now.Add(time.Hour + time.Second)
+	if _, _, err := HandshakeInit(Safe, nil, WithTicket(ticket)); err != ErrConfig {
+		t.Fatalf("expired ticket on the client: got %v, want ErrConfig", err)
+	}
+	// A client with a slow clock still sends it; the server refuses.
+	late.expires = now.Add(time.Hour)
+	if err := resume(t, late, keys); err != ErrHandshake {
+		t.Fatalf("expired ticket on the server: got %v, want ErrHandshake", err)
+	}
+}
+
+func TestTicketKeyRotation(t *testing.T) {
+	now := time.Now()
+	SetTimeNowForTest(func() time.Time { return now })
+	defer SetTimeNowForTest(nil)
+
+	keys := newTestTicketKeys(t, 2*time.Hour, time.Hour)
+	old := issueTicket(t, keys)
+	oldID := string(old.ticket[:ticketKeyIDSize])
+	now = now.Add(90 * time.Minute)
+	fresh := issueTicket(t, keys)
+	if len(keys.keys) != 2 || string(fresh.ticket[:ticketKeyIDSize]) == oldID {
+		t.Fatalf("issuing after rotateEvery should rotate: %d keys", len(keys.keys))
+	}
+	if err := resume(t, old, keys); err != nil {
+		t.Fatalf("ticket under the previous key: %v", err)
+	}
+	if err := resume(t, fresh, keys); err != nil {
+		t.Fatalf("ticket under the current key: %v", err)
+	}
+
+	// The old key stays until every ticket it could have issued has expired.
+	now = now.Add(2 * time.Hour)
+	if err := keys.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if len(keys.keys) != 3 {
+		t.Fatalf("%d ticket keys, want 3", len(keys.keys))
+	}
+	now = now.Add(time.Second)
+	if err := keys.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range keys.keys {
+		if string(k.id) == oldID {
+			t.Fatal("expired ticket key kept")
+		}
+	}
+}
+
+func TestTicketRejected(t *testing.T) {
+	keys := newTestTicketKeys(t, time.Hour, time.Hour)
+	other := newTestTicketKeys(t, time.Hour, time.Hour)
+
+	if err := resume(t, issueTicket(t, other), keys); err != ErrHandshake {
+		t.Fatalf("foreign ticket: got %v, want ErrHandshake", err)
+	}
+	tampered := issueTicket(t, keys)
+	tampered.ticket[len(tampered.ticket)-1] ^= 1
+	if err := resume(t, tampered, keys); err != ErrHandshake {
+		t.Fatalf("tampered ticket: got %v, want ErrHandshake", err)
+	}
+	// The binder ties the ticket to its PSK.
+	wrong := issueTicket(t, keys)
+	wrong.psk[0] ^= 1
+	if err := resume(t, wrong, keys); err != ErrHandshake {
+		t.Fatalf("wrong ticket PSK: got %v, want ErrHandshake", err)
+	}
+	if err := resume(t, issueTicket(t, keys), nil); err != ErrHandshake {
+		t.Fatalf("responder without ticket keys: got %v, want ErrHandshake", err)
+	}
+}
+
+// The responder resumes only with versions and components it configured
+// itself, whatever the initiator offers.
+func TestTicketResponderConfig(t *testing.T) {
+	keys := newTestTicketKeys(t, time.Hour, time.Hour)
+	for _, tc := range []struct {
+		name               string
+		initOpts, respOpts []Option
+	}{
+		{"no v2", nil, []Option{WithTicketKeys(keys)}},
+		{"unconfigured component", []Option{WithKEMs(KEMX448)}, ticketServer(keys)},
+		{"components out of order", []Option{WithKEMs(KEMKyber768, KEMX25519)}, ticketServer(keys)},
+		{"no x25519", nil, []Option{WithTicketKeys(keys), WithKEMs(KEMX448), WithVersions(Version2)}},
+	} {
+		initMsg, _, err := HandshakeInit(Safe, nil, append([]Option{WithTicket(issueTicket(t, keys))}, tc.initOpts...)...)
+		if err != nil {
+			t.Fatalf("%s: HandshakeInit: %v", tc.name, err)
+		}
+		if _, _, err := HandshakeResp(Safe, initMsg, nil, tc.respOpts...); err != ErrHandshake {
+			t.Errorf("%s: got %v, want ErrHandshake", tc.name, err)
+		}
+	}
+	// A component subset the responder configured is served.
+	ticket := issueTicket(t, keys)
+	initOpts := []Option{WithTicket(ticket), WithKEMs(KEMX448)}
+	_, respSession := sessionPair(t, Safe, initOpts, []Option{WithTicketKeys(keys), WithKEMs(KEMX25519, KEMX448), WithVersions(Version2)})
+	if !respSession.Resumed() || !equalKEMs(respSession.cfg.kemList(), []KEM{KEMX448}) {
+		t.Fatalf("resumed %v with %v", respSession.Resumed(), respSession.cfg.kemList())
+	}
+}
+
+func TestTicketConfig(t *testing.T) {
+	keys := newTestTicketKeys(t, time.Hour, time.Hour)
+	client, server := sessionPair(t, Safe, nil, nil)
+	if _, err := client.IssueTicket(keys); err != ErrConfig {
+		t.Fatalf("IssueTicket on the initiator: got %v, want ErrConfig", err)
+	}
+	if _, err := server.ReceiveTicket(nil); err != ErrConfig {
+		t.Fatalf("ReceiveTicket on the responder: got %v, want ErrConfig", err)
+	}
+	if _, err := client.ReceiveTicket([]byte{1, 2, 3}); err != ErrHandshake {
+		t.Fatalf("short ticket message: got %v, want ErrHandshake", err)
+	}
+	if _, _, err := HandshakeInit(Safe, nil, WithTicket(issueTicket(t, keys)), WithPSK(testPSKID, testPSK)); err != ErrConfig {
+		t.Fatalf("ticket and PSK: got %v, want ErrConfig", err)
+	}
+	for _, tc := range []struct{ lifetime, rotate time.Duration }{{0, time.Hour}, {time.Hour, 0}, {-time.Hour, time.Hour}} {
+		if _, err := NewTicketKeys(tc.lifetime, tc.rotate, nil); err != ErrConfig {
+			t.Errorf("NewTicketKeys(%v, %v): got %v, want ErrConfig", tc.lifetime, tc.rotate, err)
+		}
+	}
+}
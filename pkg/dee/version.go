@@ -0,0 +1,301 @@
+package dee
+
+import (
+	"encoding/binary"
+
+	"deadend-lab/pkg/common"
+)
+
+// keySchedule derives keys with the labels of one protocol version and the hash
+// and key sizes of one suite.
+type keySchedule struct {
+	version byte
+	suite   Suite
+}
+
+func (ks keySchedule) label(label string) string {
+	return common.VersionLabel(label, ks.version)
+}
+
+func (ks keySchedule) extract(secret, salt []byte) []byte {
+	return common.ExtractWith(ks.params().hash, secret, salt)
+}
+
+func (ks keySchedule) expand(secret []byte, label string, size int) []byte {
+	return common.ExpandWith(ks.params().hash, secret, ks.label(label), size)
+}
+
+func (ks keySchedule) trafficKeys(secret []byte) trafficKeys {
+	return trafficKeys{
+		secret: secret,
+		kAead:  ks.expand(secret, common.LabelAEADKey, ks.params().keySize),
+		kNonce: ks.expand(secret, common.LabelNonceBase, 32),
+		kAudit: ks.expand(secret, common.LabelAuditTag, 32),
+		kRekey: ks.expand(secret, common.LabelRekey, 32),
+	}
+}
+
+// ratchetKeys derives the keys of epoch from the previous epoch's rekey key.
+func (ks keySchedule) ratchetKeys(keys trafficKeys, epoch uint64) trafficKeys {
+	info := ks.label(common.LabelRekeyRatchet) + string(uint64ToBytes(epoch))
+	return ks.trafficKeys(common.ExpandWith(ks.params().hash, keys.kRekey, info, 32))
+}
+
+// wireFormat is the byte layout of one protocol version: handshake messages
+// and frame headers.
+//
+// v1 handshake: version(1) || mode(1) || type(1) || x25519(32) || kyber768
+// v2 handshake: version(1) || mode(1) || type(1) || count(1) || count * (kem(1) || len(2) || share)
+// v1 header:    version(1) || mode(1) || session_id(32) || counter(8) || flags(2)
+// v2 header:    version(1) || mode(1) || session_id(32) || epoch(4) || counter(8) || flags(2)
+//
+// A share is a component's public key in the init message and its ciphertext
+// in the response. v1 only carries the default X25519 + Kyber768 pair. In v1
+// the high byte of flags carries the low byte of the epoch; v2 carries the
+// epoch explicitly and reserves every flag bit but the key-update one.
+type wireFormat struct {
+	version      byte
+	headerSize   int
+	reservedMask uint16
+}
+
+var (
+	wireV1 = &wireFormat{version: Version, headerSize: HeaderSize, reservedMask: flagReservedMask}
+	wireV2 = &wireFormat{version: Version2, headerSize: HeaderSizeV2, reservedMask: flagReservedMaskV2}
+)
+
+func wireFor(version byte) (*wireFormat, bool) {
+	switch version {
+	case Version:
+		return wireV1, true
+	case Version2:
+		return wireV2, true
+	}
+	return nil, false
+}
+
+// handshakeMsg is a parsed init or resp message. body excludes trailer
+// extensions and is what the transcript covers.
+type handshakeMsg struct {
+	version byte
+	mode    byte
+	kems    []KEM
+	shares  [][]byte
+	body    []byte
+	exts    extensions
+}
+
+func (w *wireFormat) buildHandshake(mode, typ byte, kems []KEM, shares [][]byte) []byte {
+	b := []byte{w.version, mode, typ}
+	if w.version == Version {
+		for _, share := range shares {
+			b = append(b, share...)
+		}
+		return b
+	}
+	b = append(b, byte(len(kems)))
+	for i, k := range kems {
+		b = append(b, byte(k))
+		b = binary.BigEndian.AppendUint16(b, uint16(len(shares[i])))
+		b = append(b, shares[i]...)
+	}
+	return b
+}
+
+// parseHandshake reads a message of type typ, then its extensions. Every share
+// must have its component's exact size.
+func (w *wireFormat) parseHandshake(b []byte, typ byte) (handshakeMsg, error) {
+	if len(b) < 3 || b[0] != w.version || b[2] != typ {
+		return handshakeMsg{}, ErrHandshake
+	}
+	m := handshakeMsg{version: b[0], mode: b[1]}
+	off := 3
+	share := func(k KEM) bool {
+		s, ok := kemSchemes[k]
+		if !ok {
+			return false
+		}
+		n := shareSize(s, typ)
+		if w.version != Version {
+			if len(b)-off < 2 || int(binary.BigEndian.Uint16(b[off:])) != n {
+				return false
+			}
+			off += 2
+		}
+		if len(b)-off < n {
+			return false
+		}
+		m.kems = append(m.kems, k)
+		m.shares = append(m.shares, append([]byte(nil), b[off:off+n]...))
+		off += n
+		return true
+	}
+	if w.version == Version {
+		for _, k := range defaultKEMs {
+			if !share(k) {
+				return handshakeMsg{}, ErrHandshake
+			}
+		}
+	} else {
+		if len(b) == off {
+			return handshakeMsg{}, ErrHandshake
+		}
+		count := int(b[off])
+		off++
+		for i := 0; i < count; i++ {
+			if len(b) == off {
+				return handshakeMsg{}, ErrHandshake
+			}
+			off++
+			if !share(KEM(b[off-1])) {
+				return handshakeMsg{}, ErrHandshake
+			}
+		}
+		if !validKEMs(m.kems) {
+			return handshakeMsg{}, ErrHandshake
+		}
+	}
+	var err error
+	if m.body, m.exts, err = parseExtensions(b, off); err != nil {
+		return handshakeMsg{}, err
+	}
+	return m, nil
+}
+
+func parseHandshakeAny(b []byte, typ byte) (handshakeMsg, error) {
+	if len(b) < 1 {
+		return handshakeMsg{}, ErrHandshake
+	}
+	w, ok := wireFor(b[0])
+	if !ok {
+		return handshakeMsg{}, ErrHandshake
+	}
+	return w.parseHandshake(b, typ)
+}
+
+func (w *wireFormat) buildHeader(mode byte, sessionID []byte, counter, epoch uint64, flags uint16) []byte {
+	b := make([]byte, w.headerSize)
+	b[0] = w.version
+	b[1] = mode
+	copy(b[2:34], sessionID)
+	if w.version == Version {
+		binary.BigEndian.PutUint64(b[34:42], counter)
+		flags |= uint16(uint8(epoch)) << flagEpochShift
+		binary.BigEndian.PutUint16(b[42:44], flags)
+		return b
+	}
+	binary.BigEndian.PutUint32(b[34:38], uint32(epoch))
+	binary.BigEndian.PutUint64(b[38:46], counter)
+	binary.BigEndian.PutUint16(b[46:48], flags)
+	return b
+}
+
+// parseHeader returns the counter, flags and epoch of header. For v1 the epoch
+// is reconstructed relative to the receive direction.
+func (w *wireFormat) parseHeader(header []byte, rx *direction) (counter uint64, flags uint16, epoch uint64) {
+	if w.version == Version {
+		counter = binary.BigEndian.Uint64(header[34:42])
+		flags = binary.BigEndian.Uint16(header[42:44])
+		return counter, flags, rx.fullEpoch(uint8(flags >> flagEpochShift))
+	}
+	epoch = uint64(binary.BigEndian.Uint32(header[34:38]))
+	counter = binary.BigEndian.Uint64(header[38:46])
+	flags = binary.BigEndian.Uint16(header[46:48])
+	return counter, flags, epoch
+}
+
+// counterOf returns the counter in header.
+func (w *wireFormat) counterOf(header []byte) uint64 {
+	counter, _, _ := w.parseHeader(header, &direction{})
+	return counter
+}
+
+// WithVersions sets the protocol versions this side speaks; the default is
+// Version only. The highest version both peers support is used. An initiator
+// that also speaks v1 sends a v1 init message listing the others in an
+// extension, so v1-only responders still understand it; the list is bound into
+// the transcript, so stripping a version breaks key agreement.
+func WithVersions(versions ...byte) Option {
+	return func(c *config) {
+		c.versions = append([]byte(nil), versions...)
+	}
+}
+
+// Version returns the protocol version of the session.
+func (s *Session) Version() byte {
+	return s.ks.version
+}
+
+// HeaderSize returns the frame header size for the session's version. Decrypt
+// expects this many header bytes at the start of ad.
+func (s *Session) HeaderSize() int {
+	return s.wire().headerSize
+}
+
+func (s *Session) wire() *wireFormat {
+	w, _ := wireFor(s.ks.version)
+	return w
+}
+
+// supportedVersions returns the configured versions, highest first.
+func (c config) supportedVersions() []byte {
+	if len(c.versions) == 0 {
+		return []byte{Version}
+	}
+	out := make([]byte, 0, len(c.versions))
+	for v := byte(Version2); v >= Version; v-- {
+		for _, x := range c.versions {
+			if x == v {
+				out = append(out, v)
+				break
+			}
+		}
+	}
+	return out
+}
+
+func (c config) speaks(version byte) bool {
+	for _, v := range c.supportedVersions() {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+// initVersion is the layout an initiator uses for its init message: v1 if it
+// speaks v1, so any responder can parse it, otherwise its highest version.
+func (c config) initVersion() byte {
+	if c.speaks(Version) {
+		return Version
+	}
+	return c.supportedVersions()[0]
+}
+
+// selectVersion picks the highest version offered in init that the responder
+// speaks. Without a versions extension the offer is the header version, which
+// any explicit offer must contain.
+func selectVersion(init handshakeMsg, cfg config) (byte, error) {
+	offer := []byte{init.version}
+	if v, ok := init.exts[extVersions]; ok {
+		if len(v) == 0 || !containsByte(v, init.version) {
+			return 0, ErrHandshake
+		}
+		offer = v
+	}
+	for _, v := range cfg.supportedVersions() {
+		if containsByte(offer, v) {
+			return v, nil
+		}
+	}
+	return 0, ErrHandshake
+}
+
+func containsByte(list []byte, b byte) bool {
+	for _, x := range list {
+		if x == b {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,169 @@
+package dee
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestVersionNegotiation(t *testing.T) {
+	cases := []struct {
+		name      string
+		initVers  []byte
+		respVers  []byte
+		want      byte
+		wantError bool
+	}{
+		{"default", nil, nil, Version, false},
+		{"both speak v2", []byte{Version, Version2}, []byte{Version, Version2}, Version2, false},
+		{"v2 only", []byte{Version2}, []byte{Version2}, Version2, false},
+		{"v1-only responder", []byte{Version, Version2}, nil, Version, false},
+		{"v1-only initiator", nil, []byte{Version, Version2}, Version, false},
+		{"no common version", []byte{Version2}, nil, 0, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			initMsg, initSession, err := HandshakeInit(Safe, nil, WithVersions(tc.initVers...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			respMsg, respSession, err := HandshakeResp(Safe, initMsg, nil, WithVersions(tc.respVers...))
+			if tc.wantError {
+				if err != ErrHandshake {
+					t.Fatalf("got %v, want ErrHandshake", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("HandshakeResp: %v", err)
+			}
+			if err := initSession.HandshakeComplete(respMsg); err != nil {
+				t.Fatalf("HandshakeComplete: %v", err)
+			}
+			finishHandshake(t, initSession, respSession)
+			if initSession.Version() != tc.want || respSession.Version() != tc.want {
+				t.Fatalf("versions: initiator %d responder %d, want %d", initSession.Version(), respSession.Version(), tc.want)
+			}
+			if respMsg[0] != tc.want {
+				t.Fatalf("response layout version %d, want %d", respMsg[0], tc.want)
+			}
+			f, err := initSession.EncryptToFrame([]byte("data"), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if f[0] != tc.want || len(f) < initSession.HeaderSize()+4 {
+				t.Fatalf("frame header version %d", f[0])
+			}
+			if _, err := respSession.DecryptFromFrame(f); err != nil {
+				t.Fatalf("DecryptFromFrame: %v", err)
+			}
+			sendFrames(t, initSession, respSession, 2)
+			sendFrames(t, respSession, initSession, 2)
+		})
+	}
+}
+
+func TestVersionInitLayout(t *testing.T) {
+	// An initiator that still speaks v1 uses the v1 layout, so v1 parsers
+	// read it; a v2-only initiator uses length-prefixed components.
+	initMsg, _, err := HandshakeInit(Safe, nil, WithVersions(Version, Version2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if initMsg[0] != Version {
+		t.Fatalf("init version byte %d, want %d", initMsg[0], Version)
+	}
+	if _, err := wireV1.parseHandshake(initMsg, HandshakeTypeInit); err != nil {
+		t.Fatalf("v1 parser: %v", err)
+	}
+	initMsg, _, err = HandshakeInit(Safe, nil, WithVersions(Version2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if initMsg[0] != Version2 || len(initMsg) != initFixedSize+1+2*3 {
+		t.Fatalf("v2 init: version %d length %d", initMsg[0], len(initMsg))
+	}
+	if _, err := wireV1.parseHandshake(initMsg, HandshakeTypeInit); err != ErrHandshake {
+		t.Fatal("v1 parser must reject a v2 message")
+	}
+}
+
+func TestVersionV2Frames(t *testing.T) {
+	opts := []Option{WithVersions(Version2), WithReplayWindow(MinReplayWindow)}
+	initSession, respSession := sessionPair(t, Safe, opts, opts)
+	sendFrames(t, initSession, respSession, 3)
+
+	// Explicit epochs across key updates and reordering.
+	held, err := initSession.EncryptToFrame([]byte("data"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		ku, err := initSession.RequestKeyUpdate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := respSession.DecryptFromFrame(ku); err != nil {
+			t.Fatalf("key update %d: %v", i, err)
+		}
+	}
+	f, err := initSession.EncryptToFrame([]byte("data"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := f[37]; got != 3 {
+		t.Fatalf("explicit epoch field: got %d want 3", got)
+	}
+	for _, frame := range [][]byte{f, held} {
+		if pt, err := respSession.DecryptFromFrame(frame); err != nil || string(pt) != "data" {
+			t.Fatalf("v2 frame: %v", err)
+		}
+	}
+
+	// v2 reserves the high flag byte that carries the epoch in v1.
+	f, err = initSession.EncryptToFrame([]byte("data"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f[46] |= 0x01
+	if _, err := respSession.DecryptFromFrame(f); err != ErrDecrypt {
+		t.Fatal("v2 must reject reserved flag bits")
+	}
+}
+
+func TestVersionLabelsSeparateKeys(t *testing.T) {
+	secret := bytes.Repeat([]byte{7}, 32)
+	v1 := keySchedule{version: Version, suite: SuiteChaCha20Poly1305}.trafficKeys(secret)
+	v2 := keySchedule{version: Version2, suite: SuiteChaCha20Poly1305}.trafficKeys(secret)
+	if bytes.Equal(v1.kAead, v2.kAead) || bytes.Equal(v1.kNonce, v2.kNonce) {
+		t.Fatal("v1 and v2 must derive different keys from the same secret")
+	}
+}
+
+func TestVersionDowngradeDetected(t *testing.T) {
+	initMsg, initSession, err := HandshakeInit(Safe, nil, WithVersions(Version, Version2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Rewrite the versions extension (the last one) to offer only v1.
+	ext := []byte{extVersions, 0x00, 0x02, Version2, Version}
+	if !bytes.HasSuffix(initMsg, ext) {
+		t.Fatal("init message does not end with the versions extension")
+	}
+	forged := append(append([]byte(nil), initMsg[:len(initMsg)-len(ext)]...), extVersions, 0x00, 0x01, Version)
+	respMsg, respSession, err := HandshakeResp(Safe, forged, nil, WithVersions(Version, Version2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if respSession.Version() != Version {
+		t.Fatalf("responder picked %d, want v1", respSession.Version())
+	}
+	if err := initSession.HandshakeComplete(respMsg); err != ErrHandshake {
+		t.Fatalf("a stripped version offer must fail key confirmation, got %v", err)
+	}
+}
+
+func TestVersionConfig(t *testing.T) {
+	if _, _, err := HandshakeInit(Safe, nil, WithVersions(0x03)); err != ErrConfig {
+		t.Fatalf("got %v, want ErrConfig", err)
+	}
+}
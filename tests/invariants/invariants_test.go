@@ -3,6 +3,7 @@ package invariants
 import (
 	"bytes"
 	"crypto/rand"
+	mathrand "math/rand"
 	"testing"
 
 	"deadend-lab/pkg/dee"
@@ -12,6 +13,7 @@ func TestNonceUniquenessSAFE(t *testing.T) {
 	initMsg, initSession, _ := dee.HandshakeInit(dee.Safe, nil)
 	respMsg, respSession, _ := dee.HandshakeResp(dee.Safe, initMsg, nil)
 	_ = initSession.HandshakeComplete(respMsg)
+	finishHandshake(t, initSession, respSession)
 
 	plaintext := []byte("same")
 	ad := []byte("ad")
@@ -55,6 +57,7 @@ func TestCounterMonotonicityRejectReplay(t *testing.T) {
 	initMsg, initSession, _ := dee.HandshakeInit(dee.Safe, nil)
 	respMsg, respSession, _ := dee.HandshakeResp(dee.Safe, initMsg, nil)
 	_ = initSession.HandshakeComplete(respMsg)
+	finishHandshake(t, initSession, respSession)
 
 	ct, _ := initSession.Encrypt([]byte("msg"), nil)
 	header := respSession.WireHeader(0)
@@ -75,6 +78,7 @@ func TestCounterMonotonicityRejectOutOfOrder(t *testing.T) {
 	initMsg, initSession, _ := dee.HandshakeInit(dee.Safe, nil)
 	respMsg, respSession, _ := dee.HandshakeResp(dee.Safe, initMsg, nil)
 	_ = initSession.HandshakeComplete(respMsg)
+	finishHandshake(t, initSession, respSession)
 
 	ct0, _ := initSession.Encrypt([]byte("zero"), nil)
 	ct1, _ := initSession.Encrypt([]byte("one"), nil)
@@ -103,10 +107,12 @@ func TestTranscriptBinding(t *testing.T) {
 	initMsg1, initSession1, _ := dee.HandshakeInit(dee.Safe, rand.Reader)
 	respMsg1, respSession1, _ := dee.HandshakeResp(dee.Safe, initMsg1, rand.Reader)
 	_ = initSession1.HandshakeComplete(respMsg1)
+	finishHandshake(t, initSession1, respSession1)
 
 	initMsg2, initSession2, _ := dee.HandshakeInit(dee.Safe, rand.Reader)
 	respMsg2, respSession2, _ := dee.HandshakeResp(dee.Safe, initMsg2, rand.Reader)
 	_ = initSession2.HandshakeComplete(respMsg2)
+	finishHandshake(t, initSession2, respSession2)
 
 	if bytes.Equal(initSession1.SessionID(), initSession2.SessionID()) {
 		t.Error("different transcripts must yield different session IDs")
@@ -124,10 +130,12 @@ func TestTranscriptBinding(t *testing.T) {
 		initSafe, initSessSafe, _ := dee.HandshakeInit(dee.Safe, nil)
 		respSafe, respSessSafe, _ := dee.HandshakeResp(dee.Safe, initSafe, nil)
 		_ = initSessSafe.HandshakeComplete(respSafe)
+		finishHandshake(t, initSessSafe, respSessSafe)
 
 		initNaive, initSessNaive, _ := dee.HandshakeInit(dee.Naive, nil)
 		respNaive, respSessNaive, _ := dee.HandshakeResp(dee.Naive, initNaive, nil)
 		_ = initSessNaive.HandshakeComplete(respNaive)
+		finishHandshake(t, initSessNaive, respSessNaive)
 
 		if bytes.Equal(initSessSafe.SessionID(), initSessNaive.SessionID()) {
 			t.Error("different mode must yield different session ID")
@@ -147,6 +155,7 @@ func TestUniformFailure(t *testing.T) {
 	initMsg, initSession, _ := dee.HandshakeInit(dee.Safe, nil)
 	respMsg, respSession, _ := dee.HandshakeResp(dee.Safe, initMsg, nil)
 	_ = initSession.HandshakeComplete(respMsg)
+	finishHandshake(t, initSession, respSession)
 
 	ct, _ := initSession.Encrypt([]byte("x"), nil)
 	header := respSession.WireHeader(0)
@@ -190,3 +199,137 @@ func TestUniformFailure(t *testing.T) {
 		}
 	})
 }
+
+// TestCrossDirectionNoncesNeverCollide sends identical plaintext and AD at the
+// same counter in both directions. With a shared key and nonce the two
+// ciphertexts would be byte-identical (keystream reuse); they must never be.
+func TestCrossDirectionNoncesNeverCollide(t *testing.T) {
+	initMsg, initSession, _ := dee.HandshakeInit(dee.Safe, nil)
+	respMsg, respSession, _ := dee.HandshakeResp(dee.Safe, initMsg, nil)
+	_ = initSession.HandshakeComplete(respMsg)
+	finishHandshake(t, initSession, respSession)
+
+	plaintext := []byte("same message both ways")
+	ad := []byte("same ad")
+	seen := make(map[string]string)
+	for i := 0; i < 256; i++ {
+		ctI, err := initSession.Encrypt(plaintext, ad)
+		if err != nil {
+			t.Fatalf("initiator Encrypt: %v", err)
+		}
+		ctR, err := respSession.Encrypt(plaintext, ad)
+		if err != nil {
+			t.Fatalf("responder Encrypt: %v", err)
+		}
+		if bytes.Equal(ctI, ctR) {
+			t.Fatalf("counter %d: initiator and responder produced identical ciphertext", i)
+		}
+		for dir, ct := range map[string][]byte{"i2r": ctI, "r2i": ctR} {
+			if prev, ok := seen[string(ct)]; ok {
+				t.Fatalf("counter %d: %s ciphertext repeats an earlier %s ciphertext", i, dir, prev)
+			}
+			seen[string(ct)] = dir
+		}
+		if _, err := respSession.Decrypt(ctI, append(respSession.WireHeader(uint64(i)), ad...)); err != nil {
+			t.Fatalf("counter %d: responder Decrypt: %v", i, err)
+		}
+		if _, err := initSession.Decrypt(ctR, append(initSession.WireHeader(uint64(i)), ad...)); err != nil {
+			t.Fatalf("counter %d: initiator Decrypt: %v", i, err)
+		}
+	}
+
+	t.Run("reflection", func(t *testing.T) {
+		// A frame reflected back to its sender must not open under the receive keys.
+		ct, _ := initSession.Encrypt(plaintext, ad)
+		_, err := initSession.Decrypt(ct, append(initSession.WireHeader(256), ad...))
+		if err != dee.ErrDecrypt {
+			t.Errorf("reflected frame: want ErrDecrypt, got %v", err)
+		}
+	})
+}
+
+// TestReplayWindowAcceptsOnce delivers every frame of a reordered batch twice to
+// a windowed receiver: the first copy must open, the second must fail uniformly.
+func TestReplayWindowAcceptsOnce(t *testing.T) {
+	initMsg, initSession, _ := dee.HandshakeInit(dee.Safe, nil)
+	respMsg, respSession, _ := dee.HandshakeResp(dee.Safe, initMsg, nil, dee.WithReplayWindow(128))
+	_ = initSession.HandshakeComplete(respMsg)
+	finishHandshake(t, initSession, respSession)
+
+	const n = 100
+	frames := make([][]byte, n)
+	for i := range frames {
+		frames[i], _ = initSession.EncryptToFrame([]byte("payload"), nil)
+	}
+	for _, i := range mathrand.New(mathrand.NewSource(7)).Perm(n) {
+		if _, err := respSession.DecryptFromFrame(frames[i]); err != nil {
+			t.Fatalf("frame %d first delivery: %v", i, err)
+		}
+		if _, err := respSession.DecryptFromFrame(frames[i]); err != dee.ErrDecrypt {
+			t.Fatalf("frame %d duplicate: want ErrDecrypt, got %v", i, err)
+		}
+	}
+}
+
+// TestDowngradeStrippingSafeDetected plays a MITM that removes SAFE from the
+// initiator's mode offer so the responder settles on NAIVE. The offer is bound
+// into the transcript, so the peers end up with different keys and no frame
+// crosses in either direction.
+func TestDowngradeStrippingSafeDetected(t *testing.T) {
+	initMsg, initSession, err := dee.HandshakeInit(dee.Safe, nil, dee.WithFallbackModes(dee.Naive))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The offer is the last extension: type 0x02, length 2, SAFE, NAIVE.
+	offer := []byte{0x02, 0x00, 0x02, byte(dee.Safe), byte(dee.Naive)}
+	if !bytes.HasSuffix(initMsg, offer) {
+		t.Fatal("init message does not end with the expected offer")
+	}
+	forged := append([]byte(nil), initMsg[:len(initMsg)-len(offer)]...)
+	forged[1] = byte(dee.Naive)
+	forged = append(forged, 0x02, 0x00, 0x01, byte(dee.Naive))
+
+	respMsg, respSession, err := dee.HandshakeResp(dee.Safe, forged, nil, dee.WithFallbackModes(dee.Naive))
+	if err != nil {
+		t.Fatalf("responder should accept the rewritten offer: %v", err)
+	}
+	if respSession.Mode() != dee.Naive {
+		t.Fatalf("responder picked %v, want NAIVE", respSession.Mode())
+	}
+	if err := initSession.HandshakeComplete(respMsg); err != nil {
+		return // rejected outright
+	}
+	finishHandshake(t, initSession, respSession)
+	if bytes.Equal(initSession.SessionID(), respSession.SessionID()) {
+		t.Fatal("rewritten offer must change the transcript")
+	}
+	f, _ := respSession.EncryptToFrame([]byte("downgraded"), nil)
+	if _, err := initSession.DecryptFromFrame(f); err != dee.ErrDecrypt {
+		t.Fatalf("initiator opened a downgraded frame: %v", err)
+	}
+	f, _ = initSession.EncryptToFrame([]byte("downgraded"), nil)
+	if _, err := respSession.DecryptFromFrame(f); err != dee.ErrDecrypt {
+		t.Fatalf("responder opened a frame across the downgrade: %v", err)
+	}
+
+	t.Run("offer only", func(t *testing.T) {
+		// Stripping SAFE from the offer alone contradicts the header mode.
+		initMsg, _, _ := dee.HandshakeInit(dee.Safe, nil, dee.WithFallbackModes(dee.Naive))
+		forged := append(append([]byte(nil), initMsg[:len(initMsg)-len(offer)]...), 0x02, 0x00, 0x01, byte(dee.Naive))
+		if _, _, err := dee.HandshakeResp(dee.Safe, forged, nil, dee.WithFallbackModes(dee.Naive)); err != dee.ErrHandshake {
+			t.Fatalf("want ErrHandshake, got %v", err)
+		}
+	})
+}
+
+// finishHandshake sends the initiator's Finished message to the responder.
+func finishHandshake(t testing.TB, initSession, respSession *dee.Session) {
+	t.Helper()
+	finMsg, err := initSession.HandshakeFinishMsg()
+	if err != nil {
+		t.Fatalf("HandshakeFinishMsg: %v", err)
+	}
+	if err := respSession.HandshakeFinish(finMsg); err != nil {
+		t.Fatalf("HandshakeFinish: %v", err)
+	}
+}
@@ -50,6 +50,13 @@ func TestNoSecretsInRuntimeLogs(t *testing.T) {
 	if err := initSession.HandshakeComplete(respMsg); err != nil {
 		t.Fatalf("HandshakeComplete: %v", err)
 	}
+	finMsg, err := initSession.HandshakeFinishMsg()
+	if err != nil {
+		t.Fatalf("HandshakeFinishMsg: %v", err)
+	}
+	if err := respSession.HandshakeFinish(finMsg); err != nil {
+		t.Fatalf("HandshakeFinish: %v", err)
+	}
 	plaintext := []byte("test message")
 	ct, err := initSession.Encrypt(plaintext, nil)
 	if err != nil {
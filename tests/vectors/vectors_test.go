@@ -19,6 +19,7 @@ type Vector struct {
 }
 
 type MessageVectorEntry struct {
+	Direction string `json:"direction"`
 	Counter   uint64 `json:"counter"`
 	MsgHex    string `json:"msg_hex"`
 	ADHex     string `json:"ad_hex"`
@@ -32,6 +33,20 @@ type MessageVector struct {
 	Label             string               `json:"label"`
 }
 
+type CombinerVector struct {
+	Label         string `json:"label"`
+	PublicKeyHex  string `json:"public_key_hex"`
+	CiphertextHex string `json:"ciphertext_hex"`
+	OutputHex     string `json:"output_hex"`
+}
+
+type NoiseVector struct {
+	Label            string               `json:"label"`
+	HandshakeHashHex string               `json:"handshake_hash_hex"`
+	Handshake        []MessageVectorEntry `json:"handshake"`
+	Messages         []MessageVectorEntry `json:"messages"`
+}
+
 func TestHKDFVector(t *testing.T) {
 	path := filepath.Join("testdata", "handshake_vector.json")
 	b, err := os.ReadFile(path)
@@ -75,8 +90,11 @@ func TestMessageVectorByteForByte(t *testing.T) {
 	if err := json.Unmarshal(b, &v); err != nil {
 		t.Fatalf("unmarshal: %v", err)
 	}
-	if len(v.Messages) < 2 {
-		t.Fatal("vector must have at least 2 messages")
+	if len(v.Messages) < 4 {
+		t.Fatal("vector must have at least 4 messages")
+	}
+	if len(v.Messages) != len(expected.Messages) {
+		t.Fatalf("message count: got %d want %d (run: make vectors)", len(v.Messages), len(expected.Messages))
 	}
 
 	// Byte-for-byte validation. Test fails on mismatch.
@@ -90,6 +108,9 @@ func TestMessageVectorByteForByte(t *testing.T) {
 		if i >= len(expected.Messages) {
 			t.Fatalf("file has more messages than expected")
 		}
+		if v.Messages[i].Direction != expected.Messages[i].Direction || v.Messages[i].Counter != expected.Messages[i].Counter {
+			t.Errorf("message %d: direction/counter mismatch", i)
+		}
 		if v.Messages[i].MsgHex != expected.Messages[i].MsgHex {
 			t.Errorf("message %d msg_hex mismatch", i)
 		}
@@ -101,3 +122,127 @@ func TestMessageVectorByteForByte(t *testing.T) {
 		}
 	}
 }
+
+func TestRatchetVectorByteForByte(t *testing.T) {
+	expected, err := vectorgenerate.GenerateRatchetVector(vectorgenerate.VectorSeed)
+	if err != nil {
+		t.Fatalf("GenerateRatchetVector: %v", err)
+	}
+
+	path := filepath.Join("testdata", "ratchet_vector.json")
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("vector file not found: %v (run: make vectors)", err)
+	}
+	var v MessageVector
+	if err := json.Unmarshal(b, &v); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if v.Label != expected.Label || v.TranscriptHex != expected.TranscriptHex {
+		t.Errorf("label/transcript mismatch (run: make vectors)")
+	}
+	if len(v.Messages) != len(expected.Messages) {
+		t.Fatalf("message count: got %d want %d (run: make vectors)", len(v.Messages), len(expected.Messages))
+	}
+	for i := range v.Messages {
+		if v.Messages[i] != MessageVectorEntry(expected.Messages[i]) {
+			t.Errorf("message %d: mismatch (run: make vectors)", i)
+		}
+	}
+}
+
+func TestSuiteVectorsByteForByte(t *testing.T) {
+	expected, err := vectorgenerate.GenerateSuiteVectors(vectorgenerate.VectorSeed)
+	if err != nil {
+		t.Fatalf("GenerateSuiteVectors: %v", err)
+	}
+
+	path := filepath.Join("testdata", "suite_vectors.json")
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("vector file not found: %v (run: make vectors)", err)
+	}
+	var vs []MessageVector
+	if err := json.Unmarshal(b, &vs); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if len(vs) != len(expected) {
+		t.Fatalf("suite count: got %d want %d (run: make vectors)", len(vs), len(expected))
+	}
+	for i, v := range vs {
+		if v.Label != expected[i].Label || v.TranscriptHex != expected[i].TranscriptHex {
+			t.Errorf("%s: label/transcript mismatch (run: make vectors)", v.Label)
+		}
+		if len(v.Messages) != len(expected[i].Messages) {
+			t.Fatalf("%s: message count mismatch (run: make vectors)", v.Label)
+		}
+		for j := range v.Messages {
+			if v.Messages[j] != MessageVectorEntry(expected[i].Messages[j]) {
+				t.Errorf("%s message %d: mismatch (run: make vectors)", v.Label, j)
+			}
+		}
+	}
+}
+
+func TestCombinerVectorsByteForByte(t *testing.T) {
+	expected, err := vectorgenerate.GenerateCombinerVectors(vectorgenerate.VectorSeed)
+	if err != nil {
+		t.Fatalf("GenerateCombinerVectors: %v", err)
+	}
+
+	path := filepath.Join("testdata", "combiner_vectors.json")
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("vector file not found: %v (run: make vectors)", err)
+	}
+	var vs []CombinerVector
+	if err := json.Unmarshal(b, &vs); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if len(vs) != len(expected) {
+		t.Fatalf("combiner count: got %d want %d (run: make vectors)", len(vs), len(expected))
+	}
+	for i, v := range vs {
+		if v != CombinerVector(expected[i]) {
+			t.Errorf("%s: mismatch (run: make vectors)", v.Label)
+		}
+	}
+}
+
+func TestNoiseVectorsByteForByte(t *testing.T) {
+	expected, err := vectorgenerate.GenerateNoiseVectors(vectorgenerate.VectorSeed)
+	if err != nil {
+		t.Fatalf("GenerateNoiseVectors: %v", err)
+	}
+
+	path := filepath.Join("testdata", "noise_vectors.json")
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("vector file not found: %v (run: make vectors)", err)
+	}
+	var vs []NoiseVector
+	if err := json.Unmarshal(b, &vs); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if len(vs) != len(expected) {
+		t.Fatalf("pattern count: got %d want %d (run: make vectors)", len(vs), len(expected))
+	}
+	for i, v := range vs {
+		if v.Label != expected[i].Label || v.HandshakeHashHex != expected[i].HandshakeHashHex {
+			t.Errorf("%s: label/handshake hash mismatch (run: make vectors)", v.Label)
+		}
+		if len(v.Handshake) != len(expected[i].Handshake) || len(v.Messages) != len(expected[i].Messages) {
+			t.Fatalf("%s: message count mismatch (run: make vectors)", v.Label)
+		}
+		for j := range v.Handshake {
+			if v.Handshake[j] != MessageVectorEntry(expected[i].Handshake[j]) {
+				t.Errorf("%s handshake message %d: mismatch (run: make vectors)", v.Label, j)
+			}
+		}
+		for j := range v.Messages {
+			if v.Messages[j] != MessageVectorEntry(expected[i].Messages[j]) {
+				t.Errorf("%s message %d: mismatch (run: make vectors)", v.Label, j)
+			}
+		}
+	}
+}